@@ -2,22 +2,22 @@ package app
 
 import (
 	"context"
+	"time"
 
 	"github.com/rs/zerolog/log"
 
 	"github.com/aslon1213/g4h_pos_erp/pkg/configs"
+	"github.com/aslon1213/g4h_pos_erp/platform/cache"
 	"github.com/aslon1213/g4h_pos_erp/platform/database"
 	"github.com/aslon1213/g4h_pos_erp/platform/logger"
 
 	"github.com/gofiber/contrib/otelfiber"
-	"github.com/gofiber/fiber/v2/middleware/basicauth"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/sdk/resource"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/rs/zerolog"
-	fiberSwagger "github.com/swaggo/fiber-swagger"
 
 	_ "github.com/aslon1213/g4h_pos_erp/docs"
 	"github.com/go-playground/validator/v10"
@@ -38,10 +38,13 @@ import (
 // @securityDefinitions.apikey BearerAuth
 // @in header
 // @name Authorization
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
 
 type App struct {
 	Logger *zerolog.Logger
-	// Cache  *cache.Cache
+	Cache  *cache.Cache
 	DB     *mongo.Client
 	Config *configs.Config
 	Router *fiber.App
@@ -91,8 +94,6 @@ func (v XValidator) Validate(data interface{}) []ErrorResponse {
 }
 
 func NewFiberApp() *fiber.App {
-	config, _ := configs.LoadConfig(".")
-
 	app := fiber.New(
 	// fiber.Config{
 	// 	ErrorHandler: func(c *fiber.Ctx, err error) error {
@@ -123,19 +124,7 @@ func NewFiberApp() *fiber.App {
 	app.Use(cors.New())
 	app.Use(logger.CustomZerologMiddleware)
 
-	app.Group(
-		"/docs",
-		basicauth.New(basicauth.Config{
-			Users: map[string]string{
-				config.Server.AdminDocsUsers[0].Username: config.Server.AdminDocsUsers[0].Password,
-				config.Server.AdminDocsUsers[1].Username: config.Server.AdminDocsUsers[1].Password,
-			},
-			Realm: "Restricted", // hello world
-		}),
-	)
-
-	app.Get("/docs/*", fiberSwagger.WrapHandler)
-
+	// /docs is served with the routes, it is protected by the login of the dashboards
 	app.Get("/", func(c *fiber.Ctx) error {
 		return c.Redirect("/docs/index.html")
 	})
@@ -151,7 +140,7 @@ func New() *App {
 
 	return &App{
 		Logger: logger.SetupLogger(),
-		// Cache:  cache.New(),
+		Cache:  cache.New(),
 		DB:     database.NewDB(),
 		Router: NewFiberApp(),
 		Config: config,
@@ -178,8 +167,17 @@ func initTracer() *sdktrace.TracerProvider {
 }
 
 func (a *App) Run() {
-	controllers := NewControllers(a.DB.Database(a.Config.DB.Database))
+	controllers := NewControllers(a.DB.Database(a.Config.DB.Database), a.Cache)
 	SetupRoutes(a.Router, controllers)
+	if a.Config.Alerts.LowStockCheckIntervalMinutes > 0 {
+		go controllers.Products.RunLowStockChecker(context.Background(), time.Duration(a.Config.Alerts.LowStockCheckIntervalMinutes)*time.Minute)
+	}
+	if a.Config.Expenses.RecurringIntervalMinutes > 0 {
+		go controllers.Expenses.RunRecurringExpenses(context.Background(), time.Duration(a.Config.Expenses.RecurringIntervalMinutes)*time.Minute)
+	}
+	if a.Config.Reconciliation.IntervalMinutes > 0 {
+		go controllers.Finance.RunReconciliationJob(context.Background(), time.Duration(a.Config.Reconciliation.IntervalMinutes)*time.Minute)
+	}
 	a.Router.Listen(a.Config.Server.Port)
 }
 
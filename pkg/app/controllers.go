@@ -7,16 +7,20 @@ import (
 	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/analytics"
 	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/arrivals"
 	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/auth"
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/branches"
 	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/customers"
 	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/customers/bnpl"
 	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/finance"
+	internalexpenses "github.com/aslon1213/g4h_pos_erp/pkg/controllers/internalExpenses"
 	journal_handlers "github.com/aslon1213/g4h_pos_erp/pkg/controllers/journals"
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/ledger"
 	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/products"
 	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/sales"
 	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/suppliers"
 	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/transactions"
 	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
 	"github.com/aslon1213/g4h_pos_erp/pkg/routes"
+	"github.com/aslon1213/g4h_pos_erp/platform/cache"
 	pasetoware "github.com/gofiber/contrib/paseto"
 	"github.com/gofiber/fiber/v2"
 	"github.com/rs/zerolog/log"
@@ -25,6 +29,7 @@ import (
 
 type Controllers struct {
 	Finance      *finance.FinanceController
+	Branches     *branches.BranchesController
 	Suppliers    *suppliers.SuppliersController
 	Transactions *transactions.TransactionsController
 	Sales        *sales.SalesTransactionsController
@@ -37,25 +42,30 @@ type Controllers struct {
 	Middlewares  *middleware.Middlewares
 	Dashboard    *analytics.DashboardHandler
 	Proposals    *arrivals.ProposalsHandlers
+	Ledger       *ledger.LedgerController
+	Expenses     *internalexpenses.InternalExpensesController
 }
 
-func NewControllers(db *mongo.Database) *Controllers {
+func NewControllers(db *mongo.Database, cache *cache.Cache) *Controllers {
 	log.Debug().Msg("Initializing new controllers")
-	middleware := middleware.New(db)
+	middleware := middleware.New(db, cache)
 	controllers := &Controllers{
 		Finance:      finance.New(db),
+		Branches:     branches.New(db),
 		Suppliers:    suppliers.New(db),
 		Transactions: transactions.New(db),
-		Sales:        sales.New(db),
+		Sales:        sales.New(db, cache),
 		Journals:     journal_handlers.New(db),
 		Operations:   journal_handlers.NewOperationsHandler(db),
 		Products:     products.New(db),
-		Auth:         auth.New(db),
+		Auth:         auth.New(db, cache),
 		Customers:    customers.New(db),
 		BNPL:         bnpl.New(db),
 		Middlewares:  middleware,
 		Dashboard:    analytics.New(db),
 		Proposals:    arrivals.New(db),
+		Ledger:       ledger.New(db),
+		Expenses:     internalexpenses.New(db),
 	}
 	log.Debug().Msg("Controllers initialized successfully")
 	return controllers
@@ -71,10 +81,12 @@ func SetupRoutes(app *fiber.App, controllers *Controllers) {
 	if err != nil {
 		log.Fatal().Err(err).Msg("invalid symmetric key")
 	}
-	app.Group("/api", pasetoware.New(
+	app.Group("/api", controllers.Middlewares.APIKeyMiddleware, pasetoware.New(
 		pasetoware.Config{
+			Next:         middleware.HasAPIKey, // authenticated with api key
 			SymmetricKey: keyBytes,
 			// TokenPrefix:    "Bearer",
+			Validate:       middleware.ValidateAccessToken,
 			SuccessHandler: controllers.Middlewares.AuthMiddleware,
 		},
 	))
@@ -88,6 +100,8 @@ func SetupRoutes(app *fiber.App, controllers *Controllers) {
 	log.Debug().Msg("Transactions routes set up successfully")
 	routes.FinanceRoutes(app, controllers.Finance, controllers.Middlewares)
 	log.Debug().Msg("Finance routes set up successfully")
+	routes.BranchesRoutes(app, controllers.Branches, controllers.Middlewares)
+	log.Debug().Msg("Branches routes set up successfully")
 	routes.SalesRoutes(app, controllers.Sales, controllers.Middlewares)
 	log.Debug().Msg("Sales routes set up successfully")
 	routes.JournalsRoutes(app, controllers.Journals, controllers.Operations, controllers.Middlewares)
@@ -98,11 +112,17 @@ func SetupRoutes(app *fiber.App, controllers *Controllers) {
 	log.Debug().Msg("Customer routes set up successfully")
 	routes.BNPLRoutes(app, controllers.BNPL, controllers.Middlewares)
 	log.Debug().Msg("BNPL routes set up successfully")
-	routes.DashboardRoutes(app, controllers.Dashboard, controllers.Middlewares)
+	routes.DashboardRoutes(app, controllers.Dashboard, controllers.Auth, controllers.Middlewares)
 	log.Debug().Msg("Dashboard routes set up successfully")
+	routes.DocsRoutes(app, controllers.Middlewares)
+	log.Debug().Msg("Docs routes set up successfully")
 	routes.ProxyRoutes(app, controllers.Middlewares)
 	log.Debug().Msg("Proxy routes set up successfully")
 	routes.ProposalsRoutes(app, controllers.Proposals, controllers.Middlewares)
 	log.Debug().Msg("Proposals routes set up successfully")
+	routes.LedgerRoutes(app, controllers.Ledger, controllers.Middlewares)
+	log.Debug().Msg("Ledger routes set up successfully")
+	routes.InternalExpensesRoutes(app, controllers.Expenses, controllers.Middlewares)
+	log.Debug().Msg("Internal expenses routes set up successfully")
 	log.Debug().Msg("All routes set up successfully")
 }
@@ -13,10 +13,14 @@ import (
 var ENVT_TYPE_LOGGED bool = false
 
 type Config struct {
-	DB     DBConfig     `mapstructure:"database"`
-	Redis  RedisConfig  `mapstructure:"redis"`
-	Server ServerConfig `mapstructure:"server"`
-	S3     S3Config     `mapstructure:"s3"`
+	DB             DBConfig             `mapstructure:"database"`
+	Redis          RedisConfig          `mapstructure:"redis"`
+	Server         ServerConfig         `mapstructure:"server"`
+	S3             S3Config             `mapstructure:"s3"`
+	Alerts         AlertsConfig         `mapstructure:"alerts"`
+	Security       SecurityConfig       `mapstructure:"security"`
+	Reconciliation ReconciliationConfig `mapstructure:"reconciliation"`
+	Expenses       ExpensesConfig       `mapstructure:"expenses"`
 }
 
 type DBConfig struct {
@@ -47,12 +51,12 @@ type ProxyConfig struct {
 }
 
 type ServerConfig struct {
-	Host               string          `mapstructure:"host"`
-	Port               string          `mapstructure:"port"`
-	SecretSymmetricKey string          `mapstructure:"secret_symmetric_key"`
-	TokenExpiryHours   int             `mapstructure:"token_expiry_hours"`
-	AdminDocsUsers     []AdminDocsUser `mapstructure:"admin_docs_users"`
-	Proxy              []ProxyConfig   `mapstructure:"proxy"`
+	Host               string        `mapstructure:"host"`
+	Port               string        `mapstructure:"port"`
+	SecretSymmetricKey string        `mapstructure:"secret_symmetric_key"`
+	TokenExpiryHours   int           `mapstructure:"token_expiry_hours"`   // lifetime of sessions (refresh tokens)
+	AccessTokenMinutes int           `mapstructure:"access_token_minutes"` // lifetime of access tokens, 15 if not set
+	Proxy              []ProxyConfig `mapstructure:"proxy"`
 }
 
 type S3Config struct {
@@ -63,9 +67,29 @@ type S3Config struct {
 	ImageBucket     string `mapstructure:"image_bucket"`
 }
 
-type AdminDocsUser struct {
-	Username string `mapstructure:"username"`
-	Password string `mapstructure:"password"`
+type AlertsConfig struct {
+	LowStockCheckIntervalMinutes int    `mapstructure:"low_stock_check_interval_minutes"` // 0 disables the background checker
+	LowStockWebhookURL           string `mapstructure:"low_stock_webhook_url"`            // events are only logged if empty
+	AutoCreateProposals          bool   `mapstructure:"auto_create_proposals"`            // create proposals for products crossing the threshold
+}
+
+// ReconciliationConfig configures the background job comparing finances of the branches with their transactions
+type ReconciliationConfig struct {
+	IntervalMinutes int  `mapstructure:"interval_minutes"` // 0 disables the background job
+	AutoRepair      bool `mapstructure:"auto_repair"`      // apply the corrective adjustment when drift is found, otherwise drift is only logged
+}
+
+// ExpensesConfig configures the background job generating expenses of the recurring templates
+type ExpensesConfig struct {
+	RecurringIntervalMinutes int `mapstructure:"recurring_interval_minutes"` // 0 disables the background job
+}
+
+// SecurityConfig configures brute-force protection of the login. Zero values fall back to the defaults in the comments
+type SecurityConfig struct {
+	MaxFailedLoginsPerUser int `mapstructure:"max_failed_logins_per_user"` // failures within the window before the username is locked, 5
+	MaxFailedLoginsPerIP   int `mapstructure:"max_failed_logins_per_ip"`   // failures within the window before the ip is locked, 20
+	FailureWindowMinutes   int `mapstructure:"failure_window_minutes"`     // failures older than the window are forgotten, 15
+	LockoutMinutes         int `mapstructure:"lockout_minutes"`            // 15
 }
 
 func LoadConfig(path string) (*Config, error) {
@@ -123,6 +147,7 @@ func LoadConfig(path string) (*Config, error) {
 		"server.port":                 "SERVER_PORT",
 		"server.secret_symmetric_key": "SERVER_SECRET_SYMMETRIC_KEY",
 		"server.token_expiry_hours":   "SERVER_TOKEN_EXPIRY_HOURS",
+		"server.access_token_minutes": "SERVER_ACCESS_TOKEN_MINUTES",
 	}
 
 	for key, env := range bindings {
@@ -18,6 +18,7 @@ var branches []models.BranchFinance
 
 type DashboardHandler struct {
 	journals *mongo.Collection
+	receipts *mongo.Collection
 	tracer   trace.Tracer
 }
 
@@ -37,6 +38,7 @@ func New(db *mongo.Database) *DashboardHandler {
 	log.Info().Msgf("Fetched %d branches", len(branches))
 	return &DashboardHandler{
 		journals: db.Collection("journals"),
+		receipts: db.Collection("receipts"),
 		tracer:   tracer,
 	}
 }
@@ -72,10 +74,13 @@ func (d *DashboardHandler) MainPage(c *fiber.Ctx) error {
             .card h2 { margin: 0 0 8px 0; font-size: 18px; color: #111827; }
             .card p { margin: 0 0 12px 0; color: #6b7280; }
             .card a { display: inline-block; color: #fff; background: #4f46e5; padding: 10px 14px; border-radius: 8px; text-decoration: none; font-weight: 600; }
+            .logout { text-align: right; }
+            .logout button { color: #4f46e5; background: none; border: 0; font-weight: 600; cursor: pointer; }
         </style>
     </head>
     <body>
         <div class="container">
+            <form class="logout" method="POST" action="/dashboard/logout"><button type="submit">Log out</button></form>
             <div class="header">
                 <h1>Analytics Dashboards</h1>
                 <p>Select a dashboard to view analytics</p>
@@ -0,0 +1,142 @@
+package analytics
+
+import (
+	"time"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+	"github.com/aslon1213/g4h_pos_erp/pkg/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// MarginReportRow is gross margin of a group (product, category, branch or period) of sold items
+type MarginReportRow struct {
+	Key           string  `json:"key" bson:"_id"`
+	Quantity      int64   `json:"quantity" bson:"quantity"`
+	Revenue       int64   `json:"revenue" bson:"revenue"`
+	Cost          int64   `json:"cost" bson:"cost"`
+	Margin        int64   `json:"margin" bson:"margin"`
+	MarginPercent float64 `json:"margin_percent" bson:"margin_percent"`
+}
+
+// margin report groupings
+var marginGroupKeys = map[string]interface{}{
+	"product":  "$line.k",
+	"category": "$category",
+	"branch":   "$branch_id",
+	"day":      bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$created_at", "timezone": utils.GetTimeZone().String()}},
+	"month":    bson.M{"$dateToString": bson.M{"format": "%Y-%m", "date": "$created_at", "timezone": utils.GetTimeZone().String()}},
+}
+
+// GetMarginReport godoc
+// @Security BearerAuth
+// @Summary Gross margin report
+// @Description Revenue, cost and gross margin of sold items grouped by product, category, branch or period (day/month).
+// @Description Cost is the weighted average cost snapshotted on each sale line, returned items are excluded
+// @Tags analytics
+// @Produce json
+// @Param group_by query string false "product, category, branch, day or month" default(product)
+// @Param branch_id query string false "Branch ID"
+// @Param from_date query string false "From date (YYYY-MM-DD)" default(30 days ago)
+// @Param to_date query string false "To date (YYYY-MM-DD)" default(today)
+// @Success 200 {object} models.Output
+// @Failure 400 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/analytics/margins [get]
+func (d *DashboardHandler) GetMarginReport(c *fiber.Ctx) error {
+	ctx, span := d.tracer.Start(c.Context(), "DashboardHandler.GetMarginReport")
+	defer span.End()
+
+	group_by := c.Query("group_by", "product")
+	group_key, ok := marginGroupKeys[group_by]
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Invalid group_by, use one of product, category, branch, day, month",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	from_date, err := time.ParseInLocation("2006-01-02", c.Query("from_date", time.Now().AddDate(0, 0, -30).Format("2006-01-02")), utils.GetTimeZone())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Invalid from date",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	to_date, err := time.ParseInLocation("2006-01-02", c.Query("to_date", time.Now().Format("2006-01-02")), utils.GetTimeZone())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Invalid to date",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	match := bson.M{"created_at": bson.M{"$gte": from_date, "$lt": to_date.AddDate(0, 0, 1)}}
+	if branch_id := c.Query("branch_id"); branch_id != "" {
+		match["branch_id"] = branch_id
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$project", Value: bson.M{
+			"branch_id":  1,
+			"created_at": 1,
+			"returned":   1,
+			"line":       bson.M{"$objectToArray": "$products"},
+		}}},
+		{{Key: "$unwind", Value: "$line"}},
+		// returned quantity of the line is not a sale
+		{{Key: "$set", Value: bson.M{
+			"sold": bson.M{"$subtract": bson.A{
+				"$line.v.quantity",
+				bson.M{"$ifNull": bson.A{bson.M{"$getField": bson.M{"field": "$line.k", "input": "$returned"}}, 0}},
+			}},
+		}}},
+	}
+	if group_by == "category" {
+		pipeline = append(pipeline,
+			bson.D{{Key: "$lookup", Value: bson.M{
+				"from":         "products",
+				"localField":   "line.k",
+				"foreignField": "_id",
+				"as":           "product",
+			}}},
+			bson.D{{Key: "$unwind", Value: "$product"}},
+			bson.D{{Key: "$unwind", Value: "$product.category"}},
+			bson.D{{Key: "$set", Value: bson.M{"category": "$product.category"}}},
+		)
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":      group_key,
+			"quantity": bson.M{"$sum": "$sold"},
+			"revenue":  bson.M{"$sum": bson.M{"$multiply": bson.A{"$sold", "$line.v.price"}}},
+			"cost":     bson.M{"$sum": bson.M{"$multiply": bson.A{"$sold", bson.M{"$ifNull": bson.A{"$line.v.cost", 0}}}}},
+		}}},
+		bson.D{{Key: "$set", Value: bson.M{
+			"margin": bson.M{"$subtract": bson.A{"$revenue", "$cost"}},
+			"margin_percent": bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{"$revenue", 0}},
+				bson.M{"$multiply": bson.A{100, bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{"$revenue", "$cost"}}, "$revenue"}}}},
+				0,
+			}},
+		}}},
+		bson.D{{Key: "$sort", Value: bson.M{"margin": -1}}},
+	)
+
+	cursor, err := d.receipts.Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to aggregate margin report")
+		return models.ReturnError(c, err)
+	}
+	rows := []MarginReportRow{}
+	if err := cursor.All(ctx, &rows); err != nil {
+		log.Error().Err(err).Msg("Failed to decode margin report")
+		return models.ReturnError(c, err)
+	}
+
+	return c.JSON(models.NewOutput(rows))
+}
@@ -19,17 +19,17 @@ import (
 	"go.opentelemetry.io/otel"
 )
 
-var branches = []string{"xonobod", "polevoy"}
-
 type ProposalsHandlers struct {
 	ctx                 context.Context
 	ProposalsCollection *mongo.Collection
+	BranchesCollection  *mongo.Collection
 }
 
 func New(db *mongo.Database) *ProposalsHandlers {
 	return &ProposalsHandlers{
 		ctx:                 context.Background(),
 		ProposalsCollection: db.Collection("proposals"),
+		BranchesCollection:  db.Collection("branches"),
 	}
 }
 
@@ -196,7 +196,7 @@ func (h *ProposalsHandlers) UploadImage(c *fiber.Ctx) error {
 // @Security BearerAuth
 // @Accept json
 // @Produce json
-// @Param branch query string true "Branch name"
+// @Param branch query string true "Branch ID or name"
 // @Param body body []string true "List of proposal names"
 // @Success 200 {object} map[string]interface{} "Proposals created successfully"
 // @Failure 400 {object} map[string]string "Invalid branch or request body"
@@ -207,8 +207,8 @@ func (h *ProposalsHandlers) NewProposals(c *fiber.Ctx) error {
 	ctx, span := tracer.Start(h.ctx, "NewProposals")
 	defer span.End()
 
-	branch := c.Query("branch", c.Params("branch"))
-	if !h.checkBranch(branch) {
+	branch, ok := h.checkBranch(ctx, c.Query("branch", c.Params("branch")))
+	if !ok {
 		log.Error().Str("branch", branch).Msg("new_proposals.invalid_branch")
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid branch",
@@ -265,7 +265,7 @@ func (h *ProposalsHandlers) NewProposals(c *fiber.Ctx) error {
 // @Accept json
 // @Produce json
 // @Param name query string false "Filter by name (case-insensitive)"
-// @Param branch query string false "Filter by branch (case-insensitive)"
+// @Param branch query string false "Filter by branch ID or name (case-insensitive)"
 // @Param fulfilled query string false "Filter by fulfilled status (true/false)" default(false)
 // @Param date_from query string false "Filter by start date (YYYY-MM-DD)"
 // @Param date_to query string false "Filter by end date (YYYY-MM-DD)"
@@ -287,7 +287,7 @@ func (h *ProposalsHandlers) GetProposals(c *fiber.Ctx) error {
 
 	// Branch filter
 	if branch := c.Query("branch"); branch != "" {
-		filter["branch"] = bson.M{"$regex": regexp.QuoteMeta(branch), "$options": "i"}
+		filter["branch"] = h.branchFilter(ctx, branch)
 	}
 
 	// Fulfilled filter
@@ -466,7 +466,14 @@ func (h *ProposalsHandlers) EditProposal(c *fiber.Ctx) error {
 		update["$set"].(bson.M)["name"] = req.Name
 	}
 	if req.Branch != "" {
-		update["$set"].(bson.M)["branch"] = req.Branch
+		branch, ok := h.checkBranch(ctx, req.Branch)
+		if !ok {
+			log.Error().Str("branch", req.Branch).Msg("edit_proposal.invalid_branch")
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid branch",
+			})
+		}
+		update["$set"].(bson.M)["branch"] = branch
 	}
 	if req.Fulfilled != nil {
 		update["$set"].(bson.M)["fulfilled"] = *req.Fulfilled
@@ -551,7 +558,7 @@ func (h *ProposalsHandlers) DeleteProposal(c *fiber.Ctx) error {
 // @Security BearerAuth
 // @Accept json
 // @Produce json
-// @Param branch query string true "Branch name"
+// @Param branch query string true "Branch ID or name"
 // @Param ids query string true "Comma-separated list of proposal IDs"
 // @Success 200 {object} map[string]interface{} "Fulfillment result"
 // @Failure 400 {object} map[string]string "Invalid branch or no valid IDs provided"
@@ -562,8 +569,8 @@ func (h *ProposalsHandlers) FulfillProposals(c *fiber.Ctx) error {
 	ctx, span := tracer.Start(h.ctx, "FulfillProposals")
 	defer span.End()
 
-	branch := c.Query("branch", c.Params("branch"))
-	if !h.checkBranch(branch) {
+	branch, ok := h.checkBranch(ctx, c.Query("branch", c.Params("branch")))
+	if !ok {
 		log.Error().Str("branch", branch).Msg("fulfill_proposals.invalid_branch")
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid branch",
@@ -628,78 +635,43 @@ func (h *ProposalsHandlers) FulfillProposals(c *fiber.Ctx) error {
 	})
 }
 
-// GeneratePDF handles GET /api/proposals/pdf/pdf
-// @Summary Generate PDF
-// @Description Generates a PDF document with unfulfilled proposals
-// @Tags proposals
-// @Security BearerAuth
-// @Accept json
-// @Produce json
-// @Success 200 {object} map[string]interface{} "PDF generation result with proposals data"
-// @Failure 500 {object} map[string]string "Failed to fetch or decode proposals"
-// @Router /api/proposals/pdf/pdf [get]
-func (h *ProposalsHandlers) GeneratePDF(c *fiber.Ctx) error {
-	tracer := otel.Tracer("proposals-handlers")
-	ctx, span := tracer.Start(h.ctx, "GeneratePDF")
-	defer span.End()
-
-	filter := bson.M{"fulfilled": false}
-	cursor, err := h.ProposalsCollection.Find(ctx, filter)
+// checkBranch finds the active branch by its ID or name and returns its ID, proposals reference branches by ID
+func (h *ProposalsHandlers) checkBranch(ctx context.Context, branch string) (string, bool) {
+	found, err := models.FindActiveBranch(ctx, branch, h.BranchesCollection)
 	if err != nil {
-		log.Error().Err(err).Msg("generate_pdf.find_failed")
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to fetch proposals",
-		})
+		log.Warn().Err(err).Str("branch", branch).Msg("check_branch.not_found")
+		return branch, false
 	}
-	defer cursor.Close(ctx)
-
-	var proposals []models.ProductProposal
-	if err := cursor.All(ctx, &proposals); err != nil {
-		log.Error().Err(err).Msg("generate_pdf.decode_failed")
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to decode proposals",
-		})
-	}
-
-	// Format proposals for PDF
-	var formattedProposals []fiber.Map
-	baseURL := os.Getenv("PROPOSALS_BASE_URL")
-	for _, proposal := range proposals {
-		imageFile := ""
-		if proposal.ImageFile != nil && *proposal.ImageFile != "" && baseURL != "" {
-			imageFile = baseURL + *proposal.ImageFile
-		}
+	return found.ID, true
+}
 
-		formattedProposals = append(formattedProposals, fiber.Map{
-			"_id":        proposal.ID.Hex(),
-			"name":       proposal.Name,
-			"date":       proposal.Date.Format("02-01-2006"),
-			"branch":     proposal.Branch,
-			"fulfilled":  proposal.Fulfilled,
-			"image_file": imageFile,
-		})
+// branchFilter matches the proposals of the branch given by its ID or name, unknown branches are searched
+// in the stored values as before
+func (h *ProposalsHandlers) branchFilter(ctx context.Context, branch string) interface{} {
+	if found, err := models.FindBranch(ctx, branch, h.BranchesCollection); err == nil {
+		return found.ID
 	}
-
-	// Render PDF template (this would need to be implemented with a PDF library)
-	// For now, returning JSON response
-	log.Info().Int("proposals_count", len(proposals)).Msg("generate_pdf.success")
-
-	return c.JSON(fiber.Map{
-		"message":   "PDF generation not implemented yet",
-		"proposals": formattedProposals,
-	})
+	return bson.M{"$regex": regexp.QuoteMeta(branch), "$options": "i"}
 }
 
-// Helper function to check branch validity
-func (h *ProposalsHandlers) checkBranch(branch string) bool {
-
-	// Implement branch validation logic here
-	// This is a placeholder implementation
-	validBranches := branches
-	for _, validBranch := range validBranches {
-		if branch == validBranch {
-			return true
-		}
+// branchNames maps the IDs of the branches to their names, unknown IDs are kept as they are
+func (h *ProposalsHandlers) branchNames(ctx context.Context, ids []string) map[string]string {
+	names := map[string]string{}
+	for _, id := range ids {
+		names[id] = id
+	}
+	cursor, err := h.BranchesCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		log.Error().Err(err).Msg("branch_names.find_failed")
+		return names
+	}
+	branches := []models.Branch{}
+	if err := cursor.All(ctx, &branches); err != nil {
+		log.Error().Err(err).Msg("branch_names.decode_failed")
+		return names
+	}
+	for _, branch := range branches {
+		names[branch.ID] = branch.Name
 	}
-	return false
+	return names
 }
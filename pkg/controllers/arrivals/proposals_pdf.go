@@ -0,0 +1,171 @@
+package arrivals
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg" // thumbnails
+	_ "image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+	"github.com/aslon1213/g4h_pos_erp/platform/pdf"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.opentelemetry.io/otel"
+)
+
+// layout of the proposals pdf in points
+const (
+	pdfMargin        = 40.0
+	pdfRowHeight     = 48.0
+	pdfThumbnailSize = 40.0
+	pdfMaxNameLength = 70
+)
+
+// GeneratePDF handles GET /api/proposals/pdf/pdf
+// @Summary Generate PDF
+// @Description Generates a printable PDF of unfulfilled proposals grouped by branch with proposal dates and image thumbnails
+// @Tags proposals
+// @Security BearerAuth
+// @Produce application/pdf
+// @Param branch query string false "Filter by branch ID or name (case-insensitive)"
+// @Param date_from query string false "Filter by start date (YYYY-MM-DD)" default(30 days ago)
+// @Param date_to query string false "Filter by end date (YYYY-MM-DD)" default(today)
+// @Success 200 {file} file "PDF document"
+// @Failure 400 {object} map[string]string "Invalid date format"
+// @Failure 500 {object} map[string]string "Failed to fetch or decode proposals"
+// @Router /api/proposals/pdf/pdf [get]
+func (h *ProposalsHandlers) GeneratePDF(c *fiber.Ctx) error {
+	tracer := otel.Tracer("proposals-handlers")
+	ctx, span := tracer.Start(h.ctx, "GeneratePDF")
+	defer span.End()
+
+	filter := bson.M{"fulfilled": false}
+	if branch := c.Query("branch"); branch != "" {
+		filter["branch"] = h.branchFilter(ctx, branch)
+	}
+
+	date_from := time.Now().Add(-30 * 24 * time.Hour)
+	if value := c.Query("date_from"); value != "" {
+		parsed, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			log.Error().Str("date_from", value).Msg("generate_pdf.invalid_date_from")
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid date_from format. Use YYYY-MM-DD.",
+			})
+		}
+		date_from = parsed
+	}
+	date_to := time.Now()
+	if value := c.Query("date_to"); value != "" {
+		parsed, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			log.Error().Str("date_to", value).Msg("generate_pdf.invalid_date_to")
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid date_to format. Use YYYY-MM-DD.",
+			})
+		}
+		date_to = parsed.Add(24 * time.Hour) // whole day is included
+	}
+	filter["date"] = bson.M{"$gte": date_from, "$lte": date_to}
+
+	opts := options.Find().SetSort(bson.D{{Key: "branch", Value: 1}, {Key: "date", Value: 1}})
+	cursor, err := h.ProposalsCollection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Error().Err(err).Msg("generate_pdf.find_failed")
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch proposals",
+		})
+	}
+	defer cursor.Close(ctx)
+
+	var proposals []models.ProductProposal
+	if err := cursor.All(ctx, &proposals); err != nil {
+		log.Error().Err(err).Msg("generate_pdf.decode_failed")
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to decode proposals",
+		})
+	}
+
+	// group by branch
+	by_branch := map[string][]models.ProductProposal{}
+	branch_ids := []string{}
+	for _, proposal := range proposals {
+		if _, ok := by_branch[proposal.Branch]; !ok {
+			branch_ids = append(branch_ids, proposal.Branch)
+		}
+		by_branch[proposal.Branch] = append(by_branch[proposal.Branch], proposal)
+	}
+	names := h.branchNames(ctx, branch_ids)
+	sort.Slice(branch_ids, func(i, j int) bool { return names[branch_ids[i]] < names[branch_ids[j]] })
+
+	document := pdf.New()
+	document.AddPage()
+	y := pdfMargin + 10
+	document.Text(pdfMargin, y, 18, true, "Proposals")
+	y += 18
+	document.Text(pdfMargin, y, 10, false, fmt.Sprintf("%s - %s, %d proposals, generated %s",
+		date_from.Format("02-01-2006"), date_to.Add(-time.Second).Format("02-01-2006"), len(proposals), time.Now().Format("02-01-2006 15:04")))
+	y += 20
+
+	// newRow moves to the next page if the row does not fit
+	newRow := func(height float64) {
+		if y+height > pdf.PageHeight-pdfMargin {
+			document.AddPage()
+			y = pdfMargin
+		}
+	}
+
+	for _, branch := range branch_ids {
+		newRow(30 + pdfRowHeight)
+		y += 16
+		document.Text(pdfMargin, y, 14, true, fmt.Sprintf("%s (%d)", names[branch], len(by_branch[branch])))
+		y += 6
+		document.Line(pdfMargin, y, pdf.PageWidth-pdfMargin, y)
+		y += 6
+
+		for i, proposal := range by_branch[branch] {
+			newRow(pdfRowHeight)
+			if proposal.ImageFile != nil && *proposal.ImageFile != "" {
+				if err := h.drawThumbnail(document, *proposal.ImageFile, pdfMargin, y+4); err != nil {
+					log.Warn().Err(err).Str("proposal_id", proposal.ID.Hex()).Msg("generate_pdf.thumbnail_failed")
+				}
+			}
+			name := []rune(proposal.Name)
+			if len(name) > pdfMaxNameLength {
+				name = append(name[:pdfMaxNameLength-3], []rune("...")...)
+			}
+			document.Text(pdfMargin+pdfThumbnailSize+12, y+pdfRowHeight/2+4, 11, false, fmt.Sprintf("%d. %s", i+1, string(name)))
+			document.Text(pdf.PageWidth-pdfMargin-60, y+pdfRowHeight/2+4, 10, false, proposal.Date.Format("02-01-2006"))
+			y += pdfRowHeight
+		}
+	}
+
+	log.Info().Int("proposals_count", len(proposals)).Int("branches_count", len(branch_ids)).Msg("generate_pdf.success")
+
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("inline; filename=\"proposals-%s.pdf\"", time.Now().Format("2006-01-02")))
+	return c.Send(document.Bytes())
+}
+
+// drawThumbnail draws the image of the proposal from the images directory
+func (h *ProposalsHandlers) drawThumbnail(document *pdf.Document, image_file string, x, y float64) error {
+	file, err := os.Open(filepath.Join("images", filepath.Base(image_file)))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return err
+	}
+	return document.Image(img, x, y, pdfThumbnailSize, pdfThumbnailSize, 160)
+}
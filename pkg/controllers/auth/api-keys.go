@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func apiKeyNotFound(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+		Message: "API key not found",
+		Code:    fiber.StatusNotFound,
+	}))
+}
+
+// CreateAPIKey godoc
+// @Summary Create API key
+// @Security BearerAuth
+// @Description Issues a named api key with scopes for an integration (kiosk, export job ...). Reads need read scopes (products:read, sales:read, finance:read, suppliers:read, customers:read, reports:read), writes need the permission of the route as scope. The key is sent in the X-API-Key header and is returned only once
+// @Tags api-keys
+// @Accept json
+// @Produce json
+// @Param input body models.APIKeyInput true "Name, scopes, branch and expiry of the key"
+// @Success 201 {object} models.APIKeyCreatedOutput
+// @Failure 400 {object} models.Output
+// @Router /api/api-keys [post]
+func (a *AuthControllers) CreateAPIKey(c *fiber.Ctx) error {
+	input := models.APIKeyInput{}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if err := input.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	created_by, _ := c.Locals("user").(string)
+	api_key, key, err := models.NewAPIKey(input, created_by)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate api key")
+		return models.ReturnError(c, err)
+	}
+	if _, err := a.APIKeysCollection.InsertOne(c.Context(), api_key); err != nil {
+		log.Error().Err(err).Msg("Failed to insert api key")
+		return models.ReturnError(c, err)
+	}
+
+	log.Info().Str("api_key", api_key.Name).Str("prefix", api_key.Prefix).Msg("API key created")
+	c.Status(fiber.StatusCreated)
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeCreateAPIKey, api_key, a.ActivitiesCollection)
+	return c.JSON(models.APIKeyCreatedOutput{
+		Data:  []models.APIKey{*api_key},
+		Key:   key,
+		Error: []models.Error{},
+	})
+}
+
+// QueryAPIKeys godoc
+// @Summary Query API keys
+// @Security BearerAuth
+// @Description Lists the api keys with their scopes, expiry and last use
+// @Tags api-keys
+// @Produce json
+// @Param revoked query bool false "Filter by revoked"
+// @Success 200 {object} models.APIKeysOutput
+// @Router /api/api-keys [get]
+func (a *AuthControllers) QueryAPIKeys(c *fiber.Ctx) error {
+	params := models.APIKeysQueryParams{}
+	if err := c.QueryParser(&params); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	filter := bson.M{}
+	if params.Revoked != nil {
+		filter["revoked"] = *params.Revoked
+	}
+
+	cursor, err := a.APIKeysCollection.Find(c.Context(), filter, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query api keys")
+		return models.ReturnError(c, err)
+	}
+	api_keys := []models.APIKey{}
+	if err := cursor.All(c.Context(), &api_keys); err != nil {
+		log.Error().Err(err).Msg("Failed to decode api keys")
+		return models.ReturnError(c, err)
+	}
+	return c.JSON(models.NewOutput(api_keys))
+}
+
+// GetAPIKeyByID godoc
+// @Summary Get API key
+// @Security BearerAuth
+// @Tags api-keys
+// @Produce json
+// @Param id path string true "API key ID"
+// @Success 200 {object} models.APIKeysOutput
+// @Failure 404 {object} models.Output
+// @Router /api/api-keys/{id} [get]
+func (a *AuthControllers) GetAPIKeyByID(c *fiber.Ctx) error {
+	api_key := models.APIKey{}
+	err := a.APIKeysCollection.FindOne(c.Context(), bson.M{"_id": c.Params("id")}).Decode(&api_key)
+	if err == mongo.ErrNoDocuments {
+		return apiKeyNotFound(c)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get api key")
+		return models.ReturnError(c, err)
+	}
+	return c.JSON(models.NewOutput([]models.APIKey{api_key}))
+}
+
+// RevokeAPIKey godoc
+// @Summary Revoke API key
+// @Security BearerAuth
+// @Description Revokes the api key, requests with it are rejected immediately. Revoked keys are kept for the audit
+// @Tags api-keys
+// @Produce json
+// @Param id path string true "API key ID"
+// @Success 200 {object} models.APIKeysOutput
+// @Failure 404 {object} models.Output
+// @Router /api/api-keys/{id} [delete]
+func (a *AuthControllers) RevokeAPIKey(c *fiber.Ctx) error {
+	api_key := models.APIKey{}
+	err := a.APIKeysCollection.FindOneAndUpdate(
+		c.Context(),
+		bson.M{"_id": c.Params("id"), "revoked": false},
+		bson.M{"$set": bson.M{"revoked": true, "revoked_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&api_key)
+	if err == mongo.ErrNoDocuments {
+		return apiKeyNotFound(c)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to revoke api key")
+		return models.ReturnError(c, err)
+	}
+
+	log.Info().Str("api_key", api_key.Name).Str("prefix", api_key.Prefix).Msg("API key revoked")
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeRevokeAPIKey, fiber.Map{
+		"id":     api_key.ID,
+		"name":   api_key.Name,
+		"prefix": api_key.Prefix,
+	}, a.ActivitiesCollection)
+	return c.JSON(models.NewOutput([]models.APIKey{api_key}))
+}
@@ -2,32 +2,39 @@ package auth
 
 import (
 	"context"
-	"encoding/base64"
+	"strconv"
 	"time"
 
 	"github.com/aslon1213/g4h_pos_erp/pkg/configs"
 	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
 	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+	"github.com/aslon1213/g4h_pos_erp/platform/cache"
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
-	"golang.org/x/crypto/bcrypt"
 
-	pasetoware "github.com/gofiber/contrib/paseto"
 	"github.com/rs/zerolog/log"
 )
 
 // AuthControllers handles authentication-related operations
 type AuthControllers struct {
 	UserCollection       *mongo.Collection
+	BranchesCollection   *mongo.Collection
 	ActivitiesCollection *mongo.Collection
+	APIKeysCollection    *mongo.Collection
+	TerminalsCollection  *mongo.Collection
+	ShiftsCollection     *mongo.Collection // terminal shifts
+	BootstrapCollection  *mongo.Collection // holds the marker of the first admin registration
 	SecretSymmetricKey   string
-	TokenExpiryHours     int
+	TokenExpiryHours     int // lifetime of sessions
+	AccessTokenMinutes   int
+	LoginPolicy          models.LoginPolicy
+	Cache                *cache.Cache
 }
 
 // New initializes a new AuthControllers instance
-func New(db *mongo.Database) *AuthControllers {
+func New(db *mongo.Database, cache *cache.Cache) *AuthControllers {
 
 	users_collection := db.Collection("users")
 	users_collection.Indexes().CreateOne(
@@ -40,15 +47,34 @@ func New(db *mongo.Database) *AuthControllers {
 		},
 	)
 
+	api_keys_collection := db.Collection("api_keys")
+	api_keys_collection.Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{
+			Keys: bson.M{
+				"prefix": 1,
+			},
+			Options: options.Index().SetUnique(true),
+		},
+	)
+
 	config, err := configs.LoadConfig(".")
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to load config")
 	}
 	return &AuthControllers{
 		UserCollection:       users_collection,
+		BranchesCollection:   db.Collection("branches"),
 		ActivitiesCollection: db.Collection("activities"),
+		APIKeysCollection:    api_keys_collection,
+		TerminalsCollection:  db.Collection("terminals"),
+		ShiftsCollection:     db.Collection("terminal_shifts"),
+		BootstrapCollection:  db.Collection("bootstrap"),
 		SecretSymmetricKey:   config.Server.SecretSymmetricKey,
 		TokenExpiryHours:     config.Server.TokenExpiryHours,
+		AccessTokenMinutes:   config.Server.AccessTokenMinutes,
+		LoginPolicy:          loginPolicy(config.Security),
+		Cache:                cache,
 	}
 }
 
@@ -81,18 +107,14 @@ func (a *AuthControllers) InfoMe(c *fiber.Ctx) error {
 // @Accept json
 // @Produce json
 // @Param user body LoginInput true "User credentials"
-// @Success 200 {object} map[string]string "token"
+// @Success 200 {object} models.TokenPairOutput "access token in data and refresh token"
 // @Failure 500 {string} string "Internal Server Error"
 // @Failure 401 {string} string "Unauthorized"
+// @Failure 429 {object} models.Output "Too many failed logins"
 // @Router /auth/login [post]
 func (a *AuthControllers) Login(c *fiber.Ctx) error {
 	var user_to_check LoginInput
 
-	config, err := configs.LoadConfig(".")
-	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to load config")
-	}
-
 	if err := c.BodyParser(&user_to_check); err != nil {
 		log.Error().Err(err).Msg("Failed to parse request body")
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -107,63 +129,49 @@ func (a *AuthControllers) Login(c *fiber.Ctx) error {
 		})
 	}
 
-	pass := user_to_check.Password
-
-	// check in the database if the user exists
-	user_db := models.User{}
-	err = a.UserCollection.FindOne(c.Context(), bson.M{"username": user_to_check.Username}).Decode(&user_db)
-
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to find user")
-		// no user found
-		middleware.LogActivityWithCtx(c, middleware.ActivityTypeLogin, fiber.Map{
-			"error": "User not found",
-		}, a.ActivitiesCollection)
-
-		return c.SendStatus(fiber.StatusUnauthorized)
-	}
-	c.Locals("user", user_db.Username)
-
-	equal := bcrypt.CompareHashAndPassword([]byte(user_db.Password), []byte(pass))
-	if equal != nil {
-		log.Warn().Msg("Unauthorized access attempt")
-		middleware.LogActivityWithCtx(c, middleware.ActivityTypeLogin, fiber.Map{
-			"error": "Invalid password",
-		}, a.ActivitiesCollection)
-		return c.SendStatus(fiber.StatusUnauthorized)
+	user_db, login_err := a.authenticate(c, user_to_check.Username, user_to_check.Password)
+	if login_err != nil {
+		if login_err.Status != fiber.StatusTooManyRequests {
+			return c.SendStatus(login_err.Status)
+		}
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(login_err.RetryAfter.Seconds())+1))
+		return c.Status(fiber.StatusTooManyRequests).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: login_err.Message,
+			Code:    fiber.StatusTooManyRequests,
+		}))
 	}
 
-	// Create token and encrypt it
-	keyBytes, err := base64.StdEncoding.DecodeString(a.SecretSymmetricKey)
-	encryptedToken, err := pasetoware.CreateToken(keyBytes, user_to_check.Username, time.Duration(a.TokenExpiryHours)*time.Hour, pasetoware.PurposeLocal)
+	// Create session and its tokens
+	session, refresh_token, err := models.NewAuthSession(user_db.Username, c.Get(fiber.HeaderUserAgent), c.IP(), a.sessionTTL(), a.Cache)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to create token")
+		log.Error().Err(err).Msg("Failed to create session")
 
 		return c.SendStatus(fiber.StatusInternalServerError)
 	}
-
-	payload, err := pasetoware.NewPayload(
-		encryptedToken,
-		time.Duration(config.Server.TokenExpiryHours)*time.Hour,
-	)
+	tokens, err := a.issueTokens(session, refresh_token)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to create payload")
+		log.Error().Err(err).Msg("Failed to create token")
 
 		return c.SendStatus(fiber.StatusInternalServerError)
 	}
 
-	log.Info().Str("username", user_to_check.Username).Msg("User logged in successfully")
-	return c.JSON(payload)
+	log.Info().Str("username", user_to_check.Username).Str("session_id", session.ID).Msg("User logged in successfully")
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeLogin, fiber.Map{
+		"session_id": session.ID,
+		"device":     session.Device,
+	}, a.ActivitiesCollection)
+	return c.JSON(tokens)
 }
 
 // Register handles user registration
-// @Summary Register a new user
-// @Description Create a new user account
+// @Summary Register the first admin
+// @Description Creates the first user of the system as admin. Once any user exists registration is closed --- admins create users with POST /api/users
 // @Tags auth
 // @Accept json
 // @Produce json
-// @Param user body models.User true "User credentials"
+// @Param user body models.UserRegisterInput true "User credentials"
 // @Success 201 {string} string "Created"
+// @Failure 403 {object} models.Output "Registration is closed"
 // @Failure 500 {string} string "Internal Server Error"
 // @Router /auth/register [post]
 func (a *AuthControllers) Register(c *fiber.Ctx) error {
@@ -175,41 +183,82 @@ func (a *AuthControllers) Register(c *fiber.Ctx) error {
 		log.Error().Err(err).Msg("Failed to parse user")
 		return c.SendStatus(fiber.StatusInternalServerError)
 	}
-	// validate the user
-
-	// log the action
 	c.Locals("user", user.Username)
-	middleware.LogActivityWithCtx(c, middleware.ActivityTypeRegister, fiber.Map{
-		"username": user.Username,
-		"email":    user.Email,
-		"role":     user.Role,
-		"phone":    user.Phone,
-		"branch":   user.Branch,
-	}, a.ActivitiesCollection)
 
-	// Hash the password using bcrypt
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	// registration is open only to bootstrap the first admin
+	users_count, err := a.UserCollection.CountDocuments(c.Context(), bson.M{})
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to hash password")
-
+		log.Error().Err(err).Msg("Failed to count users")
 		return c.SendStatus(fiber.StatusInternalServerError)
 	}
+	closed := users_count > 0
+	if !closed {
+		// concurrent registrations may all count no users --- only the one inserting the marker goes on
+		_, err = a.BootstrapCollection.InsertOne(c.Context(), bson.M{"_id": bootstrapMarkerID, "username": user.Username, "created_at": time.Now()})
+		if mongo.IsDuplicateKeyError(err) {
+			closed = true
+		} else if err != nil {
+			log.Error().Err(err).Msg("Failed to insert bootstrap marker")
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+	}
+	if closed {
+		log.Warn().Str("username", user.Username).Msg("Registration attempt while registration is closed")
+		c.Status(fiber.StatusForbidden)
+		middleware.LogActivityWithCtx(c, middleware.ActivityTypeRegisterFailed, fiber.Map{
+			"username": user.Username,
+			"role":     user.Role,
+			"error":    "Registration is closed",
+		}, a.ActivitiesCollection)
+		return c.JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Registration is closed, ask an admin to create your account",
+			Code:    fiber.StatusForbidden,
+		}))
+	}
+	user.Role = models.RoleAdmin
+	user.Branch = ""
 
-	// Create a new user document
-	newUser := models.NewUser(user.Username, string(hashedPassword), user.Email, user.Role, user.Phone, user.Branch)
+	// validate the user
+	if err := user.Validate(); err != nil {
+		a.releaseBootstrap(c.Context())
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
 
-	// Insert the new user into the database
-	_, err = a.UserCollection.InsertOne(c.Context(), newUser)
+	// Create a new user document
+	newUser, err := a.createUser(c, &user)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to register user")
-
+		a.releaseBootstrap(c.Context())
 		return c.SendStatus(fiber.StatusInternalServerError)
 	}
 
+	// log the action
+	c.Status(fiber.StatusCreated)
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeRegister, fiber.Map{
+		"user_id":  newUser.ID,
+		"username": newUser.Username,
+		"email":    newUser.Email,
+		"role":     newUser.Role,
+		"phone":    newUser.Phone,
+	}, a.ActivitiesCollection)
+
 	log.Info().Str("username", user.Username).Msg("User registered successfully")
 	return c.SendStatus(fiber.StatusCreated)
 }
 
+// bootstrapMarkerID is the _id of the marker inserted by the registration of the first admin
+const bootstrapMarkerID = "first_admin"
+
+// releaseBootstrap removes the marker so that registration can be retried after a failed first registration
+func (a *AuthControllers) releaseBootstrap(ctx context.Context) {
+	if _, err := a.BootstrapCollection.DeleteOne(ctx, bson.M{"_id": bootstrapMarkerID}); err != nil {
+		log.Error().Err(err).Msg("Failed to release bootstrap marker")
+	}
+}
+
 // GetRecentActivities godoc
 // @Summary Get recent activities
 // @Security BearerAuth
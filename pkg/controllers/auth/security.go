@@ -0,0 +1,346 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/configs"
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+	"github.com/aslon1213/g4h_pos_erp/pkg/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func loginPolicy(config configs.SecurityConfig) models.LoginPolicy {
+	policy := models.LoginPolicy{
+		MaxUserFailures: config.MaxFailedLoginsPerUser,
+		MaxIPFailures:   config.MaxFailedLoginsPerIP,
+		Window:          time.Duration(config.FailureWindowMinutes) * time.Minute,
+		Lockout:         time.Duration(config.LockoutMinutes) * time.Minute,
+	}
+	if policy.MaxUserFailures <= 0 {
+		policy.MaxUserFailures = 5
+	}
+	if policy.MaxIPFailures <= 0 {
+		policy.MaxIPFailures = 20
+	}
+	if policy.Window <= 0 {
+		policy.Window = 15 * time.Minute
+	}
+	if policy.Lockout <= 0 {
+		policy.Lockout = 15 * time.Minute
+	}
+	return policy
+}
+
+// loginError is a rejected login. The failure is already counted and logged when it is returned
+type loginError struct {
+	Status     int
+	Message    string
+	RetryAfter time.Duration // of lockouts
+}
+
+// authenticate checks the lockouts, the credentials and whether the user is disabled. It is shared by the login of the api
+// and the login page of the dashboards so that both are protected the same way
+func (a *AuthControllers) authenticate(c *fiber.Ctx, username string, password string) (*models.User, *loginError) {
+	c.Locals("user", username)
+
+	// locked usernames and ips are rejected before the password is checked
+	locked_for, err := models.LoginLockedFor(username, c.IP(), a.Cache)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to check login lockout")
+		return nil, &loginError{Status: fiber.StatusInternalServerError, Message: "Internal Server Error"}
+	}
+	if locked_for > 0 {
+		log.Warn().Str("username", username).Str("ip", c.IP()).Dur("locked_for", locked_for).Msg("Login attempt while locked")
+		c.Status(fiber.StatusTooManyRequests)
+		middleware.LogActivityWithCtx(c, middleware.ActivityTypeLoginFailed, fiber.Map{
+			"error": "Login is locked",
+		}, a.ActivitiesCollection)
+		return nil, &loginError{
+			Status:     fiber.StatusTooManyRequests,
+			Message:    "Too many failed logins, try again later",
+			RetryAfter: locked_for,
+		}
+	}
+
+	// check in the database if the user exists
+	user := models.User{}
+	if err := a.UserCollection.FindOne(c.Context(), bson.M{"username": username}).Decode(&user); err != nil {
+		log.Error().Err(err).Msg("Failed to find user")
+		// no user found --- counted like a wrong password so that usernames can not be probed
+		return nil, a.loginFailed(c, username, c.IP(), "User not found")
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		log.Warn().Msg("Unauthorized access attempt")
+		return nil, a.loginFailed(c, user.Username, c.IP(), "Invalid password")
+	}
+
+	// checked after the password so that only the owner of the account learns that it is disabled
+	if user.Disabled {
+		log.Warn().Str("username", user.Username).Msg("Login attempt of disabled user")
+		c.Status(fiber.StatusForbidden)
+		middleware.LogActivityWithCtx(c, middleware.ActivityTypeLoginFailed, fiber.Map{
+			"error": "User is disabled",
+		}, a.ActivitiesCollection)
+		return nil, &loginError{Status: fiber.StatusForbidden, Message: "User is disabled"}
+	}
+	if err := models.ResetFailedLogins(user.Username, a.Cache); err != nil {
+		log.Error().Err(err).Msg("Failed to reset failed logins")
+	}
+	return &user, nil
+}
+
+// loginFailed counts the failed login, starts lockouts and delays the response progressively.
+// Source is the ip of password logins and the terminal of PIN switches
+func (a *AuthControllers) loginFailed(c *fiber.Ctx, username string, source string, reason string) *loginError {
+	failures, lockouts, err := models.RegisterFailedLogin(username, source, a.LoginPolicy, a.Cache)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to count failed login")
+	}
+
+	c.Status(fiber.StatusUnauthorized)
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeLoginFailed, fiber.Map{
+		"error":    reason,
+		"failures": failures,
+	}, a.ActivitiesCollection)
+	for _, lockout := range lockouts {
+		log.Warn().Str("kind", string(lockout.Kind)).Str("value", lockout.Value).Time("expires_at", lockout.ExpiresAt).Msg("Login locked")
+		middleware.LogActivityWithCtx(c, middleware.ActivityTypeLoginLockout, lockout, a.ActivitiesCollection)
+	}
+
+	time.Sleep(a.LoginPolicy.Delay(failures))
+	return &loginError{Status: fiber.StatusUnauthorized, Message: "Invalid username or password"}
+}
+
+// GetLoginLockouts godoc
+// @Summary Get login lockouts
+// @Security BearerAuth
+// @Description Lists the usernames and ips which are locked out of login because of failed logins
+// @Tags security
+// @Produce json
+// @Success 200 {object} models.LoginLockoutsOutput
+// @Router /api/security/lockouts [get]
+func (a *AuthControllers) GetLoginLockouts(c *fiber.Ctx) error {
+	lockouts, err := models.GetLoginLockouts(a.Cache)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get login lockouts")
+		return models.ReturnError(c, err)
+	}
+	return c.JSON(models.NewOutput(lockouts))
+}
+
+// UnlockLogin godoc
+// @Summary Unlock login
+// @Security BearerAuth
+// @Description Removes the lockout and the failed login counter of a username or an ip
+// @Tags security
+// @Accept json
+// @Produce json
+// @Param input body models.UnlockLoginInput true "Kind (user or ip) and value"
+// @Success 200 {object} models.Output
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Router /api/security/unlock [post]
+func (a *AuthControllers) UnlockLogin(c *fiber.Ctx) error {
+	input := models.UnlockLoginInput{}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if (input.Kind != models.LockoutKindUser && input.Kind != models.LockoutKindIP) || input.Value == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "kind must be user or ip and value is required",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	return a.unlock(c, input.Kind, input.Value)
+}
+
+// UnlockUser godoc
+// @Summary Unlock login of user
+// @Security BearerAuth
+// @Tags users
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Router /api/users/{id}/unlock [post]
+func (a *AuthControllers) UnlockUser(c *fiber.Ctx) error {
+	user, err := a.findUser(c, c.Params("id"))
+	if err == mongo.ErrNoDocuments {
+		return userNotFound(c)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to find user")
+		return models.ReturnError(c, err)
+	}
+	return a.unlock(c, models.LockoutKindUser, user.Username)
+}
+
+func (a *AuthControllers) unlock(c *fiber.Ctx, kind models.LockoutKind, value string) error {
+	unlocked, err := models.UnlockLogin(kind, value, a.Cache)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to unlock login")
+		return models.ReturnError(c, err)
+	}
+	if !unlocked {
+		return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "No lockout or failed logins found",
+			Code:    fiber.StatusNotFound,
+		}))
+	}
+
+	log.Info().Str("kind", string(kind)).Str("value", value).Msg("Login unlocked")
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeUnlockLogin, fiber.Map{
+		"kind":  kind,
+		"value": value,
+	}, a.ActivitiesCollection)
+	return c.JSON(models.NewOutput([]models.UnlockLoginInput{{Kind: kind, Value: value}}))
+}
+
+// GetSecurityReport godoc
+// @Summary Security report
+// @Security BearerAuth
+// @Description Summarizes failed logins from the activities: bursts of failures of the same username from the same ip within a time window, most attacked usernames, most failing ips and the active lockouts
+// @Tags security
+// @Produce json
+// @Param from_date query string false "From date (YYYY-MM-DD)" default(7 days ago)
+// @Param to_date query string false "To date (YYYY-MM-DD)" default(today)
+// @Param window_minutes query int false "Burst window in minutes" default(10)
+// @Param threshold query int false "Minimum failed attempts within the window to be reported as burst" default(5)
+// @Success 200 {object} models.SecurityReport
+// @Failure 400 {object} models.Output
+// @Router /api/security/report [get]
+func (a *AuthControllers) GetSecurityReport(c *fiber.Ctx) error {
+	params := models.SecurityReportQueryParams{}
+	if err := c.QueryParser(&params); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if params.WindowMinutes <= 0 {
+		params.WindowMinutes = 10
+	}
+	if params.Threshold <= 0 {
+		params.Threshold = 5
+	}
+	if params.FromDate == "" {
+		params.FromDate = time.Now().AddDate(0, 0, -7).Format("2006-01-02")
+	}
+	if params.ToDate == "" {
+		params.ToDate = time.Now().Format("2006-01-02")
+	}
+	from_date, err := time.ParseInLocation("2006-01-02", params.FromDate, utils.GetTimeZone())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Invalid from_date, expected YYYY-MM-DD",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	to_date, err := time.ParseInLocation("2006-01-02", params.ToDate, utils.GetTimeZone())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Invalid to_date, expected YYYY-MM-DD",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	to_date = to_date.AddDate(0, 0, 1) // whole day is included
+
+	window_ms := int64(params.WindowMinutes) * int64(time.Minute/time.Millisecond)
+	date_ms := bson.M{"$toLong": "$date"}
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{
+			"date": bson.M{"$gte": from_date, "$lt": to_date},
+			"$or": bson.A{
+				bson.M{"action": middleware.ActivityTypeLoginFailed},
+				// failures were logged as login_success with an error before login_failed was used
+				bson.M{"action": middleware.ActivityTypeLogin, "data.error": bson.M{"$exists": true}},
+			},
+		}},
+		bson.M{"$facet": bson.M{
+			"total": bson.A{bson.M{"$count": "count"}},
+			"bursts": bson.A{
+				bson.M{"$group": bson.M{
+					"_id": bson.M{
+						"username": "$user_id",
+						"ip":       "$ip",
+						"window":   bson.M{"$subtract": bson.A{date_ms, bson.M{"$mod": bson.A{date_ms, window_ms}}}},
+					},
+					"attempts": bson.M{"$sum": 1},
+					"first":    bson.M{"$min": "$date"},
+					"last":     bson.M{"$max": "$date"},
+				}},
+				bson.M{"$match": bson.M{"attempts": bson.M{"$gte": params.Threshold}}},
+				bson.M{"$project": bson.M{
+					"_id":          0,
+					"username":     "$_id.username",
+					"ip":           "$_id.ip",
+					"window_start": bson.M{"$toDate": "$_id.window"},
+					"attempts":     1,
+					"first":        1,
+					"last":         1,
+				}},
+				bson.M{"$sort": bson.D{{Key: "attempts", Value: -1}, {Key: "window_start", Value: -1}}},
+				bson.M{"$limit": 100},
+			},
+			"by_username": bson.A{
+				bson.M{"$group": bson.M{"_id": "$user_id", "attempts": bson.M{"$sum": 1}, "last": bson.M{"$max": "$date"}}},
+				bson.M{"$sort": bson.M{"attempts": -1}},
+				bson.M{"$limit": 25},
+			},
+			"by_ip": bson.A{
+				bson.M{"$group": bson.M{"_id": "$ip", "attempts": bson.M{"$sum": 1}, "usernames": bson.M{"$addToSet": "$user_id"}, "last": bson.M{"$max": "$date"}}},
+				bson.M{"$sort": bson.M{"attempts": -1}},
+				bson.M{"$limit": 25},
+			},
+		}},
+	}
+
+	cursor, err := a.ActivitiesCollection.Aggregate(c.Context(), pipeline)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to aggregate failed logins")
+		return models.ReturnError(c, err)
+	}
+	results := []struct {
+		Total      []struct{ Count int }      `bson:"total"`
+		Bursts     []models.FailedLoginBurst  `bson:"bursts"`
+		ByUsername []models.FailedLoginsCount `bson:"by_username"`
+		ByIP       []models.FailedLoginsCount `bson:"by_ip"`
+	}{}
+	if err := cursor.All(c.Context(), &results); err != nil {
+		log.Error().Err(err).Msg("Failed to decode failed logins")
+		return models.ReturnError(c, err)
+	}
+
+	report := models.SecurityReport{
+		From:       from_date,
+		To:         to_date,
+		Bursts:     []models.FailedLoginBurst{},
+		ByUsername: []models.FailedLoginsCount{},
+		ByIP:       []models.FailedLoginsCount{},
+	}
+	if len(results) > 0 {
+		if len(results[0].Total) > 0 {
+			report.TotalFailures = results[0].Total[0].Count
+		}
+		report.Bursts = append(report.Bursts, results[0].Bursts...)
+		report.ByUsername = append(report.ByUsername, results[0].ByUsername...)
+		report.ByIP = append(report.ByIP, results[0].ByIP...)
+	}
+	report.Lockouts, err = models.GetLoginLockouts(a.Cache)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get login lockouts")
+		return models.ReturnError(c, err)
+	}
+
+	return c.JSON(models.NewOutput([]models.SecurityReport{report}))
+}
@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"encoding/base64"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	pasetoware "github.com/gofiber/contrib/paseto"
+	"github.com/gofiber/fiber/v2"
+	"github.com/o1egl/paseto"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func (a *AuthControllers) sessionTTL() time.Duration {
+	return time.Duration(a.TokenExpiryHours) * time.Hour
+}
+
+func (a *AuthControllers) accessTokenTTL() time.Duration {
+	if a.AccessTokenMinutes <= 0 {
+		return 15 * time.Minute
+	}
+	return time.Duration(a.AccessTokenMinutes) * time.Minute
+}
+
+// issueTokens creates a short-lived access token of the session. The username stays in the data claim as before,
+// the session id is added so that the token dies with its session
+func (a *AuthControllers) issueTokens(session *models.AuthSession, refresh_token string) (*models.TokenPairOutput, error) {
+	key, err := base64.StdEncoding.DecodeString(a.SecretSymmetricKey)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := pasetoware.NewPayload(session.Username, a.accessTokenTTL())
+	if err != nil {
+		return nil, err
+	}
+	payload.Set("session_id", session.ID)
+	if session.TerminalID != "" {
+		payload.Set("terminal_id", session.TerminalID)
+	}
+	token, err := paseto.NewV2().Encrypt(key, payload, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &models.TokenPairOutput{
+		Data:         token,
+		RefreshToken: refresh_token,
+		SessionID:    session.ID,
+		ExpiresAt:    payload.Expiration,
+	}, nil
+}
+
+// Refresh godoc
+// @Summary Refresh access token
+// @Description Exchanges the refresh token for a new access token and a new refresh token. Every refresh token can be used once, reusing one revokes the session
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param input body models.RefreshTokenInput true "Refresh token"
+// @Success 200 {object} models.TokenPairOutput
+// @Failure 401 {object} models.Output
+// @Router /auth/refresh [post]
+func (a *AuthControllers) Refresh(c *fiber.Ctx) error {
+	input := models.RefreshTokenInput{}
+	if err := c.BodyParser(&input); err != nil || input.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "refresh_token is required",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	session, refresh_token, err := models.RefreshAuthSession(input.RefreshToken, c.IP(), a.Cache)
+	if err == models.ErrAuthSessionNotFound || err == models.ErrInvalidRefreshToken {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusUnauthorized,
+		}))
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to refresh session")
+		return models.ReturnError(c, err)
+	}
+
+	// disabled users can not refresh their sessions
+	user := models.User{}
+	if err := a.UserCollection.FindOne(c.Context(), bson.M{"username": session.Username}).Decode(&user); err != nil || user.Disabled {
+		models.DeleteAuthSession(session.Username, session.ID, a.Cache)
+		return c.Status(fiber.StatusUnauthorized).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "User is disabled or deleted",
+			Code:    fiber.StatusUnauthorized,
+		}))
+	}
+
+	tokens, err := a.issueTokens(session, refresh_token)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create token")
+		return models.ReturnError(c, err)
+	}
+	return c.JSON(tokens)
+}
+
+// Logout godoc
+// @Summary Logout
+// @Security BearerAuth
+// @Description Revokes the access token of the request and ends its session
+// @Tags auth
+// @Produce json
+// @Success 200 {object} models.Output
+// @Router /api/auth/logout [post]
+func (a *AuthControllers) Logout(c *fiber.Ctx) error {
+	claims, _ := middleware.Claims(c)
+	if err := models.RevokeAccessToken(claims, a.Cache); err != nil {
+		log.Error().Err(err).Msg("Failed to revoke access token")
+		c.Status(fiber.StatusInternalServerError)
+		middleware.LogActivityWithCtx(c, middleware.ActivityTypeLogoutFailed, fiber.Map{
+			"session_id": claims.SessionID,
+			"error":      err.Error(),
+		}, a.ActivitiesCollection)
+		return c.JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusInternalServerError)))
+	}
+	if err := models.DeleteAuthSession(claims.Username, claims.SessionID, a.Cache); err != nil {
+		log.Error().Err(err).Msg("Failed to delete session")
+		return models.ReturnError(c, err)
+	}
+
+	log.Info().Str("username", claims.Username).Str("session_id", claims.SessionID).Msg("User logged out")
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeLogout, fiber.Map{
+		"session_id": claims.SessionID,
+	}, a.ActivitiesCollection)
+	return c.JSON(models.NewOutput([]string{"Logged out"}))
+}
+
+// GetMySessions godoc
+// @Summary Get my active sessions
+// @Security BearerAuth
+// @Description Lists the active sessions of the user with device and IP, the session of the request is marked as current
+// @Tags auth
+// @Produce json
+// @Success 200 {object} models.AuthSessionsOutput
+// @Router /api/auth/sessions [get]
+func (a *AuthControllers) GetMySessions(c *fiber.Ctx) error {
+	claims, _ := middleware.Claims(c)
+	sessions, err := models.GetAuthSessionsOfUser(claims.Username, a.Cache)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get sessions")
+		return models.ReturnError(c, err)
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == claims.SessionID
+	}
+	return c.JSON(models.NewOutput(sessions))
+}
+
+// RevokeMySession godoc
+// @Summary Revoke my session
+// @Security BearerAuth
+// @Description Logs out one of the sessions of the user, e.g. a lost device
+// @Tags auth
+// @Produce json
+// @Param id path string true "Session ID"
+// @Success 200 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Router /api/auth/sessions/{id} [delete]
+func (a *AuthControllers) RevokeMySession(c *fiber.Ctx) error {
+	claims, _ := middleware.Claims(c)
+	session, err := models.GetAuthSession(c.Params("id"), a.Cache)
+	if err == models.ErrAuthSessionNotFound || (err == nil && session.Username != claims.Username) {
+		return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: models.ErrAuthSessionNotFound.Error(),
+			Code:    fiber.StatusNotFound,
+		}))
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get session")
+		return models.ReturnError(c, err)
+	}
+	if err := models.DeleteAuthSession(session.Username, session.ID, a.Cache); err != nil {
+		return models.ReturnError(c, err)
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeRevokeSession, fiber.Map{
+		"session_id": session.ID,
+		"device":     session.Device,
+		"ip":         session.IP,
+	}, a.ActivitiesCollection)
+	return c.JSON(models.NewOutput([]string{"Session revoked"}))
+}
+
+// ForceLogoutUser godoc
+// @Summary Force logout of user
+// @Security BearerAuth
+// @Description Ends every session of the user, their access tokens are rejected from the next request
+// @Tags users
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Router /api/users/{id}/logout [post]
+func (a *AuthControllers) ForceLogoutUser(c *fiber.Ctx) error {
+	user, err := a.findUser(c, c.Params("id"))
+	if err == mongo.ErrNoDocuments {
+		return userNotFound(c)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to find user")
+		return models.ReturnError(c, err)
+	}
+
+	count, err := models.DeleteAuthSessionsOfUser(user.Username, a.Cache)
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+
+	log.Info().Str("username", user.Username).Int("sessions", count).Msg("User force logged out")
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeForceLogout, fiber.Map{
+		"user_id":  user.ID,
+		"username": user.Username,
+		"sessions": count,
+	}, a.ActivitiesCollection)
+	return c.JSON(models.NewOutput([]fiber.Map{{"username": user.Username, "revoked_sessions": count}}))
+}
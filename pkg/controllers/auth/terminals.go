@@ -0,0 +1,485 @@
+package auth
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+	"github.com/aslon1213/g4h_pos_erp/pkg/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func terminalNotFound(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+		Message: "Terminal not found",
+		Code:    fiber.StatusNotFound,
+	}))
+}
+
+// findTerminal returns the terminal if it is of a branch the user can access
+func (a *AuthControllers) findTerminal(c *fiber.Ctx, id string) (*models.Terminal, error) {
+	terminal := &models.Terminal{}
+	if err := a.TerminalsCollection.FindOne(c.Context(), bson.M{"_id": id}).Decode(terminal); err != nil {
+		return nil, err
+	}
+	if !middleware.CanAccessBranch(c, terminal.Branch) {
+		return nil, mongo.ErrNoDocuments
+	}
+	return terminal, nil
+}
+
+// endActiveShift ends the shift of the cashier active on the terminal and logs the cashier out of it
+func (a *AuthControllers) endActiveShift(c *fiber.Ctx, terminal *models.Terminal, reason models.TerminalShiftEnd) error {
+	if terminal.ActiveShift == "" {
+		return nil
+	}
+	shift := models.TerminalShift{}
+	err := a.ShiftsCollection.FindOneAndUpdate(
+		c.Context(),
+		bson.M{"_id": terminal.ActiveShift, "ended_at": nil},
+		bson.M{"$set": bson.M{"ended_at": time.Now(), "end_reason": reason}},
+	).Decode(&shift)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	if err == nil {
+		if err := models.DeleteAuthSession(shift.Username, shift.SessionID, a.Cache); err != nil {
+			return err
+		}
+	}
+	_, err = a.TerminalsCollection.UpdateOne(c.Context(), bson.M{"_id": terminal.ID}, bson.M{"$set": bson.M{
+		"active_user":  "",
+		"active_shift": "",
+		"active_since": nil,
+	}})
+	return err
+}
+
+// CreateTerminal godoc
+// @Summary Register terminal
+// @Security BearerAuth
+// @Description Registers a shared POS terminal of a branch. The device token is returned only once, the terminal sends it in the X-Terminal-Token header
+// @Tags terminals
+// @Accept json
+// @Produce json
+// @Param input body models.TerminalInput true "Name and branch of the terminal"
+// @Success 201 {object} models.TerminalCreatedOutput
+// @Failure 400 {object} models.Output
+// @Failure 403 {object} models.Output
+// @Router /api/terminals [post]
+func (a *AuthControllers) CreateTerminal(c *fiber.Ctx) error {
+	input := models.TerminalInput{}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if err := input.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	branch, err := models.FindActiveBranch(c.Context(), input.Branch, a.BranchesCollection)
+	if errors.Is(err, models.ErrBranchNotFound) || errors.Is(err, models.ErrBranchInactive) {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to find branch")
+		return models.ReturnError(c, err)
+	}
+	input.Branch = branch.ID
+	if !middleware.CanAccessBranch(c, input.Branch) {
+		return middleware.ForbiddenBranch(c)
+	}
+
+	created_by, _ := c.Locals("user").(string)
+	terminal, token, err := models.NewTerminal(input, created_by)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate terminal token")
+		return models.ReturnError(c, err)
+	}
+	if _, err := a.TerminalsCollection.InsertOne(c.Context(), terminal); err != nil {
+		log.Error().Err(err).Msg("Failed to insert terminal")
+		return models.ReturnError(c, err)
+	}
+
+	log.Info().Str("terminal", terminal.Name).Str("branch", terminal.Branch).Msg("Terminal registered")
+	c.Status(fiber.StatusCreated)
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeCreateTerminal, terminal, a.ActivitiesCollection)
+	return c.JSON(models.TerminalCreatedOutput{
+		Data:  []models.Terminal{*terminal},
+		Token: token,
+		Error: []models.Error{},
+	})
+}
+
+// QueryTerminals godoc
+// @Summary Query terminals
+// @Security BearerAuth
+// @Description Lists the terminals with their active cashier. Users bound to a branch only see the terminals of their branch
+// @Tags terminals
+// @Produce json
+// @Param branch query string false "Branch ID"
+// @Success 200 {object} models.TerminalsOutput
+// @Router /api/terminals [get]
+func (a *AuthControllers) QueryTerminals(c *fiber.Ctx) error {
+	filter := bson.M{}
+	if branch := c.Query("branch"); branch != "" {
+		filter["branch"] = branch
+	}
+	if branch, bound := middleware.UserBranch(c); bound {
+		filter["branch"] = branch
+	}
+
+	cursor, err := a.TerminalsCollection.Find(c.Context(), filter, options.Find().SetSort(bson.D{{Key: "branch", Value: 1}, {Key: "name", Value: 1}}))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query terminals")
+		return models.ReturnError(c, err)
+	}
+	terminals := []models.Terminal{}
+	if err := cursor.All(c.Context(), &terminals); err != nil {
+		log.Error().Err(err).Msg("Failed to decode terminals")
+		return models.ReturnError(c, err)
+	}
+	return c.JSON(models.NewOutput(terminals))
+}
+
+// RevokeTerminal godoc
+// @Summary Revoke terminal
+// @Security BearerAuth
+// @Description Revokes the device token of the terminal (e.g. stolen device) and logs out its active cashier
+// @Tags terminals
+// @Produce json
+// @Param id path string true "Terminal ID"
+// @Success 200 {object} models.TerminalsOutput
+// @Failure 404 {object} models.Output
+// @Router /api/terminals/{id} [delete]
+func (a *AuthControllers) RevokeTerminal(c *fiber.Ctx) error {
+	terminal, err := a.findTerminal(c, c.Params("id"))
+	if err == mongo.ErrNoDocuments {
+		return terminalNotFound(c)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to find terminal")
+		return models.ReturnError(c, err)
+	}
+
+	if err := a.endActiveShift(c, terminal, models.TerminalShiftEndRevoked); err != nil {
+		log.Error().Err(err).Msg("Failed to end active shift of terminal")
+		return models.ReturnError(c, err)
+	}
+	if _, err := a.TerminalsCollection.UpdateOne(c.Context(), bson.M{"_id": terminal.ID}, bson.M{"$set": bson.M{"revoked": true}}); err != nil {
+		log.Error().Err(err).Msg("Failed to revoke terminal")
+		return models.ReturnError(c, err)
+	}
+	terminal.Revoked = true
+	terminal.ActiveUser, terminal.ActiveShift, terminal.ActiveSince = "", "", nil
+
+	log.Info().Str("terminal", terminal.Name).Msg("Terminal revoked")
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeRevokeTerminal, fiber.Map{
+		"terminal_id": terminal.ID,
+		"name":        terminal.Name,
+		"branch":      terminal.Branch,
+	}, a.ActivitiesCollection)
+	return c.JSON(models.NewOutput([]models.Terminal{*terminal}))
+}
+
+// GetTerminalShifts godoc
+// @Summary Audit of terminal
+// @Security BearerAuth
+// @Description Lists which cashier was active on the terminal when, newest first
+// @Tags terminals
+// @Produce json
+// @Param id path string true "Terminal ID"
+// @Param username query string false "Username"
+// @Param from_date query string false "From date (YYYY-MM-DD)"
+// @Param to_date query string false "To date (YYYY-MM-DD)"
+// @Param page query int false "Page" default(1)
+// @Param count query int false "Count" default(50)
+// @Success 200 {object} models.TerminalShiftsOutput
+// @Failure 404 {object} models.Output
+// @Router /api/terminals/{id}/shifts [get]
+func (a *AuthControllers) GetTerminalShifts(c *fiber.Ctx) error {
+	terminal, err := a.findTerminal(c, c.Params("id"))
+	if err == mongo.ErrNoDocuments {
+		return terminalNotFound(c)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to find terminal")
+		return models.ReturnError(c, err)
+	}
+
+	params := models.TerminalShiftsQueryParams{}
+	if err := c.QueryParser(&params); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if params.Page < 1 {
+		params.Page = 1
+	}
+	if params.Count < 1 {
+		params.Count = 50
+	}
+
+	filter := bson.M{"terminal_id": terminal.ID}
+	if params.Username != "" {
+		filter["username"] = params.Username
+	}
+	started_at := bson.M{}
+	if params.FromDate != "" {
+		from_date, err := time.ParseInLocation("2006-01-02", params.FromDate, utils.GetTimeZone())
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+				Message: "Invalid from_date, expected YYYY-MM-DD",
+				Code:    fiber.StatusBadRequest,
+			}))
+		}
+		started_at["$gte"] = from_date
+	}
+	if params.ToDate != "" {
+		to_date, err := time.ParseInLocation("2006-01-02", params.ToDate, utils.GetTimeZone())
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+				Message: "Invalid to_date, expected YYYY-MM-DD",
+				Code:    fiber.StatusBadRequest,
+			}))
+		}
+		started_at["$lt"] = to_date.AddDate(0, 0, 1)
+	}
+	if len(started_at) > 0 {
+		filter["started_at"] = started_at
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "started_at", Value: -1}}).
+		SetSkip(int64((params.Page - 1) * params.Count)).
+		SetLimit(int64(params.Count))
+	cursor, err := a.ShiftsCollection.Find(c.Context(), filter, opts)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query terminal shifts")
+		return models.ReturnError(c, err)
+	}
+	shifts := []models.TerminalShift{}
+	if err := cursor.All(c.Context(), &shifts); err != nil {
+		log.Error().Err(err).Msg("Failed to decode terminal shifts")
+		return models.ReturnError(c, err)
+	}
+	return c.JSON(models.NewOutput(shifts))
+}
+
+// SetMyPIN godoc
+// @Summary Set my PIN
+// @Security BearerAuth
+// @Description Sets the PIN (4 to 8 digits) the user switches in on terminals with. The password of the user confirms the change
+// @Tags terminals
+// @Accept json
+// @Produce json
+// @Param input body models.SetPINInput true "Password and new PIN"
+// @Success 200 {object} models.Output
+// @Failure 400 {object} models.Output
+// @Failure 401 {object} models.Output
+// @Router /api/auth/pin [put]
+func (a *AuthControllers) SetMyPIN(c *fiber.Ctx) error {
+	input := models.SetPINInput{}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if err := models.ValidatePIN(input.PIN); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	username, _ := c.Locals("user").(string)
+	user := models.User{}
+	if err := a.UserCollection.FindOne(c.Context(), bson.M{"username": username}).Decode(&user); err != nil {
+		log.Error().Err(err).Msg("Failed to find user")
+		return models.ReturnError(c, err)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Invalid password",
+			Code:    fiber.StatusUnauthorized,
+		}))
+	}
+
+	pin_hash, err := bcrypt.GenerateFromPassword([]byte(input.PIN), bcrypt.DefaultCost)
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+	if _, err := a.UserCollection.UpdateOne(c.Context(), bson.M{"_id": user.ID}, bson.M{"$set": bson.M{
+		"pin_hash":   string(pin_hash),
+		"updated_at": time.Now(),
+	}}); err != nil {
+		log.Error().Err(err).Msg("Failed to set pin")
+		return models.ReturnError(c, err)
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeSetPIN, fiber.Map{}, a.ActivitiesCollection)
+	return c.JSON(models.NewOutput([]string{"PIN set"}))
+}
+
+// GetTerminal godoc
+// @Summary Get terminal
+// @Description Returns the terminal of the device token with its active cashier
+// @Tags terminals
+// @Produce json
+// @Param X-Terminal-Token header string true "Device token of the terminal"
+// @Success 200 {object} models.TerminalsOutput
+// @Router /terminal [get]
+func (a *AuthControllers) GetTerminal(c *fiber.Ctx) error {
+	terminal, _ := middleware.TerminalOf(c)
+	return c.JSON(models.NewOutput([]models.Terminal{*terminal}))
+}
+
+// SwitchCashier godoc
+// @Summary Switch cashier
+// @Description Switches the active cashier of the terminal with username and PIN. The previous cashier is logged out of the terminal, the returned tokens attribute every sale, operation and activity to the new cashier. Failed PINs are counted like failed logins
+// @Tags terminals
+// @Accept json
+// @Produce json
+// @Param X-Terminal-Token header string true "Device token of the terminal"
+// @Param input body models.SwitchCashierInput true "Username and PIN"
+// @Success 200 {object} models.TokenPairOutput
+// @Failure 401 {object} models.Output
+// @Failure 403 {object} models.Output
+// @Failure 429 {object} models.Output
+// @Router /terminal/switch [post]
+func (a *AuthControllers) SwitchCashier(c *fiber.Ctx) error {
+	terminal, _ := middleware.TerminalOf(c)
+	input := models.SwitchCashierInput{}
+	if err := c.BodyParser(&input); err != nil || input.Username == "" || input.PIN == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "username and pin are required",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	c.Locals("user", input.Username)
+	source := "terminal:" + terminal.ID
+
+	locked_for, err := models.LoginLockedFor(input.Username, source, a.Cache)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to check login lockout")
+		return models.ReturnError(c, err)
+	}
+	if locked_for > 0 {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(locked_for.Seconds())+1))
+		return c.Status(fiber.StatusTooManyRequests).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Too many failed logins, try again later",
+			Code:    fiber.StatusTooManyRequests,
+		}))
+	}
+
+	user := models.User{}
+	err = a.UserCollection.FindOne(c.Context(), bson.M{"username": input.Username}).Decode(&user)
+	if err == nil && user.PINHash != "" {
+		err = bcrypt.CompareHashAndPassword([]byte(user.PINHash), []byte(input.PIN))
+	} else if err == nil {
+		err = models.ErrInvalidPIN
+	}
+	if err != nil {
+		login_err := a.loginFailed(c, input.Username, source, "Invalid PIN")
+		return c.Status(login_err.Status).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Invalid username or PIN",
+			Code:    login_err.Status,
+		}))
+	}
+	if err := models.ResetFailedLogins(user.Username, a.Cache); err != nil {
+		log.Error().Err(err).Msg("Failed to reset failed logins")
+	}
+
+	if branch, bound := user.BoundBranch(); user.Disabled || !models.HasPermission(user.Role, models.PermissionSalesWrite) || (bound && branch != terminal.Branch) {
+		c.Status(fiber.StatusForbidden)
+		middleware.LogActivityWithCtx(c, middleware.ActivityTypeLoginFailed, fiber.Map{
+			"error":       "User can not work on the terminal",
+			"terminal_id": terminal.ID,
+		}, a.ActivitiesCollection)
+		return c.JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "User can not work on this terminal",
+			Code:    fiber.StatusForbidden,
+		}))
+	}
+
+	previous_user := terminal.ActiveUser
+	if err := a.endActiveShift(c, terminal, models.TerminalShiftEndSwitch); err != nil {
+		log.Error().Err(err).Msg("Failed to end active shift of terminal")
+		return models.ReturnError(c, err)
+	}
+
+	session, refresh_token, err := models.NewTerminalAuthSession(user.Username, terminal, c.IP(), a.sessionTTL(), a.Cache)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create session")
+		return models.ReturnError(c, err)
+	}
+	tokens, err := a.issueTokens(session, refresh_token)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create token")
+		return models.ReturnError(c, err)
+	}
+
+	shift := models.NewTerminalShift(terminal, user.Username, session.ID)
+	if _, err := a.ShiftsCollection.InsertOne(c.Context(), shift); err != nil {
+		log.Error().Err(err).Msg("Failed to insert terminal shift")
+		return models.ReturnError(c, err)
+	}
+	if _, err := a.TerminalsCollection.UpdateOne(c.Context(), bson.M{"_id": terminal.ID}, bson.M{"$set": bson.M{
+		"active_user":  user.Username,
+		"active_shift": shift.ID,
+		"active_since": shift.StartedAt,
+	}}); err != nil {
+		log.Error().Err(err).Msg("Failed to update terminal")
+		return models.ReturnError(c, err)
+	}
+
+	log.Info().Str("terminal", terminal.Name).Str("username", user.Username).Str("previous_user", previous_user).Msg("Cashier switched")
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeSwitchCashier, fiber.Map{
+		"terminal_id":   terminal.ID,
+		"previous_user": previous_user,
+		"session_id":    session.ID,
+	}, a.ActivitiesCollection)
+	return c.JSON(tokens)
+}
+
+// LockTerminal godoc
+// @Summary Lock terminal
+// @Description Ends the shift of the active cashier, nobody can work on the terminal until a cashier switches in
+// @Tags terminals
+// @Produce json
+// @Param X-Terminal-Token header string true "Device token of the terminal"
+// @Success 200 {object} models.TerminalsOutput
+// @Router /terminal/lock [post]
+func (a *AuthControllers) LockTerminal(c *fiber.Ctx) error {
+	terminal, _ := middleware.TerminalOf(c)
+	c.Locals("user", terminal.ActiveUser)
+	if err := a.endActiveShift(c, terminal, models.TerminalShiftEndLock); err != nil {
+		log.Error().Err(err).Msg("Failed to end active shift of terminal")
+		return models.ReturnError(c, err)
+	}
+	previous_user := terminal.ActiveUser
+	terminal.ActiveUser, terminal.ActiveShift, terminal.ActiveSince = "", "", nil
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeLockTerminal, fiber.Map{
+		"terminal_id":   terminal.ID,
+		"previous_user": previous_user,
+	}, a.ActivitiesCollection)
+	return c.JSON(models.NewOutput([]models.Terminal{*terminal}))
+}
@@ -0,0 +1,565 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordResetCodeTTL is how long a one-time password reset code can be redeemed
+const passwordResetCodeTTL = 24 * time.Hour
+
+var ErrUsernameTaken = errors.New("username is already taken")
+
+// createUser hashes the password and inserts the user
+func (a *AuthControllers) createUser(c *fiber.Ctx, input *models.UserRegisterInput) (*models.User, error) {
+	hashed_password, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	user := models.NewUser(input.Username, string(hashed_password), input.Email, input.Role, input.Phone, input.Branch)
+	if _, err := a.UserCollection.InsertOne(c.Context(), user); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrUsernameTaken
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// userBranch returns the ID of the branch the user is bound to, the branch is given by its ID or name
+func (a *AuthControllers) userBranch(c *fiber.Ctx, id_or_name string) (string, error) {
+	if id_or_name == "" {
+		return "", nil
+	}
+	branch, err := models.FindBranch(c.Context(), id_or_name, a.BranchesCollection)
+	if err != nil {
+		return "", err
+	}
+	return branch.ID, nil
+}
+
+func branchNotFound(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+		Message: models.ErrBranchNotFound.Error(),
+		Code:    fiber.StatusBadRequest,
+	}))
+}
+
+func (a *AuthControllers) findUser(c *fiber.Ctx, id string) (*models.User, error) {
+	user := &models.User{}
+	err := a.UserCollection.FindOne(c.Context(), bson.M{"_id": id}).Decode(user)
+	return user, err
+}
+
+func userNotFound(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+		Message: "User not found",
+		Code:    fiber.StatusNotFound,
+	}))
+}
+
+// CreateUser godoc
+// @Summary Create user
+// @Security BearerAuth
+// @Description Creates a user with the role and the branch. Only admins can create users
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param user body models.UserRegisterInput true "User"
+// @Success 201 {object} models.UsersOutput
+// @Failure 400 {object} models.Output
+// @Failure 409 {object} models.Output
+// @Router /api/users [post]
+func (a *AuthControllers) CreateUser(c *fiber.Ctx) error {
+	input := &models.UserRegisterInput{}
+	if err := c.BodyParser(input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if err := input.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	branch, err := a.userBranch(c, input.Branch)
+	if errors.Is(err, models.ErrBranchNotFound) {
+		return branchNotFound(c)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to find branch")
+		return models.ReturnError(c, err)
+	}
+	input.Branch = branch
+
+	user, err := a.createUser(c, input)
+	if errors.Is(err, ErrUsernameTaken) {
+		return c.Status(fiber.StatusConflict).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusConflict,
+		}))
+	}
+	if err != nil {
+		log.Error().Err(err).Str("username", input.Username).Msg("Failed to create user")
+		return models.ReturnError(c, err)
+	}
+
+	log.Info().Str("username", user.Username).Str("role", user.Role).Msg("User created")
+	c.Status(fiber.StatusCreated)
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeCreateUser, fiber.Map{
+		"user_id":  user.ID,
+		"username": user.Username,
+		"role":     user.Role,
+		"branch":   user.Branch,
+	}, a.ActivitiesCollection)
+
+	return c.JSON(models.NewOutput([]models.UserInfo{user.Info()}))
+}
+
+// QueryUsers godoc
+// @Summary Query users
+// @Security BearerAuth
+// @Description Lists users filtered by search text (username, email or phone), role, branch and disabled
+// @Tags users
+// @Produce json
+// @Param search query string false "Search in username, email and phone"
+// @Param role query string false "Role"
+// @Param branch query string false "Branch ID"
+// @Param disabled query bool false "Disabled"
+// @Param page query int false "Page" default(1)
+// @Param count query int false "Count" default(25)
+// @Success 200 {object} models.UsersOutput
+// @Router /api/users [get]
+func (a *AuthControllers) QueryUsers(c *fiber.Ctx) error {
+	params := models.UsersQueryParams{}
+	if err := c.QueryParser(&params); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if params.Page < 1 {
+		params.Page = 1
+	}
+	if params.Count < 1 {
+		params.Count = 25
+	}
+
+	filter := bson.M{}
+	if params.Search != "" {
+		search := bson.M{"$regex": regexp.QuoteMeta(params.Search), "$options": "i"}
+		filter["$or"] = bson.A{
+			bson.M{"username": search},
+			bson.M{"email": search},
+			bson.M{"phone": search},
+		}
+	}
+	if params.Role != "" {
+		filter["role"] = params.Role
+	}
+	if params.Branch != "" {
+		filter["branch"] = params.Branch
+	}
+	if params.Disabled != nil {
+		if *params.Disabled {
+			filter["disabled"] = true
+		} else {
+			filter["disabled"] = bson.M{"$ne": true}
+		}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "username", Value: 1}}).
+		SetSkip(int64((params.Page - 1) * params.Count)).
+		SetLimit(int64(params.Count))
+	cursor, err := a.UserCollection.Find(c.Context(), filter, opts)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to find users")
+		return models.ReturnError(c, err)
+	}
+	users := []models.User{}
+	if err := cursor.All(c.Context(), &users); err != nil {
+		log.Error().Err(err).Msg("Failed to decode users")
+		return models.ReturnError(c, err)
+	}
+
+	infos := make([]models.UserInfo, 0, len(users))
+	for i := range users {
+		infos = append(infos, users[i].Info())
+	}
+	return c.JSON(models.NewOutput(infos))
+}
+
+// GetUserByID godoc
+// @Summary Get user
+// @Security BearerAuth
+// @Tags users
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} models.UsersOutput
+// @Failure 404 {object} models.Output
+// @Router /api/users/{id} [get]
+func (a *AuthControllers) GetUserByID(c *fiber.Ctx) error {
+	user, err := a.findUser(c, c.Params("id"))
+	if err == mongo.ErrNoDocuments {
+		return userNotFound(c)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to find user")
+		return models.ReturnError(c, err)
+	}
+	return c.JSON(models.NewOutput([]models.UserInfo{user.Info()}))
+}
+
+// UpdateUser godoc
+// @Summary Change role and branch of user
+// @Security BearerAuth
+// @Description Changes the role and/or the branch of the user. Admins can not change their own role
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param input body models.UpdateUserInput true "Role and branch"
+// @Success 200 {object} models.UsersOutput
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Router /api/users/{id} [put]
+func (a *AuthControllers) UpdateUser(c *fiber.Ctx) error {
+	input := &models.UpdateUserInput{}
+	if err := c.BodyParser(input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if err := input.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	user, err := a.findUser(c, c.Params("id"))
+	if err == mongo.ErrNoDocuments {
+		return userNotFound(c)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to find user")
+		return models.ReturnError(c, err)
+	}
+	if input.Role != nil && *input.Role != user.Role && user.Username == c.Locals("user") {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "You can not change your own role",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	changes := fiber.Map{
+		"user_id":  user.ID,
+		"username": user.Username,
+	}
+	set := bson.M{"updated_at": time.Now()}
+	if input.Role != nil {
+		changes["role"] = fiber.Map{"from": user.Role, "to": *input.Role}
+		set["role"] = *input.Role
+		user.Role = *input.Role
+	}
+	if input.Branch != nil {
+		branch, err := a.userBranch(c, *input.Branch)
+		if errors.Is(err, models.ErrBranchNotFound) {
+			return branchNotFound(c)
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to find branch")
+			return models.ReturnError(c, err)
+		}
+		changes["branch"] = fiber.Map{"from": user.Branch, "to": branch}
+		set["branch"] = branch
+		user.Branch = branch
+	}
+	user.UpdatedAt = set["updated_at"].(time.Time)
+
+	if _, err := a.UserCollection.UpdateOne(c.Context(), bson.M{"_id": user.ID}, bson.M{"$set": set}); err != nil {
+		log.Error().Err(err).Str("user_id", user.ID).Msg("Failed to update user")
+		return models.ReturnError(c, err)
+	}
+
+	log.Info().Str("username", user.Username).Interface("changes", changes).Msg("User updated")
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeUpdateUser, changes, a.ActivitiesCollection)
+
+	return c.JSON(models.NewOutput([]models.UserInfo{user.Info()}))
+}
+
+// DisableUser godoc
+// @Summary Disable user
+// @Security BearerAuth
+// @Description Disabled users can not login and their tokens are rejected
+// @Tags users
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} models.UsersOutput
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Router /api/users/{id}/disable [post]
+func (a *AuthControllers) DisableUser(c *fiber.Ctx) error {
+	return a.setDisabled(c, true)
+}
+
+// EnableUser godoc
+// @Summary Enable user
+// @Security BearerAuth
+// @Tags users
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} models.UsersOutput
+// @Failure 404 {object} models.Output
+// @Router /api/users/{id}/enable [post]
+func (a *AuthControllers) EnableUser(c *fiber.Ctx) error {
+	return a.setDisabled(c, false)
+}
+
+func (a *AuthControllers) setDisabled(c *fiber.Ctx, disabled bool) error {
+	user, err := a.findUser(c, c.Params("id"))
+	if err == mongo.ErrNoDocuments {
+		return userNotFound(c)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to find user")
+		return models.ReturnError(c, err)
+	}
+	if disabled && user.Username == c.Locals("user") {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "You can not disable yourself",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	user.Disabled = disabled
+	user.UpdatedAt = time.Now()
+	if _, err := a.UserCollection.UpdateOne(c.Context(), bson.M{"_id": user.ID}, bson.M{"$set": bson.M{
+		"disabled":   disabled,
+		"updated_at": user.UpdatedAt,
+	}}); err != nil {
+		log.Error().Err(err).Str("user_id", user.ID).Msg("Failed to update user")
+		return models.ReturnError(c, err)
+	}
+
+	activity := middleware.ActivityTypeEnableUser
+	if disabled {
+		activity = middleware.ActivityTypeDisableUser
+		// disabled users are logged out everywhere
+		if _, err := models.DeleteAuthSessionsOfUser(user.Username, a.Cache); err != nil {
+			log.Error().Err(err).Str("username", user.Username).Msg("Failed to delete sessions of disabled user")
+		}
+	}
+	log.Info().Str("username", user.Username).Bool("disabled", disabled).Msg("User disabled changed")
+	middleware.LogActivityWithCtx(c, activity, fiber.Map{
+		"user_id":  user.ID,
+		"username": user.Username,
+	}, a.ActivitiesCollection)
+
+	return c.JSON(models.NewOutput([]models.UserInfo{user.Info()}))
+}
+
+// IssuePasswordReset godoc
+// @Summary Issue password reset code
+// @Security BearerAuth
+// @Description Generates a one-time code the user redeems at /auth/password-reset to set a new password. The code is shown only in this response and expires in 24 hours. Issuing a new code invalidates the previous one
+// @Tags users
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} models.PasswordResetCodeOutput
+// @Failure 404 {object} models.Output
+// @Router /api/users/{id}/password-reset [post]
+func (a *AuthControllers) IssuePasswordReset(c *fiber.Ctx) error {
+	user, err := a.findUser(c, c.Params("id"))
+	if err == mongo.ErrNoDocuments {
+		return userNotFound(c)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to find user")
+		return models.ReturnError(c, err)
+	}
+
+	code, err := generateResetCode()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate password reset code")
+		return models.ReturnError(c, err)
+	}
+	code_hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to hash password reset code")
+		return models.ReturnError(c, err)
+	}
+
+	issued_by, _ := c.Locals("user").(string)
+	reset := models.PasswordReset{
+		CodeHash:  string(code_hash),
+		IssuedBy:  issued_by,
+		ExpiresAt: time.Now().Add(passwordResetCodeTTL),
+	}
+	if _, err := a.UserCollection.UpdateOne(c.Context(), bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"password_reset": reset}}); err != nil {
+		log.Error().Err(err).Str("user_id", user.ID).Msg("Failed to save password reset code")
+		return models.ReturnError(c, err)
+	}
+
+	log.Info().Str("username", user.Username).Str("issued_by", issued_by).Msg("Password reset code issued")
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeIssuePasswordReset, fiber.Map{
+		"user_id":    user.ID,
+		"username":   user.Username,
+		"expires_at": reset.ExpiresAt,
+	}, a.ActivitiesCollection)
+
+	return c.JSON(models.NewOutput([]models.PasswordResetCodeOutput{{
+		Username:  user.Username,
+		Code:      code,
+		ExpiresAt: reset.ExpiresAt,
+	}}))
+}
+
+// RedeemPasswordReset godoc
+// @Summary Reset password with code
+// @Description Sets a new password using the one-time code issued by an admin. The code can be used only once
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param input body models.RedeemPasswordResetInput true "Username, code and new password"
+// @Success 200 {object} models.Output
+// @Failure 400 {object} models.Output
+// @Failure 401 {object} models.Output
+// @Router /auth/password-reset [post]
+func (a *AuthControllers) RedeemPasswordReset(c *fiber.Ctx) error {
+	input := &models.RedeemPasswordResetInput{}
+	if err := c.BodyParser(input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if err := input.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	c.Locals("user", input.Username)
+
+	invalid := func() error {
+		c.Status(fiber.StatusUnauthorized)
+		middleware.LogActivityWithCtx(c, middleware.ActivityTypePasswordReset, fiber.Map{
+			"error": "Invalid or expired code",
+		}, a.ActivitiesCollection)
+		return c.JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Invalid or expired code",
+			Code:    fiber.StatusUnauthorized,
+		}))
+	}
+
+	user := &models.User{}
+	if err := a.UserCollection.FindOne(c.Context(), bson.M{"username": input.Username}).Decode(user); err != nil {
+		return invalid()
+	}
+	if user.PasswordReset == nil || time.Now().After(user.PasswordReset.ExpiresAt) {
+		return invalid()
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordReset.CodeHash), []byte(input.Code)) != nil {
+		return invalid()
+	}
+
+	hashed_password, err := bcrypt.GenerateFromPassword([]byte(input.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to hash password")
+		return models.ReturnError(c, err)
+	}
+	// matching the code hash makes the code usable only once even with concurrent requests
+	result, err := a.UserCollection.UpdateOne(c.Context(), bson.M{
+		"_id":                      user.ID,
+		"password_reset.code_hash": user.PasswordReset.CodeHash,
+	}, bson.M{
+		"$set":   bson.M{"password": string(hashed_password), "updated_at": time.Now()},
+		"$unset": bson.M{"password_reset": ""},
+	})
+	if err != nil {
+		log.Error().Err(err).Str("user_id", user.ID).Msg("Failed to reset password")
+		return models.ReturnError(c, err)
+	}
+	if result.ModifiedCount == 0 {
+		return invalid()
+	}
+
+	// sessions opened with the old password are ended
+	if _, err := models.DeleteAuthSessionsOfUser(user.Username, a.Cache); err != nil {
+		log.Error().Err(err).Str("username", user.Username).Msg("Failed to delete sessions after password reset")
+	}
+
+	log.Info().Str("username", user.Username).Msg("Password reset")
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypePasswordReset, fiber.Map{
+		"user_id":  user.ID,
+		"username": user.Username,
+	}, a.ActivitiesCollection)
+
+	return c.JSON(models.NewOutput([]string{"Password has been reset"}))
+}
+
+// GetActivitiesOfUserByID godoc
+// @Summary Get activities of user
+// @Security BearerAuth
+// @Description Get the most recent activities done by the user
+// @Tags users
+// @Produce json
+// @Param id path string true "User ID"
+// @Param count query int false "Count" default(50)
+// @Success 200 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Router /api/users/{id}/activities [get]
+func (a *AuthControllers) GetActivitiesOfUserByID(c *fiber.Ctx) error {
+	user, err := a.findUser(c, c.Params("id"))
+	if err == mongo.ErrNoDocuments {
+		return userNotFound(c)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to find user")
+		return models.ReturnError(c, err)
+	}
+
+	count := c.QueryInt("count", 50)
+	if count < 1 {
+		count = 50
+	}
+	cursor, err := a.ActivitiesCollection.Find(c.Context(), bson.M{"user_id": user.Username}, options.Find().SetSort(bson.M{"date": -1}).SetLimit(int64(count)))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get activities of user")
+		return models.ReturnError(c, err)
+	}
+	activities := []middleware.Activity{}
+	if err := cursor.All(c.Context(), &activities); err != nil {
+		log.Error().Err(err).Msg("Failed to decode activities of user")
+		return models.ReturnError(c, err)
+	}
+	return c.JSON(models.NewOutput(activities))
+}
+
+// generateResetCode returns a random code of 10 characters which is easy to read out
+func generateResetCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(buf)[:10], nil
+}
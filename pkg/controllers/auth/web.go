@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"html/template"
+	"strconv"
+	"strings"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	pasetoware "github.com/gofiber/contrib/paseto"
+	"github.com/gofiber/fiber/v2"
+	"github.com/o1egl/paseto"
+	"github.com/rs/zerolog/log"
+)
+
+var loginPage = template.Must(template.New("login").Parse(`
+    <!DOCTYPE html>
+    <html>
+    <head>
+        <title>Login</title>
+        <meta name="viewport" content="width=device-width, initial-scale=1" />
+        <style>
+            body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Oxygen, Ubuntu, Cantarell, 'Open Sans', 'Helvetica Neue', sans-serif; margin: 0; padding: 24px; background: #f3f4f6; }
+            .card { max-width: 360px; margin: 80px auto 0 auto; background: white; padding: 24px; border-radius: 12px; box-shadow: 0 8px 25px rgba(0,0,0,0.05); }
+            .card h1 { margin: 0 0 16px 0; font-size: 22px; color: #111827; }
+            .card label { display: block; margin: 12px 0 4px 0; color: #374151; font-size: 14px; }
+            .card input { width: 100%; box-sizing: border-box; padding: 10px; border: 1px solid #d1d5db; border-radius: 8px; }
+            .card button { width: 100%; margin-top: 20px; color: #fff; background: #4f46e5; padding: 10px 14px; border: 0; border-radius: 8px; font-weight: 600; cursor: pointer; }
+            .error { margin: 0 0 12px 0; padding: 10px; border-radius: 8px; background: #fee2e2; color: #991b1b; }
+        </style>
+    </head>
+    <body>
+        <form class="card" method="POST" action="/dashboard/login">
+            <h1>G4H ERP/POS</h1>
+            {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+            <input type="hidden" name="next" value="{{.Next}}" />
+            <label for="username">Username</label>
+            <input id="username" name="username" value="{{.Username}}" autocomplete="username" required autofocus />
+            <label for="password">Password</label>
+            <input id="password" name="password" type="password" autocomplete="current-password" required />
+            <button type="submit">Log in</button>
+        </form>
+    </body>
+    </html>`))
+
+type loginPageData struct {
+	Error    string
+	Next     string
+	Username string
+}
+
+// safeNext only allows redirects to pages of this server after the login
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/dashboard/"
+	}
+	return next
+}
+
+func renderLoginPage(c *fiber.Ctx, status int, data loginPageData) error {
+	data.Next = safeNext(data.Next)
+	body := bytes.Buffer{}
+	if err := loginPage.Execute(&body, data); err != nil {
+		log.Error().Err(err).Msg("Failed to render login page")
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	c.Set("Content-Type", "text/html; charset=utf-8")
+	return c.Status(status).Send(body.Bytes())
+}
+
+// issueWebToken creates the token of the session cookie. It lives as long as the session and is only accepted by the html pages
+func (a *AuthControllers) issueWebToken(session *models.AuthSession) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(a.SecretSymmetricKey)
+	if err != nil {
+		return "", err
+	}
+	payload, err := pasetoware.NewPayload(session.Username, a.sessionTTL())
+	if err != nil {
+		return "", err
+	}
+	payload.Set("session_id", session.ID)
+	payload.Set("kind", middleware.WebTokenKind)
+	return paseto.NewV2().Encrypt(key, payload, nil)
+}
+
+// LoginPage serves the login form of the dashboards and docs
+func (a *AuthControllers) LoginPage(c *fiber.Ctx) error {
+	return renderLoginPage(c, fiber.StatusOK, loginPageData{Next: c.Query("next")})
+}
+
+// WebLogin logs in with the login form. The same lockouts apply as to the api login, on success a session is created
+// and its token is set as http-only cookie
+func (a *AuthControllers) WebLogin(c *fiber.Ctx) error {
+	data := loginPageData{
+		Next:     c.FormValue("next"),
+		Username: c.FormValue("username"),
+	}
+	password := c.FormValue("password")
+	if data.Username == "" || password == "" {
+		data.Error = "Username and password are required"
+		return renderLoginPage(c, fiber.StatusBadRequest, data)
+	}
+
+	user, login_err := a.authenticate(c, data.Username, password)
+	if login_err != nil {
+		if login_err.Status == fiber.StatusTooManyRequests {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(login_err.RetryAfter.Seconds())+1))
+		}
+		data.Error = login_err.Message
+		return renderLoginPage(c, login_err.Status, data)
+	}
+	if !models.HasPermission(user.Role, models.PermissionReportsRead) && !models.HasPermission(user.Role, models.PermissionDocsRead) {
+		data.Error = "Your role has no access to the dashboards"
+		return renderLoginPage(c, fiber.StatusForbidden, data)
+	}
+
+	session, _, err := models.NewAuthSession(user.Username, c.Get(fiber.HeaderUserAgent), c.IP(), a.sessionTTL(), a.Cache)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create session")
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	token, err := a.issueWebToken(session)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create token")
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	c.Cookie(&fiber.Cookie{
+		Name:     middleware.SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HTTPOnly: true,
+		Secure:   c.Protocol() == "https",
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+
+	log.Info().Str("username", user.Username).Str("session_id", session.ID).Msg("User logged in to the dashboards")
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeLogin, fiber.Map{
+		"session_id": session.ID,
+		"device":     session.Device,
+		"web":        true,
+	}, a.ActivitiesCollection)
+	return c.Redirect(safeNext(data.Next), fiber.StatusSeeOther)
+}
+
+// WebLogout ends the session of the cookie and removes the cookie
+func (a *AuthControllers) WebLogout(c *fiber.Ctx) error {
+	claims, _ := middleware.Claims(c)
+	if err := models.RevokeAccessToken(claims, a.Cache); err != nil {
+		log.Error().Err(err).Msg("Failed to revoke access token")
+	}
+	if err := models.DeleteAuthSession(claims.Username, claims.SessionID, a.Cache); err != nil {
+		log.Error().Err(err).Msg("Failed to delete session")
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	middleware.ClearSessionCookie(c)
+
+	log.Info().Str("username", claims.Username).Str("session_id", claims.SessionID).Msg("User logged out of the dashboards")
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeLogout, fiber.Map{
+		"session_id": claims.SessionID,
+		"web":        true,
+	}, a.ActivitiesCollection)
+	return c.Redirect(middleware.WebLoginPath, fiber.StatusSeeOther)
+}
@@ -0,0 +1,401 @@
+package branches
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+	"github.com/aslon1213/g4h_pos_erp/platform/database"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var ErrBranchNameTaken = errors.New("branch with the name already exists")
+
+type BranchesController struct {
+	BranchesCollection     *mongo.Collection
+	FinanceCollection      *mongo.Collection
+	JournalsCollection     *mongo.Collection
+	TransactionsCollection *mongo.Collection
+	ProposalsCollection    *mongo.Collection
+	ProductsCollection     *mongo.Collection
+	UsersCollection        *mongo.Collection
+	ActivitiesCollection   *mongo.Collection
+}
+
+func New(db *mongo.Database) *BranchesController {
+	log.Info().Msg("Initializing BranchesController")
+	branches_collection := db.Collection("branches")
+	_, _ = branches_collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.M{"name": 1},
+		Options: options.Index().SetUnique(true),
+	})
+
+	return &BranchesController{
+		BranchesCollection:     branches_collection,
+		FinanceCollection:      db.Collection("finance"),
+		JournalsCollection:     db.Collection("journals"),
+		TransactionsCollection: db.Collection("transactions"),
+		ProposalsCollection:    db.Collection("proposals"),
+		ProductsCollection:     db.Collection("products"),
+		UsersCollection:        db.Collection("users"),
+		ActivitiesCollection:   db.Collection("activities"),
+	}
+}
+
+func branchNotFound(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+		Message: models.ErrBranchNotFound.Error(),
+		Code:    fiber.StatusNotFound,
+	}))
+}
+
+// CreateBranchWithFinance inserts the branch with its empty finance in one db transaction
+func CreateBranchWithFinance(branch *models.Branch, details interface{}, branches *mongo.Collection, finances *mongo.Collection) (*models.BranchFinance, error) {
+	// names are unique regardless of the case
+	if _, err := models.FindBranch(context.Background(), branch.Name, branches); err == nil {
+		return nil, ErrBranchNameTaken
+	} else if !errors.Is(err, models.ErrBranchNotFound) {
+		return nil, err
+	}
+
+	ses, ctx, err := database.StartTransaction(branches.Database().Client())
+	if err != nil {
+		return nil, err
+	}
+	defer ses.EndSession(ctx)
+
+	if _, err := branches.InsertOne(ctx, branch); err != nil {
+		ses.AbortTransaction(ctx)
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrBranchNameTaken
+		}
+		return nil, err
+	}
+	finance := models.NewBranchFinance(branch, details)
+	if _, err := finances.InsertOne(ctx, finance); err != nil {
+		ses.AbortTransaction(ctx)
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrBranchNameTaken
+		}
+		return nil, err
+	}
+	if err := ses.CommitTransaction(ctx); err != nil {
+		return nil, err
+	}
+	return &finance, nil
+}
+
+// CreateBranch godoc
+// @Summary Create branch
+// @Security BearerAuth
+// @Description Creates the branch together with its empty finance. The ID of the branch is the branch_id used by journals, finance, transactions, products and users
+// @Tags branches
+// @Accept json
+// @Produce json
+// @Param input body models.BranchInput true "Branch"
+// @Success 201 {object} models.BranchesOutput
+// @Failure 400 {object} models.Output
+// @Failure 409 {object} models.Output
+// @Router /api/branches [post]
+func (b *BranchesController) CreateBranch(c *fiber.Ctx) error {
+	input := models.BranchInput{}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if err := input.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	created_by, _ := c.Locals("user").(string)
+	branch := models.NewBranch(input, created_by)
+	_, err := CreateBranchWithFinance(branch, nil, b.BranchesCollection, b.FinanceCollection)
+	if errors.Is(err, ErrBranchNameTaken) {
+		return c.Status(fiber.StatusConflict).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusConflict,
+		}))
+	}
+	if err != nil {
+		log.Error().Err(err).Str("name", branch.Name).Msg("Failed to create branch")
+		return models.ReturnError(c, err)
+	}
+
+	log.Info().Str("branch_id", branch.ID).Str("name", branch.Name).Msg("Branch created")
+	c.Status(fiber.StatusCreated)
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeCreateBranch, branch, b.ActivitiesCollection)
+	return c.JSON(models.NewOutput([]models.Branch{*branch}))
+}
+
+// QueryBranches godoc
+// @Summary Query branches
+// @Security BearerAuth
+// @Description Lists the branches filtered by name, active flag and the warehouse supplying them
+// @Tags branches
+// @Produce json
+// @Param name query string false "Name (case-insensitive search)"
+// @Param active query bool false "Filter by active"
+// @Param warehouse query string false "Warehouse ID"
+// @Success 200 {object} models.BranchesOutput
+// @Router /api/branches [get]
+func (b *BranchesController) QueryBranches(c *fiber.Ctx) error {
+	params := models.BranchesQueryParams{}
+	if err := c.QueryParser(&params); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	filter := bson.M{}
+	if params.Name != "" {
+		filter["name"] = bson.M{"$regex": regexp.QuoteMeta(params.Name), "$options": "i"}
+	}
+	if params.Active != nil {
+		filter["active"] = *params.Active
+	}
+	if params.Warehouse != "" {
+		filter["warehouses"] = params.Warehouse
+	}
+
+	cursor, err := b.BranchesCollection.Find(c.Context(), filter, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query branches")
+		return models.ReturnError(c, err)
+	}
+	branches := []models.Branch{}
+	if err := cursor.All(c.Context(), &branches); err != nil {
+		log.Error().Err(err).Msg("Failed to decode branches")
+		return models.ReturnError(c, err)
+	}
+	return c.JSON(models.NewOutput(branches))
+}
+
+// GetBranchByID godoc
+// @Summary Get branch
+// @Security BearerAuth
+// @Tags branches
+// @Produce json
+// @Param id path string true "Branch ID"
+// @Success 200 {object} models.BranchesOutput
+// @Failure 404 {object} models.Output
+// @Router /api/branches/{id} [get]
+func (b *BranchesController) GetBranchByID(c *fiber.Ctx) error {
+	branch := models.Branch{}
+	err := b.BranchesCollection.FindOne(c.Context(), bson.M{"_id": c.Params("id")}).Decode(&branch)
+	if err == mongo.ErrNoDocuments {
+		return branchNotFound(c)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get branch")
+		return models.ReturnError(c, err)
+	}
+	return c.JSON(models.NewOutput([]models.Branch{branch}))
+}
+
+// UpdateBranch godoc
+// @Summary Update branch
+// @Security BearerAuth
+// @Description Changes the given fields of the branch. Renaming also renames the finance of the branch, journals keep the snapshot of the branch they were opened with. Inactive branches get no new journals, proposals or stock
+// @Tags branches
+// @Accept json
+// @Produce json
+// @Param id path string true "Branch ID"
+// @Param input body models.UpdateBranchInput true "Fields to change"
+// @Success 200 {object} models.BranchesOutput
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 409 {object} models.Output
+// @Router /api/branches/{id} [put]
+func (b *BranchesController) UpdateBranch(c *fiber.Ctx) error {
+	input := models.UpdateBranchInput{}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if err := input.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	branch := models.Branch{}
+	err := b.BranchesCollection.FindOne(c.Context(), bson.M{"_id": c.Params("id")}).Decode(&branch)
+	if err == mongo.ErrNoDocuments {
+		return branchNotFound(c)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get branch")
+		return models.ReturnError(c, err)
+	}
+
+	changes := fiber.Map{"branch_id": branch.ID}
+	set := bson.M{"updated_at": time.Now()}
+	if input.Name != nil && *input.Name != branch.Name {
+		other, err := models.FindBranch(c.Context(), *input.Name, b.BranchesCollection)
+		if err == nil && other.ID != branch.ID {
+			return c.Status(fiber.StatusConflict).JSON(models.NewOutput([]interface{}{}, models.Error{
+				Message: ErrBranchNameTaken.Error(),
+				Code:    fiber.StatusConflict,
+			}))
+		}
+		changes["name"] = fiber.Map{"from": branch.Name, "to": *input.Name}
+		set["name"] = *input.Name
+		branch.Name = *input.Name
+	}
+	if input.Address != nil {
+		changes["address"] = fiber.Map{"from": branch.Address, "to": *input.Address}
+		set["address"] = *input.Address
+		branch.Address = *input.Address
+	}
+	if input.Phone != nil {
+		changes["phone"] = fiber.Map{"from": branch.Phone, "to": *input.Phone}
+		set["phone"] = *input.Phone
+		branch.Phone = *input.Phone
+	}
+	if input.Timezone != nil {
+		changes["timezone"] = fiber.Map{"from": branch.Timezone, "to": *input.Timezone}
+		set["timezone"] = *input.Timezone
+		branch.Timezone = *input.Timezone
+	}
+	if input.Active != nil {
+		changes["active"] = fiber.Map{"from": branch.Active, "to": *input.Active}
+		set["active"] = *input.Active
+		branch.Active = *input.Active
+	}
+	if input.Warehouses != nil {
+		changes["warehouses"] = fiber.Map{"from": branch.Warehouses, "to": *input.Warehouses}
+		set["warehouses"] = *input.Warehouses
+		branch.Warehouses = *input.Warehouses
+	}
+	branch.UpdatedAt = set["updated_at"].(time.Time)
+
+	ses, ctx, err := database.StartTransaction(b.BranchesCollection.Database().Client())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to start transaction")
+		return models.ReturnError(c, err)
+	}
+	defer ses.EndSession(ctx)
+
+	if _, err := b.BranchesCollection.UpdateOne(ctx, bson.M{"_id": branch.ID}, bson.M{"$set": set}); err != nil {
+		ses.AbortTransaction(ctx)
+		log.Error().Err(err).Str("branch_id", branch.ID).Msg("Failed to update branch")
+		return models.ReturnError(c, err)
+	}
+	if _, ok := set["name"]; ok {
+		if _, err := b.FinanceCollection.UpdateOne(ctx, bson.M{"branch_id": branch.ID}, bson.M{"$set": bson.M{"branch_name": branch.Name}}); err != nil {
+			ses.AbortTransaction(ctx)
+			log.Error().Err(err).Str("branch_id", branch.ID).Msg("Failed to rename finance of branch")
+			return models.ReturnError(c, err)
+		}
+	}
+	if err := ses.CommitTransaction(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to commit transaction")
+		return models.ReturnError(c, err)
+	}
+
+	log.Info().Str("branch_id", branch.ID).Interface("changes", changes).Msg("Branch updated")
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeUpdateBranch, changes, b.ActivitiesCollection)
+	return c.JSON(models.NewOutput([]models.Branch{branch}))
+}
+
+// referencedBy returns what references the branch, empty if nothing does
+func (b *BranchesController) referencedBy(ctx context.Context, branch_id string) (string, error) {
+	references := []struct {
+		name       string
+		collection *mongo.Collection
+		filter     bson.M
+	}{
+		{"journals", b.JournalsCollection, bson.M{"branch._id": branch_id}},
+		{"transactions", b.TransactionsCollection, bson.M{"branch_id": branch_id}},
+		{"proposals", b.ProposalsCollection, bson.M{"branch": branch_id}},
+		{"products", b.ProductsCollection, bson.M{"quantity_distribution.place.id": branch_id}},
+		{"users", b.UsersCollection, bson.M{"branch": branch_id}},
+	}
+	for _, reference := range references {
+		count, err := reference.collection.CountDocuments(ctx, reference.filter, options.Count().SetLimit(1))
+		if err != nil {
+			return "", err
+		}
+		if count > 0 {
+			return reference.name, nil
+		}
+	}
+	return "", nil
+}
+
+// DeleteBranch godoc
+// @Summary Delete branch
+// @Security BearerAuth
+// @Description Deletes the branch and its finance. Only branches nothing references (journals, transactions, proposals, products, users) can be deleted, the others are deactivated with update
+// @Tags branches
+// @Produce json
+// @Param id path string true "Branch ID"
+// @Success 200 {object} models.BranchesOutput
+// @Failure 404 {object} models.Output
+// @Failure 409 {object} models.Output
+// @Router /api/branches/{id} [delete]
+func (b *BranchesController) DeleteBranch(c *fiber.Ctx) error {
+	branch := models.Branch{}
+	err := b.BranchesCollection.FindOne(c.Context(), bson.M{"_id": c.Params("id")}).Decode(&branch)
+	if err == mongo.ErrNoDocuments {
+		return branchNotFound(c)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get branch")
+		return models.ReturnError(c, err)
+	}
+
+	referenced_by, err := b.referencedBy(c.Context(), branch.ID)
+	if err != nil {
+		log.Error().Err(err).Str("branch_id", branch.ID).Msg("Failed to check references of branch")
+		return models.ReturnError(c, err)
+	}
+	if referenced_by != "" {
+		return c.Status(fiber.StatusConflict).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Branch is referenced by " + referenced_by + ", deactivate it instead",
+			Code:    fiber.StatusConflict,
+		}))
+	}
+
+	ses, ctx, err := database.StartTransaction(b.BranchesCollection.Database().Client())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to start transaction")
+		return models.ReturnError(c, err)
+	}
+	defer ses.EndSession(ctx)
+
+	if _, err := b.BranchesCollection.DeleteOne(ctx, bson.M{"_id": branch.ID}); err != nil {
+		ses.AbortTransaction(ctx)
+		log.Error().Err(err).Str("branch_id", branch.ID).Msg("Failed to delete branch")
+		return models.ReturnError(c, err)
+	}
+	if _, err := b.FinanceCollection.DeleteOne(ctx, bson.M{"branch_id": branch.ID}); err != nil {
+		ses.AbortTransaction(ctx)
+		log.Error().Err(err).Str("branch_id", branch.ID).Msg("Failed to delete finance of branch")
+		return models.ReturnError(c, err)
+	}
+	if err := ses.CommitTransaction(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to commit transaction")
+		return models.ReturnError(c, err)
+	}
+
+	log.Info().Str("branch_id", branch.ID).Str("name", branch.Name).Msg("Branch deleted")
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeDeleteBranch, branch, b.ActivitiesCollection)
+	return c.JSON(models.NewOutput([]models.Branch{branch}))
+}
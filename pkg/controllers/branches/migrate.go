@@ -0,0 +1,241 @@
+package branches
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// legacyBranches are the branches which were hardcoded before branches were managed, the migration takes
+// their address and phone
+var legacyBranches = []models.BranchInput{
+	{
+		Name:    "Xonobod",
+		Address: "Xonobod",
+		Phone:   "+998 97 034 38 58",
+	},
+	{
+		Name:    "Yangi Hayot",
+		Address: "Yangi Hayot Qo'rg'ontepa mahallasi",
+		Phone:   "+998 33 119 12 13",
+	},
+	{
+		Name:    "Polevoy",
+		Address: "Polevoy Savatchi mahallasi",
+		Phone:   "+998 33 119 12 13",
+	},
+}
+
+const migrationUser = "migration"
+
+func legacyBranch(name string) (models.BranchInput, bool) {
+	for _, legacy := range legacyBranches {
+		if strings.EqualFold(legacy.Name, name) {
+			return legacy, true
+		}
+	}
+	return models.BranchInput{}, false
+}
+
+// Migrate creates the branches collection from the existing data. It is idempotent:
+//   - every finance gets a branch with its branch_id as ID, address and phone are taken from the legacy branches
+//   - legacy branches without finance are created with an empty finance
+//   - proposals referencing their branch by name are changed to the ID of the branch
+//   - journals with empty branch snapshot get the snapshot of their branch
+//   - users bound to a branch by name are changed to the ID, users and product places matching no branch are reported
+func (b *BranchesController) Migrate(ctx context.Context) (*models.BranchesMigrationReport, error) {
+	report := &models.BranchesMigrationReport{
+		BranchesCreated:  []string{},
+		FinancesCreated:  []string{},
+		UnknownProposals: []string{},
+		UnknownUsers:     []string{},
+		UnknownPlaces:    []string{},
+	}
+
+	// branches of finances
+	cursor, err := b.FinanceCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	finances := []models.BranchFinance{}
+	if err := cursor.All(ctx, &finances); err != nil {
+		return nil, err
+	}
+	for _, finance := range finances {
+		count, err := b.BranchesCollection.CountDocuments(ctx, bson.M{"_id": finance.BranchID})
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			continue
+		}
+		input, _ := legacyBranch(finance.BranchName)
+		input.Name = finance.BranchName
+		if err := input.Validate(); err != nil {
+			return nil, err
+		}
+		branch := models.NewBranch(input, migrationUser)
+		branch.ID = finance.BranchID
+		if _, err := b.BranchesCollection.InsertOne(ctx, branch); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				log.Warn().Str("branch_id", finance.BranchID).Str("name", finance.BranchName).Msg("Another branch has the name of the finance, skipping")
+				continue
+			}
+			return nil, err
+		}
+		report.BranchesCreated = append(report.BranchesCreated, branch.ID)
+	}
+
+	// legacy branches which never got a finance
+	for _, legacy := range legacyBranches {
+		_, err := models.FindBranch(ctx, legacy.Name, b.BranchesCollection)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, models.ErrBranchNotFound) {
+			return nil, err
+		}
+		input := legacy
+		if err := input.Validate(); err != nil {
+			return nil, err
+		}
+		branch := models.NewBranch(input, migrationUser)
+		if _, err := CreateBranchWithFinance(branch, nil, b.BranchesCollection, b.FinanceCollection); err != nil {
+			return nil, err
+		}
+		report.BranchesCreated = append(report.BranchesCreated, branch.ID)
+		report.FinancesCreated = append(report.FinancesCreated, branch.ID)
+	}
+
+	// proposals referenced the branch by its lower case name
+	proposal_branches := []string{}
+	if err := b.ProposalsCollection.Distinct(ctx, "branch", bson.M{}).Decode(&proposal_branches); err != nil {
+		return nil, err
+	}
+	for _, name := range proposal_branches {
+		branch, err := models.FindBranch(ctx, name, b.BranchesCollection)
+		if errors.Is(err, models.ErrBranchNotFound) {
+			report.UnknownProposals = append(report.UnknownProposals, name)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if branch.ID == name {
+			continue
+		}
+		result, err := b.ProposalsCollection.UpdateMany(ctx, bson.M{"branch": name}, bson.M{"$set": bson.M{"branch": branch.ID}})
+		if err != nil {
+			return nil, err
+		}
+		report.ProposalsUpdated += result.ModifiedCount
+	}
+
+	// journals opened by branch id got no name, location and phone
+	cursor, err = b.BranchesCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	branches := []models.Branch{}
+	if err := cursor.All(ctx, &branches); err != nil {
+		return nil, err
+	}
+	for _, branch := range branches {
+		result, err := b.JournalsCollection.UpdateMany(ctx,
+			bson.M{"branch._id": branch.ID, "branch.name": ""},
+			bson.M{"$set": bson.M{"branch": branch.Ref()}},
+		)
+		if err != nil {
+			return nil, err
+		}
+		report.JournalsUpdated += result.ModifiedCount
+	}
+
+	// users bound to a branch by its name or to a branch which does not exist
+	cursor, err = b.UsersCollection.Find(ctx, bson.M{"branch": bson.M{"$nin": append(branchIDs(branches), "")}})
+	if err != nil {
+		return nil, err
+	}
+	users := []models.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		branch, err := models.FindBranch(ctx, user.Branch, b.BranchesCollection)
+		if errors.Is(err, models.ErrBranchNotFound) {
+			report.UnknownUsers = append(report.UnknownUsers, user.Username)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err := b.UsersCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"branch": branch.ID, "updated_at": time.Now()}}); err != nil {
+			return nil, err
+		}
+		report.UsersUpdated++
+	}
+
+	// places of type branch of products
+	cursor, err = b.ProductsCollection.Aggregate(ctx, bson.A{
+		bson.M{"$unwind": "$quantity_distribution"},
+		bson.M{"$match": bson.M{
+			"quantity_distribution.place.place_type": models.ProductPlaceTypeBranch,
+			"quantity_distribution.place.id":         bson.M{"$nin": branchIDs(branches)},
+		}},
+		bson.M{"$group": bson.M{"_id": "$quantity_distribution.place.id"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	places := []struct {
+		ID string `bson:"_id"`
+	}{}
+	if err := cursor.All(ctx, &places); err != nil {
+		return nil, err
+	}
+	for _, place := range places {
+		report.UnknownPlaces = append(report.UnknownPlaces, place.ID)
+	}
+
+	return report, nil
+}
+
+func branchIDs(branches []models.Branch) []string {
+	ids := []string{}
+	for _, branch := range branches {
+		ids = append(ids, branch.ID)
+	}
+	return ids
+}
+
+// MigrateBranches godoc
+// @Summary Migrate branches
+// @Security BearerAuth
+// @Description Creates the branches from the finances and the legacy hardcoded branches, changes proposals and users referencing branches by name to the ID and fills empty journal snapshots. It can be run again safely, the report tells what was changed and what references no branch
+// @Tags branches
+// @Produce json
+// @Success 200 {object} models.BranchesMigrationOutput
+// @Router /api/branches/migrate [post]
+func (b *BranchesController) MigrateBranches(c *fiber.Ctx) error {
+	report, err := b.Migrate(c.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to migrate branches")
+		return models.ReturnError(c, err)
+	}
+
+	log.Info().Interface("report", report).Msg("Branches migrated")
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeMigrateBranches, report, b.ActivitiesCollection)
+	return c.JSON(models.BranchesMigrationOutput{
+		Data:  *report,
+		Error: []models.Error{},
+	})
+}
@@ -2,9 +2,12 @@ package bnpl
 
 import (
 	"context"
+	"errors"
 	"time"
 
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/ledger"
 	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/transactions"
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
 	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
 	"github.com/aslon1213/g4h_pos_erp/platform/database"
 	"github.com/gofiber/fiber/v2"
@@ -61,23 +64,70 @@ func (ctrl *BNPLController) NewBNPL(c *fiber.Ctx) error {
 			Code:    fiber.StatusBadRequest,
 		}))
 	}
+	if !middleware.CanAccessBranch(c, new_bnpl_input.BranchID) {
+		return middleware.ForbiddenBranch(c)
+	}
 
-	// check if the customer exists
-	customer := &models.Customer{}
-	err := ctrl.customersCollection.FindOne(context.Background(), bson.M{"_id": new_bnpl_input.CustomerID}).Decode(customer)
+	// the bnpl and its ledger entry are written together
+	session, ctx, err := database.StartTransaction(ctrl.customersCollection.Database().Client())
 	if err != nil {
-		log.Error().Err(err).Str("customer_id", new_bnpl_input.CustomerID).Msg("Customer not found")
+		log.Error().Err(err).Msg("Failed to start transaction")
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusInternalServerError,
+		}))
+	}
+	defer session.EndSession(ctx)
+	ctx = middleware.Attributed(c, ctx)
+
+	bnpl, err := NewBNPL(ctx, new_bnpl_input, ctrl.customersCollection)
+	if errors.Is(err, ErrCustomerNotFound) {
+		session.AbortTransaction(ctx)
 		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
 			Message: err.Error(),
 			Code:    fiber.StatusBadRequest,
 		}))
 	}
+	if err != nil {
+		session.AbortTransaction(ctx)
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusInternalServerError,
+		}))
+	}
+	if err := session.CommitTransaction(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to commit transaction")
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusInternalServerError,
+		}))
+	}
+
+	log.Info().Str("bnpl_id", bnpl.ID).Msg("Successfully created new BNPL")
+	return c.JSON(models.NewOutput([]interface{}{}))
+}
+
+var ErrCustomerNotFound = errors.New("customer not found")
+
+// NewBNPL creates a BNPL for the customer, pushes it to the customer document and posts it to the ledger. Must run in a db transaction.
+// Also used by sales when part of a receipt is paid with BNPL
+func NewBNPL(ctx context.Context, new_bnpl_input *models.NewBNPLInput, customersCollection *mongo.Collection) (*models.BNPL, error) {
+	// check if the customer exists
+	count, err := customersCollection.CountDocuments(ctx, bson.M{"_id": new_bnpl_input.CustomerID})
+	if err != nil {
+		log.Error().Err(err).Str("customer_id", new_bnpl_input.CustomerID).Msg("Failed to find customer")
+		return nil, err
+	}
+	if count == 0 {
+		log.Error().Str("customer_id", new_bnpl_input.CustomerID).Msg("Customer not found")
+		return nil, ErrCustomerNotFound
+	}
 
 	// calculate total amount
-	var total_amount int32
+	var total_amount int64
 	if new_bnpl_input.CalculateTotalAmount {
 		for _, product := range new_bnpl_input.Products {
-			total_amount += product.Price * int32(product.Quantity)
+			total_amount += int64(product.Price) * int64(product.Quantity)
 		}
 	} else {
 		total_amount = new_bnpl_input.TotalAmount
@@ -96,17 +146,24 @@ func (ctrl *BNPLController) NewBNPL(c *fiber.Ctx) error {
 		UpdatedAt:    time.Now(),
 	}
 
-	_, err = ctrl.customersCollection.UpdateOne(context.Background(), bson.M{"_id": new_bnpl_input.CustomerID}, bson.M{"$push": bson.M{"bnpls": bnpl}})
+	_, err = customersCollection.UpdateOne(ctx, bson.M{"_id": new_bnpl_input.CustomerID}, bson.M{"$push": bson.M{"bnpls": bnpl}})
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to update customer with new BNPL")
-		return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
-			Message: err.Error(),
-			Code:    fiber.StatusInternalServerError,
-		}))
+		return nil, err
 	}
 
-	log.Info().Str("bnpl_id", bnpl.ID).Msg("Successfully created new BNPL")
-	return c.JSON(models.NewOutput([]interface{}{}))
+	// the sale is made, its money is received later through credits
+	if total_amount > 0 {
+		entry := models.NewLedgerEntry(ctx, bnpl.BranchID, models.InitiatorTypeBNPL, "BNPL of customer "+bnpl.CustomerID,
+			models.DebitLine(models.AccountReceivables, int64(total_amount)),
+			models.CreditLine(models.AccountSales, int64(total_amount)),
+		)
+		entry.Reference = bnpl.ID
+		if err := ledger.Post(ctx, ledger.Collection(customersCollection), entry); err != nil {
+			return nil, err
+		}
+	}
+	return bnpl, nil
 }
 
 // CreditBNPL godoc
@@ -120,6 +177,7 @@ func (ctrl *BNPLController) NewBNPL(c *fiber.Ctx) error {
 // @Param amount query int true "Payment amount"
 // @Param payment_method query string false "Payment method" default(cash)
 // @Success 200 {object} models.BNPL
+// @Failure 403 {object} models.Output
 // @Failure 500 {object} models.Error
 // @Router /api/bnpl/{id}/credit [post]
 func (ctrl *BNPLController) CreditBNPL(c *fiber.Ctx) error {
@@ -151,7 +209,7 @@ func (ctrl *BNPLController) CreditBNPL(c *fiber.Ctx) error {
 	defer session.EndSession(ctx)
 
 	transaction := models.TransactionBase{
-		Amount:        uint32(amount),
+		Amount:        int64(amount),
 		Description:   "Credit BNPL",
 		Type:          models.TransactionTypeCredit,
 		PaymentMethod: models.PaymentMethod(payment_method),
@@ -167,6 +225,10 @@ func (ctrl *BNPLController) CreditBNPL(c *fiber.Ctx) error {
 			Code:    fiber.StatusInternalServerError,
 		}))
 	}
+	if !middleware.CanAccessBranch(c, bnpl.BranchID) {
+		session.AbortTransaction(ctx)
+		return middleware.ForbiddenBranch(c)
+	}
 
 	trx_id, err := transactions.NewTransaction(
 		ctx,
@@ -174,6 +236,7 @@ func (ctrl *BNPLController) CreditBNPL(c *fiber.Ctx) error {
 		models.InitiatorTypeBNPL,
 		bnpl.BranchID,
 		ctrl.transactionsCollection,
+		ctrl.financeCollection,
 	)
 
 	if err != nil {
@@ -212,7 +275,7 @@ func (ctrl *BNPLController) CreditBNPL(c *fiber.Ctx) error {
 		}))
 	}
 
-	total_paid_amount := bnpl.PaidAmount + int32(amount)
+	total_paid_amount := bnpl.PaidAmount + int64(amount)
 	bnpl.UpdatedAt = time.Now()
 	bnpl.PaidAmount = total_paid_amount
 	bnpl.Transactions = append(bnpl.Transactions, trx_id)
@@ -263,6 +326,8 @@ func (ctrl *BNPLController) CreditBNPL(c *fiber.Ctx) error {
 // @Produce json
 // @Param id path string true "BNPL ID"
 // @Success 200 {object} map[string]string
+// @Failure 409 {object} models.Output
+// @Failure 403 {object} models.Output
 // @Failure 500 {object} models.Error
 // @Router /api/bnpl/{id} [delete]
 func (ctrl *BNPLController) DeleteBNPL(c *fiber.Ctx) error {
@@ -270,8 +335,54 @@ func (ctrl *BNPLController) DeleteBNPL(c *fiber.Ctx) error {
 
 	bnpl_id := c.Params("id")
 
-	_, err := ctrl.customersCollection.UpdateOne(
-		context.Background(),
+	bnpl, err := GetBNPLByIDFromDB(context.Background(), bnpl_id, ctrl.customersCollection)
+	if err != nil {
+		log.Error().Err(err).Str("bnpl_id", bnpl_id).Msg("Failed to find BNPL")
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusInternalServerError,
+		}))
+	}
+	if !middleware.CanAccessBranch(c, bnpl.BranchID) {
+		return middleware.ForbiddenBranch(c)
+	}
+	err = models.CheckPeriodOpen(c.Context(), models.PeriodClosesCollection(ctrl.customersCollection), bnpl.BranchID, bnpl.CreatedAt)
+	if errors.Is(err, models.ErrPeriodClosed) {
+		return middleware.PeriodClosed(c, err)
+	}
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+
+	session, ctx, err := database.StartTransaction(ctrl.customersCollection.Database().Client())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to start transaction")
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusInternalServerError,
+		}))
+	}
+	defer session.EndSession(ctx)
+	ctx = middleware.Attributed(c, ctx)
+
+	// the unpaid part of the sale is cancelled, credits already received stay
+	if unpaid := bnpl.TotalAmount - bnpl.PaidAmount; unpaid > 0 {
+		entry := models.NewLedgerEntry(ctx, bnpl.BranchID, models.InitiatorTypeBNPL, "Deleted BNPL of customer "+bnpl.CustomerID,
+			models.DebitLine(models.AccountSales, int64(unpaid)),
+			models.CreditLine(models.AccountReceivables, int64(unpaid)),
+		)
+		entry.Reference = bnpl.ID
+		if err := ledger.Post(ctx, ledger.Collection(ctrl.customersCollection), entry); err != nil {
+			session.AbortTransaction(ctx)
+			return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
+				Message: err.Error(),
+				Code:    fiber.StatusInternalServerError,
+			}))
+		}
+	}
+
+	_, err = ctrl.customersCollection.UpdateOne(
+		ctx,
 		bson.M{
 			"bnpls.id": bnpl_id,
 		},
@@ -283,6 +394,14 @@ func (ctrl *BNPLController) DeleteBNPL(c *fiber.Ctx) error {
 	)
 	if err != nil {
 		log.Error().Err(err).Str("bnpl_id", bnpl_id).Msg("Failed to delete BNPL")
+		session.AbortTransaction(ctx)
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusInternalServerError,
+		}))
+	}
+	if err := session.CommitTransaction(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to commit transaction")
 		return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
 			Message: err.Error(),
 			Code:    fiber.StatusInternalServerError,
@@ -304,6 +423,7 @@ func (ctrl *BNPLController) DeleteBNPL(c *fiber.Ctx) error {
 // @Produce json
 // @Param id path string true "BNPL ID"
 // @Success 200 {object} models.Output
+// @Failure 403 {object} models.Output
 // @Failure 500 {object} models.Error
 // @Router /api/bnpl/{id} [get]
 func (ctrl *BNPLController) GetBNPLByID(c *fiber.Ctx) error {
@@ -318,6 +438,9 @@ func (ctrl *BNPLController) GetBNPLByID(c *fiber.Ctx) error {
 			Code:    fiber.StatusInternalServerError,
 		}))
 	}
+	if !middleware.CanAccessBranch(c, bnpl.BranchID) {
+		return middleware.ForbiddenBranch(c)
+	}
 
 	log.Info().Str("bnpl_id", bnpl_id).Msg("Successfully retrieved BNPL details")
 	return c.JSON(models.NewOutput(bnpl))
@@ -366,6 +489,10 @@ func GetBNPLByIDFromDB(ctx context.Context, bnpl_id string, customersCollection
 		return nil, err
 	}
 
+	if len(output) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+
 	log.Debug().Interface("output", output).Str("bnpl_id", bnpl_id).Msg("Successfully retrieved BNPL from database")
 	return &output[0].BNPL, nil
 }
@@ -401,8 +528,19 @@ func (ctrl *BNPLController) GetBNPLSofCustomer(c *fiber.Ctx) error {
 		}))
 	}
 
+	bnpls := customer.BNPLs
+	if branch_id != "" {
+		// only the bnpls of the branch, the customer may have bnpls in other branches
+		bnpls = []models.BNPL{}
+		for _, bnpl := range customer.BNPLs {
+			if bnpl.BranchID == branch_id {
+				bnpls = append(bnpls, bnpl)
+			}
+		}
+	}
+
 	log.Info().Str("customer_id", customer_id).Msg("Successfully retrieved customer BNPLs")
-	return c.JSON(models.NewOutput(customer.BNPLs))
+	return c.JSON(models.NewOutput(bnpls))
 }
 
 // Get BNPLs of branch
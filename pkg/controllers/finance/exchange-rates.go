@@ -0,0 +1,147 @@
+package finance
+
+import (
+	"errors"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// SetExchangeRate godoc
+// @Security BearerAuth
+// @Summary Set the exchange rate of a currency for a day
+// @Description Stores the rate of the currency in the base currency (UZS by default) for the day of the date (today by default),
+// @Description the rate of the day is replaced if it was already set. Transactions in foreign currencies are converted at the latest rate of their day or before it
+// @Tags finance
+// @Accept json
+// @Produce json
+// @Param input body models.ExchangeRateInput true "Rate"
+// @Success 200 {object} models.ExchangeRateOutputSingle
+// @Failure 400 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/finance/exchange-rates [post]
+func (f *FinanceController) SetExchangeRate(c *fiber.Ctx) error {
+	input := models.ExchangeRateInput{}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+	}
+	if err := input.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+	}
+
+	user, _ := c.Locals("user").(string)
+	rate := models.NewExchangeRate(&input, user)
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := f.ExchangeRatesCollection.FindOneAndUpdate(c.Context(), bson.M{
+		"currency": rate.Currency,
+		"base":     rate.Base,
+		"date":     rate.Date,
+	}, bson.M{
+		"$set": bson.M{
+			"rate":       rate.Rate,
+			"created_by": rate.CreatedBy,
+			"updated_at": rate.UpdatedAt,
+		},
+		"$setOnInsert": bson.M{
+			"_id":        uuid.New().String(),
+			"created_at": rate.CreatedAt,
+		},
+	}, opts).Decode(rate)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to set exchange rate")
+		return models.ReturnError(c, err)
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeSetExchangeRate, rate, f.ActivitiesCollection)
+	log.Info().Str("currency", string(rate.Currency)).Str("base", string(rate.Base)).Time("date", rate.Date).Float64("rate", rate.Rate).Msg("Exchange rate set")
+	return c.JSON(models.NewOutput(rate))
+}
+
+// GetExchangeRates godoc
+// @Security BearerAuth
+// @Summary List exchange rates
+// @Description Stored daily rates, newest first. Users bound to a branch only get the rates to the base currency of their branch
+// @Tags finance
+// @Produce json
+// @Param params query models.ExchangeRateQueryParams false "Query params"
+// @Success 200 {object} models.ExchangeRateOutput
+// @Failure 400 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/finance/exchange-rates [get]
+func (f *FinanceController) GetExchangeRates(c *fiber.Ctx) error {
+	params := models.ExchangeRateQueryParams{}
+	if err := c.QueryParser(&params); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+	}
+	filter := bson.M{}
+	if params.Currency != "" {
+		filter["currency"] = params.Currency
+	}
+	if params.Base != "" {
+		filter["base"] = params.Base
+	}
+	if branch, bound := middleware.UserBranch(c); bound {
+		finance := models.BranchFinance{}
+		if err := f.FinanceCollection.FindOne(c.Context(), bson.M{"branch_id": branch}).Decode(&finance); err != nil {
+			return models.ReturnError(c, err)
+		}
+		filter["base"] = finance.Currency.OrDefault()
+	}
+	date := bson.M{}
+	if !params.DateMin.IsZero() {
+		date["$gte"] = models.RateDay(params.DateMin)
+	}
+	if !params.DateMax.IsZero() {
+		date["$lte"] = models.RateDay(params.DateMax)
+	}
+	if len(date) > 0 {
+		filter["date"] = date
+	}
+
+	cursor, err := f.ExchangeRatesCollection.Find(c.Context(), filter, options.Find().SetSort(bson.D{{Key: "date", Value: -1}, {Key: "currency", Value: 1}}))
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+	rates := []models.ExchangeRate{}
+	if err := cursor.All(c.Context(), &rates); err != nil {
+		return models.ReturnError(c, err)
+	}
+	return c.JSON(models.NewOutput(rates))
+}
+
+// GetExchangeRate godoc
+// @Security BearerAuth
+// @Summary Exchange rate of a day
+// @Description The rate converting the currency to the base on the date (today by default) --- the latest stored rate of the day or before it
+// @Tags finance
+// @Produce json
+// @Param params query models.ExchangeRateLookupParams true "Query params"
+// @Success 200 {object} models.ExchangeRateOutputSingle
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/finance/exchange-rates/rate [get]
+func (f *FinanceController) GetExchangeRate(c *fiber.Ctx) error {
+	params := models.ExchangeRateLookupParams{}
+	if err := c.QueryParser(&params); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+	}
+	if err := params.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+	}
+
+	rate, err := models.FindExchangeRate(c.Context(), f.ExchangeRatesCollection, params.Currency, params.Base, params.Date)
+	if errors.Is(err, models.ErrExchangeRateNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusNotFound)))
+	}
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+	return c.JSON(models.NewOutput(rate))
+}
@@ -2,13 +2,15 @@ package finance
 
 import (
 	"context"
+	"errors"
 	"net/url"
 
 	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
 
+	"github.com/aslon1213/g4h_pos_erp/pkg/configs"
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/branches"
 	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
 	"github.com/gofiber/fiber/v2"
-	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
@@ -16,8 +18,14 @@ import (
 )
 
 type FinanceController struct {
-	FinanceCollection    *mongo.Collection
-	ActivitiesCollection *mongo.Collection
+	FinanceCollection       *mongo.Collection
+	BranchesCollection      *mongo.Collection
+	ActivitiesCollection    *mongo.Collection
+	TransactionsCollection  *mongo.Collection
+	AdjustmentsCollection   *mongo.Collection
+	ExchangeRatesCollection *mongo.Collection
+	PeriodClosesCollection  *mongo.Collection
+	Reconciliation          configs.ReconciliationConfig
 }
 
 func New(db *mongo.Database) *FinanceController {
@@ -25,25 +33,63 @@ func New(db *mongo.Database) *FinanceController {
 	financeCollection := db.Collection("finance")
 
 	log.Info().Msg("Creating indexes for finance collection")
+	// a branch has one finance
 	financeCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
-		Keys: bson.M{"branch_id": 1},
+		Keys:    bson.M{"branch_id": 1},
+		Options: options.Index().SetUnique(true),
 	})
 	_, _ = financeCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
 		Keys:    bson.M{"branch_name": 1},
 		Options: options.Index().SetUnique(true),
 	})
 
+	adjustmentsCollection := db.Collection("finance_adjustments")
+	_, _ = adjustmentsCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "branch_id", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+
+	// both sides of a money transfer are found by its id
+	transactionsCollection := db.Collection("transactions")
+	_, _ = transactionsCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.M{"transfer_id": 1},
+		Options: options.Index().SetSparse(true),
+	})
+
+	// a currency has one rate per day in a base currency
+	exchangeRatesCollection := db.Collection("exchange_rates")
+	_, _ = exchangeRatesCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "currency", Value: 1}, {Key: "base", Value: 1}, {Key: "date", Value: -1}},
+		Options: options.Index().SetUnique(true),
+	})
+
+	// the lock date of a branch is looked up on every change of a financial document
+	periodClosesCollection := db.Collection("period_closes")
+	_, _ = periodClosesCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "branch_id", Value: 1}, {Key: "reopened", Value: 1}, {Key: "lock_date", Value: -1}},
+	})
+
+	config, err := configs.LoadConfig(".")
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load config")
+	}
+
 	log.Info().Msg("FinanceController initialized successfully")
 	return &FinanceController{
-		FinanceCollection:    financeCollection,
-		ActivitiesCollection: db.Collection("activities"),
+		FinanceCollection:       financeCollection,
+		BranchesCollection:      db.Collection("branches"),
+		ActivitiesCollection:    db.Collection("activities"),
+		TransactionsCollection:  transactionsCollection,
+		AdjustmentsCollection:   adjustmentsCollection,
+		ExchangeRatesCollection: exchangeRatesCollection,
+		PeriodClosesCollection:  periodClosesCollection,
+		Reconciliation:          config.Reconciliation,
 	}
 }
 
 // GetBranches godoc
 // @Security BearerAuth
 // @Summary Fetch all branches
-// @Description Retrieve all branches from the finance collection
+// @Description Retrieve all branches from the finance collection, users bound to a branch only get their branch
 // @Tags finance
 // @Produce json
 // @Success 200 {object} models.Output
@@ -51,7 +97,11 @@ func New(db *mongo.Database) *FinanceController {
 // @Router /api/finance/branches [get]
 func (f *FinanceController) GetBranches(c *fiber.Ctx) error {
 	log.Debug().Msg("Fetching all branches")
-	cursor, err := f.FinanceCollection.Find(context.Background(), bson.M{})
+	filter := bson.M{}
+	if branch, bound := middleware.UserBranch(c); bound {
+		filter["branch_id"] = branch
+	}
+	cursor, err := f.FinanceCollection.Find(context.Background(), filter)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to fetch branches")
 		return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusInternalServerError)))
@@ -110,6 +160,7 @@ func (f *FinanceController) GetFinanceBranchByBranchID(c *fiber.Ctx) error {
 // @Param branch_name path string true "Branch Name"
 // @Produce json
 // @Success 200 {object} models.Output
+// @Failure 403 {object} models.Output
 // @Failure 404 {object} models.Output
 // @Router /api/finance/branch/name/{branch_name} [get]
 func (f *FinanceController) GetFinanceByBranchName(c *fiber.Ctx) error {
@@ -127,6 +178,9 @@ func (f *FinanceController) GetFinanceByBranchName(c *fiber.Ctx) error {
 		log.Error().Err(err).Str("branch_name", branchName).Msg("Branch not found")
 		return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.NewError("Branch not found", fiber.StatusNotFound)))
 	}
+	if !middleware.CanAccessBranch(c, branch.BranchID) {
+		return middleware.ForbiddenBranch(c)
+	}
 
 	log.Debug().Str("branch_name", branchName).Msg("Successfully fetched branch finance")
 	return c.JSON(models.NewOutput(branch))
@@ -135,7 +189,7 @@ func (f *FinanceController) GetFinanceByBranchName(c *fiber.Ctx) error {
 // NewFinanceOfBranch godoc
 // @Security BearerAuth
 // @Summary Create new finance for a branch
-// @Description Add new financial records for a branch
+// @Description Add new financial records for a branch. The branch is found by branch_id or branch_name, a branch is created with the finance if there is no branch with the name
 // @Tags finance
 // @Accept json
 // @Produce json
@@ -153,25 +207,35 @@ func (f *FinanceController) NewFinanceOfBranch(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
 	}
 
-	branchFinance := models.BranchFinance{
-		BranchID:   uuid.New().String(),
-		BranchName: Input.BranchName,
-		Details:    Input.Details,
-		Finance: models.Finance{
-			Balance: models.Balance{
-				Cash:       0,
-				Bank:       0,
-				MobileApps: 0,
-			},
-			TotalIncome:   0,
-			TotalExpenses: 0,
-			Debt:          0,
-		},
-		Suppliers: []string{},
+	var branchFinance models.BranchFinance
+	id_or_name := Input.BranchID
+	if id_or_name == "" {
+		id_or_name = Input.BranchName
+	}
+	branch, err := models.FindBranch(context.Background(), id_or_name, f.BranchesCollection)
+	switch {
+	case err == nil:
+		// finance of an existing branch
+		branchFinance = models.NewBranchFinance(branch, Input.Details)
+		log.Info().Str("branch_id", branchFinance.BranchID).Msg("Inserting new branch finance")
+		_, err = f.FinanceCollection.InsertOne(context.Background(), branchFinance)
+	case errors.Is(err, models.ErrBranchNotFound) && Input.BranchID == "" && Input.BranchName != "":
+		// the branch is created with its finance
+		input := models.BranchInput{Name: Input.BranchName}
+		if err := input.Validate(); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+		}
+		created_by, _ := c.Locals("user").(string)
+		branch = models.NewBranch(input, created_by)
+		log.Info().Str("branch_id", branch.ID).Msg("Inserting new branch with finance")
+		var finance *models.BranchFinance
+		finance, err = branches.CreateBranchWithFinance(branch, Input.Details, f.BranchesCollection, f.FinanceCollection)
+		if finance != nil {
+			branchFinance = *finance
+		}
+	case errors.Is(err, models.ErrBranchNotFound):
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
 	}
-
-	log.Info().Str("branch_id", branchFinance.BranchID).Msg("Inserting new branch finance")
-	_, err := f.FinanceCollection.InsertOne(context.Background(), branchFinance)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to insert new branch finance")
 		return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusInternalServerError)))
@@ -0,0 +1,150 @@
+package finance
+
+import (
+	"context"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// toLong converts the stored 32-bit value of the field to a 64-bit integer, missing fields become 0
+func toLong(field string) bson.M {
+	return bson.M{"$toLong": bson.M{"$ifNull": bson.A{"$" + field, 0}}}
+}
+
+// anyInt returns the conditions matching documents in which any of the fields is still a 32-bit integer
+func anyInt(fields ...string) bson.A {
+	conditions := bson.A{}
+	for _, field := range fields {
+		conditions = append(conditions, bson.M{field: bson.M{"$type": "int"}})
+	}
+	return conditions
+}
+
+// toLongFields returns the $set stage converting the fields to 64-bit integers
+func toLongFields(fields ...string) bson.M {
+	set := bson.M{}
+	for _, field := range fields {
+		set[field] = toLong(field)
+	}
+	return set
+}
+
+// amounts of finances and suppliers converted by the migration
+var (
+	financeFields = []string{"finance.balance.cash", "finance.balance.bank", "finance.balance.terminal", "finance.balance.mobile_apps",
+		"finance.total_income", "finance.total_expenses", "finance.debt"}
+	supplierFields = []string{"financial_data.balance", "financial_data.total_income", "financial_data.total_expenses"}
+)
+
+// MigrateMoney converts the amounts of the documents written before currencies to 64-bit integers and gives them the default currency.
+// It is idempotent, documents are matched by the missing currency or base amount or by any of their amounts still being 32-bit:
+//   - transactions get the currency and their amount as base amount
+//   - branches, finances and suppliers get the default currency, finances and suppliers their balances as 64-bit integers
+//   - totals of journals, receipts and amounts of expenses are converted to 64-bit integers
+//
+// Transactions embedded in suppliers and tenders of receipts are decoded into 64-bit fields as they are
+func (f *FinanceController) MigrateMoney(ctx context.Context) (*models.MoneyMigrationReport, error) {
+	report := &models.MoneyMigrationReport{}
+	db := f.FinanceCollection.Database()
+	currency := bson.M{"$ifNull": bson.A{"$currency", models.DefaultCurrency}}
+
+	migrations := []struct {
+		collection string
+		filter     bson.M
+		set        bson.M
+		count      *int64
+	}{
+		{
+			collection: "transactions",
+			filter:     bson.M{"$or": append(anyInt("transactionbase.amount", "base_amount"), bson.M{"base_amount": bson.M{"$exists": false}})},
+			set: bson.M{
+				"transactionbase.amount":   toLong("transactionbase.amount"),
+				"transactionbase.currency": bson.M{"$ifNull": bson.A{"$transactionbase.currency", models.DefaultCurrency}},
+				"base_amount":              bson.M{"$toLong": bson.M{"$ifNull": bson.A{"$base_amount", "$transactionbase.amount"}}}, // converted amounts keep their base
+			},
+			count: &report.Transactions,
+		},
+		{
+			collection: "branches",
+			filter:     bson.M{"currency": bson.M{"$exists": false}},
+			set:        bson.M{"currency": models.DefaultCurrency},
+			count:      &report.Branches,
+		},
+		{
+			collection: "finance",
+			filter:     bson.M{"$or": append(anyInt(financeFields...), bson.M{"currency": bson.M{"$exists": false}})},
+			set: func() bson.M {
+				set := toLongFields(financeFields...)
+				set["currency"] = currency
+				return set
+			}(),
+			count: &report.Finances,
+		},
+		{
+			collection: "suppliers",
+			filter:     bson.M{"$or": append(anyInt(supplierFields...), bson.M{"currency": bson.M{"$exists": false}})},
+			set: func() bson.M {
+				set := toLongFields(supplierFields...)
+				set["currency"] = currency
+				return set
+			}(),
+			count: &report.Suppliers,
+		},
+		{
+			collection: "journals",
+			filter:     bson.M{"$or": anyInt("total", "cash_left", "terminal_income")},
+			set:        toLongFields("total", "cash_left", "terminal_income"),
+			count:      &report.Journals,
+		},
+		{
+			collection: "receipts",
+			filter:     bson.M{"$or": anyInt("total", "refunded")},
+			set:        toLongFields("total", "refunded"),
+			count:      &report.Receipts,
+		},
+		{
+			collection: "internal_expenses",
+			filter:     bson.M{"$or": anyInt("amount")},
+			set:        toLongFields("amount"),
+			count:      &report.Expenses,
+		},
+	}
+	for _, migration := range migrations {
+		result, err := db.Collection(migration.collection).UpdateMany(ctx, migration.filter, bson.A{bson.M{"$set": migration.set}})
+		if err != nil {
+			log.Error().Err(err).Str("collection", migration.collection).Msg("Failed to migrate money")
+			return nil, err
+		}
+		*migration.count = result.ModifiedCount
+	}
+	return report, nil
+}
+
+// MigrateMoneyHandler godoc
+// @Security BearerAuth
+// @Summary Migrate amounts to 64-bit integers and currencies
+// @Description Converts the 32-bit amounts, balances and totals of existing documents to 64-bit integers and gives transactions, branches,
+// @Description finances and suppliers the default currency (UZS). It can be run again safely, the report tells how many documents were changed
+// @Tags finance
+// @Produce json
+// @Success 200 {object} models.MoneyMigrationOutput
+// @Failure 500 {object} models.Output
+// @Router /api/finance/migrate-money [post]
+func (f *FinanceController) MigrateMoneyHandler(c *fiber.Ctx) error {
+	report, err := f.MigrateMoney(c.Context())
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+
+	log.Info().Interface("report", report).Msg("Money migrated")
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeMigrateMoney, report, f.ActivitiesCollection)
+	return c.JSON(models.MoneyMigrationOutput{
+		Data:  *report,
+		Error: []models.Error{},
+	})
+}
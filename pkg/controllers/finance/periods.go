@@ -0,0 +1,226 @@
+package finance
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/ledger"
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+	"github.com/aslon1213/g4h_pos_erp/pkg/utils"
+	"github.com/aslon1213/g4h_pos_erp/platform/database"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// ClosePeriod godoc
+// @Security BearerAuth
+// @Summary Close the accounting period of a branch
+// @Description Locks the financial documents of the branch dated before the lock date: sales, journals and their operations, supplier payments,
+// @Description BNPLs, expenses and transactions dated before it can no longer be created, changed or deleted. The balances of the finance and
+// @Description the trial balance of the ledger up to the lock date are kept on the close. The lock date must be after the current one
+// @Tags finance
+// @Accept json
+// @Produce json
+// @Param branch_id path string true "Branch ID"
+// @Param input body models.PeriodCloseInput true "Close"
+// @Success 201 {object} models.PeriodCloseOutputSingle
+// @Failure 400 {object} models.Output
+// @Failure 409 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/finance/periods/{branch_id}/close [post]
+func (f *FinanceController) ClosePeriod(c *fiber.Ctx) error {
+	branch_id := c.Params("branch_id")
+	input := models.PeriodCloseInput{}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+	}
+	if err := input.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+	}
+
+	ses, ctx, err := database.StartTransaction(f.FinanceCollection.Database().Client())
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+	defer ses.EndSession(ctx)
+
+	latest, err := models.LatestPeriodClose(ctx, f.PeriodClosesCollection, branch_id)
+	if err != nil {
+		ses.AbortTransaction(ctx)
+		return models.ReturnError(c, err)
+	}
+	if latest != nil && !input.LockDate.After(latest.LockDate) {
+		ses.AbortTransaction(ctx)
+		return c.Status(fiber.StatusConflict).JSON(models.NewOutput([]interface{}{}, models.NewError(models.ErrPeriodAlreadyClosed.Error(), fiber.StatusConflict)))
+	}
+
+	finance := models.BranchFinance{}
+	err = f.FinanceCollection.FindOne(ctx, bson.M{"branch_id": branch_id}).Decode(&finance)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		ses.AbortTransaction(ctx)
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(ErrFinanceNotFound.Error(), fiber.StatusBadRequest)))
+	}
+	if err != nil {
+		ses.AbortTransaction(ctx)
+		return models.ReturnError(c, err)
+	}
+	trial_balance, err := ledger.TrialBalanceOf(ctx, ledger.Collection(f.FinanceCollection), branch_id, input.LockDate)
+	if err != nil {
+		ses.AbortTransaction(ctx)
+		log.Error().Err(err).Str("branch_id", branch_id).Msg("Failed to compute trial balance of period")
+		return models.ReturnError(c, err)
+	}
+
+	user, _ := c.Locals("user").(string)
+	period := models.NewPeriodClose(branch_id, &input, finance.Finance, trial_balance, user)
+	if _, err := f.PeriodClosesCollection.InsertOne(ctx, period); err != nil {
+		ses.AbortTransaction(ctx)
+		return models.ReturnError(c, err)
+	}
+	if err := ses.CommitTransaction(ctx); err != nil {
+		return models.ReturnError(c, err)
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeClosePeriod, fiber.Map{
+		"period_id": period.ID,
+		"branch_id": branch_id,
+		"lock_date": period.LockDate,
+		"note":      period.Note,
+	}, f.ActivitiesCollection)
+	log.Info().Str("period_id", period.ID).Str("branch_id", branch_id).Time("lock_date", period.LockDate).Msg("Period closed")
+	return c.Status(fiber.StatusCreated).JSON(models.NewOutput(period))
+}
+
+// ReopenPeriod godoc
+// @Security BearerAuth
+// @Summary Reopen a closed accounting period
+// @Description Reopens the latest close of the branch, the lock date falls back to the previous close. Who reopened it, when and why
+// @Description is kept on the close
+// @Tags finance
+// @Accept json
+// @Produce json
+// @Param id path string true "Period close ID"
+// @Param input body models.PeriodReopenInput true "Reason"
+// @Success 200 {object} models.PeriodCloseOutputSingle
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 409 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/finance/periods/{id}/reopen [post]
+func (f *FinanceController) ReopenPeriod(c *fiber.Ctx) error {
+	period_id := c.Params("id")
+	input := models.PeriodReopenInput{}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+	}
+	if err := input.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+	}
+
+	period := &models.PeriodClose{}
+	err := f.PeriodClosesCollection.FindOne(c.Context(), bson.M{"_id": period_id}).Decode(period)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.NewError("Period close not found", fiber.StatusNotFound)))
+	}
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+	if period.Reopened {
+		return c.Status(fiber.StatusConflict).JSON(models.NewOutput([]interface{}{}, models.NewError(models.ErrPeriodReopened.Error(), fiber.StatusConflict)))
+	}
+	// later closes would keep the period locked, they are reopened first
+	latest, err := models.LatestPeriodClose(c.Context(), f.PeriodClosesCollection, period.BranchID)
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+	if latest == nil || latest.ID != period.ID {
+		return c.Status(fiber.StatusConflict).JSON(models.NewOutput([]interface{}{}, models.NewError(models.ErrPeriodNotLatestClose.Error(), fiber.StatusConflict)))
+	}
+
+	user, _ := c.Locals("user").(string)
+	now := time.Now().In(utils.GetTimeZone())
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = f.PeriodClosesCollection.FindOneAndUpdate(c.Context(), bson.M{"_id": period.ID, "reopened": false}, bson.M{
+		"$set": bson.M{
+			"reopened":      true,
+			"reopened_by":   user,
+			"reopened_at":   now,
+			"reopen_reason": input.Reason,
+		},
+	}, opts).Decode(period)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return c.Status(fiber.StatusConflict).JSON(models.NewOutput([]interface{}{}, models.NewError(models.ErrPeriodReopened.Error(), fiber.StatusConflict)))
+	}
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeReopenPeriod, fiber.Map{
+		"period_id": period.ID,
+		"branch_id": period.BranchID,
+		"lock_date": period.LockDate,
+		"reason":    input.Reason,
+	}, f.ActivitiesCollection)
+	log.Warn().Str("period_id", period.ID).Str("branch_id", period.BranchID).Time("lock_date", period.LockDate).Str("reason", input.Reason).Msg("Period reopened")
+	return c.JSON(models.NewOutput(period))
+}
+
+// GetPeriodCloses godoc
+// @Security BearerAuth
+// @Summary List period closes
+// @Description Closes of the branch (of every branch if not set), the latest lock date first, reopened ones included
+// @Tags finance
+// @Produce json
+// @Param params query models.PeriodCloseQueryParams false "Query params"
+// @Success 200 {object} models.PeriodCloseOutput
+// @Failure 400 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/finance/periods [get]
+func (f *FinanceController) GetPeriodCloses(c *fiber.Ctx) error {
+	params := models.PeriodCloseQueryParams{}
+	if err := c.QueryParser(&params); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+	}
+	filter := bson.M{}
+	if params.BranchID != "" {
+		filter["branch_id"] = params.BranchID
+	}
+
+	cursor, err := f.PeriodClosesCollection.Find(c.Context(), filter, options.Find().SetSort(bson.D{{Key: "lock_date", Value: -1}, {Key: "closed_at", Value: -1}}))
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+	periods := []models.PeriodClose{}
+	if err := cursor.All(c.Context(), &periods); err != nil {
+		return models.ReturnError(c, err)
+	}
+	return c.JSON(models.NewOutput(periods))
+}
+
+// GetPeriodLock godoc
+// @Security BearerAuth
+// @Summary Lock date of a branch
+// @Description Documents of the branch dated before the lock date can not be created, changed or deleted. Zero if no period is closed
+// @Tags finance
+// @Produce json
+// @Param branch_id path string true "Branch ID"
+// @Success 200 {object} models.PeriodLockOutput
+// @Failure 500 {object} models.Output
+// @Router /api/finance/periods/{branch_id}/lock [get]
+func (f *FinanceController) GetPeriodLock(c *fiber.Ctx) error {
+	branch_id := c.Params("branch_id")
+	latest, err := models.LatestPeriodClose(c.Context(), f.PeriodClosesCollection, branch_id)
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+	lock := models.PeriodLock{BranchID: branch_id, Close: latest}
+	if latest != nil {
+		lock.LockDate = latest.LockDate
+	}
+	return c.JSON(models.NewOutput(lock))
+}
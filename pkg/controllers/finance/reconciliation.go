@@ -0,0 +1,341 @@
+package finance
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/ledger"
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+	"github.com/aslon1213/g4h_pos_erp/platform/database"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// reconciliationJobUser is recorded as the author of adjustments applied by the background job
+const reconciliationJobUser = "reconciliation-job"
+
+// Reconcile recomputes the buckets of the finance from the transactions of the branch.
+// At most limit offending transactions are listed per bucket
+func Reconcile(ctx context.Context, finance *models.BranchFinance, transactionsCollection *mongo.Collection, limit int) (models.Reconciliation, error) {
+	reconciliation := models.Reconciliation{
+		BranchID:   finance.BranchID,
+		BranchName: finance.BranchName,
+		Date:       time.Now(),
+		Unmapped:   []string{},
+	}
+	buckets := map[models.FinanceBucket]*models.BucketReconciliation{}
+	for _, bucket := range models.FinanceBuckets {
+		buckets[bucket] = &models.BucketReconciliation{
+			Bucket:       bucket,
+			Stored:       finance.Of(bucket),
+			Transactions: []models.TransactionDrift{},
+		}
+	}
+
+	cursor, err := transactionsCollection.Find(ctx, bson.M{"branch_id": finance.BranchID, "voided": bson.M{"$ne": true}}, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return reconciliation, err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		transaction := models.Transaction{}
+		if err := cursor.Decode(&transaction); err != nil {
+			return reconciliation, err
+		}
+		expected, err := models.ExpectedEffectOfTransaction(&transaction)
+		if err != nil {
+			reconciliation.Unmapped = append(reconciliation.Unmapped, transaction.ID)
+			continue
+		}
+		recorded := models.RecordedEffectOfTransaction(&transaction)
+		for _, bucket := range models.FinanceBuckets {
+			reconciled := buckets[bucket]
+			reconciled.Computed += expected[bucket]
+			if expected[bucket] == recorded[bucket] {
+				continue
+			}
+			reconciled.Offending++
+			reconciled.Explained += expected[bucket] - recorded[bucket]
+			if len(reconciled.Transactions) < limit {
+				reconciled.Transactions = append(reconciled.Transactions, models.TransactionDrift{
+					TransactionID: transaction.ID,
+					Type:          transaction.Type,
+					PaymentMethod: transaction.PaymentMethod,
+					Amount:        transaction.Amount,
+					CreatedAt:     transaction.CreatedAt,
+					Expected:      expected[bucket],
+					Recorded:      recorded[bucket],
+				})
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return reconciliation, err
+	}
+
+	reconciliation.InSync = true
+	for _, bucket := range models.FinanceBuckets {
+		reconciled := buckets[bucket]
+		reconciled.Difference = reconciled.Computed - reconciled.Stored
+		if reconciled.Difference != 0 {
+			reconciliation.InSync = false
+		}
+		reconciliation.Buckets = append(reconciliation.Buckets, *reconciled)
+	}
+	return reconciliation, nil
+}
+
+// ApplyAdjustment reconciles the finance of the branch and adds the differences of the buckets to it in the
+// db transaction of the context, recording the audit of the adjustment and posting it to the ledger. Nil is returned if there is nothing to adjust
+func (f *FinanceController) ApplyAdjustment(ctx context.Context, branch_id string, input models.ApplyReconciliationInput, created_by string, automatic bool) (*models.FinanceAdjustment, error) {
+	finance := models.BranchFinance{}
+	if err := f.FinanceCollection.FindOne(ctx, bson.M{"branch_id": branch_id}).Decode(&finance); err != nil {
+		return nil, err
+	}
+	reconciliation, err := Reconcile(ctx, &finance, f.TransactionsCollection, 0)
+	if err != nil {
+		return nil, err
+	}
+	differences := reconciliation.Differences(input.Buckets...)
+	if len(differences) == 0 {
+		return nil, nil
+	}
+
+	adjustment := models.NewFinanceAdjustment(branch_id, input.Reason, differences, created_by)
+	adjustment.Automatic = automatic
+	adjustment.Before = finance.Finance
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := f.FinanceCollection.FindOneAndUpdate(ctx, bson.M{"branch_id": branch_id}, bson.M{"$inc": differences.Inc()}, opts).Decode(&finance); err != nil {
+		return nil, err
+	}
+	adjustment.After = finance.Finance
+	if _, err := f.AdjustmentsCollection.InsertOne(ctx, adjustment); err != nil {
+		return nil, err
+	}
+
+	entry := models.NewLedgerEntry(ctx, branch_id, models.InitiatorTypeReconciliation, "Reconciliation adjustment: "+input.Reason, models.LinesOfAdjustment(differences)...)
+	entry.Reference = adjustment.ID
+	entry.CreatedBy = created_by
+	if err := ledger.Post(ctx, ledger.Collection(f.FinanceCollection), entry); err != nil {
+		return nil, err
+	}
+	return adjustment, nil
+}
+
+// GetReconciliations godoc
+// @Security BearerAuth
+// @Summary Reconcile finances of all branches
+// @Description Recomputes balance, total income and total expenses of every branch from its transactions and reports the differences
+// @Tags finance
+// @Produce json
+// @Param branch_id query string false "Branch ID"
+// @Param limit query int false "Offending transactions listed per bucket" default(20)
+// @Success 200 {object} models.ReconciliationOutput
+// @Failure 500 {object} models.Output
+// @Router /api/finance/reconciliation [get]
+func (f *FinanceController) GetReconciliations(c *fiber.Ctx) error {
+	filter := bson.M{}
+	if branch_id := c.Query("branch_id"); branch_id != "" {
+		filter["branch_id"] = branch_id
+	}
+	cursor, err := f.FinanceCollection.Find(c.Context(), filter)
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+	finances := []models.BranchFinance{}
+	if err := cursor.All(c.Context(), &finances); err != nil {
+		return models.ReturnError(c, err)
+	}
+
+	reconciliations := []models.Reconciliation{}
+	for _, finance := range finances {
+		reconciliation, err := Reconcile(c.Context(), &finance, f.TransactionsCollection, c.QueryInt("limit", 20))
+		if err != nil {
+			log.Error().Err(err).Str("branch_id", finance.BranchID).Msg("Failed to reconcile finance")
+			return models.ReturnError(c, err)
+		}
+		reconciliations = append(reconciliations, reconciliation)
+	}
+	return c.JSON(models.NewOutput(reconciliations))
+}
+
+// GetReconciliation godoc
+// @Security BearerAuth
+// @Summary Reconcile finance of branch
+// @Description Recomputes balance, total income and total expenses of the branch from its transactions.
+// @Description Every bucket lists the transactions whose effect on it was recorded differently than expected
+// @Tags finance
+// @Produce json
+// @Param branch_id path string true "Branch ID"
+// @Param limit query int false "Offending transactions listed per bucket" default(100)
+// @Success 200 {object} models.ReconciliationOutputSingle
+// @Failure 404 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/finance/reconciliation/{branch_id} [get]
+func (f *FinanceController) GetReconciliation(c *fiber.Ctx) error {
+	branch_id := c.Params("branch_id")
+	finance := models.BranchFinance{}
+	if err := f.FinanceCollection.FindOne(c.Context(), bson.M{"branch_id": branch_id}).Decode(&finance); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.NewError("Finance of the branch not found", fiber.StatusNotFound)))
+		}
+		return models.ReturnError(c, err)
+	}
+
+	reconciliation, err := Reconcile(c.Context(), &finance, f.TransactionsCollection, c.QueryInt("limit", 100))
+	if err != nil {
+		log.Error().Err(err).Str("branch_id", branch_id).Msg("Failed to reconcile finance")
+		return models.ReturnError(c, err)
+	}
+	return c.JSON(models.NewOutput(reconciliation))
+}
+
+// ApplyReconciliation godoc
+// @Security BearerAuth
+// @Summary Apply corrective adjustment to finance of branch
+// @Description Adds the differences found by the reconciliation to the buckets (all or the given ones) of the finance and records the adjustment
+// @Tags finance
+// @Accept json
+// @Produce json
+// @Param branch_id path string true "Branch ID"
+// @Param input body models.ApplyReconciliationInput true "Reason and buckets"
+// @Success 201 {object} models.FinanceAdjustmentOutputSingle
+// @Success 200 {object} models.Output "finance is in sync, nothing adjusted"
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/finance/reconciliation/{branch_id}/apply [post]
+func (f *FinanceController) ApplyReconciliation(c *fiber.Ctx) error {
+	branch_id := c.Params("branch_id")
+	input := models.ApplyReconciliationInput{}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+	}
+	if err := input.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+	}
+
+	ses, ctx, err := database.StartTransaction(f.FinanceCollection.Database().Client())
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+	defer ses.EndSession(ctx)
+
+	user, _ := c.Locals("user").(string)
+	adjustment, err := f.ApplyAdjustment(ctx, branch_id, input, user, false)
+	if err != nil {
+		ses.AbortTransaction(ctx)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.NewError("Finance of the branch not found", fiber.StatusNotFound)))
+		}
+		log.Error().Err(err).Str("branch_id", branch_id).Msg("Failed to apply reconciliation")
+		return models.ReturnError(c, err)
+	}
+	if adjustment == nil {
+		ses.AbortTransaction(ctx)
+		return c.JSON(models.NewOutput(fiber.Map{"message": "finance is in sync with the transactions"}))
+	}
+	if err := ses.CommitTransaction(ctx); err != nil {
+		return models.ReturnError(c, err)
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeAdjustFinance, adjustment, f.ActivitiesCollection)
+	log.Info().Str("branch_id", branch_id).Interface("adjustments", adjustment.Adjustments).Msg("Finance adjusted")
+	return c.Status(fiber.StatusCreated).JSON(models.NewOutput(adjustment))
+}
+
+// GetFinanceAdjustments godoc
+// @Security BearerAuth
+// @Summary Corrective adjustments of finance of branch
+// @Description Audit of the adjustments applied to the finance of the branch, newest first
+// @Tags finance
+// @Produce json
+// @Param branch_id path string true "Branch ID"
+// @Success 200 {object} models.FinanceAdjustmentOutput
+// @Failure 500 {object} models.Output
+// @Router /api/finance/adjustments/{branch_id} [get]
+func (f *FinanceController) GetFinanceAdjustments(c *fiber.Ctx) error {
+	branch_id := c.Params("branch_id")
+	cursor, err := f.AdjustmentsCollection.Find(c.Context(), bson.M{"branch_id": branch_id}, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+	adjustments := []models.FinanceAdjustment{}
+	if err := cursor.All(c.Context(), &adjustments); err != nil {
+		return models.ReturnError(c, err)
+	}
+	return c.JSON(models.NewOutput(adjustments))
+}
+
+// ReconcileAll reconciles the finances of every branch, logging drift and applying the adjustment if auto repair is configured
+func (f *FinanceController) ReconcileAll(ctx context.Context) error {
+	cursor, err := f.FinanceCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	finances := []models.BranchFinance{}
+	if err := cursor.All(ctx, &finances); err != nil {
+		return err
+	}
+
+	for _, finance := range finances {
+		reconciliation, err := Reconcile(ctx, &finance, f.TransactionsCollection, 0)
+		if err != nil {
+			log.Error().Err(err).Str("branch_id", finance.BranchID).Msg("Failed to reconcile finance")
+			continue
+		}
+		if reconciliation.InSync {
+			continue
+		}
+		log.Warn().Str("branch_id", finance.BranchID).Interface("differences", reconciliation.Differences()).Msg("Finance drifted from transactions")
+		if !f.Reconciliation.AutoRepair {
+			continue
+		}
+
+		ses, ses_ctx, err := database.StartTransaction(f.FinanceCollection.Database().Client())
+		if err != nil {
+			return err
+		}
+		adjustment, err := f.ApplyAdjustment(ses_ctx, finance.BranchID, models.ApplyReconciliationInput{Reason: "Automatic repair of drift"}, reconciliationJobUser, true)
+		if err != nil {
+			ses.AbortTransaction(ses_ctx)
+			ses.EndSession(ses_ctx)
+			log.Error().Err(err).Str("branch_id", finance.BranchID).Msg("Failed to repair finance")
+			continue
+		}
+		err = ses.CommitTransaction(ses_ctx)
+		ses.EndSession(ses_ctx)
+		if err != nil {
+			log.Error().Err(err).Str("branch_id", finance.BranchID).Msg("Failed to commit repair of finance")
+			continue
+		}
+		if adjustment != nil {
+			middleware.LogActivity(reconciliationJobUser, middleware.ActivityTypeAdjustFinance, adjustment, "", fiber.StatusCreated, f.ActivitiesCollection)
+		}
+	}
+	return nil
+}
+
+// RunReconciliationJob reconciles the finances every interval until the context is cancelled
+func (f *FinanceController) RunReconciliationJob(ctx context.Context, interval time.Duration) {
+	log.Info().Dur("interval", interval).Bool("auto_repair", f.Reconciliation.AutoRepair).Msg("Starting finance reconciliation job")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := f.ReconcileAll(ctx); err != nil {
+			log.Error().Err(err).Msg("Finance reconciliation failed")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
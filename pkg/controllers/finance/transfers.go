@@ -0,0 +1,168 @@
+package finance
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/ledger"
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+	"github.com/aslon1213/g4h_pos_erp/platform/database"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var (
+	ErrInsufficientBalance = errors.New("balance of the source bucket is less than the amount")
+	ErrFinanceNotFound     = errors.New("finance of the branch not found")
+	ErrCurrencyMismatch    = errors.New("branches of the transfer have different base currencies")
+)
+
+// TransferMoney godoc
+// @Security BearerAuth
+// @Summary Transfer money between balance buckets or branches
+// @Description Atomically debits a bucket (cash, bank, terminal or mobile apps) of a branch and credits a bucket of the same or another branch,
+// @Description e.g. depositing cash to the bank or settling the terminal. Two transactions sharing the transfer ID are recorded,
+// @Description the income and expense totals are not changed
+// @Tags finance
+// @Accept json
+// @Produce json
+// @Param input body models.MoneyTransferInput true "Transfer"
+// @Success 201 {object} models.MoneyTransferOutputSingle
+// @Failure 400 {object} models.Output
+// @Failure 403 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/finance/transfers [post]
+func (f *FinanceController) TransferMoney(c *fiber.Ctx) error {
+	input := models.MoneyTransferInput{}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+	}
+	if err := input.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+	}
+	// money can only be sent from the branch of the user
+	if !middleware.CanAccessBranch(c, input.From.BranchID) {
+		return middleware.ForbiddenBranch(c)
+	}
+
+	ses, ctx, err := database.StartTransaction(f.FinanceCollection.Database().Client())
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+	defer ses.EndSession(ctx)
+	ctx = middleware.Attributed(c, ctx)
+
+	transfer, err := f.Transfer(ctx, &input)
+	if err != nil {
+		ses.AbortTransaction(ctx)
+		if errors.Is(err, ErrInsufficientBalance) || errors.Is(err, ErrFinanceNotFound) || errors.Is(err, ErrCurrencyMismatch) {
+			return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+		}
+		log.Error().Err(err).Msg("Failed to transfer money")
+		return models.ReturnError(c, err)
+	}
+	if err := ses.CommitTransaction(ctx); err != nil {
+		return models.ReturnError(c, err)
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeTransferMoney, fiber.Map{
+		"transfer_id": transfer.ID,
+		"from":        input.From,
+		"to":          input.To,
+		"amount":      input.Amount,
+	}, f.ActivitiesCollection)
+	log.Info().Str("transfer_id", transfer.ID).Str("from", input.From.String()).Str("to", input.To.String()).Int64("amount", input.Amount).Msg("Money transferred")
+	return c.Status(fiber.StatusCreated).JSON(models.NewOutput(transfer))
+}
+
+// Transfer records the paired transactions of the transfer, moves the money between the buckets of the finances
+// and posts both sides to the ledger. Must run in a db transaction
+func (f *FinanceController) Transfer(ctx context.Context, input *models.MoneyTransferInput) (*models.MoneyTransfer, error) {
+	transfer := models.NewMoneyTransfer(input)
+	for _, transaction := range []*models.Transaction{&transfer.Outgoing, &transfer.Incoming} {
+		if err := transaction.InBranchCurrency(ctx, f.FinanceCollection); err != nil {
+			return nil, err
+		}
+	}
+	// the amount leaves and arrives unchanged, so both branches must keep their balances in the same currency
+	if transfer.Outgoing.Currency != transfer.Incoming.Currency {
+		return nil, ErrCurrencyMismatch
+	}
+	for _, transaction := range []*models.Transaction{&transfer.Outgoing, &transfer.Incoming} {
+		transaction.Attribute(ctx)
+		effect, err := models.ExpectedEffectOfTransaction(transaction)
+		if err != nil {
+			return nil, err
+		}
+
+		filter := bson.M{"branch_id": transaction.BranchID}
+		if transaction.TransactionBase.Type == models.TransactionTypeDebit {
+			filter[input.From.Bucket().Field()] = bson.M{"$gte": transaction.Amount}
+		}
+		result, err := f.FinanceCollection.UpdateOne(ctx, filter, bson.M{"$inc": effect.Inc()})
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			count, err := f.FinanceCollection.CountDocuments(ctx, bson.M{"branch_id": transaction.BranchID})
+			if err != nil {
+				return nil, err
+			}
+			if count == 0 {
+				return nil, ErrFinanceNotFound
+			}
+			return nil, ErrInsufficientBalance
+		}
+
+		if _, err := f.TransactionsCollection.InsertOne(ctx, transaction); err != nil {
+			return nil, err
+		}
+		if _, err := ledger.PostTransaction(ctx, ledger.Collection(f.TransactionsCollection), transaction); err != nil {
+			return nil, err
+		}
+	}
+	return transfer, nil
+}
+
+// GetMoneyTransfer godoc
+// @Security BearerAuth
+// @Summary Get a money transfer
+// @Description Returns the pair of transactions of the transfer
+// @Tags finance
+// @Produce json
+// @Param id path string true "Transfer ID"
+// @Success 200 {object} models.MoneyTransferOutputSingle
+// @Failure 403 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/finance/transfers/{id} [get]
+func (f *FinanceController) GetMoneyTransfer(c *fiber.Ctx) error {
+	transfer_id := c.Params("id")
+	cursor, err := f.TransactionsCollection.Find(c.Context(), bson.M{"transfer_id": transfer_id})
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+	transactions := []models.Transaction{}
+	if err := cursor.All(c.Context(), &transactions); err != nil {
+		return models.ReturnError(c, err)
+	}
+
+	transfer := models.MoneyTransfer{ID: transfer_id}
+	for _, transaction := range transactions {
+		if transaction.TransactionBase.Type == models.TransactionTypeDebit {
+			transfer.Outgoing = transaction
+		} else {
+			transfer.Incoming = transaction
+		}
+	}
+	if transfer.Outgoing.ID == "" || transfer.Incoming.ID == "" {
+		return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.NewError("Transfer not found", fiber.StatusNotFound)))
+	}
+	if !middleware.CanAccessBranch(c, transfer.Outgoing.BranchID) && !middleware.CanAccessBranch(c, transfer.Incoming.BranchID) {
+		return middleware.ForbiddenBranch(c)
+	}
+	return c.JSON(models.NewOutput(transfer))
+}
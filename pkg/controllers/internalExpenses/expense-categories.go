@@ -0,0 +1,138 @@
+package internalexpenses
+
+import (
+	"errors"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// GetExpenseCategories godoc
+// @Security BearerAuth
+// @Summary List expense categories
+// @Tags expenses
+// @Produce json
+// @Success 200 {object} models.ExpenseCategoryOutput
+// @Failure 500 {object} models.Output
+// @Router /api/expenses/categories [get]
+func (i *InternalExpensesController) GetExpenseCategories(c *fiber.Ctx) error {
+	cursor, err := i.CategoriesCollection.Find(c.Context(), bson.M{}, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+	categories := []models.ExpenseCategory{}
+	if err := cursor.All(c.Context(), &categories); err != nil {
+		return models.ReturnError(c, err)
+	}
+	return c.JSON(models.NewOutput(categories))
+}
+
+// CreateExpenseCategory godoc
+// @Security BearerAuth
+// @Summary Create an expense category
+// @Description The type of the category (salary, rent, utilities or other) decides the expense account the approved expenses are posted to
+// @Tags expenses
+// @Accept json
+// @Produce json
+// @Param input body models.ExpenseCategoryInput true "Category"
+// @Success 201 {object} models.ExpenseCategoryOutput
+// @Failure 400 {object} models.Output
+// @Failure 409 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/expenses/categories [post]
+func (i *InternalExpensesController) CreateExpenseCategory(c *fiber.Ctx) error {
+	input := models.ExpenseCategoryInput{}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if err := input.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	category := models.NewExpenseCategory(&input)
+	if _, err := i.CategoriesCollection.InsertOne(c.Context(), category); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(fiber.StatusConflict).JSON(models.NewOutput([]interface{}{}, models.Error{
+				Message: "Expense category " + category.Name + " already exists",
+				Code:    fiber.StatusConflict,
+			}))
+		}
+		log.Error().Err(err).Msg("Failed to insert expense category")
+		return models.ReturnError(c, err)
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeCreateExpenseCategory, category, i.ActivitiesCollection)
+	return c.Status(fiber.StatusCreated).JSON(models.NewOutput([]*models.ExpenseCategory{category}))
+}
+
+// UpdateExpenseCategory godoc
+// @Security BearerAuth
+// @Summary Update an expense category
+// @Description Expenses keep the name and type the category had when they were created
+// @Tags expenses
+// @Accept json
+// @Produce json
+// @Param id path string true "Category ID"
+// @Param input body models.ExpenseCategoryInput true "Category"
+// @Success 200 {object} models.ExpenseCategoryOutput
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 409 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/expenses/categories/{id} [put]
+func (i *InternalExpensesController) UpdateExpenseCategory(c *fiber.Ctx) error {
+	input := models.ExpenseCategoryInput{}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if err := input.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	category := models.ExpenseCategory{}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := i.CategoriesCollection.FindOneAndUpdate(c.Context(), bson.M{"_id": c.Params("id")}, bson.M{
+		"$set": bson.M{
+			"name":        input.Name,
+			"type":        input.Type,
+			"description": input.Description,
+		},
+	}, opts).Decode(&category)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Expense category not found",
+			Code:    fiber.StatusNotFound,
+		}))
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return c.Status(fiber.StatusConflict).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Expense category " + input.Name + " already exists",
+			Code:    fiber.StatusConflict,
+		}))
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to update expense category")
+		return models.ReturnError(c, err)
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeUpdateExpenseCategory, category, i.ActivitiesCollection)
+	return c.JSON(models.NewOutput([]models.ExpenseCategory{category}))
+}
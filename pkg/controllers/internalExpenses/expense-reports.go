@@ -0,0 +1,97 @@
+package internalexpenses
+
+import (
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+	"github.com/aslon1213/g4h_pos_erp/pkg/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// GetExpenseReport godoc
+// @Security BearerAuth
+// @Summary Report of internal expenses by category and period
+// @Description Totals of the expenses (approved ones if status is not set) grouped by the period (day, month or year) of their date and category
+// @Tags expenses
+// @Produce json
+// @Param params query models.ExpenseReportQueryParams false "Query params"
+// @Success 200 {object} models.ExpenseReportOutput
+// @Failure 400 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/expenses/reports [get]
+func (i *InternalExpensesController) GetExpenseReport(c *fiber.Ctx) error {
+	params := models.ExpenseReportQueryParams{}
+	if err := c.QueryParser(&params); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	format, err := params.Period.Format()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if params.Period == "" {
+		params.Period = models.ExpenseReportPeriodMonth
+	}
+	if params.Status == "" {
+		params.Status = models.ExpenseStatusApproved
+	}
+
+	match := bson.M{"status": params.Status}
+	if params.BranchID != "" {
+		match["branch_id"] = params.BranchID
+	}
+	date := bson.M{}
+	if !params.DateMin.IsZero() {
+		date["$gte"] = params.DateMin
+	}
+	if !params.DateMax.IsZero() {
+		date["$lte"] = params.DateMax
+	}
+	if len(date) > 0 {
+		match["date"] = date
+	}
+
+	pipeline := []bson.M{
+		{"$match": match},
+		{"$group": bson.M{
+			"_id": bson.M{
+				"period":      bson.M{"$dateToString": bson.M{"format": format, "date": "$date", "timezone": utils.GetTimeZone().String()}},
+				"category_id": "$category_id",
+			},
+			"category": bson.M{"$last": "$category"},
+			"count":    bson.M{"$sum": 1},
+			"total":    bson.M{"$sum": "$amount"},
+		}},
+		{"$project": bson.M{
+			"_id":         0,
+			"period":      "$_id.period",
+			"category_id": "$_id.category_id",
+			"category":    1,
+			"count":       1,
+			"total":       1,
+		}},
+		{"$sort": bson.D{{Key: "period", Value: 1}, {Key: "category", Value: 1}}},
+	}
+	cursor, err := i.ExpensesCollection.Aggregate(c.Context(), pipeline)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to aggregate expenses")
+		return models.ReturnError(c, err)
+	}
+	report := models.ExpenseReport{
+		Period: params.Period,
+		Rows:   []models.ExpenseReportRow{},
+	}
+	if err := cursor.All(c.Context(), &report.Rows); err != nil {
+		return models.ReturnError(c, err)
+	}
+	for _, row := range report.Rows {
+		report.Total += row.Total
+	}
+	return c.JSON(models.NewOutput(report))
+}
@@ -1,31 +1,526 @@
 package internalexpenses
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/configs"
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/ledger"
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+	"github.com/aslon1213/g4h_pos_erp/pkg/utils"
+	"github.com/aslon1213/g4h_pos_erp/platform/database"
+	s3provider "github.com/aslon1213/g4h_pos_erp/platform/s3"
+
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 type InternalExpensesController struct {
-	collection *mongo.Collection
+	ExpensesCollection     *mongo.Collection
+	CategoriesCollection   *mongo.Collection
+	RecurringCollection    *mongo.Collection
+	TransactionsCollection *mongo.Collection
+	FinanceCollection      *mongo.Collection
+	ActivitiesCollection   *mongo.Collection
+	S3Client               *s3provider.S3Client
+	Expenses               configs.ExpensesConfig
 }
 
 func New(db *mongo.Database) *InternalExpensesController {
+	config, err := configs.LoadConfig(".")
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load config")
+	}
+
+	expensesCollection := db.Collection("internal_expenses")
+	_, _ = expensesCollection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "branch_id", Value: 1}, {Key: "date", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "date", Value: -1}}},
+	})
+	categoriesCollection := db.Collection("expense_categories")
+	_, _ = categoriesCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.M{"name": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	recurringCollection := db.Collection("recurring_expenses")
+	_, _ = recurringCollection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "active", Value: 1}, {Key: "next_run", Value: 1}},
+	})
+
 	return &InternalExpensesController{
-		collection: db.Collection("internal_expenses"),
+		ExpensesCollection:     expensesCollection,
+		CategoriesCollection:   categoriesCollection,
+		RecurringCollection:    recurringCollection,
+		TransactionsCollection: db.Collection("transactions"),
+		FinanceCollection:      db.Collection("finance"),
+		ActivitiesCollection:   db.Collection("activities"),
+		S3Client:               s3provider.New(),
+		Expenses:               config.Expenses,
+	}
+}
+
+func (i *InternalExpensesController) findExpense(ctx context.Context, c *fiber.Ctx, expense_id string) (*models.Expense, error) {
+	expense := &models.Expense{}
+	err := i.ExpensesCollection.FindOne(ctx, bson.M{"_id": expense_id}).Decode(expense)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Expense not found",
+			Code:    fiber.StatusNotFound,
+		}))
+	}
+	if err != nil {
+		log.Error().Err(err).Str("expense_id", expense_id).Msg("Failed to find expense")
+		return nil, models.ReturnError(c, err)
+	}
+	return expense, nil
+}
+
+func (i *InternalExpensesController) findCategory(ctx context.Context, category_id string) (*models.ExpenseCategory, error) {
+	category := &models.ExpenseCategory{}
+	err := i.CategoriesCollection.FindOne(ctx, bson.M{"_id": category_id}).Decode(category)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errors.New("expense category not found")
 	}
+	return category, err
 }
 
+// GetInternalExpenses godoc
+// @Security BearerAuth
+// @Summary Query internal expenses
+// @Description Lists internal expenses filtered by branch, category, status, recurring template and date, newest first
+// @Tags expenses
+// @Produce json
+// @Param params query models.ExpenseQueryParams false "Query params"
+// @Success 200 {object} models.ExpenseOutput
+// @Failure 400 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/expenses [get]
 func (i *InternalExpensesController) GetInternalExpenses(c *fiber.Ctx) error {
-	panic("not implemented")
-	return c.SendString("Hello, World!")
+	params := models.ExpenseQueryParams{}
+	if err := c.QueryParser(&params); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if params.Page < 1 {
+		params.Page = 1
+	}
+	if params.Count < 1 {
+		params.Count = 25
+	}
+
+	filter := bson.M{}
+	if params.BranchID != "" {
+		filter["branch_id"] = params.BranchID
+	}
+	if params.CategoryID != "" {
+		filter["category_id"] = params.CategoryID
+	}
+	if params.Status != "" {
+		filter["status"] = params.Status
+	}
+	if params.RecurringID != "" {
+		filter["recurring_id"] = params.RecurringID
+	}
+	date := bson.M{}
+	if !params.DateMin.IsZero() {
+		date["$gte"] = params.DateMin
+	}
+	if !params.DateMax.IsZero() {
+		date["$lte"] = params.DateMax
+	}
+	if len(date) > 0 {
+		filter["date"] = date
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "date", Value: -1}}).
+		SetSkip(int64((params.Page - 1) * params.Count)).
+		SetLimit(int64(params.Count))
+	cursor, err := i.ExpensesCollection.Find(c.Context(), filter, opts)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to find expenses")
+		return models.ReturnError(c, err)
+	}
+	expenses := []models.Expense{}
+	if err := cursor.All(c.Context(), &expenses); err != nil {
+		log.Error().Err(err).Msg("Failed to decode expenses")
+		return models.ReturnError(c, err)
+	}
+	return c.JSON(models.NewOutput(expenses))
 }
 
+// GetInternalExpense godoc
+// @Security BearerAuth
+// @Summary Get an internal expense
+// @Tags expenses
+// @Produce json
+// @Param id path string true "Expense ID"
+// @Success 200 {object} models.ExpenseOutputSingle
+// @Failure 404 {object} models.Output
+// @Router /api/expenses/{id} [get]
 func (i *InternalExpensesController) GetInternalExpense(c *fiber.Ctx) error {
-	panic("not implemented")
-	return c.SendString("Hello, World!")
+	expense, err := i.findExpense(c.Context(), c, c.Params("id"))
+	if expense == nil {
+		return err
+	}
+	return c.JSON(models.NewOutput(expense))
 }
 
+// CreateInternalExpense godoc
+// @Security BearerAuth
+// @Summary Create an internal expense
+// @Description Records an expense of a branch in a category. The expense waits for the approval of a manager before the finance of the branch is changed
+// @Tags expenses
+// @Accept json
+// @Produce json
+// @Param input body models.NewExpenseInput true "Expense"
+// @Success 201 {object} models.ExpenseOutputSingle
+// @Failure 400 {object} models.Output
+// @Failure 403 {object} models.Output
+// @Failure 409 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/expenses [post]
 func (i *InternalExpensesController) CreateInternalExpense(c *fiber.Ctx) error {
-	panic("not implemented")
-	return c.SendString("Hello, World!")
+	input := models.NewExpenseInput{}
+	if err := c.BodyParser(&input); err != nil {
+		log.Error().Err(err).Msg("Failed to parse expense input")
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if err := input.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if !middleware.CanAccessBranch(c, input.BranchID) {
+		return middleware.ForbiddenBranch(c)
+	}
+
+	category, err := i.findCategory(c.Context(), input.CategoryID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	user, _ := c.Locals("user").(string)
+	expense := models.NewExpense(&input, category, user)
+	err = models.CheckPeriodOpen(c.Context(), models.PeriodClosesCollection(i.ExpensesCollection), expense.BranchID, expense.Date)
+	if errors.Is(err, models.ErrPeriodClosed) {
+		return middleware.PeriodClosed(c, err)
+	}
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+	if _, err := i.ExpensesCollection.InsertOne(c.Context(), expense); err != nil {
+		log.Error().Err(err).Msg("Failed to insert expense")
+		return models.ReturnError(c, err)
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeCreateExpense, expense, i.ActivitiesCollection)
+	log.Info().Str("expense_id", expense.ID).Str("branch_id", expense.BranchID).Int64("amount", expense.Amount).Msg("Expense created")
+	return c.Status(fiber.StatusCreated).JSON(models.NewOutput(expense))
+}
+
+// ApproveInternalExpense godoc
+// @Security BearerAuth
+// @Summary Approve an internal expense
+// @Description Pays the pending expense: a debit transaction of the category type is recorded, the balance of the payment method
+// @Description and the total expenses of the branch are changed and the transaction is posted to the ledger
+// @Tags expenses
+// @Produce json
+// @Param id path string true "Expense ID"
+// @Success 200 {object} models.ExpenseOutputSingle
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/expenses/{id}/approve [post]
+func (i *InternalExpensesController) ApproveInternalExpense(c *fiber.Ctx) error {
+	expense_id := c.Params("id")
+	log.Info().Str("expense_id", expense_id).Msg("Approving expense")
+
+	session, ctx, err := database.StartTransaction(i.ExpensesCollection.Database().Client())
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+	defer session.EndSession(ctx)
+	ctx = middleware.Attributed(c, ctx)
+
+	expense, err := i.findExpense(ctx, c, expense_id)
+	if expense == nil {
+		session.AbortTransaction(ctx)
+		return err
+	}
+	if expense.Status != models.ExpenseStatusPending {
+		session.AbortTransaction(ctx)
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Only pending expenses can be approved",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	user, _ := c.Locals("user").(string)
+	transaction, err := PayExpense(ctx, expense, user, i.ExpensesCollection, i.TransactionsCollection, i.FinanceCollection)
+	if err != nil {
+		session.AbortTransaction(ctx)
+		log.Error().Err(err).Str("expense_id", expense_id).Msg("Failed to pay expense")
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	if err := session.CommitTransaction(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to commit transaction")
+		return models.ReturnError(c, err)
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeApproveExpense, fiber.Map{
+		"expense_id":     expense.ID,
+		"transaction_id": transaction.ID,
+		"amount":         expense.Amount,
+	}, i.ActivitiesCollection)
+	log.Info().Str("expense_id", expense.ID).Str("transaction_id", transaction.ID).Msg("Expense approved")
+	return c.JSON(models.NewOutput(expense))
+}
+
+// PayExpense records the transaction of the pending expense, applies it to the finance of the branch and the ledger
+// and marks the expense approved. Must run in a db transaction
+func PayExpense(ctx context.Context, expense *models.Expense, reviewed_by string, expensesCollection *mongo.Collection, transactionsCollection *mongo.Collection, financeCollection *mongo.Collection) (*models.Transaction, error) {
+	transaction := expense.Transaction()
+	transaction.Attribute(ctx)
+	if err := transaction.InBranchCurrency(ctx, financeCollection); err != nil {
+		return nil, err
+	}
+	effect, err := models.ExpectedEffectOfTransaction(transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := transactionsCollection.InsertOne(ctx, transaction); err != nil {
+		return nil, err
+	}
+	result, err := financeCollection.UpdateOne(ctx, bson.M{"branch_id": expense.BranchID}, bson.M{"$inc": effect.Inc()})
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, errors.New("finance of the branch not found")
+	}
+	if _, err := ledger.PostTransaction(ctx, ledger.Collection(transactionsCollection), transaction); err != nil {
+		return nil, err
+	}
+
+	expense.Status = models.ExpenseStatusApproved
+	expense.TransactionID = transaction.ID
+	expense.ReviewedBy = reviewed_by
+	expense.ReviewedAt = time.Now()
+	result, err = expensesCollection.UpdateOne(ctx, bson.M{"_id": expense.ID, "status": models.ExpenseStatusPending}, bson.M{
+		"$set": bson.M{
+			"status":         expense.Status,
+			"transaction_id": expense.TransactionID,
+			"reviewed_by":    expense.ReviewedBy,
+			"reviewed_at":    expense.ReviewedAt,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, errors.New("expense is not pending")
+	}
+	return transaction, nil
+}
+
+// RejectInternalExpense godoc
+// @Security BearerAuth
+// @Summary Reject an internal expense
+// @Description Rejects the pending expense with a reason, the finance is not changed
+// @Tags expenses
+// @Accept json
+// @Produce json
+// @Param id path string true "Expense ID"
+// @Param input body models.RejectExpenseInput true "Reason"
+// @Success 200 {object} models.ExpenseOutputSingle
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/expenses/{id}/reject [post]
+func (i *InternalExpensesController) RejectInternalExpense(c *fiber.Ctx) error {
+	input := models.RejectExpenseInput{}
+	if err := c.BodyParser(&input); err != nil || input.Reason == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "reason is required",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	expense, err := i.findExpense(c.Context(), c, c.Params("id"))
+	if expense == nil {
+		return err
+	}
+
+	user, _ := c.Locals("user").(string)
+	expense.Status = models.ExpenseStatusRejected
+	expense.RejectionReason = input.Reason
+	expense.ReviewedBy = user
+	expense.ReviewedAt = time.Now()
+	result, err := i.ExpensesCollection.UpdateOne(c.Context(), bson.M{"_id": expense.ID, "status": models.ExpenseStatusPending}, bson.M{
+		"$set": bson.M{
+			"status":           expense.Status,
+			"rejection_reason": expense.RejectionReason,
+			"reviewed_by":      expense.ReviewedBy,
+			"reviewed_at":      expense.ReviewedAt,
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to update expense")
+		return models.ReturnError(c, err)
+	}
+	if result.MatchedCount == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Only pending expenses can be rejected",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeRejectExpense, fiber.Map{
+		"expense_id": expense.ID,
+		"reason":     expense.RejectionReason,
+	}, i.ActivitiesCollection)
+	return c.JSON(models.NewOutput(expense))
+}
+
+func attachmentKey(expense_id string, name string) string {
+	return fmt.Sprintf("expenses/%s/%s", expense_id, name)
+}
+
+// UploadExpenseAttachment godoc
+// @Security BearerAuth
+// @Summary Attach a file to an internal expense
+// @Description Uploads an invoice or a receipt of the expense to the storage
+// @Tags expenses
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path string true "Expense ID"
+// @Param file formData file true "File to attach"
+// @Success 201 {object} models.ExpenseOutputSingle
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/expenses/{id}/attachments [post]
+func (i *InternalExpensesController) UploadExpenseAttachment(c *fiber.Ctx) error {
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "No file uploaded",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	expense, err := i.findExpense(c.Context(), c, c.Params("id"))
+	if expense == nil {
+		return err
+	}
+
+	key := attachmentKey(expense.ID, uuid.New().String()+path.Ext(file.Filename))
+	if err := i.S3Client.UploadFile(file, key); err != nil {
+		log.Error().Err(err).Str("expense_id", expense.ID).Msg("Failed to upload attachment")
+		return models.ReturnError(c, err)
+	}
+	if _, err := i.ExpensesCollection.UpdateOne(c.Context(), bson.M{"_id": expense.ID}, bson.M{"$push": bson.M{"attachments": key}}); err != nil {
+		log.Error().Err(err).Str("expense_id", expense.ID).Msg("Failed to add attachment to expense")
+		return models.ReturnError(c, err)
+	}
+	expense.Attachments = append(expense.Attachments, key)
+
+	return c.Status(fiber.StatusCreated).JSON(models.NewOutput(expense))
+}
+
+// GetExpenseAttachment godoc
+// @Security BearerAuth
+// @Summary Download an attachment of an internal expense
+// @Tags expenses
+// @Produce octet-stream
+// @Param id path string true "Expense ID"
+// @Param name path string true "File name of the attachment"
+// @Success 200 {file} binary
+// @Failure 404 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/expenses/{id}/attachments/{name} [get]
+func (i *InternalExpensesController) GetExpenseAttachment(c *fiber.Ctx) error {
+	key := attachmentKey(c.Params("id"), c.Params("name"))
+	count, err := i.ExpensesCollection.CountDocuments(c.Context(), bson.M{"_id": c.Params("id"), "attachments": key})
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+	if count == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Attachment not found",
+			Code:    fiber.StatusNotFound,
+		}))
+	}
+
+	object, err := i.S3Client.GetFile(key)
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+	return c.SendStream(object.Body, int(*object.ContentLength))
+}
+
+// DeleteExpenseAttachment godoc
+// @Security BearerAuth
+// @Summary Delete an attachment of an internal expense
+// @Description Attachments can only be deleted while the expense is pending
+// @Tags expenses
+// @Produce json
+// @Param id path string true "Expense ID"
+// @Param name path string true "File name of the attachment"
+// @Success 200 {object} models.ExpenseOutputSingle
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/expenses/{id}/attachments/{name} [delete]
+func (i *InternalExpensesController) DeleteExpenseAttachment(c *fiber.Ctx) error {
+	expense, err := i.findExpense(c.Context(), c, c.Params("id"))
+	if expense == nil {
+		return err
+	}
+	if expense.Status != models.ExpenseStatusPending {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Attachments of reviewed expenses cannot be deleted",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	key := attachmentKey(expense.ID, c.Params("name"))
+	result, err := i.ExpensesCollection.UpdateOne(c.Context(), bson.M{"_id": expense.ID}, bson.M{"$pull": bson.M{"attachments": key}})
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+	if result.ModifiedCount == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Attachment not found",
+			Code:    fiber.StatusNotFound,
+		}))
+	}
+	if err := i.S3Client.DeleteFile(key); err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("Failed to delete attachment from storage")
+	}
+
+	expense.Attachments = utils.RemoveElement(expense.Attachments, key)
+	return c.JSON(models.NewOutput(expense))
 }
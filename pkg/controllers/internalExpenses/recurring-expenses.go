@@ -0,0 +1,278 @@
+package internalexpenses
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+	"github.com/aslon1213/g4h_pos_erp/pkg/utils"
+	"github.com/aslon1213/g4h_pos_erp/platform/database"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// recurringExpensesUser is recorded as the creator of the expenses generated from the templates
+const recurringExpensesUser = "recurring-expenses"
+
+// GetRecurringExpenses godoc
+// @Security BearerAuth
+// @Summary List recurring expense templates
+// @Tags expenses
+// @Produce json
+// @Param branch_id query string false "Branch ID"
+// @Success 200 {object} models.RecurringExpenseOutput
+// @Failure 500 {object} models.Output
+// @Router /api/expenses/recurring [get]
+func (i *InternalExpensesController) GetRecurringExpenses(c *fiber.Ctx) error {
+	filter := bson.M{}
+	if branch_id := c.Query("branch_id"); branch_id != "" {
+		filter["branch_id"] = branch_id
+	}
+	cursor, err := i.RecurringCollection.Find(c.Context(), filter, options.Find().SetSort(bson.M{"next_run": 1}))
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+	templates := []models.RecurringExpense{}
+	if err := cursor.All(c.Context(), &templates); err != nil {
+		return models.ReturnError(c, err)
+	}
+	return c.JSON(models.NewOutput(templates))
+}
+
+// CreateRecurringExpense godoc
+// @Security BearerAuth
+// @Summary Create a recurring expense template
+// @Description The template generates a pending expense every month on the day of the month (1-28), starting today if it is the day
+// @Tags expenses
+// @Accept json
+// @Produce json
+// @Param input body models.RecurringExpenseInput true "Template"
+// @Success 201 {object} models.RecurringExpenseOutput
+// @Failure 400 {object} models.Output
+// @Failure 403 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/expenses/recurring [post]
+func (i *InternalExpensesController) CreateRecurringExpense(c *fiber.Ctx) error {
+	input := models.RecurringExpenseInput{}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if err := input.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if !middleware.CanAccessBranch(c, input.BranchID) {
+		return middleware.ForbiddenBranch(c)
+	}
+	if _, err := i.findCategory(c.Context(), input.CategoryID); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	user, _ := c.Locals("user").(string)
+	template := models.NewRecurringExpense(&input, user)
+	if _, err := i.RecurringCollection.InsertOne(c.Context(), template); err != nil {
+		log.Error().Err(err).Msg("Failed to insert recurring expense")
+		return models.ReturnError(c, err)
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeCreateRecurringExpense, template, i.ActivitiesCollection)
+	return c.Status(fiber.StatusCreated).JSON(models.NewOutput([]*models.RecurringExpense{template}))
+}
+
+// UpdateRecurringExpense godoc
+// @Security BearerAuth
+// @Summary Update a recurring expense template
+// @Description Changes the template, pauses it with active false or resumes it. Expenses already generated are not changed
+// @Tags expenses
+// @Accept json
+// @Produce json
+// @Param id path string true "Template ID"
+// @Param input body models.RecurringExpenseInput true "Template"
+// @Success 200 {object} models.RecurringExpenseOutput
+// @Failure 400 {object} models.Output
+// @Failure 403 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/expenses/recurring/{id} [put]
+func (i *InternalExpensesController) UpdateRecurringExpense(c *fiber.Ctx) error {
+	input := models.RecurringExpenseInput{}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if err := input.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if !middleware.CanAccessBranch(c, input.BranchID) {
+		return middleware.ForbiddenBranch(c)
+	}
+	if _, err := i.findCategory(c.Context(), input.CategoryID); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	template := models.RecurringExpense{}
+	err := i.RecurringCollection.FindOne(c.Context(), bson.M{"_id": c.Params("id")}).Decode(&template)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Recurring expense not found",
+			Code:    fiber.StatusNotFound,
+		}))
+	}
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+
+	active := template.Active
+	if input.Active != nil {
+		active = *input.Active
+	}
+	template.Update(&input, active)
+	if _, err := i.RecurringCollection.ReplaceOne(c.Context(), bson.M{"_id": template.ID}, template); err != nil {
+		log.Error().Err(err).Msg("Failed to update recurring expense")
+		return models.ReturnError(c, err)
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeUpdateRecurringExpense, template, i.ActivitiesCollection)
+	return c.JSON(models.NewOutput([]models.RecurringExpense{template}))
+}
+
+// GenerateRecurringExpenses godoc
+// @Security BearerAuth
+// @Summary Generate due recurring expenses
+// @Description Generates the pending expenses of the templates which are due now, as the background job does
+// @Tags expenses
+// @Produce json
+// @Success 200 {object} models.ExpenseOutput
+// @Failure 500 {object} models.Output
+// @Router /api/expenses/recurring/generate [post]
+func (i *InternalExpensesController) GenerateRecurringExpenses(c *fiber.Ctx) error {
+	expenses, err := i.GenerateDueExpenses(c.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate recurring expenses")
+		return models.ReturnError(c, err)
+	}
+	if len(expenses) > 0 {
+		middleware.LogActivityWithCtx(c, middleware.ActivityTypeGenerateRecurringExpenses, len(expenses), i.ActivitiesCollection)
+	}
+	return c.JSON(models.NewOutput(expenses))
+}
+
+// GenerateDueExpenses creates the pending expenses of every active template due now. Every template is generated in its own
+// db transaction and only if its next run was not moved meanwhile, so concurrent runs do not generate an expense twice
+func (i *InternalExpensesController) GenerateDueExpenses(ctx context.Context) ([]models.Expense, error) {
+	now := time.Now().In(utils.GetTimeZone())
+	cursor, err := i.RecurringCollection.Find(ctx, bson.M{"active": true, "next_run": bson.M{"$lte": now}})
+	if err != nil {
+		return nil, err
+	}
+	templates := []models.RecurringExpense{}
+	if err := cursor.All(ctx, &templates); err != nil {
+		return nil, err
+	}
+
+	generated := []models.Expense{}
+	for _, template := range templates {
+		expenses, err := i.generate(ctx, &template, now)
+		if err != nil {
+			log.Error().Err(err).Str("recurring_id", template.ID).Msg("Failed to generate recurring expense")
+			continue
+		}
+		generated = append(generated, expenses...)
+	}
+	return generated, nil
+}
+
+func (i *InternalExpensesController) generate(ctx context.Context, template *models.RecurringExpense, now time.Time) ([]models.Expense, error) {
+	category, err := i.findCategory(ctx, template.CategoryID)
+	if err != nil {
+		return nil, err
+	}
+
+	session, ses_ctx, err := database.StartTransaction(i.ExpensesCollection.Database().Client())
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ses_ctx)
+
+	lock_date, err := models.LockDateOf(ses_ctx, models.PeriodClosesCollection(i.ExpensesCollection), template.BranchID)
+	if err != nil {
+		return nil, err
+	}
+
+	next_run := template.NextRun
+	expenses := []models.Expense{}
+	for _, date := range template.Due(now) {
+		if date.Before(lock_date) {
+			log.Warn().Str("recurring_id", template.ID).Time("date", date).Time("lock_date", lock_date).Msg("Skipped recurring expense due in a closed period")
+			continue
+		}
+		expense := models.NewExpense(template.Expense(date), category, recurringExpensesUser)
+		expense.RecurringID = template.ID
+		if _, err := i.ExpensesCollection.InsertOne(ses_ctx, expense); err != nil {
+			session.AbortTransaction(ses_ctx)
+			return nil, err
+		}
+		expenses = append(expenses, *expense)
+	}
+	result, err := i.RecurringCollection.UpdateOne(ses_ctx, bson.M{"_id": template.ID, "next_run": next_run}, bson.M{
+		"$set": bson.M{
+			"next_run": template.NextRun,
+			"last_run": template.LastRun,
+		},
+	})
+	if err != nil {
+		session.AbortTransaction(ses_ctx)
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		// generated by another run meanwhile
+		session.AbortTransaction(ses_ctx)
+		return []models.Expense{}, nil
+	}
+	if err := session.CommitTransaction(ses_ctx); err != nil {
+		return nil, err
+	}
+
+	log.Info().Str("recurring_id", template.ID).Int("count", len(expenses)).Msg("Generated recurring expenses")
+	return expenses, nil
+}
+
+// RunRecurringExpenses generates due recurring expenses every interval until the context is cancelled
+func (i *InternalExpensesController) RunRecurringExpenses(ctx context.Context, interval time.Duration) {
+	log.Info().Dur("interval", interval).Msg("Starting recurring expenses generator")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := i.GenerateDueExpenses(ctx); err != nil {
+			log.Error().Err(err).Msg("Generating recurring expenses failed")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
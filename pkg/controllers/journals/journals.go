@@ -9,7 +9,6 @@ import (
 	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/sales"
 	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
 	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
-	"github.com/aslon1213/g4h_pos_erp/pkg/utils"
 	"github.com/aslon1213/g4h_pos_erp/platform/database"
 
 	"github.com/gofiber/fiber/v2"
@@ -26,6 +25,7 @@ type JournalHandlers struct {
 	ctx                    context.Context
 	JournalCollection      *mongo.Collection
 	FinanceCollection      *mongo.Collection
+	BranchesCollection     *mongo.Collection
 	TransactionsCollection *mongo.Collection
 	ActivitiesCollection   *mongo.Collection
 	Tracer                 trace.Tracer
@@ -48,6 +48,17 @@ func New(db *mongo.Database) *JournalHandlers {
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to create index for journals")
 	}
+	// one journal per day of the branch --- the snapshot of the branch changes when the branch is edited
+	_, err = journalCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "date", Value: -1},
+			{Key: "branch._id", Value: -1},
+		},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create index for journals")
+	}
 	financeCollection := db.Collection("finance")
 	transactionsCollection := db.Collection("transactions")
 	activitiesCollection := db.Collection("activities")
@@ -56,6 +67,7 @@ func New(db *mongo.Database) *JournalHandlers {
 		ctx:                    ctx,
 		JournalCollection:      journalCollection,
 		FinanceCollection:      financeCollection,
+		BranchesCollection:     db.Collection("branches"),
 		TransactionsCollection: transactionsCollection,
 		ActivitiesCollection:   activitiesCollection,
 		Tracer:                 tracer,
@@ -205,6 +217,7 @@ func QueryJournals(span trace.Span, ctx context.Context, c *fiber.Ctx, queryPara
 // @Param input body models.NewJournalEntryInput true "New Journal Entry Input"
 // @Success 201 {object} models.Journal
 // @Failure 400 {object} models.Error
+// @Failure 409 {object} models.Output
 // @Failure 500 {object} models.Error
 // @Router /api/journals [post]
 func (j *JournalHandlers) NewJournalEntry(c *fiber.Ctx) error {
@@ -220,30 +233,34 @@ func (j *JournalHandlers) NewJournalEntry(c *fiber.Ctx) error {
 	// log activity
 
 	// get the branch from the database
-	financeBranch := models.BranchFinance{}
-	err := j.FinanceCollection.FindOne(j.ctx, bson.M{"$or": []bson.M{{"branch_name": bson.M{"$regex": input.BranchNameOrID, "$options": "i"}}, {"branch_id": input.BranchNameOrID}}}).Decode(&financeBranch)
+	branch, err := models.FindActiveBranch(j.ctx, input.BranchNameOrID, j.BranchesCollection)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to find branch in finance collection")
+		log.Error().Err(err).Str("branch", input.BranchNameOrID).Msg("Failed to find branch")
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": err.Error(),
 		})
 	}
 
-	// parse the date to the timezone first and set to midnight
-	loc := utils.GetTimeZone()
+	if !middleware.CanAccessBranch(c, branch.ID) {
+		return middleware.ForbiddenBranch(c)
+	}
+
+	// parse the date to the timezone of the branch first and set to midnight
+	loc := branch.Location()
 	input.Date = input.Date.In(loc)
 	input.Date = time.Date(input.Date.Year(), input.Date.Month(), input.Date.Day(), 0, 0, 0, 0, loc)
 
-	branch := models.Branch_names[input.BranchNameOrID]
+	err = models.CheckPeriodOpen(j.ctx, models.PeriodClosesCollection(j.JournalCollection), branch.ID, input.Date)
+	if errors.Is(err, models.ErrPeriodClosed) {
+		return middleware.PeriodClosed(c, err)
+	}
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
 
 	journal := models.JournalWithTransactionID{
 		JournalBase: models.JournalBase{
-			Branch: models.Branch{
-				Name:     branch.Name,
-				Location: branch.Location,
-				Phone:    branch.Phone,
-				ID:       financeBranch.BranchID,
-			},
+			Branch:          branch.Ref(),
 			Date:            input.Date,
 			Shift_is_closed: false,
 			Terminal_income: 0,
@@ -321,6 +338,7 @@ func (j *JournalHandlers) CloseJournalEntry(c *fiber.Ctx) error {
 		}))
 	}
 	defer ses.EndSession(ctx)
+	ctx = middleware.Attributed(c, ctx) // records are attributed to the user and terminal of the request
 	// get journal info
 	journal, err := FetchJournalByID(ctx, c, true, j.JournalCollection)
 	if err != nil {
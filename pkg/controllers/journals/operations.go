@@ -2,13 +2,12 @@ package journal_handlers
 
 import (
 	"context"
-	"time"
 
 	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/sales"
 	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/suppliers"
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/transactions"
 	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
 	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
-	"github.com/aslon1213/g4h_pos_erp/pkg/utils"
 	"github.com/aslon1213/g4h_pos_erp/platform/database"
 
 	"github.com/gofiber/fiber/v2"
@@ -79,6 +78,7 @@ func (o *OperationHandlers) NewOperationTransaction(c *fiber.Ctx) error {
 		}))
 	}
 	defer ses.EndSession(ctx)
+	ctx = middleware.Attributed(c, ctx) // records are attributed to the user and terminal of the request
 	// log activity
 
 	ids := []string{}
@@ -117,7 +117,7 @@ func (o *OperationHandlers) NewOperationTransaction(c *fiber.Ctx) error {
 			}))
 		}
 		log.Info().Msg("Creating new supplier transaction")
-		supplier_transaction, err := suppliers.NewSupplierTransaction(ctx, transaction.TransactionBase, transaction.SupplierID, journal.Branch.ID, o.TransactionsCollection, o.JournalsCollection, o.SuppliersCollections)
+		supplier_transaction, err := suppliers.NewSupplierTransaction(ctx, transaction.TransactionBase, transaction.SupplierID, journal.Branch.ID, o.TransactionsCollection, o.FinancesCollection, o.SuppliersCollections)
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to create supplier transaction")
 
@@ -195,16 +195,20 @@ func (o *OperationHandlers) ShiftIsOpenMiddleware(c *fiber.Ctx) error {
 // UpdateOperationTransactionByID godoc
 // @Security BearerAuth
 // @Summary Update an operation transaction
-// @Description Update an operation transaction by ID
+// @Description Edits the amount and description of an operation of the journal, the journal total and the finance of the branch change
+// @Description by the difference and the prior version is kept (see PUT /api/transactions/{id}). Supplier operations are changed through the supplier
 // @Tags journals/operations
 // @Accept json
 // @Produce json
 // @Param id path string true "Operation ID"
 // @Param journal_id path string true "Journal ID"
-// @Param amount query int true "Amount"
+// @Param amount query int false "Amount, kept if not given"
 // @Param description query string false "Description"
+// @Param reason query string false "Reason of the change"
 // @Success 200 {object} models.Output
 // @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 409 {object} models.Output
 // @Failure 500 {object} models.Output
 // @Router /api/journals/{journal_id}/operations/{id} [put]
 func (o *OperationHandlers) UpdateOperationTransactionByID(c *fiber.Ctx) error {
@@ -213,125 +217,49 @@ func (o *OperationHandlers) UpdateOperationTransactionByID(c *fiber.Ctx) error {
 
 	journal := c.Locals("journal").(*models.Journal)
 	operation_id := c.Params("operation_id")
-	amount := c.QueryInt("amount")
-	description := c.Query("description")
-	if amount <= 0 {
+	input := models.TransactionEditInput{
+		Reason: c.Query("reason", "Corrected in journal "+journal.ID.Hex()),
+	}
+	// only the given fields change
+	if c.Query("amount") != "" {
+		amount := int64(c.QueryInt("amount"))
+		input.Amount = &amount
+	}
+	if description := c.Query("description"); description != "" {
+		input.Description = &description
+	}
+	if err := input.Validate(); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
-			Message: "Amount must be greater than 0",
+			Message: err.Error(),
 			Code:    fiber.StatusBadRequest,
 		}))
 	}
-
-	// start a new session and transaction
-	ses, ctx, err := database.StartTransaction(o.JournalsCollection.Database().Client())
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to start transaction")
-		return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
-			Message: err.Error(),
-			Code:    fiber.StatusInternalServerError,
+	if !hasOperation(journal, operation_id) {
+		return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Operation not found",
+			Code:    fiber.StatusNotFound,
 		}))
 	}
-	defer ses.EndSession(ctx)
 
-	branch_id := journal.Branch.ID
-	// check if shift is closed or not
-
-	for _, operation := range journal.Operations {
-		if operation.ID == operation_id {
-			// update transaction in transactions collection
-			update_query := bson.M{
-				"$set": bson.M{
-					"transactionbase.amount": uint32(amount),
-					"updated_at":             time.Now(),
-				},
-			}
-			if description != "" {
-				update_query["$set"].(bson.M)["transactionbase.description"] = description
-
-			}
-
-			_, err = o.TransactionsCollection.UpdateOne(ctx, bson.M{"_id": operation.ID}, update_query)
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to update transaction in transactions collection")
-				ses.AbortTransaction(ctx)
-				return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
-					Message: err.Error(),
-					Code:    fiber.StatusInternalServerError,
-				}))
-			}
-			// update journal total
-			diff := int32(int(operation.Amount) - amount)
-			_, err = o.JournalsCollection.UpdateOne(ctx, bson.M{"_id": journal.ID}, bson.M{"$inc": bson.M{"total": -diff}})
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to update journal total, operations list")
-				ses.AbortTransaction(ctx)
-				return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
-					Message: err.Error(),
-					Code:    fiber.StatusInternalServerError,
-				}))
-			}
-			new_operation := models.Transaction{
-				ID: operation.ID,
-				TransactionBase: models.TransactionBase{
-					Amount:      uint32(amount),
-					Description: operation.Description,
-				},
-				CreatedAt: operation.CreatedAt,
-				UpdatedAt: time.Now(),
-			}
-			if description != "" {
-				new_operation.Description = description
-			}
-			journal.Total -= uint32(diff)
-			journal.Operations = utils.ReplaceElement(journal.Operations, operation, new_operation)
-
-			// update finance of branch
-			_, err = o.FinancesCollection.UpdateOne(ctx, bson.M{"branch_id": branch_id}, bson.M{"$inc": bson.M{"total": -diff}})
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to update finance of branch")
-				ses.AbortTransaction(ctx)
-				return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
-					Message: err.Error(),
-					Code:    fiber.StatusInternalServerError,
-				}))
-			}
-			// commit the transaction
-			if err := ses.CommitTransaction(ctx); err != nil {
-				log.Error().Err(err).Msg("Failed to commit transaction")
-				ses.AbortTransaction(ctx)
-				return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
-					Message: err.Error(),
-					Code:    fiber.StatusInternalServerError,
-				}))
-			}
-			// log activity
-			middleware.LogActivityWithCtx(c, middleware.ActivityTypeEditOperation, fiber.Map{
-				"journal_id":   journal.ID,
-				"operation_id": operation_id,
-				"amount":       amount,
-				"description":  description,
-				"activity":     "updated",
-			}, o.ActivitiesCollection)
-			return c.Status(fiber.StatusOK).JSON(models.NewOutput(journal))
-		}
-	}
-	return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
-		Message: "Operation not found",
-		Code:    fiber.StatusNotFound,
-	}))
+	return o.revise(c, middleware.ActivityTypeEditOperation, func(ctx context.Context) (*models.TransactionRevision, error) {
+		return transactions.EditTransaction(ctx, o.TransactionsCollection, operation_id, &input)
+	})
 }
 
 // DeleteOperationTransactionByID godoc
 // @Security BearerAuth
 // @Summary Delete an operation transaction
-// @Description Delete an operation transaction by ID
+// @Description Voids an operation of the journal: it leaves the operations, the journal total and the finance of the branch lose its amount
+// @Description and its last version is kept (see DELETE /api/transactions/{id}). Supplier operations are changed through the supplier
 // @Tags journals/operations
 // @Accept json
 // @Produce json
 // @Param id path string true "Operation ID"
 // @Param journal_id path string true "Journal ID"
+// @Param reason query string false "Reason of the void"
 // @Success 200 {object} models.Output
-// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 409 {object} models.Output
 // @Failure 500 {object} models.Output
 // @Router /api/journals/{journal_id}/operations/{id} [delete]
 func (o *OperationHandlers) DeleteOperationTransactionByID(c *fiber.Ctx) error {
@@ -339,6 +267,32 @@ func (o *OperationHandlers) DeleteOperationTransactionByID(c *fiber.Ctx) error {
 	log.Info().Str("operation_id", c.Params("operation_id")).Msg("Deleting operation transaction by ID")
 	operation_id := c.Params("operation_id")
 
+	journal := c.Locals("journal").(*models.Journal)
+	reason := c.Query("reason", "Deleted from journal "+journal.ID.Hex())
+	if !hasOperation(journal, operation_id) {
+		log.Error().Msg("Transaction not found")
+		return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Transaction not found",
+			Code:    fiber.StatusNotFound,
+		}))
+	}
+
+	return o.revise(c, middleware.ActivityTypeDeleteOperation, func(ctx context.Context) (*models.TransactionRevision, error) {
+		return transactions.VoidTransaction(ctx, o.TransactionsCollection, operation_id, reason)
+	})
+}
+
+func hasOperation(journal *models.Journal, operation_id string) bool {
+	for _, operation := range journal.Operations {
+		if operation.ID == operation_id {
+			return true
+		}
+	}
+	return false
+}
+
+// revise runs the edit or void of the operation in a db transaction and responds with the journal after it
+func (o *OperationHandlers) revise(c *fiber.Ctx, activity middleware.ActivityType, fn func(ctx context.Context) (*models.TransactionRevision, error)) error {
 	// start a new session and transaction
 	ses, ctx, err := database.StartTransaction(o.JournalsCollection.Database().Client())
 	if err != nil {
@@ -349,79 +303,38 @@ func (o *OperationHandlers) DeleteOperationTransactionByID(c *fiber.Ctx) error {
 		}))
 	}
 	defer ses.EndSession(ctx)
+	ctx = middleware.Attributed(c, ctx) // versions are attributed to the user and terminal of the request
 
-	journal := c.Locals("journal").(*models.Journal)
-
-	branch_id := journal.Branch.ID
-	// delete transaction from transactions collection
-	// check if the transaction exists
-	for _, operation := range journal.Operations {
-		if operation.ID == operation_id {
-			// delete transaction from transactions collection
-
-			_, err = o.TransactionsCollection.DeleteOne(ctx, bson.M{"_id": operation.ID})
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to delete transaction from transactions collection")
-				ses.AbortTransaction(ctx)
-				return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
-					Message: err.Error(),
-					Code:    fiber.StatusInternalServerError,
-				}))
-			}
-
-			// update journal total, operations list
-			// update finance of branch
-			_, err = o.FinancesCollection.UpdateOne(ctx, bson.M{"branch_id": branch_id}, bson.M{"$inc": bson.M{"total": -1 * int32(operation.Amount)}})
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to update finance of branch")
-				ses.AbortTransaction(ctx)
-				return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
-					Message: err.Error(),
-					Code:    fiber.StatusInternalServerError,
-				}))
-			}
-
-			// update journal total, operations list
-			_, err = o.JournalsCollection.UpdateOne(ctx, bson.M{"_id": journal.ID}, bson.M{"$pull": bson.M{"operations": operation_id}, "$inc": bson.M{"total": -1 * int32(operation.Amount)}})
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to update journal total, operations list")
-				ses.AbortTransaction(ctx)
-				return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
-					Message: err.Error(),
-					Code:    fiber.StatusInternalServerError,
-				}))
-			}
-			journal.Operations = utils.RemoveElement(journal.Operations, operation)
-			journal.Total -= operation.Amount
-
-			// commit the transaction
-			if err := ses.CommitTransaction(ctx); err != nil {
-				log.Error().Err(err).Msg("Failed to commit transaction")
-				ses.AbortTransaction(ctx)
-				return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
-					Message: err.Error(),
-					Code:    fiber.StatusInternalServerError,
-				}))
-			}
-			// log activity
-			middleware.LogActivityWithCtx(c, middleware.ActivityTypeDeleteOperation, fiber.Map{
-				"journal_id":   journal.ID,
-				"operation_id": operation_id,
-				"activity":     "deleted",
-			}, o.ActivitiesCollection)
-
-			log.Info().Msg("Transaction deleted successfully")
-			return c.Status(fiber.StatusOK).JSON(models.NewOutput(journal))
-		}
+	revision, err := fn(ctx)
+	if err != nil {
+		ses.AbortTransaction(ctx)
+		log.Error().Err(err).Msg("Failed to revise operation")
+		status := transactions.RevisionErrorStatus(err)
+		return c.Status(status).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    status,
+		}))
 	}
+	// commit the transaction
+	if err := ses.CommitTransaction(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to commit transaction")
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusInternalServerError,
+		}))
+	}
+	// log activity
+	middleware.LogActivityWithCtx(c, activity, revision.Previous, o.ActivitiesCollection)
 
-	log.Error().Msg("Transaction not found")
-	ses.AbortTransaction(ctx)
-	return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
-		Message: "Transaction not found",
-		Code:    fiber.StatusNotFound,
-	}))
-
+	journal, err := FetchJournalByID(context.Background(), c, true, o.JournalsCollection)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch journal by ID")
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusInternalServerError,
+		}))
+	}
+	return c.Status(fiber.StatusOK).JSON(models.NewOutput(journal))
 }
 
 // GetOperationTransactionByID godoc
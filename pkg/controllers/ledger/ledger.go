@@ -0,0 +1,376 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+	"github.com/aslon1213/g4h_pos_erp/pkg/utils"
+	"github.com/aslon1213/g4h_pos_erp/platform/database"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var ErrLedgerNotEmpty = errors.New("ledger of the branch already has entries")
+
+type LedgerController struct {
+	LedgerCollection     *mongo.Collection
+	FinanceCollection    *mongo.Collection
+	ActivitiesCollection *mongo.Collection
+}
+
+func New(db *mongo.Database) *LedgerController {
+	log.Info().Msg("Initializing LedgerController")
+	ledger_collection := db.Collection("ledger")
+	_, _ = ledger_collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "branch_id", Value: 1}, {Key: "date", Value: 1}},
+	})
+	_, _ = ledger_collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.M{"transaction_id": 1},
+	})
+	return &LedgerController{
+		LedgerCollection:     ledger_collection,
+		FinanceCollection:    db.Collection("finance"),
+		ActivitiesCollection: db.Collection("activities"),
+	}
+}
+
+// Collection returns the ledger collection of the database of the given collection, so that posting
+// can be done by code which only has the transactions or finance collection at hand
+func Collection(of *mongo.Collection) *mongo.Collection {
+	return of.Database().Collection("ledger")
+}
+
+// Post validates the entry and inserts it into the ledger
+func Post(ctx context.Context, ledgerCollection *mongo.Collection, entry *models.LedgerEntry) error {
+	if err := entry.Validate(); err != nil {
+		log.Error().Err(err).Interface("entry", entry).Msg("Invalid ledger entry")
+		return err
+	}
+	if _, err := ledgerCollection.InsertOne(ctx, entry); err != nil {
+		log.Error().Err(err).Str("branch_id", entry.BranchID).Msg("Failed to post ledger entry")
+		return err
+	}
+	return nil
+}
+
+// PostTransaction posts the balanced entry of the transaction
+func PostTransaction(ctx context.Context, ledgerCollection *mongo.Collection, transaction *models.Transaction) (*models.LedgerEntry, error) {
+	entry, err := models.NewLedgerEntryOfTransaction(ctx, transaction)
+	if err != nil {
+		log.Error().Err(err).Str("transaction_id", transaction.ID).Msg("Failed to build ledger entry of transaction")
+		return nil, err
+	}
+	if err := Post(ctx, ledgerCollection, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// ReverseTransaction posts reversals of the entries of the transaction which are not reversed yet
+func ReverseTransaction(ctx context.Context, ledgerCollection *mongo.Collection, transaction_id string, description string) ([]models.LedgerEntry, error) {
+	return reverse(ctx, ledgerCollection, bson.M{"transaction_id": transaction_id}, description)
+}
+
+// ReverseReference posts reversals of the entries of the document (bnpl, ...) which are not reversed yet
+func ReverseReference(ctx context.Context, ledgerCollection *mongo.Collection, reference string, description string) ([]models.LedgerEntry, error) {
+	return reverse(ctx, ledgerCollection, bson.M{"reference": reference}, description)
+}
+
+func reverse(ctx context.Context, ledgerCollection *mongo.Collection, filter bson.M, description string) ([]models.LedgerEntry, error) {
+	cursor, err := ledgerCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	entries := []models.LedgerEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	reversed := map[string]bool{}
+	for _, entry := range entries {
+		if entry.Reverses != "" {
+			reversed[entry.Reverses] = true
+		}
+	}
+	reversals := []models.LedgerEntry{}
+	for _, entry := range entries {
+		if entry.Reverses != "" || reversed[entry.ID] {
+			continue
+		}
+		reversal := entry.Reversal(ctx, description)
+		if err := Post(ctx, ledgerCollection, reversal); err != nil {
+			return nil, err
+		}
+		reversals = append(reversals, *reversal)
+	}
+	return reversals, nil
+}
+
+// TrialBalanceOf sums the entries of the branch dated before the given time by account
+func TrialBalanceOf(ctx context.Context, ledgerCollection *mongo.Collection, branch_id string, before time.Time) (models.TrialBalance, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"branch_id": branch_id, "date": bson.M{"$lt": before}}}},
+		{{Key: "$unwind", Value: "$lines"}},
+		{{Key: "$group", Value: bson.M{
+			"_id":    "$lines.account",
+			"debit":  bson.M{"$sum": "$lines.debit"},
+			"credit": bson.M{"$sum": "$lines.credit"},
+		}}},
+	}
+	cursor, err := ledgerCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return models.TrialBalance{}, err
+	}
+	rows := []struct {
+		Account models.AccountCode `bson:"_id"`
+		Debit   int64              `bson:"debit"`
+		Credit  int64              `bson:"credit"`
+	}{}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return models.TrialBalance{}, err
+	}
+	totals := map[models.AccountCode]models.LedgerLine{}
+	for _, row := range rows {
+		totals[row.Account] = models.LedgerLine{Account: row.Account, Debit: row.Debit, Credit: row.Credit}
+	}
+	return models.NewTrialBalance(branch_id, before, totals), nil
+}
+
+// endOfDate parses the date query (YYYY-MM-DD, today by default) and returns the start of the next day
+func endOfDate(c *fiber.Ctx) (time.Time, error) {
+	date, err := time.ParseInLocation("2006-01-02", c.Query("date", time.Now().In(utils.GetTimeZone()).Format("2006-01-02")), utils.GetTimeZone())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date, use YYYY-MM-DD")
+	}
+	return date.AddDate(0, 0, 1), nil
+}
+
+// GetChartOfAccounts godoc
+// @Security BearerAuth
+// @Summary Chart of accounts
+// @Description Accounts every financial event of the branches is posted to
+// @Tags ledger
+// @Produce json
+// @Success 200 {object} models.Output
+// @Router /api/ledger/accounts [get]
+func (l *LedgerController) GetChartOfAccounts(c *fiber.Ctx) error {
+	return c.JSON(models.NewOutput(models.ChartOfAccounts))
+}
+
+// QueryLedgerEntries godoc
+// @Security BearerAuth
+// @Summary Query ledger entries of branch
+// @Description Entries of the branch, newest first
+// @Tags ledger
+// @Produce json
+// @Param branch_id path string true "Branch ID"
+// @Param account query string false "Account code"
+// @Param source query string false "Initiator type of the entry"
+// @Param transaction_id query string false "Transaction ID"
+// @Param from_date query string false "From date (YYYY-MM-DD)"
+// @Param to_date query string false "To date (YYYY-MM-DD)"
+// @Param page query int false "Page number" default(1)
+// @Param count query int false "Entries per page" default(20)
+// @Success 200 {object} models.LedgerEntriesOutput
+// @Failure 400 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/ledger/branch/{branch_id}/entries [get]
+func (l *LedgerController) QueryLedgerEntries(c *fiber.Ctx) error {
+	branch_id := c.Params("branch_id")
+	filter := bson.M{"branch_id": branch_id}
+	if account := c.Query("account"); account != "" {
+		filter["lines.account"] = account
+	}
+	if source := c.Query("source"); source != "" {
+		filter["source"] = source
+	}
+	if transaction_id := c.Query("transaction_id"); transaction_id != "" {
+		filter["transaction_id"] = transaction_id
+	}
+	date := bson.M{}
+	if from_date := c.Query("from_date"); from_date != "" {
+		from, err := time.ParseInLocation("2006-01-02", from_date, utils.GetTimeZone())
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError("Invalid from date", fiber.StatusBadRequest)))
+		}
+		date["$gte"] = from
+	}
+	if to_date := c.Query("to_date"); to_date != "" {
+		to, err := time.ParseInLocation("2006-01-02", to_date, utils.GetTimeZone())
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError("Invalid to date", fiber.StatusBadRequest)))
+		}
+		date["$lt"] = to.AddDate(0, 0, 1)
+	}
+	if len(date) > 0 {
+		filter["date"] = date
+	}
+
+	page := c.QueryInt("page", 1)
+	count := c.QueryInt("count", 20)
+	if page < 1 {
+		page = 1
+	}
+	if count < 1 {
+		count = 20
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "date", Value: -1}}).SetSkip(int64(count * (page - 1))).SetLimit(int64(count))
+	cursor, err := l.LedgerCollection.Find(c.Context(), filter, opts)
+	if err != nil {
+		log.Error().Err(err).Str("branch_id", branch_id).Msg("Failed to query ledger entries")
+		return models.ReturnError(c, err)
+	}
+	entries := []models.LedgerEntry{}
+	if err := cursor.All(c.Context(), &entries); err != nil {
+		log.Error().Err(err).Msg("Failed to decode ledger entries")
+		return models.ReturnError(c, err)
+	}
+	return c.JSON(models.NewOutput(entries))
+}
+
+// GetTrialBalance godoc
+// @Security BearerAuth
+// @Summary Trial balance of branch
+// @Description Debit and credit totals of every account of the branch up to the end of the date
+// @Tags ledger
+// @Produce json
+// @Param branch_id path string true "Branch ID"
+// @Param date query string false "Date (YYYY-MM-DD)" default(today)
+// @Success 200 {object} models.TrialBalanceOutput
+// @Failure 400 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/ledger/branch/{branch_id}/trial-balance [get]
+func (l *LedgerController) GetTrialBalance(c *fiber.Ctx) error {
+	branch_id := c.Params("branch_id")
+	before, err := endOfDate(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+	}
+	trial_balance, err := TrialBalanceOf(c.Context(), l.LedgerCollection, branch_id, before)
+	if err != nil {
+		log.Error().Err(err).Str("branch_id", branch_id).Msg("Failed to compute trial balance")
+		return models.ReturnError(c, err)
+	}
+	return c.JSON(models.NewOutput(trial_balance))
+}
+
+// GetLedgerBalances godoc
+// @Security BearerAuth
+// @Summary Balances of branch derived from the ledger
+// @Description Cash, bank, terminal, mobile apps, payables and receivables of the branch up to the end of the date
+// @Tags ledger
+// @Produce json
+// @Param branch_id path string true "Branch ID"
+// @Param date query string false "Date (YYYY-MM-DD)" default(today)
+// @Success 200 {object} models.LedgerBalancesOutput
+// @Failure 400 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/ledger/branch/{branch_id}/balances [get]
+func (l *LedgerController) GetLedgerBalances(c *fiber.Ctx) error {
+	branch_id := c.Params("branch_id")
+	before, err := endOfDate(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+	}
+	trial_balance, err := TrialBalanceOf(c.Context(), l.LedgerCollection, branch_id, before)
+	if err != nil {
+		log.Error().Err(err).Str("branch_id", branch_id).Msg("Failed to compute balances")
+		return models.ReturnError(c, err)
+	}
+	return c.JSON(models.NewOutput(trial_balance.Balances()))
+}
+
+// PostOpeningBalance godoc
+// @Security BearerAuth
+// @Summary Post opening balance of branch
+// @Description Brings the balances and debt of the finance of the branch kept before the ledger into the ledger against equity.
+// @Description Only allowed while the ledger of the branch is empty
+// @Tags ledger
+// @Produce json
+// @Param branch_id path string true "Branch ID"
+// @Success 201 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 409 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/ledger/branch/{branch_id}/opening-balance [post]
+func (l *LedgerController) PostOpeningBalance(c *fiber.Ctx) error {
+	branch_id := c.Params("branch_id")
+
+	ses, ctx, err := database.StartTransaction(l.LedgerCollection.Database().Client())
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+	defer ses.EndSession(ctx)
+	ctx = middleware.Attributed(c, ctx)
+
+	finance := models.BranchFinance{}
+	if err := l.FinanceCollection.FindOne(ctx, bson.M{"branch_id": branch_id}).Decode(&finance); err != nil {
+		ses.AbortTransaction(ctx)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.NewError("Finance of the branch not found", fiber.StatusNotFound)))
+		}
+		return models.ReturnError(c, err)
+	}
+	count, err := l.LedgerCollection.CountDocuments(ctx, bson.M{"branch_id": branch_id})
+	if err != nil {
+		ses.AbortTransaction(ctx)
+		return models.ReturnError(c, err)
+	}
+	if count > 0 {
+		ses.AbortTransaction(ctx)
+		return c.Status(fiber.StatusConflict).JSON(models.NewOutput([]interface{}{}, models.NewError(ErrLedgerNotEmpty.Error(), fiber.StatusConflict)))
+	}
+
+	entry := OpeningEntry(ctx, &finance)
+	if entry == nil {
+		ses.AbortTransaction(ctx)
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError("Finance of the branch has no balances", fiber.StatusBadRequest)))
+	}
+	if err := Post(ctx, l.LedgerCollection, entry); err != nil {
+		ses.AbortTransaction(ctx)
+		return models.ReturnError(c, err)
+	}
+	if err := ses.CommitTransaction(ctx); err != nil {
+		return models.ReturnError(c, err)
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypePostOpeningBalance, entry, l.ActivitiesCollection)
+	return c.Status(fiber.StatusCreated).JSON(models.NewOutput(entry))
+}
+
+// OpeningEntry returns the entry of the balances and debt of the finance against equity, nil if there is nothing to post
+func OpeningEntry(ctx context.Context, finance *models.BranchFinance) *models.LedgerEntry {
+	lines := []models.LedgerLine{}
+	var equity int64
+	add := func(account models.AccountCode, amount int64) {
+		switch {
+		case amount > 0:
+			lines = append(lines, models.DebitLine(account, amount))
+		case amount < 0:
+			lines = append(lines, models.CreditLine(account, -amount))
+		}
+		equity += amount
+	}
+	add(models.AccountCash, finance.Balance.Cash)
+	add(models.AccountBank, finance.Balance.Bank)
+	add(models.AccountTerminal, finance.Balance.Terminal)
+	add(models.AccountMobileApps, finance.Balance.MobileApps)
+	add(models.AccountPayables, -finance.Debt)
+	switch {
+	case equity > 0:
+		lines = append(lines, models.CreditLine(models.AccountEquity, equity))
+	case equity < 0:
+		lines = append(lines, models.DebitLine(models.AccountEquity, -equity))
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return models.NewLedgerEntry(ctx, finance.BranchID, models.InitiatorTypeOpening, "Opening balance", lines...)
+}
@@ -0,0 +1,199 @@
+package products
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/ledger"
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/sales"
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+	"github.com/aslon1213/g4h_pos_erp/platform/database"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// GetExpiringBatches godoc
+// @Security BearerAuth
+// @Summary Get batches expiring soon
+// @Description Lists batches with quantity left which expire within the given number of days (already expired ones included), first expired first
+// @Tags products/batches
+// @Produce json
+// @Param branch_id query string true "Branch ID"
+// @Param days query int false "Number of days" default(30)
+// @Success 200 {object} models.ProductBatchOutput
+// @Failure 400 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/products/batches/expiring [get]
+func (p *ProductsController) GetExpiringBatches(c *fiber.Ctx) error {
+	params := models.ExpiringBatchesQueryParams{Days: 30}
+	if err := c.QueryParser(&params); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if params.BranchID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "branch_id is required",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	log.Info().Str("branch_id", params.BranchID).Int("days", params.Days).Msg("Getting expiring batches")
+
+	filter := bson.M{
+		"place.id": params.BranchID,
+		"quantity": bson.M{"$gt": 0},
+		"expire":   bson.M{"$lte": time.Now().AddDate(0, 0, params.Days)},
+	}
+	cursor, err := p.BatchesCollection.Find(c.Context(), filter, options.Find().SetSort(bson.D{{Key: "expire", Value: 1}}))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to find batches")
+		return models.ReturnError(c, err)
+	}
+	batches := []models.ProductBatch{}
+	if err := cursor.All(c.Context(), &batches); err != nil {
+		log.Error().Err(err).Msg("Failed to decode batches")
+		return models.ReturnError(c, err)
+	}
+
+	return c.JSON(models.NewOutput(batches))
+}
+
+// WriteOffBatch godoc
+// @Security BearerAuth
+// @Summary Write off a batch
+// @Description Writes off (expired, damaged) quantity of a batch: decrements the batch and the stock of the place and records a loss transaction at cost price
+// @Tags products/batches
+// @Accept json
+// @Produce json
+// @Param id path string true "Batch ID"
+// @Param input body models.WriteOffInput true "Write-off details"
+// @Success 200 {object} models.Output
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/products/batches/{id}/write-off [post]
+func (p *ProductsController) WriteOffBatch(c *fiber.Ctx) error {
+	batch_id := c.Params("id")
+
+	input := models.WriteOffInput{}
+	if err := c.BodyParser(&input); err != nil {
+		log.Error().Err(err).Msg("Failed to parse write-off input")
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	session, ctx, err := database.StartTransaction(p.ProductsCollection.Database().Client())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to start transaction")
+		return models.ReturnError(c, err)
+	}
+	defer session.EndSession(ctx)
+
+	batch := &models.ProductBatch{}
+	err = p.BatchesCollection.FindOne(ctx, bson.M{"_id": batch_id}).Decode(batch)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		session.AbortTransaction(ctx)
+		return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Batch not found",
+			Code:    fiber.StatusNotFound,
+		}))
+	}
+	if err != nil {
+		session.AbortTransaction(ctx)
+		log.Error().Err(err).Str("batch_id", batch_id).Msg("Failed to find batch")
+		return models.ReturnError(c, err)
+	}
+
+	if err := input.Validate(batch); err != nil {
+		session.AbortTransaction(ctx)
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	log.Info().Str("batch_id", batch_id).Int32("quantity", input.Quantity).Msg("Writing off batch")
+
+	_, err = p.BatchesCollection.UpdateOne(ctx, bson.M{"_id": batch.ID}, bson.M{
+		"$inc": bson.M{"quantity": -input.Quantity, "written_off": input.Quantity},
+	})
+	if err != nil {
+		session.AbortTransaction(ctx)
+		log.Error().Err(err).Msg("Failed to update batch")
+		return models.ReturnError(c, err)
+	}
+
+	if err := sales.DecrementStock(ctx, p.ProductsCollection, batch.ProductID, batch.Place.ID, input.Quantity); err != nil {
+		session.AbortTransaction(ctx)
+		if errors.Is(err, sales.ErrInsufficientStock) {
+			return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+				Message: err.Error(),
+				Code:    fiber.StatusBadRequest,
+			}))
+		}
+		return models.ReturnError(c, err)
+	}
+
+	// loss at cost price --- no money leaves the balance, only expenses grow
+	description := fmt.Sprintf("Write-off of %d items of product %s (batch %s)", input.Quantity, batch.ProductID, batch.ID)
+	if input.Reason != "" {
+		description += ": " + input.Reason
+	}
+	transaction := models.NewTransaction(&models.TransactionBase{
+		Amount:        int64(batch.Price) * int64(input.Quantity),
+		Description:   description,
+		Type:          models.TransactionTypeDebit,
+		PaymentMethod: models.PaymentMethodUndefined,
+	}, models.InitiatorTypeWriteOff, batch.Place.ID)
+	if err := transaction.InBranchCurrency(ctx, p.FinanceCollection); err != nil {
+		session.AbortTransaction(ctx)
+		return models.ReturnError(c, err)
+	}
+
+	if _, err := p.TransactionsCollection.InsertOne(ctx, transaction); err != nil {
+		session.AbortTransaction(ctx)
+		log.Error().Err(err).Msg("Failed to insert write-off transaction")
+		return models.ReturnError(c, err)
+	}
+	// warehouses have no finance, so not matching anything is fine
+	_, err = p.FinanceCollection.UpdateOne(ctx, bson.M{"branch_id": batch.Place.ID}, bson.M{
+		"$inc": bson.M{"finance.total_expenses": transaction.Amount},
+	})
+	if err != nil {
+		session.AbortTransaction(ctx)
+		log.Error().Err(err).Msg("Failed to update finance")
+		return models.ReturnError(c, err)
+	}
+	if _, err := ledger.PostTransaction(ctx, ledger.Collection(p.TransactionsCollection), transaction); err != nil {
+		session.AbortTransaction(ctx)
+		return models.ReturnError(c, err)
+	}
+
+	if err := session.CommitTransaction(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to commit transaction")
+		return models.ReturnError(c, err)
+	}
+
+	batch.Quantity -= input.Quantity
+	batch.WrittenOff += input.Quantity
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeWriteOffBatch, fiber.Map{
+		"batch_id":       batch.ID,
+		"quantity":       input.Quantity,
+		"reason":         input.Reason,
+		"transaction_id": transaction.ID,
+	}, p.ActivitiesCollection)
+
+	return c.JSON(models.NewOutput(fiber.Map{
+		"batch":       batch,
+		"transaction": transaction,
+	}))
+}
@@ -0,0 +1,254 @@
+package products
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// FindLowStock returns products whose quantity at a place is below their MinimumStockAlert.
+// Products without MinimumStockAlert are never reported. Empty place_id means all places
+func (p *ProductsController) FindLowStock(ctx context.Context, place_id string) ([]models.LowStockItem, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"minimum_stock_alert": bson.M{"$gt": 0}}}},
+		{{Key: "$unwind", Value: "$quantity_distribution"}},
+	}
+	if place_id != "" {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"quantity_distribution.place.id": place_id}}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$match", Value: bson.M{"$expr": bson.M{"$lt": bson.A{"$quantity_distribution.quantity", "$minimum_stock_alert"}}}}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"_id":                 0,
+			"product_id":          "$_id",
+			"name":                1,
+			"sku":                 1,
+			"place":               "$quantity_distribution.place",
+			"quantity":            "$quantity_distribution.quantity",
+			"minimum_stock_alert": 1,
+		}}},
+	)
+
+	cursor, err := p.ProductsCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to aggregate low stock products")
+		return nil, err
+	}
+	items := []models.LowStockItem{}
+	if err := cursor.All(ctx, &items); err != nil {
+		log.Error().Err(err).Msg("Failed to decode low stock products")
+		return nil, err
+	}
+	return items, nil
+}
+
+// CreateLowStockProposals creates proposals for low stock items so they flow into the replenishment pipeline.
+// Items at warehouses are skipped (proposals are per branch) as well as items which already have an unfulfilled proposal
+func (p *ProductsController) CreateLowStockProposals(ctx context.Context, items []models.LowStockItem) (int, error) {
+	active_branches := map[string]bool{}
+	created := 0
+	for _, item := range items {
+		if item.Place.PlaceType == models.ProductPlaceTypeWarehouse {
+			continue
+		}
+
+		// proposals reference the branch by ID, places of inactive or unknown branches are skipped
+		branch := item.Place.ID
+		active, ok := active_branches[branch]
+		if !ok {
+			_, err := models.FindActiveBranch(ctx, branch, p.BranchesCollection)
+			if errors.Is(err, models.ErrBranchNotFound) || errors.Is(err, models.ErrBranchInactive) {
+				log.Warn().Err(err).Str("place_id", item.Place.ID).Msg("No active branch found for place, skipping proposal")
+			} else if err != nil {
+				return created, err
+			}
+			active = err == nil
+			active_branches[branch] = active
+		}
+		if !active {
+			continue
+		}
+
+		count, err := p.ProposalsCollection.CountDocuments(ctx, bson.M{"name": item.Name, "branch": branch, "fulfilled": false})
+		if err != nil {
+			return created, err
+		}
+		if count > 0 {
+			continue
+		}
+
+		_, err = p.ProposalsCollection.InsertOne(ctx, models.ProductProposal{
+			ID:        bson.NewObjectID(),
+			Name:      item.Name,
+			Date:      time.Now(),
+			Branch:    branch,
+			Fulfilled: false,
+		})
+		if err != nil {
+			return created, err
+		}
+		created++
+	}
+	return created, nil
+}
+
+// CheckLowStock finds products which crossed their threshold since the last check, emits an event for them
+// and (if configured) creates proposals. Products which are back above the threshold are forgotten so they alert again next time
+func (p *ProductsController) CheckLowStock(ctx context.Context) error {
+	items, err := p.FindLowStock(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	cursor, err := p.StockAlertsCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	alerts := []models.StockAlert{}
+	if err := cursor.All(ctx, &alerts); err != nil {
+		return err
+	}
+	alerted := map[string]bool{}
+	for _, alert := range alerts {
+		alerted[alert.ID] = true
+	}
+
+	crossed := []models.LowStockItem{}
+	for _, item := range items {
+		id := models.StockAlertID(item.ProductID, item.Place.ID)
+		if alerted[id] {
+			delete(alerted, id)
+			continue
+		}
+		_, err := p.StockAlertsCollection.InsertOne(ctx, models.StockAlert{
+			ID:           id,
+			LowStockItem: item,
+			AlertedAt:    time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		crossed = append(crossed, item)
+	}
+
+	// what is left is back above the threshold
+	if len(alerted) > 0 {
+		recovered := []string{}
+		for id := range alerted {
+			recovered = append(recovered, id)
+		}
+		if _, err := p.StockAlertsCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": recovered}}); err != nil {
+			return err
+		}
+	}
+
+	if len(crossed) == 0 {
+		return nil
+	}
+	log.Warn().Int("count", len(crossed)).Interface("items", crossed).Msg("Products crossed minimum stock alert")
+
+	if err := p.sendLowStockEvent(ctx, crossed); err != nil {
+		log.Error().Err(err).Msg("Failed to send low stock event")
+	}
+	if p.Alerts.AutoCreateProposals {
+		created, err := p.CreateLowStockProposals(ctx, crossed)
+		if err != nil {
+			return err
+		}
+		log.Info().Int("count", created).Msg("Created proposals for low stock products")
+	}
+	return nil
+}
+
+func (p *ProductsController) sendLowStockEvent(ctx context.Context, items []models.LowStockItem) error {
+	if p.Alerts.LowStockWebhookURL == "" {
+		return nil
+	}
+	body, err := json.Marshal(models.LowStockEvent{
+		Type:  models.LowStockEventType,
+		Items: items,
+		Date:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.Alerts.LowStockWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// RunLowStockChecker checks low stock every interval until the context is cancelled
+func (p *ProductsController) RunLowStockChecker(ctx context.Context, interval time.Duration) {
+	log.Info().Dur("interval", interval).Msg("Starting low stock checker")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.CheckLowStock(ctx); err != nil {
+			log.Error().Err(err).Msg("Low stock check failed")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// GetLowStockProducts godoc
+// @Security BearerAuth
+// @Summary Get low stock products
+// @Description Lists products whose quantity at a place is below their minimum stock alert. Optionally creates proposals for them
+// @Tags products
+// @Produce json
+// @Param branch_id query string false "Branch (place) ID"
+// @Param create_proposals query bool false "Create proposals for the products" default(false)
+// @Success 200 {object} models.LowStockOutput
+// @Failure 500 {object} models.Output
+// @Router /api/products/low-stock [get]
+func (p *ProductsController) GetLowStockProducts(c *fiber.Ctx) error {
+	branch_id := c.Query("branch_id")
+	log.Info().Str("branch_id", branch_id).Msg("Getting low stock products")
+
+	items, err := p.FindLowStock(c.Context(), branch_id)
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+
+	if c.QueryBool("create_proposals") {
+		created, err := p.CreateLowStockProposals(c.Context(), items)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to create proposals for low stock products")
+			return models.ReturnError(c, err)
+		}
+		log.Info().Int("count", created).Msg("Created proposals for low stock products")
+	}
+
+	return c.JSON(models.NewOutput(items))
+}
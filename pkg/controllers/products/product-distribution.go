@@ -2,7 +2,10 @@ package products
 
 import (
 	"context"
+	"errors"
+	"time"
 
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/sales"
 	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/suppliers"
 	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
 	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
@@ -18,7 +21,8 @@ import (
 
 type NewIncomeInput struct {
 	models.IncomeHistory
-	SellingPrice int32 `json:"selling_price"`
+	SellingPrice int32     `json:"selling_price"`
+	Expire       time.Time `json:"expire"` // optional --- if set the income is tracked as a batch
 }
 
 // NewIncome godoc
@@ -77,6 +81,10 @@ func (p *ProductsController) NewIncome(c *fiber.Ctx) error {
 		}))
 	}
 
+	if !middleware.CanAccessBranch(c, input.UploadedTo.ID) {
+		return middleware.ForbiddenBranch(c)
+	}
+
 	log.Debug().Str("product_id", product_id).Interface("input", input).Msg("Processing income for product")
 
 	// start a db transactions
@@ -88,61 +96,17 @@ func (p *ProductsController) NewIncome(c *fiber.Ctx) error {
 	}
 	defer session.EndSession(ctx)
 
-	// get the product
-	product := &models.Product{}
-	res := p.ProductsCollection.FindOne(c.Context(), bson.M{"_id": product_id})
-	if res.Err() != nil {
-		log.Error().Err(res.Err()).Str("product_id", product_id).Msg("Product not found")
-
-		return models.ReturnError(c, res.Err())
-	}
-	err = res.Decode(product)
+	product, err := p.ApplyIncome(ctx, product_id, &input)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to decode product")
+		log.Error().Err(err).Str("product_id", product_id).Msg("Failed to apply income")
 
-		return models.ReturnError(c, err)
-	}
-
-	log.Debug().Str("product_id", product_id).Msg("Updating quantity distribution")
-
-	// update the price distribution
-	if len(product.QuantityDistribution) > 0 {
-		for _, distribution := range product.QuantityDistribution {
-			if distribution.Place.ID == input.UploadedTo.ID {
-				// update the quantity
-				distribution.Quantity += input.Quantity
-				distribution.Price = input.SellingPrice
-				// update the database
-				filter := bson.M{"_id": product_id, "quantity_distribution.place._id": input.UploadedTo.ID}
-				update := bson.M{"$set": bson.M{"quantity_distribution.$.price": input.SellingPrice}, "$inc": bson.M{"quantity_distribution.$.quantity": input.Quantity}}
-				_, err = p.ProductsCollection.UpdateOne(ctx, filter, update)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to update quantity distribution")
-
-					return models.AbortTransactionAndReturnError(ctx, session, c, err)
-				}
-			} else {
-				err = AppendQuantityDistribution(ctx, input, product, session, p)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to create new distribution")
-
-					return models.AbortTransactionAndReturnError(ctx, session, c, err)
-				}
-			}
-		}
-	} else {
-		err = AppendQuantityDistribution(ctx, input, product, session, p)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to create new distribution")
-
-			return models.AbortTransactionAndReturnError(ctx, session, c, err)
-		}
+		return models.AbortTransactionAndReturnError(ctx, session, c, err)
 	}
 
 	log.Debug().Msg("Creating supplier transaction")
 	// create supplier transaction
 	transaction_base := models.TransactionBase{
-		Amount:        uint32(input.Price * input.Quantity),
+		Amount:        int64(input.Price) * int64(input.Quantity),
 		Description:   "Income from " + input.SupplierID,
 		Type:          models.TransactionTypeDebit,
 		PaymentMethod: models.PaymentMethodUndefined,
@@ -184,41 +148,80 @@ func (p *ProductsController) NewIncome(c *fiber.Ctx) error {
 	))
 }
 
-func AppendQuantityDistribution(ctx context.Context, input NewIncomeInput, product *models.Product, session *mongo.Session, p *ProductsController) error {
-	// create new distribution
-	product.QuantityDistribution = append(product.QuantityDistribution, models.ProductDistribution{
-		Place: input.UploadedTo,
-		Price: input.SellingPrice,
-	})
-	filter := bson.M{"_id": product.ID}
-	update := bson.M{"$push": bson.M{"quantity_distribution": models.ProductDistribution{
-		ProductQuantityInfo: models.ProductQuantityInfo{
-			Quantity: input.Quantity,
-		},
-		Place: input.UploadedTo,
-		Price: input.SellingPrice,
-	}}}
-	_, err := p.ProductsCollection.UpdateOne(ctx, filter, update)
+// ApplyIncome puts the received quantity of the product to the place: quantity, selling price and weighted average cost of the place are updated,
+// income is recorded in the income history and items with expire date are tracked as a batch. Supplier payable is not touched.
+// Zero selling price keeps the current selling price of the place
+func (p *ProductsController) ApplyIncome(ctx context.Context, product_id string, input *NewIncomeInput) (*models.Product, error) {
+	product := &models.Product{}
+	err := p.ProductsCollection.FindOne(ctx, bson.M{"_id": product_id}).Decode(product)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errors.New("product " + product_id + " not found")
+	}
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to create new distribution")
-		return err
+		log.Error().Err(err).Str("product_id", product_id).Msg("Failed to find product")
+		return nil, err
+	}
+	// stock is received only at active branches
+	if err := models.CheckPlace(ctx, input.UploadedTo, p.BranchesCollection); err != nil {
+		return nil, err
 	}
-	return nil
-}
 
-// NewTransfer godoc
-// @Security BearerAuth
-// @Summary Transfer product between locations
-// @Description Transfers product quantity from one location to another
-// @Tags products
-// @Accept json
-// @Produce json
-// @Router /api/products/transfer [post]
-func (p *ProductsController) NewTransfer(c *fiber.Ctx) error {
+	log.Debug().Str("product_id", product_id).Msg("Updating quantity distribution")
 
-	// log activity
-	middleware.LogActivityWithCtx(c, middleware.ActivityTypeProductTransfer, fiber.Map{}, p.ActivitiesCollection)
+	// cost is calculated from what is at the place before the income
+	distribution, _ := sales.FindDistribution(product, input.UploadedTo.ID)
+	cost := AverageCost(distribution, input.Quantity, input.Price)
+	if input.SellingPrice <= 0 {
+		input.SellingPrice = distribution.Price
+	}
+	if input.SellingPrice <= 0 {
+		return nil, errors.New("selling price of product " + product_id + " is required")
+	}
+
+	// update the quantity distribution --- a new distribution is created if the product was never at the place
+	if err := AddStock(ctx, p.ProductsCollection, product_id, input.UploadedTo, input.Quantity, input.SellingPrice); err != nil {
+		log.Error().Err(err).Msg("Failed to update quantity distribution")
+		return nil, err
+	}
+	// selling price of the place follows the latest income
+	if input.Date == "" {
+		input.Date = time.Now().Format("2006-01-02")
+	}
+	filter := bson.M{"_id": product_id, "quantity_distribution.place.id": input.UploadedTo.ID}
+	update := bson.M{
+		"$set": bson.M{
+			"quantity_distribution.$.price": input.SellingPrice,
+			"quantity_distribution.$.cost":  cost,
+		},
+		"$push": bson.M{"income_history": input.IncomeHistory},
+	}
+	if _, err := p.ProductsCollection.UpdateOne(ctx, filter, update); err != nil {
+		log.Error().Err(err).Msg("Failed to update selling price and cost")
+		return nil, err
+	}
+
+	// items with expire date are tracked as a batch
+	if !input.Expire.IsZero() {
+		batch := models.NewProductBatch(product_id, input.UploadedTo, input.Quantity, models.ProductItem{
+			Expire: input.Expire,
+			Price:  input.Price,
+		})
+		if _, err := p.BatchesCollection.InsertOne(ctx, batch); err != nil {
+			log.Error().Err(err).Msg("Failed to insert batch")
+			return nil, err
+		}
+	}
 
-	panic("Not implemented")
-	return nil
+	return product, nil
+}
+
+// AverageCost returns the moving weighted average cost of the distribution after quantity is received at unit_cost.
+// Negative quantities (oversold stock) are counted as zero
+func AverageCost(distribution models.ProductDistribution, quantity int32, unit_cost int32) int32 {
+	current := max(distribution.Quantity, 0)
+	if current+quantity <= 0 {
+		return unit_cost
+	}
+	total := int64(current)*int64(distribution.Cost) + int64(quantity)*int64(unit_cost)
+	return int32(total / int64(current+quantity))
 }
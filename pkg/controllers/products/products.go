@@ -3,6 +3,7 @@ package products
 import (
 	"fmt"
 
+	"github.com/aslon1213/g4h_pos_erp/pkg/configs"
 	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
 	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
 	s3provider "github.com/aslon1213/g4h_pos_erp/platform/s3"
@@ -17,21 +18,41 @@ import (
 )
 
 type ProductsController struct {
-	ProductsCollection     *mongo.Collection
-	TransactionsCollection *mongo.Collection
-	FinanceCollection      *mongo.Collection
-	SupplierCollection     *mongo.Collection
-	ActivitiesCollection   *mongo.Collection
-	S3Client               *s3provider.S3Client
+	ProductsCollection       *mongo.Collection
+	TransactionsCollection   *mongo.Collection
+	FinanceCollection        *mongo.Collection
+	BranchesCollection       *mongo.Collection
+	SupplierCollection       *mongo.Collection
+	ActivitiesCollection     *mongo.Collection
+	TransfersCollection      *mongo.Collection
+	BatchesCollection        *mongo.Collection
+	ProposalsCollection      *mongo.Collection
+	StockAlertsCollection    *mongo.Collection
+	StocktakesCollection     *mongo.Collection
+	PurchaseOrdersCollection *mongo.Collection
+	Alerts                   configs.AlertsConfig
+	S3Client                 *s3provider.S3Client
 }
 
 func New(db *mongo.Database) *ProductsController {
+	config, err := configs.LoadConfig(".")
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load config")
+	}
 	return &ProductsController{
-		ProductsCollection:     db.Collection("products"),
-		TransactionsCollection: db.Collection("transactions"),
-		FinanceCollection:      db.Collection("finance"),
-		SupplierCollection:     db.Collection("suppliers"),
-		ActivitiesCollection:   db.Collection("activities"),
+		ProductsCollection:       db.Collection("products"),
+		TransactionsCollection:   db.Collection("transactions"),
+		FinanceCollection:        db.Collection("finance"),
+		BranchesCollection:       db.Collection("branches"),
+		SupplierCollection:       db.Collection("suppliers"),
+		ActivitiesCollection:     db.Collection("activities"),
+		TransfersCollection:      db.Collection("transfers"),
+		BatchesCollection:        db.Collection("batches"),
+		ProposalsCollection:      db.Collection("proposals"),
+		StockAlertsCollection:    db.Collection("stock_alerts"),
+		StocktakesCollection:     db.Collection("stocktakes"),
+		PurchaseOrdersCollection: db.Collection("purchase_orders"),
+		Alerts:                   config.Alerts,
 	}
 }
 
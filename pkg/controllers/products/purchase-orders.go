@@ -0,0 +1,448 @@
+package products
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/suppliers"
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+	"github.com/aslon1213/g4h_pos_erp/platform/database"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func (p *ProductsController) findPurchaseOrder(ctx context.Context, c *fiber.Ctx, purchase_order_id string) (*models.PurchaseOrder, error) {
+	purchase_order := &models.PurchaseOrder{}
+	err := p.PurchaseOrdersCollection.FindOne(ctx, bson.M{"_id": purchase_order_id}).Decode(purchase_order)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Purchase order not found",
+			Code:    fiber.StatusNotFound,
+		}))
+	}
+	if err != nil {
+		log.Error().Err(err).Str("purchase_order_id", purchase_order_id).Msg("Failed to find purchase order")
+		return nil, models.ReturnError(c, err)
+	}
+	return purchase_order, nil
+}
+
+// NewPurchaseOrder godoc
+// @Security BearerAuth
+// @Summary Create a purchase order
+// @Description Groups proposals into a purchase order for a supplier with products, quantities and expected prices.
+// @Description Proposals must be unfulfilled and not ordered with another purchase order
+// @Tags purchase-orders
+// @Accept json
+// @Produce json
+// @Param input body models.NewPurchaseOrderInput true "Purchase order details"
+// @Success 201 {object} models.PurchaseOrderOutput
+// @Failure 400 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/purchase-orders [post]
+func (p *ProductsController) NewPurchaseOrder(c *fiber.Ctx) error {
+	input := models.NewPurchaseOrderInput{}
+	if err := c.BodyParser(&input); err != nil {
+		log.Error().Err(err).Msg("Failed to parse purchase order input")
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if err := input.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if !middleware.CanAccessBranch(c, input.Destination.ID) {
+		return middleware.ForbiddenBranch(c)
+	}
+
+	proposal_ids := []bson.ObjectID{}
+	for _, id := range input.ProposalIDs {
+		object_id, err := bson.ObjectIDFromHex(id)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+				Message: "Invalid proposal ID " + id,
+				Code:    fiber.StatusBadRequest,
+			}))
+		}
+		proposal_ids = append(proposal_ids, object_id)
+	}
+
+	session, ctx, err := database.StartTransaction(p.ProductsCollection.Database().Client())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to start transaction")
+		return models.ReturnError(c, err)
+	}
+	defer session.EndSession(ctx)
+
+	count, err := p.SupplierCollection.CountDocuments(ctx, bson.M{"_id": input.SupplierID})
+	if err != nil {
+		session.AbortTransaction(ctx)
+		log.Error().Err(err).Msg("Failed to count suppliers")
+		return models.ReturnError(c, err)
+	}
+	if count == 0 {
+		session.AbortTransaction(ctx)
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Supplier not found",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	// every product must exist
+	product_ids := []string{}
+	for _, line := range input.Lines {
+		product_ids = append(product_ids, line.ProductID)
+	}
+	count, err = p.ProductsCollection.CountDocuments(ctx, bson.M{"_id": bson.M{"$in": product_ids}})
+	if err != nil {
+		session.AbortTransaction(ctx)
+		log.Error().Err(err).Msg("Failed to count products")
+		return models.ReturnError(c, err)
+	}
+	if int(count) != len(product_ids) {
+		session.AbortTransaction(ctx)
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Some of the products are not found",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	user, _ := c.Locals("user").(string)
+	purchase_order := models.NewPurchaseOrder(&input, proposal_ids, user)
+
+	if len(proposal_ids) > 0 {
+		result, err := p.ProposalsCollection.UpdateMany(ctx, bson.M{
+			"_id":               bson.M{"$in": proposal_ids},
+			"fulfilled":         false,
+			"purchase_order_id": bson.M{"$exists": false},
+		}, bson.M{"$set": bson.M{"purchase_order_id": purchase_order.ID}})
+		if err != nil {
+			session.AbortTransaction(ctx)
+			log.Error().Err(err).Msg("Failed to link proposals")
+			return models.ReturnError(c, err)
+		}
+		if int(result.MatchedCount) != len(proposal_ids) {
+			session.AbortTransaction(ctx)
+			return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+				Message: "Some of the proposals are not found, fulfilled or already ordered",
+				Code:    fiber.StatusBadRequest,
+			}))
+		}
+	}
+
+	if _, err := p.PurchaseOrdersCollection.InsertOne(ctx, purchase_order); err != nil {
+		session.AbortTransaction(ctx)
+		log.Error().Err(err).Msg("Failed to insert purchase order")
+		return models.ReturnError(c, err)
+	}
+
+	if err := session.CommitTransaction(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to commit transaction")
+		return models.ReturnError(c, err)
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeCreatePurchaseOrder, purchase_order, p.ActivitiesCollection)
+
+	log.Info().Str("purchase_order_id", purchase_order.ID).Msg("Purchase order created successfully")
+	return c.Status(fiber.StatusCreated).JSON(models.NewOutput([]*models.PurchaseOrder{purchase_order}))
+}
+
+// ReceivePurchaseOrder godoc
+// @Security BearerAuth
+// @Summary Receive a purchase order
+// @Description Receives (part of) a purchase order: received quantities are added to the destination as product income and the supplier payable
+// @Description grows by the actual value of the received items. When every line is fully received the purchase order and its proposals are closed
+// @Tags purchase-orders
+// @Accept json
+// @Produce json
+// @Param id path string true "Purchase order ID"
+// @Param input body models.ReceivePurchaseOrderInput true "Received quantities"
+// @Success 200 {object} models.Output
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/purchase-orders/{id}/receive [post]
+func (p *ProductsController) ReceivePurchaseOrder(c *fiber.Ctx) error {
+	purchase_order_id := c.Params("id")
+	log.Info().Str("purchase_order_id", purchase_order_id).Msg("Receiving purchase order")
+
+	input := models.ReceivePurchaseOrderInput{}
+	if err := c.BodyParser(&input); err != nil {
+		log.Error().Err(err).Msg("Failed to parse receive purchase order input")
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	session, ctx, err := database.StartTransaction(p.ProductsCollection.Database().Client())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to start transaction")
+		return models.ReturnError(c, err)
+	}
+	defer session.EndSession(ctx)
+
+	purchase_order, err := p.findPurchaseOrder(ctx, c, purchase_order_id)
+	if purchase_order == nil {
+		session.AbortTransaction(ctx)
+		return err
+	}
+	previous_status := purchase_order.Status
+
+	user, _ := c.Locals("user").(string)
+	receipt, err := purchase_order.Receive(&input, user)
+	if err != nil {
+		session.AbortTransaction(ctx)
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	for _, line := range receipt.Lines {
+		income := &NewIncomeInput{
+			IncomeHistory: models.IncomeHistory{
+				Price:      line.Price,
+				Quantity:   line.Quantity,
+				UploadedTo: purchase_order.Destination,
+				SupplierID: purchase_order.SupplierID,
+			},
+			SellingPrice: line.SellingPrice,
+			Expire:       line.Expire,
+		}
+		if _, err := p.ApplyIncome(ctx, line.ProductID, income); err != nil {
+			session.AbortTransaction(ctx)
+			log.Error().Err(err).Str("product_id", line.ProductID).Msg("Failed to apply income")
+			return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+				Message: err.Error(),
+				Code:    fiber.StatusBadRequest,
+			}))
+		}
+	}
+
+	// supplier payable grows by what was actually received
+	var transaction *models.Transaction
+	if receipt.Value > 0 {
+		transaction, err = suppliers.NewSupplierTransaction(
+			ctx,
+			models.TransactionBase{
+				Amount:        receipt.Value,
+				Description:   "Purchase order " + purchase_order.ID + " received from " + purchase_order.SupplierID,
+				Type:          models.TransactionTypeDebit,
+				PaymentMethod: models.PaymentMethodUndefined,
+			},
+			purchase_order.SupplierID,
+			purchase_order.Destination.ID,
+			p.TransactionsCollection,
+			p.FinanceCollection,
+			p.SupplierCollection,
+		)
+		if err != nil {
+			session.AbortTransaction(ctx)
+			log.Error().Err(err).Msg("Failed to create supplier transaction")
+			return models.ReturnError(c, err)
+		}
+		receipt.TransactionID = transaction.ID
+	}
+	purchase_order.Receipts = append(purchase_order.Receipts, *receipt)
+
+	_, err = p.PurchaseOrdersCollection.UpdateOne(ctx, bson.M{"_id": purchase_order.ID, "status": previous_status}, bson.M{
+		"$set": bson.M{
+			"status":         purchase_order.Status,
+			"lines":          purchase_order.Lines,
+			"received_value": purchase_order.ReceivedValue,
+			"closed_at":      purchase_order.ClosedAt,
+		},
+		"$push": bson.M{"receipts": receipt},
+	})
+	if err != nil {
+		session.AbortTransaction(ctx)
+		log.Error().Err(err).Msg("Failed to update purchase order")
+		return models.ReturnError(c, err)
+	}
+
+	if purchase_order.Status == models.PurchaseOrderStatusReceived && len(purchase_order.ProposalIDs) > 0 {
+		_, err := p.ProposalsCollection.UpdateMany(ctx,
+			bson.M{"_id": bson.M{"$in": purchase_order.ProposalIDs}},
+			bson.M{"$set": bson.M{"fulfilled": true}},
+		)
+		if err != nil {
+			session.AbortTransaction(ctx)
+			log.Error().Err(err).Msg("Failed to fulfill proposals")
+			return models.ReturnError(c, err)
+		}
+	}
+
+	if err := session.CommitTransaction(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to commit transaction")
+		return models.ReturnError(c, err)
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeReceivePurchaseOrder, fiber.Map{
+		"purchase_order_id": purchase_order.ID,
+		"receipt":           receipt,
+		"status":            purchase_order.Status,
+	}, p.ActivitiesCollection)
+
+	log.Info().Str("purchase_order_id", purchase_order.ID).Str("status", string(purchase_order.Status)).Int64("value", receipt.Value).Msg("Purchase order received successfully")
+	return c.JSON(models.NewOutput(fiber.Map{
+		"purchase_order": purchase_order,
+		"receipt":        receipt,
+		"transaction":    transaction,
+	}))
+}
+
+// CancelPurchaseOrder godoc
+// @Security BearerAuth
+// @Summary Cancel a purchase order
+// @Description Cancels a purchase order nothing was received for. Its proposals are released so they can be ordered again
+// @Tags purchase-orders
+// @Produce json
+// @Param id path string true "Purchase order ID"
+// @Success 200 {object} models.PurchaseOrderOutput
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/purchase-orders/{id}/cancel [post]
+func (p *ProductsController) CancelPurchaseOrder(c *fiber.Ctx) error {
+	purchase_order_id := c.Params("id")
+	log.Info().Str("purchase_order_id", purchase_order_id).Msg("Cancelling purchase order")
+
+	session, ctx, err := database.StartTransaction(p.ProductsCollection.Database().Client())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to start transaction")
+		return models.ReturnError(c, err)
+	}
+	defer session.EndSession(ctx)
+
+	purchase_order, err := p.findPurchaseOrder(ctx, c, purchase_order_id)
+	if purchase_order == nil {
+		session.AbortTransaction(ctx)
+		return err
+	}
+
+	purchase_order.Status = models.PurchaseOrderStatusCancelled
+	purchase_order.ClosedAt = time.Now()
+	result, err := p.PurchaseOrdersCollection.UpdateOne(ctx, bson.M{"_id": purchase_order.ID, "status": models.PurchaseOrderStatusOpen}, bson.M{
+		"$set": bson.M{
+			"status":    purchase_order.Status,
+			"closed_at": purchase_order.ClosedAt,
+		},
+	})
+	if err != nil {
+		session.AbortTransaction(ctx)
+		log.Error().Err(err).Msg("Failed to update purchase order")
+		return models.ReturnError(c, err)
+	}
+	if result.MatchedCount == 0 {
+		session.AbortTransaction(ctx)
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Only purchase orders nothing was received for can be cancelled",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	if len(purchase_order.ProposalIDs) > 0 {
+		_, err := p.ProposalsCollection.UpdateMany(ctx,
+			bson.M{"_id": bson.M{"$in": purchase_order.ProposalIDs}, "purchase_order_id": purchase_order.ID},
+			bson.M{"$unset": bson.M{"purchase_order_id": ""}},
+		)
+		if err != nil {
+			session.AbortTransaction(ctx)
+			log.Error().Err(err).Msg("Failed to release proposals")
+			return models.ReturnError(c, err)
+		}
+	}
+
+	if err := session.CommitTransaction(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to commit transaction")
+		return models.ReturnError(c, err)
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeCancelPurchaseOrder, purchase_order.ID, p.ActivitiesCollection)
+
+	return c.JSON(models.NewOutput([]*models.PurchaseOrder{purchase_order}))
+}
+
+// GetPurchaseOrderByID godoc
+// @Security BearerAuth
+// @Summary Get a purchase order
+// @Tags purchase-orders
+// @Produce json
+// @Param id path string true "Purchase order ID"
+// @Success 200 {object} models.PurchaseOrderOutput
+// @Failure 404 {object} models.Output
+// @Router /api/purchase-orders/{id} [get]
+func (p *ProductsController) GetPurchaseOrderByID(c *fiber.Ctx) error {
+	purchase_order, err := p.findPurchaseOrder(c.Context(), c, c.Params("id"))
+	if purchase_order == nil {
+		return err
+	}
+	return c.JSON(models.NewOutput([]*models.PurchaseOrder{purchase_order}))
+}
+
+// QueryPurchaseOrders godoc
+// @Security BearerAuth
+// @Summary Query purchase orders
+// @Description Lists purchase orders filtered by status, supplier and destination place, newest first
+// @Tags purchase-orders
+// @Produce json
+// @Param params query models.PurchaseOrderQueryParams false "Query params"
+// @Success 200 {object} models.PurchaseOrderOutput
+// @Failure 400 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/purchase-orders [get]
+func (p *ProductsController) QueryPurchaseOrders(c *fiber.Ctx) error {
+	params := models.PurchaseOrderQueryParams{}
+	if err := c.QueryParser(&params); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if params.Page < 1 {
+		params.Page = 1
+	}
+	if params.Count < 1 {
+		params.Count = 25
+	}
+
+	filter := bson.M{}
+	if params.Status != "" {
+		filter["status"] = params.Status
+	}
+	if params.SupplierID != "" {
+		filter["supplier_id"] = params.SupplierID
+	}
+	if params.PlaceID != "" {
+		filter["destination.id"] = params.PlaceID
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64((params.Page - 1) * params.Count)).
+		SetLimit(int64(params.Count))
+
+	cursor, err := p.PurchaseOrdersCollection.Find(c.Context(), filter, opts)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to find purchase orders")
+		return models.ReturnError(c, err)
+	}
+	purchase_orders := []models.PurchaseOrder{}
+	if err := cursor.All(c.Context(), &purchase_orders); err != nil {
+		log.Error().Err(err).Msg("Failed to decode purchase orders")
+		return models.ReturnError(c, err)
+	}
+
+	return c.JSON(models.NewOutput(purchase_orders))
+}
@@ -0,0 +1,454 @@
+package products
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/ledger"
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/sales"
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+	"github.com/aslon1213/g4h_pos_erp/platform/database"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+func (p *ProductsController) findStocktake(ctx context.Context, c *fiber.Ctx, stocktake_id string) (*models.Stocktake, error) {
+	stocktake := &models.Stocktake{}
+	err := p.StocktakesCollection.FindOne(ctx, bson.M{"_id": stocktake_id}).Decode(stocktake)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Stocktake not found",
+			Code:    fiber.StatusNotFound,
+		}))
+	}
+	if err != nil {
+		log.Error().Err(err).Str("stocktake_id", stocktake_id).Msg("Failed to find stocktake")
+		return nil, models.ReturnError(c, err)
+	}
+	stocktake.Calculate()
+	return stocktake, nil
+}
+
+// NewStocktake godoc
+// @Security BearerAuth
+// @Summary Open a stocktake
+// @Description Opens a physical inventory count session for a place. Recorded quantities and costs of all products at the place are frozen as the snapshot.
+// @Description Only one stocktake can be open per place
+// @Tags products/stocktakes
+// @Accept json
+// @Produce json
+// @Param input body models.NewStocktakeInput true "Stocktake details"
+// @Success 201 {object} models.StocktakeOutput
+// @Failure 400 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/products/stocktakes [post]
+func (p *ProductsController) NewStocktake(c *fiber.Ctx) error {
+	input := models.NewStocktakeInput{}
+	if err := c.BodyParser(&input); err != nil {
+		log.Error().Err(err).Msg("Failed to parse stocktake input")
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if input.Place.ID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "place is required",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	if !middleware.CanAccessBranch(c, input.Place.ID) {
+		return middleware.ForbiddenBranch(c)
+	}
+	log.Info().Str("place_id", input.Place.ID).Msg("Opening stocktake")
+
+	count, err := p.StocktakesCollection.CountDocuments(c.Context(), bson.M{
+		"place.id": input.Place.ID,
+		"status":   models.StocktakeStatusOpen,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to count open stocktakes")
+		return models.ReturnError(c, err)
+	}
+	if count > 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "There is already an open stocktake for the place",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	cursor, err := p.ProductsCollection.Find(c.Context(), bson.M{"quantity_distribution.place.id": input.Place.ID})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to find products of the place")
+		return models.ReturnError(c, err)
+	}
+	products := []models.Product{}
+	if err := cursor.All(c.Context(), &products); err != nil {
+		log.Error().Err(err).Msg("Failed to decode products")
+		return models.ReturnError(c, err)
+	}
+
+	user, _ := c.Locals("user").(string)
+	stocktake := models.NewStocktake(input.Place, input.Note, user)
+	for i := range products {
+		distribution, _ := sales.FindDistribution(&products[i], input.Place.ID)
+		stocktake.AddLine(&products[i], distribution)
+	}
+
+	if _, err := p.StocktakesCollection.InsertOne(c.Context(), stocktake); err != nil {
+		log.Error().Err(err).Msg("Failed to insert stocktake")
+		return models.ReturnError(c, err)
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeCreateStocktake, fiber.Map{
+		"stocktake_id": stocktake.ID,
+		"place":        stocktake.Place,
+		"products":     len(stocktake.Lines),
+	}, p.ActivitiesCollection)
+
+	log.Info().Str("stocktake_id", stocktake.ID).Int("products", len(stocktake.Lines)).Msg("Stocktake opened successfully")
+	return c.Status(fiber.StatusCreated).JSON(models.NewOutput([]*models.Stocktake{stocktake}))
+}
+
+// SubmitStocktakeCounts godoc
+// @Security BearerAuth
+// @Summary Submit counted quantities
+// @Description Submits quantities counted on a device. Counts of different devices are summed, submitting again from the same device replaces its previous counts of the products
+// @Tags products/stocktakes
+// @Accept json
+// @Produce json
+// @Param id path string true "Stocktake ID"
+// @Param input body models.SubmitStocktakeCountsInput true "Counted quantities"
+// @Success 200 {object} models.StocktakeOutput
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/products/stocktakes/{id}/counts [post]
+func (p *ProductsController) SubmitStocktakeCounts(c *fiber.Ctx) error {
+	stocktake_id := c.Params("id")
+
+	input := models.SubmitStocktakeCountsInput{}
+	if err := c.BodyParser(&input); err != nil {
+		log.Error().Err(err).Msg("Failed to parse stocktake counts input")
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	user, _ := c.Locals("user").(string)
+	if input.Device == "" {
+		input.Device = user
+	}
+
+	stocktake, err := p.findStocktake(c.Context(), c, stocktake_id)
+	if stocktake == nil {
+		return err
+	}
+	if stocktake.Status != models.StocktakeStatusOpen {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Counts can only be submitted to open stocktakes",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if err := input.Validate(stocktake); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	log.Info().Str("stocktake_id", stocktake_id).Str("device", input.Device).Int("counts", len(input.Counts)).Msg("Submitting stocktake counts")
+
+	// counts of every device are set separately so devices submitting at the same time do not overwrite each other
+	set := bson.M{}
+	for _, count := range input.Counts {
+		set[fmt.Sprintf("lines.%s.counts.%s", count.ProductID, input.Device)] = count.Quantity
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = p.StocktakesCollection.FindOneAndUpdate(c.Context(),
+		bson.M{"_id": stocktake.ID, "status": models.StocktakeStatusOpen},
+		bson.M{"$set": set},
+		opts,
+	).Decode(stocktake)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Counts can only be submitted to open stocktakes",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to update stocktake counts")
+		return models.ReturnError(c, err)
+	}
+	stocktake.Calculate()
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeStocktakeCounts, fiber.Map{
+		"stocktake_id": stocktake.ID,
+		"device":       input.Device,
+		"counts":       input.Counts,
+	}, p.ActivitiesCollection)
+
+	return c.JSON(models.NewOutput([]*models.Stocktake{stocktake}))
+}
+
+// ApproveStocktake godoc
+// @Security BearerAuth
+// @Summary Approve a stocktake
+// @Description Posts variances of the counted products as stock adjustments at the place and records a shrinkage transaction for the net loss at cost.
+// @Description Variances are applied relative to the current quantity, so sales made after the snapshot are kept. Products which were not counted are not adjusted
+// @Tags products/stocktakes
+// @Produce json
+// @Param id path string true "Stocktake ID"
+// @Success 200 {object} models.Output
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 409 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/products/stocktakes/{id}/approve [post]
+func (p *ProductsController) ApproveStocktake(c *fiber.Ctx) error {
+	stocktake_id := c.Params("id")
+	log.Info().Str("stocktake_id", stocktake_id).Msg("Approving stocktake")
+
+	session, ctx, err := database.StartTransaction(p.ProductsCollection.Database().Client())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to start transaction")
+		return models.ReturnError(c, err)
+	}
+	defer session.EndSession(ctx)
+
+	stocktake, err := p.findStocktake(ctx, c, stocktake_id)
+	if stocktake == nil {
+		session.AbortTransaction(ctx)
+		return err
+	}
+	if stocktake.Status != models.StocktakeStatusOpen {
+		session.AbortTransaction(ctx)
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Only open stocktakes can be approved",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	variances := stocktake.Variances()
+	for _, line := range variances {
+		if err := sales.IncrementStock(ctx, p.ProductsCollection, line.ProductID, stocktake.Place.ID, line.Variance); err != nil {
+			session.AbortTransaction(ctx)
+			return models.ReturnError(c, err)
+		}
+		if line.Variance < 0 {
+			if _, err := sales.DepleteBatches(ctx, p.BatchesCollection, line.ProductID, stocktake.Place.ID, -line.Variance); err != nil {
+				session.AbortTransaction(ctx)
+				if errors.Is(err, sales.ErrBatchesChanged) {
+					return c.Status(fiber.StatusConflict).JSON(models.NewOutput([]interface{}{}, models.Error{
+						Message: err.Error(),
+						Code:    fiber.StatusConflict,
+					}))
+				}
+				return models.ReturnError(c, err)
+			}
+		}
+	}
+
+	// loss at cost --- no money leaves the balance, only expenses grow. Surplus is only an adjustment of stock
+	var transaction *models.Transaction
+	if stocktake.ShrinkageValue > 0 {
+		transaction = models.NewTransaction(&models.TransactionBase{
+			Amount:        stocktake.ShrinkageValue,
+			Description:   fmt.Sprintf("Shrinkage found by stocktake %s", stocktake.ID),
+			Type:          models.TransactionTypeDebit,
+			PaymentMethod: models.PaymentMethodUndefined,
+		}, models.InitiatorTypeShrinkage, stocktake.Place.ID)
+		if err := transaction.InBranchCurrency(ctx, p.FinanceCollection); err != nil {
+			session.AbortTransaction(ctx)
+			return models.ReturnError(c, err)
+		}
+
+		if _, err := p.TransactionsCollection.InsertOne(ctx, transaction); err != nil {
+			session.AbortTransaction(ctx)
+			log.Error().Err(err).Msg("Failed to insert shrinkage transaction")
+			return models.ReturnError(c, err)
+		}
+		// warehouses have no finance, so not matching anything is fine
+		_, err = p.FinanceCollection.UpdateOne(ctx, bson.M{"branch_id": stocktake.Place.ID}, bson.M{
+			"$inc": bson.M{"finance.total_expenses": transaction.Amount},
+		})
+		if err != nil {
+			session.AbortTransaction(ctx)
+			log.Error().Err(err).Msg("Failed to update finance")
+			return models.ReturnError(c, err)
+		}
+		if _, err := ledger.PostTransaction(ctx, ledger.Collection(p.TransactionsCollection), transaction); err != nil {
+			session.AbortTransaction(ctx)
+			return models.ReturnError(c, err)
+		}
+		stocktake.TransactionID = transaction.ID
+	}
+
+	user, _ := c.Locals("user").(string)
+	stocktake.Status = models.StocktakeStatusApproved
+	stocktake.ClosedBy = user
+	stocktake.ClosedAt = time.Now()
+	_, err = p.StocktakesCollection.UpdateOne(ctx, bson.M{"_id": stocktake.ID, "status": models.StocktakeStatusOpen}, bson.M{
+		"$set": bson.M{
+			"status":          stocktake.Status,
+			"lines":           stocktake.Lines,
+			"shrinkage_value": stocktake.ShrinkageValue,
+			"transaction_id":  stocktake.TransactionID,
+			"closed_by":       stocktake.ClosedBy,
+			"closed_at":       stocktake.ClosedAt,
+		},
+	})
+	if err != nil {
+		session.AbortTransaction(ctx)
+		log.Error().Err(err).Msg("Failed to update stocktake")
+		return models.ReturnError(c, err)
+	}
+
+	if err := session.CommitTransaction(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to commit transaction")
+		return models.ReturnError(c, err)
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeApproveStocktake, fiber.Map{
+		"stocktake_id":    stocktake.ID,
+		"variances":       variances,
+		"shrinkage_value": stocktake.ShrinkageValue,
+		"transaction_id":  stocktake.TransactionID,
+	}, p.ActivitiesCollection)
+
+	log.Info().Str("stocktake_id", stocktake.ID).Int("variances", len(variances)).Int64("shrinkage_value", stocktake.ShrinkageValue).Msg("Stocktake approved successfully")
+	return c.JSON(models.NewOutput(fiber.Map{
+		"stocktake":   stocktake,
+		"variances":   variances,
+		"transaction": transaction,
+	}))
+}
+
+// CancelStocktake godoc
+// @Security BearerAuth
+// @Summary Cancel a stocktake
+// @Description Cancels an open stocktake, nothing is posted
+// @Tags products/stocktakes
+// @Produce json
+// @Param id path string true "Stocktake ID"
+// @Success 200 {object} models.StocktakeOutput
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/products/stocktakes/{id}/cancel [post]
+func (p *ProductsController) CancelStocktake(c *fiber.Ctx) error {
+	stocktake_id := c.Params("id")
+	log.Info().Str("stocktake_id", stocktake_id).Msg("Cancelling stocktake")
+
+	stocktake, err := p.findStocktake(c.Context(), c, stocktake_id)
+	if stocktake == nil {
+		return err
+	}
+
+	user, _ := c.Locals("user").(string)
+	stocktake.Status = models.StocktakeStatusCancelled
+	stocktake.ClosedBy = user
+	stocktake.ClosedAt = time.Now()
+	result, err := p.StocktakesCollection.UpdateOne(c.Context(), bson.M{"_id": stocktake.ID, "status": models.StocktakeStatusOpen}, bson.M{
+		"$set": bson.M{
+			"status":    stocktake.Status,
+			"closed_by": stocktake.ClosedBy,
+			"closed_at": stocktake.ClosedAt,
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to update stocktake")
+		return models.ReturnError(c, err)
+	}
+	if result.MatchedCount == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Only open stocktakes can be cancelled",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeCancelStocktake, stocktake.ID, p.ActivitiesCollection)
+
+	return c.JSON(models.NewOutput([]*models.Stocktake{stocktake}))
+}
+
+// GetStocktakeByID godoc
+// @Security BearerAuth
+// @Summary Get a stocktake
+// @Description Returns the stocktake with counted quantities and variances valued at cost
+// @Tags products/stocktakes
+// @Produce json
+// @Param id path string true "Stocktake ID"
+// @Success 200 {object} models.StocktakeOutput
+// @Failure 404 {object} models.Output
+// @Router /api/products/stocktakes/{id} [get]
+func (p *ProductsController) GetStocktakeByID(c *fiber.Ctx) error {
+	stocktake, err := p.findStocktake(c.Context(), c, c.Params("id"))
+	if stocktake == nil {
+		return err
+	}
+	return c.JSON(models.NewOutput([]*models.Stocktake{stocktake}))
+}
+
+// QueryStocktakes godoc
+// @Security BearerAuth
+// @Summary Query stocktakes
+// @Description Lists stocktakes filtered by status and place, newest first
+// @Tags products/stocktakes
+// @Produce json
+// @Param params query models.StocktakeQueryParams false "Query params"
+// @Success 200 {object} models.StocktakeOutput
+// @Failure 400 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/products/stocktakes [get]
+func (p *ProductsController) QueryStocktakes(c *fiber.Ctx) error {
+	params := models.StocktakeQueryParams{}
+	if err := c.QueryParser(&params); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if params.Page < 1 {
+		params.Page = 1
+	}
+	if params.Count < 1 {
+		params.Count = 25
+	}
+
+	filter := bson.M{}
+	if params.Status != "" {
+		filter["status"] = params.Status
+	}
+	if params.PlaceID != "" {
+		filter["place.id"] = params.PlaceID
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64((params.Page - 1) * params.Count)).
+		SetLimit(int64(params.Count))
+
+	cursor, err := p.StocktakesCollection.Find(c.Context(), filter, opts)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to find stocktakes")
+		return models.ReturnError(c, err)
+	}
+	stocktakes := []models.Stocktake{}
+	if err := cursor.All(c.Context(), &stocktakes); err != nil {
+		log.Error().Err(err).Msg("Failed to decode stocktakes")
+		return models.ReturnError(c, err)
+	}
+	for i := range stocktakes {
+		stocktakes[i].Calculate()
+	}
+
+	return c.JSON(models.NewOutput(stocktakes))
+}
@@ -0,0 +1,445 @@
+package products
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/sales"
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+	"github.com/aslon1213/g4h_pos_erp/platform/database"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// AddStock increments the quantity of the product at the place.
+// If the product has never been at the place a new distribution is pushed with the given price
+func AddStock(ctx context.Context, productsCollection *mongo.Collection, product_id string, place models.ProductPlace, quantity int32, price int32) error {
+	result, err := productsCollection.UpdateOne(ctx, bson.M{
+		"_id":                            product_id,
+		"quantity_distribution.place.id": place.ID,
+	}, bson.M{
+		"$inc": bson.M{"quantity_distribution.$.quantity": quantity},
+		"$set": bson.M{"updated_at": time.Now()},
+	})
+	if err != nil {
+		log.Error().Err(err).Str("product_id", product_id).Msg("Failed to increment stock")
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	result, err = productsCollection.UpdateOne(ctx, bson.M{"_id": product_id}, bson.M{
+		"$push": bson.M{"quantity_distribution": models.ProductDistribution{
+			ProductQuantityInfo: models.ProductQuantityInfo{
+				Quantity: quantity,
+			},
+			Place: place,
+			Price: price,
+		}},
+		"$set": bson.M{"updated_at": time.Now()},
+	})
+	if err != nil {
+		log.Error().Err(err).Str("product_id", product_id).Msg("Failed to create new distribution")
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("product " + product_id + " not found")
+	}
+	return nil
+}
+
+func (p *ProductsController) findTransfer(ctx context.Context, c *fiber.Ctx, transfer_id string) (*models.Transfer, error) {
+	transfer := &models.Transfer{}
+	err := p.TransfersCollection.FindOne(ctx, bson.M{"_id": transfer_id}).Decode(transfer)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Transfer not found",
+			Code:    fiber.StatusNotFound,
+		}))
+	}
+	if err != nil {
+		log.Error().Err(err).Str("transfer_id", transfer_id).Msg("Failed to find transfer")
+		return nil, models.ReturnError(c, err)
+	}
+	return transfer, nil
+}
+
+// NewTransfer godoc
+// @Security BearerAuth
+// @Summary Create a product transfer
+// @Description Creates a draft transfer of products from one place (branch or warehouse) to another. Stock is not touched until the transfer is dispatched
+// @Tags products/transfers
+// @Accept json
+// @Produce json
+// @Param input body models.NewTransferInput true "Transfer details"
+// @Success 201 {object} models.TransferOutput
+// @Failure 400 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/products/transfer [post]
+func (p *ProductsController) NewTransfer(c *fiber.Ctx) error {
+	input := models.NewTransferInput{}
+	if err := c.BodyParser(&input); err != nil {
+		log.Error().Err(err).Msg("Failed to parse transfer input")
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if err := input.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	// bound users can only send stock from their branch
+	if !middleware.CanAccessBranch(c, input.Source.ID) {
+		return middleware.ForbiddenBranch(c)
+	}
+	for _, place := range []models.ProductPlace{input.Source, input.Destination} {
+		if err := models.CheckPlace(c.Context(), place, p.BranchesCollection); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+				Message: err.Error(),
+				Code:    fiber.StatusBadRequest,
+			}))
+		}
+	}
+
+	// every product must exist
+	product_ids := []string{}
+	for _, line := range input.Lines {
+		product_ids = append(product_ids, line.ProductID)
+	}
+	count, err := p.ProductsCollection.CountDocuments(c.Context(), bson.M{"_id": bson.M{"$in": product_ids}})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to count products")
+		return models.ReturnError(c, err)
+	}
+	if int(count) != len(product_ids) {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Some of the products are not found",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	user, _ := c.Locals("user").(string)
+	transfer := models.NewTransfer(&input, user)
+
+	if _, err := p.TransfersCollection.InsertOne(c.Context(), transfer); err != nil {
+		log.Error().Err(err).Msg("Failed to insert transfer")
+		return models.ReturnError(c, err)
+	}
+
+	// log activity
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeProductTransfer, transfer, p.ActivitiesCollection)
+
+	log.Info().Str("transfer_id", transfer.ID).Msg("Transfer created successfully")
+	return c.Status(fiber.StatusCreated).JSON(models.NewOutput([]*models.Transfer{transfer}))
+}
+
+// DispatchTransfer godoc
+// @Security BearerAuth
+// @Summary Dispatch a product transfer
+// @Description Takes quantities of the transfer lines from the source place and marks the transfer as in transit. Rejected if the source does not hold enough quantity
+// @Tags products/transfers
+// @Accept json
+// @Produce json
+// @Param id path string true "Transfer ID"
+// @Success 200 {object} models.TransferOutput
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 409 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/products/transfer/{id}/dispatch [post]
+func (p *ProductsController) DispatchTransfer(c *fiber.Ctx) error {
+	transfer_id := c.Params("id")
+	log.Info().Str("transfer_id", transfer_id).Msg("Dispatching transfer")
+
+	session, ctx, err := database.StartTransaction(p.ProductsCollection.Database().Client())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to start transaction")
+		return models.ReturnError(c, err)
+	}
+	defer session.EndSession(ctx)
+
+	transfer, err := p.findTransfer(ctx, c, transfer_id)
+	if transfer == nil {
+		session.AbortTransaction(ctx)
+		return err
+	}
+	if transfer.Status != models.TransferStatusDraft {
+		session.AbortTransaction(ctx)
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Only draft transfers can be dispatched",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	for i, line := range transfer.Lines {
+		if err := sales.DecrementStock(ctx, p.ProductsCollection, line.ProductID, transfer.Source.ID, line.Quantity); err != nil {
+			session.AbortTransaction(ctx)
+			if errors.Is(err, sales.ErrInsufficientStock) {
+				return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+					Message: err.Error(),
+					Code:    fiber.StatusBadRequest,
+				}))
+			}
+			return models.ReturnError(c, err)
+		}
+		// batches travel with the transfer and are recreated at the destination on receipt
+		batches, err := sales.DepleteBatches(ctx, p.BatchesCollection, line.ProductID, transfer.Source.ID, line.Quantity)
+		if err != nil {
+			session.AbortTransaction(ctx)
+			if errors.Is(err, sales.ErrBatchesChanged) {
+				return c.Status(fiber.StatusConflict).JSON(models.NewOutput([]interface{}{}, models.Error{
+					Message: err.Error(),
+					Code:    fiber.StatusConflict,
+				}))
+			}
+			return models.ReturnError(c, err)
+		}
+		transfer.Lines[i].Batches = batches
+	}
+
+	transfer.Status = models.TransferStatusInTransit
+	transfer.DispatchedAt = time.Now()
+	_, err = p.TransfersCollection.UpdateOne(ctx, bson.M{"_id": transfer.ID, "status": models.TransferStatusDraft}, bson.M{
+		"$set": bson.M{
+			"status":        transfer.Status,
+			"dispatched_at": transfer.DispatchedAt,
+			"lines":         transfer.Lines,
+		},
+	})
+	if err != nil {
+		session.AbortTransaction(ctx)
+		log.Error().Err(err).Msg("Failed to update transfer")
+		return models.ReturnError(c, err)
+	}
+
+	if err := session.CommitTransaction(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to commit transaction")
+		return models.ReturnError(c, err)
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeDispatchTransfer, transfer.ID, p.ActivitiesCollection)
+
+	return c.JSON(models.NewOutput([]*models.Transfer{transfer}))
+}
+
+// ReceiveTransfer godoc
+// @Security BearerAuth
+// @Summary Receive a product transfer
+// @Description Adds received quantities to the destination place and marks the transfer as received. Lines which are not listed are considered fully received,
+// @Description differences between sent and received quantities are recorded as discrepancies
+// @Tags products/transfers
+// @Accept json
+// @Produce json
+// @Param id path string true "Transfer ID"
+// @Param input body models.ReceiveTransferInput false "Received quantities"
+// @Success 200 {object} models.TransferOutput
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/products/transfer/{id}/receive [post]
+func (p *ProductsController) ReceiveTransfer(c *fiber.Ctx) error {
+	transfer_id := c.Params("id")
+	log.Info().Str("transfer_id", transfer_id).Msg("Receiving transfer")
+
+	input := models.ReceiveTransferInput{}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&input); err != nil {
+			log.Error().Err(err).Msg("Failed to parse receive transfer input")
+			return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+				Message: err.Error(),
+				Code:    fiber.StatusBadRequest,
+			}))
+		}
+	}
+
+	session, ctx, err := database.StartTransaction(p.ProductsCollection.Database().Client())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to start transaction")
+		return models.ReturnError(c, err)
+	}
+	defer session.EndSession(ctx)
+
+	transfer, err := p.findTransfer(ctx, c, transfer_id)
+	if transfer == nil {
+		session.AbortTransaction(ctx)
+		return err
+	}
+	if transfer.Status != models.TransferStatusInTransit {
+		session.AbortTransaction(ctx)
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Only transfers in transit can be received",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	if err := transfer.Receive(&input); err != nil {
+		session.AbortTransaction(ctx)
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	for _, line := range transfer.Lines {
+		if line.ReceivedQuantity == 0 {
+			continue
+		}
+		// destination gets the selling price of the source if it never had the product
+		product := &models.Product{}
+		if err := p.ProductsCollection.FindOne(ctx, bson.M{"_id": line.ProductID}).Decode(product); err != nil {
+			session.AbortTransaction(ctx)
+			log.Error().Err(err).Str("product_id", line.ProductID).Msg("Failed to find product")
+			return models.ReturnError(c, err)
+		}
+		source, _ := sales.FindDistribution(product, transfer.Source.ID)
+		destination, _ := sales.FindDistribution(product, transfer.Destination.ID)
+		// received items bring the cost of the source with them
+		cost := AverageCost(destination, line.ReceivedQuantity, source.Cost)
+
+		if err := AddStock(ctx, p.ProductsCollection, line.ProductID, transfer.Destination, line.ReceivedQuantity, source.Price); err != nil {
+			session.AbortTransaction(ctx)
+			return models.ReturnError(c, err)
+		}
+		_, err := p.ProductsCollection.UpdateOne(ctx,
+			bson.M{"_id": line.ProductID, "quantity_distribution.place.id": transfer.Destination.ID},
+			bson.M{"$set": bson.M{"quantity_distribution.$.cost": cost}},
+		)
+		if err != nil {
+			session.AbortTransaction(ctx)
+			log.Error().Err(err).Msg("Failed to update cost of destination")
+			return models.ReturnError(c, err)
+		}
+
+		// recreate batches taken on dispatch at the destination, first expired first
+		remaining := line.ReceivedQuantity
+		for _, taken := range line.Batches {
+			if remaining == 0 {
+				break
+			}
+			quantity := min(taken.Quantity, remaining)
+			batch := models.NewProductBatch(line.ProductID, transfer.Destination, quantity, taken.ProductItem)
+			if _, err := p.BatchesCollection.InsertOne(ctx, batch); err != nil {
+				session.AbortTransaction(ctx)
+				log.Error().Err(err).Msg("Failed to insert batch")
+				return models.ReturnError(c, err)
+			}
+			remaining -= quantity
+		}
+	}
+
+	transfer.Status = models.TransferStatusReceived
+	transfer.ReceivedAt = time.Now()
+	_, err = p.TransfersCollection.UpdateOne(ctx, bson.M{"_id": transfer.ID, "status": models.TransferStatusInTransit}, bson.M{
+		"$set": bson.M{
+			"status":        transfer.Status,
+			"received_at":   transfer.ReceivedAt,
+			"lines":         transfer.Lines,
+			"discrepancies": transfer.Discrepancies,
+			"note":          transfer.Note,
+		},
+	})
+	if err != nil {
+		session.AbortTransaction(ctx)
+		log.Error().Err(err).Msg("Failed to update transfer")
+		return models.ReturnError(c, err)
+	}
+
+	if err := session.CommitTransaction(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to commit transaction")
+		return models.ReturnError(c, err)
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeReceiveTransfer, fiber.Map{
+		"transfer_id":   transfer.ID,
+		"discrepancies": transfer.Discrepancies,
+	}, p.ActivitiesCollection)
+
+	if len(transfer.Discrepancies) > 0 {
+		log.Warn().Str("transfer_id", transfer.ID).Interface("discrepancies", transfer.Discrepancies).Msg("Transfer received with discrepancies")
+	}
+
+	return c.JSON(models.NewOutput([]*models.Transfer{transfer}))
+}
+
+// GetTransferByID godoc
+// @Security BearerAuth
+// @Summary Get a product transfer
+// @Tags products/transfers
+// @Produce json
+// @Param id path string true "Transfer ID"
+// @Success 200 {object} models.TransferOutput
+// @Failure 404 {object} models.Output
+// @Router /api/products/transfer/{id} [get]
+func (p *ProductsController) GetTransferByID(c *fiber.Ctx) error {
+	transfer, err := p.findTransfer(c.Context(), c, c.Params("id"))
+	if transfer == nil {
+		return err
+	}
+	return c.JSON(models.NewOutput([]*models.Transfer{transfer}))
+}
+
+// QueryTransfers godoc
+// @Security BearerAuth
+// @Summary Query product transfers
+// @Description Lists transfers filtered by status and place (source or destination), newest first
+// @Tags products/transfers
+// @Produce json
+// @Param params query models.TransferQueryParams false "Query params"
+// @Success 200 {object} models.TransferOutput
+// @Failure 400 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/products/transfer [get]
+func (p *ProductsController) QueryTransfers(c *fiber.Ctx) error {
+	params := models.TransferQueryParams{}
+	if err := c.QueryParser(&params); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if params.Page < 1 {
+		params.Page = 1
+	}
+	if params.Count < 1 {
+		params.Count = 25
+	}
+
+	filter := bson.M{}
+	if params.Status != "" {
+		filter["status"] = params.Status
+	}
+	if params.PlaceID != "" {
+		filter["$or"] = bson.A{
+			bson.M{"source.id": params.PlaceID},
+			bson.M{"destination.id": params.PlaceID},
+		}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64((params.Page - 1) * params.Count)).
+		SetLimit(int64(params.Count))
+
+	cursor, err := p.TransfersCollection.Find(c.Context(), filter, opts)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to find transfers")
+		return models.ReturnError(c, err)
+	}
+	transfers := []models.Transfer{}
+	if err := cursor.All(c.Context(), &transfers); err != nil {
+		log.Error().Err(err).Msg("Failed to decode transfers")
+		return models.ReturnError(c, err)
+	}
+
+	return c.JSON(models.NewOutput(transfers))
+}
@@ -0,0 +1,232 @@
+package sales
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/customers/bnpl"
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+	"github.com/aslon1213/g4h_pos_erp/platform/database"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// PayReceipt creates a transaction for every tender of the receipt and increments the matching balance bucket of the branch.
+// BNPL tenders do not bring money in, a BNPL is opened for the customer of the receipt instead.
+// The receipt is inserted into the receipts collection with all tenders filled. Must be called within a mongo transaction
+func (s *SalesTransactionsController) PayReceipt(ctx context.Context, receipt *models.Receipt, tenders []models.TenderInput) ([]*models.Transaction, error) {
+	if err := models.ValidateTenders(tenders, receipt.Total, receipt.CustomerID); err != nil {
+		return nil, err
+	}
+
+	transactions := []*models.Transaction{}
+	for _, tender := range tenders {
+		line := models.Tender{
+			PaymentMethod: tender.PaymentMethod,
+			Amount:        tender.Amount,
+		}
+
+		if tender.PaymentMethod == models.PaymentMethodBNPL {
+			new_bnpl, err := bnpl.NewBNPL(ctx, &models.NewBNPLInput{
+				CustomerID:  receipt.CustomerID,
+				TotalAmount: tender.Amount,
+				BranchID:    receipt.BranchID,
+				Products:    receipt.Products,
+			}, s.customers)
+			if err != nil {
+				log.Error().Err(err).Str("receipt_id", receipt.ID).Msg("Failed to create BNPL for receipt")
+				return nil, err
+			}
+			line.BNPLID = new_bnpl.ID
+		} else {
+			transaction, err := NewReceiptTransaction(ctx, models.TransactionBase{
+				Amount:        tender.Amount,
+				Description:   receipt.Description,
+				PaymentMethod: tender.PaymentMethod,
+			}, receipt.BranchID, receipt.ID, s.transactions, s.finances)
+			if err != nil {
+				return nil, err
+			}
+			line.TransactionID = transaction.ID
+			transactions = append(transactions, transaction)
+		}
+
+		receipt.Tenders = append(receipt.Tenders, line)
+	}
+
+	if _, err := s.receipts.InsertOne(ctx, receipt); err != nil {
+		log.Error().Err(err).Str("receipt_id", receipt.ID).Msg("Failed to insert receipt")
+		return nil, err
+	}
+
+	log.Info().
+		Str("receipt_id", receipt.ID).
+		Int64("total", receipt.Total).
+		Int("tenders", len(receipt.Tenders)).
+		Msg("Receipt paid successfully")
+
+	return transactions, nil
+}
+
+// CreateReceipt godoc
+// @Security BearerAuth
+// @Summary Create a split-tender receipt
+// @Description Creates a sale paid with several tenders (cash, terminal, mobile apps, bank, bnpl). Total of the receipt is the sum of the tenders. Every tender becomes a transaction under a single receipt ID
+// @Tags sales/receipts
+// @Accept json
+// @Produce json
+// @Param branch_id path string true "Branch ID"
+// @Param input body models.NewReceiptInput true "Receipt details"
+// @Success 201 {object} models.ReceiptOutput
+// @Failure 400 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/sales/receipts/{branch_id} [post]
+func (s *SalesTransactionsController) CreateReceipt(c *fiber.Ctx) error {
+	branch_id := c.Params("branch_id")
+
+	input := models.NewReceiptInput{}
+	if err := c.BodyParser(&input); err != nil {
+		log.Error().Err(err).Msg("Failed to parse receipt input")
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	var total int64
+	for _, tender := range input.Tenders {
+		total += tender.Amount
+	}
+	if err := models.ValidateTenders(input.Tenders, total, input.CustomerID); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	receipt := models.NewReceipt(branch_id, total, input.Description)
+	receipt.CustomerID = input.CustomerID
+	receipt.Cashier, _ = c.Locals("user").(string)
+	receipt.Terminal, _ = c.Locals("terminal").(string)
+	if receipt.Description == "" {
+		receipt.Description = "Receipt " + receipt.ID
+	}
+
+	log.Info().
+		Str("branch_id", branch_id).
+		Interface("input", input).
+		Msg("Creating new receipt")
+
+	ses, ctx, err := database.StartTransaction(s.transactions.Database().Client())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to start transaction")
+		return models.ReturnError(c, err)
+	}
+	defer ses.EndSession(ctx)
+	ctx = middleware.Attributed(c, ctx) // records are attributed to the user and terminal of the request
+
+	transactions, err := s.PayReceipt(ctx, receipt, input.Tenders)
+	if err != nil {
+		ses.AbortTransaction(ctx)
+		if errors.Is(err, bnpl.ErrCustomerNotFound) {
+			return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+				Message: err.Error(),
+				Code:    fiber.StatusBadRequest,
+			}))
+		}
+		return models.ReturnError(c, err)
+	}
+
+	if len(transactions) > 0 {
+		if err := AppendToOpenJournal(ctx, s.journals, branch_id, transactions...); err != nil {
+			ses.AbortTransaction(ctx)
+			log.Error().Err(err).Str("branch_id", branch_id).Msg("Failed to append receipt transactions to journal")
+			return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+				Message: err.Error(),
+				Code:    fiber.StatusBadRequest,
+			}))
+		}
+	}
+
+	if err := ses.CommitTransaction(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to commit transaction")
+		return models.ReturnError(c, err)
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeCreateReceipt, receipt, s.activities)
+
+	return c.Status(fiber.StatusCreated).JSON(models.NewOutput([]*models.Receipt{receipt}))
+}
+
+// GetReceipt godoc
+// @Security BearerAuth
+// @Summary Get a receipt by ID
+// @Description Retrieves a receipt with all of its tenders
+// @Tags sales/receipts
+// @Accept json
+// @Produce json
+// @Param receipt_id path string true "Receipt ID"
+// @Success 200 {object} models.ReceiptOutput
+// @Failure 404 {object} models.Output
+// @Router /api/sales/receipts/{receipt_id} [get]
+func (s *SalesTransactionsController) GetReceipt(c *fiber.Ctx) error {
+	receipt_id := c.Params("receipt_id")
+	log.Info().Str("receipt_id", receipt_id).Msg("Getting receipt")
+
+	receipt := models.Receipt{}
+	if err := s.receipts.FindOne(c.Context(), bson.M{"_id": receipt_id}).Decode(&receipt); err != nil {
+		log.Error().Err(err).Str("receipt_id", receipt_id).Msg("Failed to find receipt")
+		return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Receipt not found",
+			Code:    fiber.StatusNotFound,
+		}))
+	}
+
+	return c.JSON(models.NewOutput([]models.Receipt{receipt}))
+}
+
+// GetReceiptsOfBranch godoc
+// @Security BearerAuth
+// @Summary Get receipts of a branch
+// @Description Retrieves receipts of a branch, newest first
+// @Tags sales/receipts
+// @Accept json
+// @Produce json
+// @Param branch_id path string true "Branch ID"
+// @Param page query int false "Page number" default(1)
+// @Param count query int false "Number of receipts per page" default(25)
+// @Success 200 {object} models.ReceiptOutput
+// @Failure 500 {object} models.Output
+// @Router /api/sales/receipts/branch/{branch_id} [get]
+func (s *SalesTransactionsController) GetReceiptsOfBranch(c *fiber.Ctx) error {
+	branch_id := c.Params("branch_id")
+	page := c.QueryInt("page", 1)
+	count := c.QueryInt("count", 25)
+	if page < 1 {
+		page = 1
+	}
+	log.Info().Str("branch_id", branch_id).Int("page", page).Int("count", count).Msg("Getting receipts of branch")
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64((page - 1) * count)).
+		SetLimit(int64(count))
+
+	cursor, err := s.receipts.Find(c.Context(), bson.M{"branch_id": branch_id}, opts)
+	if err != nil {
+		log.Error().Err(err).Str("branch_id", branch_id).Msg("Failed to find receipts")
+		return models.ReturnError(c, err)
+	}
+
+	receipts := []models.Receipt{}
+	if err := cursor.All(c.Context(), &receipts); err != nil {
+		log.Error().Err(err).Msg("Failed to decode receipts")
+		return models.ReturnError(c, err)
+	}
+
+	return c.JSON(models.NewOutput(receipts))
+}
@@ -0,0 +1,176 @@
+package sales
+
+import (
+	"errors"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+	"github.com/aslon1213/g4h_pos_erp/platform/database"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// RefundReceipt godoc
+// @Security BearerAuth
+// @Summary Refund (return) products of a receipt
+// @Description Returns products (or an amount for receipts without products) of a receipt. Returned products are restocked at the branch of the receipt
+// @Description and put back into its batches, latest expiring first,
+// @Description a debit transaction with the selected payment method is created and appended to the open journal of the branch
+// @Tags sales/receipts
+// @Accept json
+// @Produce json
+// @Param receipt_id path string true "Receipt ID"
+// @Param input body models.NewRefundInput true "Refund details"
+// @Success 201 {object} models.RefundOutput
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 409 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/sales/receipts/{receipt_id}/refunds [post]
+func (s *SalesTransactionsController) RefundReceipt(c *fiber.Ctx) error {
+	receipt_id := c.Params("receipt_id")
+
+	input := models.NewRefundInput{}
+	if err := c.BodyParser(&input); err != nil {
+		log.Error().Err(err).Msg("Failed to parse refund input")
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	log.Info().Str("receipt_id", receipt_id).Interface("input", input).Msg("Refunding receipt")
+
+	ses, ctx, err := database.StartTransaction(s.transactions.Database().Client())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to start transaction")
+		return models.ReturnError(c, err)
+	}
+	defer ses.EndSession(ctx)
+	ctx = middleware.Attributed(c, ctx) // records are attributed to the user and terminal of the request
+
+	receipt := models.Receipt{}
+	err = s.receipts.FindOne(ctx, bson.M{"_id": receipt_id}).Decode(&receipt)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		ses.AbortTransaction(ctx)
+		return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Receipt not found",
+			Code:    fiber.StatusNotFound,
+		}))
+	}
+	if err != nil {
+		ses.AbortTransaction(ctx)
+		log.Error().Err(err).Str("receipt_id", receipt_id).Msg("Failed to find receipt")
+		return models.ReturnError(c, err)
+	}
+
+	refund, err := receipt.NewRefund(&input)
+	if err != nil {
+		ses.AbortTransaction(ctx)
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	refund.Cashier, _ = c.Locals("user").(string)
+	refund.Terminal, _ = c.Locals("terminal").(string)
+
+	// restock returned products at the selling place
+	update := bson.M{"refunded": refund.Total}
+	for product_id, line := range refund.Lines {
+		if err := IncrementStock(ctx, s.products, product_id, receipt.BranchID, int32(line.Quantity)); err != nil {
+			ses.AbortTransaction(ctx)
+			return models.ReturnError(c, err)
+		}
+		// the batches depleted on checkout take the returned quantity back
+		if _, err := RestoreBatches(ctx, s.batches, product_id, receipt.BranchID, int32(line.Quantity)); err != nil {
+			ses.AbortTransaction(ctx)
+			if errors.Is(err, ErrBatchesChanged) {
+				return c.Status(fiber.StatusConflict).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusConflict)))
+			}
+			return models.ReturnError(c, err)
+		}
+		update["returned."+product_id] = line.Quantity
+	}
+
+	description := "Refund of receipt " + receipt.ID
+	if refund.Reason != "" {
+		description += ": " + refund.Reason
+	}
+	transaction, err := NewRefundTransaction(ctx, refund, description, s.transactions, s.finances)
+	if err != nil {
+		ses.AbortTransaction(ctx)
+		return models.ReturnError(c, err)
+	}
+	refund.TransactionID = transaction.ID
+
+	if err := AppendRefundToOpenJournal(ctx, s.journals, receipt.BranchID, transaction); err != nil {
+		ses.AbortTransaction(ctx)
+		log.Error().Err(err).Str("branch_id", receipt.BranchID).Msg("Failed to append refund to journal")
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	if _, err := s.refunds.InsertOne(ctx, refund); err != nil {
+		ses.AbortTransaction(ctx)
+		log.Error().Err(err).Msg("Failed to insert refund")
+		return models.ReturnError(c, err)
+	}
+
+	if _, err := s.receipts.UpdateByID(ctx, receipt.ID, bson.M{"$inc": update}); err != nil {
+		ses.AbortTransaction(ctx)
+		log.Error().Err(err).Msg("Failed to update receipt")
+		return models.ReturnError(c, err)
+	}
+
+	if err := ses.CommitTransaction(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to commit transaction")
+		return models.ReturnError(c, err)
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeCreateRefund, refund, s.activities)
+
+	log.Info().
+		Str("refund_id", refund.ID).
+		Str("receipt_id", receipt.ID).
+		Int64("total", refund.Total).
+		Msg("Receipt refunded successfully")
+
+	return c.Status(fiber.StatusCreated).JSON(models.NewOutput([]*models.Refund{refund}))
+}
+
+// GetRefundsOfReceipt godoc
+// @Security BearerAuth
+// @Summary Get refunds of a receipt
+// @Description Retrieves all refunds made against a receipt
+// @Tags sales/receipts
+// @Accept json
+// @Produce json
+// @Param receipt_id path string true "Receipt ID"
+// @Success 200 {object} models.RefundOutput
+// @Failure 500 {object} models.Output
+// @Router /api/sales/receipts/{receipt_id}/refunds [get]
+func (s *SalesTransactionsController) GetRefundsOfReceipt(c *fiber.Ctx) error {
+	receipt_id := c.Params("receipt_id")
+	log.Info().Str("receipt_id", receipt_id).Msg("Getting refunds of receipt")
+
+	cursor, err := s.refunds.Find(c.Context(), bson.M{"receipt_id": receipt_id}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		log.Error().Err(err).Str("receipt_id", receipt_id).Msg("Failed to find refunds")
+		return models.ReturnError(c, err)
+	}
+
+	refunds := []models.Refund{}
+	if err := cursor.All(c.Context(), &refunds); err != nil {
+		log.Error().Err(err).Msg("Failed to decode refunds")
+		return models.ReturnError(c, err)
+	}
+
+	return c.JSON(models.NewOutput(refunds))
+}
@@ -1,265 +1,506 @@
 package sales
 
-// import (
-// 	"errors"
-
-// 	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
-// 	"github.com/aslon1213/g4h_pos_erp/platform/database"
-
-// 	"github.com/gofiber/fiber/v2"
-// 	"github.com/rs/zerolog/log"
-// 	"go.mongodb.org/mongo-driver/v2/bson"
-// )
-
-// // OpenSalesSession godoc
-// // @Security BearerAuth
-// // @Summary Open a new sales session
-// // @Description Creates a new sales session for a branch
-// // @Tags sales/session
-// // @Accept json
-// // @Produce json
-// // @Param branch_id path string true "Branch ID"
-// // @Success 200 {object} models.Output
-// // @Router /api/sales/session/branch/{branch_id} [post]
-// func (s *SalesTransactionsController) OpenSalesSession(c *fiber.Ctx) error {
-// 	branch_id := c.Params("branch_id")
-// 	log.Info().Str("branch_id", branch_id).Msg("Opening new sales session")
-
-// 	// check for branch_id existing
-// 	count, err := s.finances.CountDocuments(c.Context(), bson.M{"_id": branch_id})
-// 	if err != nil {
-// 		log.Error().Err(err).Str("branch_id", branch_id).Msg("Failed to find branch")
-// 		return models.ReturnError(c, err)
-// 	}
-
-// 	if count == 0 {
-// 		log.Error().Str("branch_id", branch_id).Msg("Branch not found")
-// 		return models.ReturnError(c, errors.New("branch not found"))
-// 	}
-
-// 	session, err := models.NewSalesSession(branch_id, s.cache)
-// 	if err != nil {
-// 		log.Error().Err(err).Msg("Failed to create new sales session")
-// 		return models.ReturnError(c, err)
-// 	}
-// 	return c.JSON(models.NewOutput([]*models.SalesSession{session}))
-// }
-
-// type AddProductItemToSessionInput struct {
-// 	ID       string `json:"id"`
-// 	Quantity int    `json:"quantity"`
-// }
-
-// // AddProductItemToSession godoc
-// // @Security BearerAuth
-// // @Summary Add product to sales session
-// // @Description Adds a product item to an existing sales session
-// // @Tags sales/session
-// // @Accept json
-// // @Produce json
-// // @Param session_id path string true "Session ID"
-// // @Param product body AddProductItemToSessionInput true "Product details"
-// // @Success 200 {object} models.Output
-// // @Failure 400 {object} models.Output
-// // @Failure 500 {object} models.Output
-// // @Router /api/sales/session/{session_id}/product [post]
-// func (s *SalesTransactionsController) AddProductItemToSession(c *fiber.Ctx) error {
-// 	session_id := c.Params("session_id")
-
-// 	product_item := AddProductItemToSessionInput{}
-// 	if err := c.BodyParser(&product_item); err != nil {
-// 		log.Error().Err(err).Msg("Failed to parse product item")
-// 		return models.ReturnError(c, err)
-// 	}
-
-// 	log.Info().Str("session_id", session_id).Interface("product_item", product_item).Msg("Adding product to session")
-// 	session, err := models.GetSalesSession(session_id, s.cache)
-// 	if err != nil {
-// 		log.Error().Err(err).Str("session_id", session_id).Msg("Failed to get sales session")
-// 		return models.ReturnError(c, err)
-// 	}
-
-// 	product := models.Product{}
-// 	err = s.products.FindOne(c.Context(), bson.M{"_id": product_item.ID}).Decode(&product)
-// 	if err != nil {
-// 		log.Error().Err(err).Str("product_id", product_item.ID).Msg("Failed to find product")
-// 		return models.ReturnError(c, err)
-// 	}
-// 	log.Info().Interface("product_item", product_item).Msg("Product item")
-
-// 	for _, distribution := range product.QuantityDistribution {
-// 		if distribution.Place.PlaceType == models.ProductPlaceTypeBranch {
-// 			log.Info().Str("branch_id", distribution.Place.ID).Msg("Adding product to session")
-
-// 			// TODO: here may be the logic for synchronizing the product quantity of this product ---
-// 			// handle situations when the product quantity is not enough, product is not available, product is not in the branch, etc.
-
-// 			err = session.AddProductItem(product_item.ID, product_item.Quantity, distribution.Price, s.cache)
-// 			if err != nil {
-// 				log.Error().Err(err).Str("product_id", product_item.ID).Msg("Failed to add product item to session")
-// 				return models.ReturnError(c, err)
-// 			}
-// 			log.Info().
-// 				Str("product_id", product_item.ID).
-// 				Int("quantity", product_item.Quantity).
-// 				Int32("price", distribution.Price).
-// 				Msg("Added product item to session")
-// 		}
-// 	}
-
-// 	return c.JSON(models.NewOutput([]*models.SalesSession{session}))
-// }
-
-// // CloseSalesSession godoc
-// // @Security BearerAuth
-// // @Summary Close a sales session
-// // @Description Closes an existing sales session and processes the transaction
-// // @Tags sales/session
-// // @Accept json
-// // @Produce json
-// // @Param session_id path string true "Session ID"
-// // @Success 200 {object} models.Output
-// // @Failure 500 {object} models.Output
-// // @Router /api/sales/session/{session_id}/close [post]
-// func (s *SalesTransactionsController) CloseSalesSession(c *fiber.Ctx) error {
-// 	session_id := c.Params("session_id")
-// 	log.Info().Str("session_id", session_id).Msg("Closing sales session")
-// 	session, err := models.GetSalesSession(session_id, s.cache)
-// 	if err != nil {
-// 		log.Error().Err(err).Str("session_id", session_id).Msg("Failed to get sales session")
-// 		return models.ReturnError(c, err)
-// 	}
-
-// 	ses, ctx, err := database.StartTransaction(s.transactions.Database().Client())
-// 	if err != nil {
-// 		log.Error().Err(err).Msg("Failed to start transaction")
-// 		return models.ReturnError(c, err)
-// 	}
-// 	defer ses.EndSession(ctx)
-
-// 	total_price := 0
-// 	for id, item := range session.Products {
-// 		total_price += int(item.Price) * item.Quantity
-// 		log.Debug().
-// 			Str("product_id", id).
-// 			Int("quantity", item.Quantity).
-// 			Int32("price", item.Price).
-// 			Int("subtotal", int(item.Price)*item.Quantity).
-// 			Msg("Calculating product price")
-// 	}
-
-// 	session.DeleteSession(s.cache)
-// 	panic("Not implemented")
-
-// 	if err := ses.CommitTransaction(ctx); err != nil {
-// 		log.Error().Err(err).Msg("Failed to commit transaction")
-// 		return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
-// 			Message: err.Error(),
-// 			Code:    fiber.StatusInternalServerError,
-// 		}))
-// 	}
-
-// 	log.Info().
-// 		Str("session_id", session_id).
-// 		Int("total_price", total_price).
-// 		Msg("Sales session closed successfully")
-
-// 	return c.JSON(models.NewOutput(fiber.Map{
-// 		"total_price": total_price,
-// 		"session":     session,
-// 	}))
-// }
-
-// // GetSalesSession godoc
-// // @Security BearerAuth
-// // @Summary Get a sales session by ID
-// // @Description Retrieves details of a specific sales session
-// // @Tags sales/session
-// // @Accept json
-// // @Produce json
-// // @Param session_id path string true "Session ID"
-// // @Success 200 {object} models.Output
-// // @Failure 400 {object} models.Output
-// // @Failure 404 {object} models.Output
-// // @Failure 500 {object} models.Output
-// // @Router /api/sales/session/{session_id} [get]
-// func (s *SalesTransactionsController) GetSalesSession(c *fiber.Ctx) error {
-// 	session_id := c.Params("session_id")
-// 	log.Info().Str("session_id", session_id).Msg("Getting sales session")
-
-// 	session, err := models.GetSalesSession(session_id, s.cache)
-// 	if err != nil {
-// 		log.Error().Err(err).Str("session_id", session_id).Msg("Failed to get sales session")
-// 		return models.ReturnError(c, err)
-// 	}
-
-// 	log.Debug().
-// 		Str("session_id", session_id).
-// 		Interface("session", session).
-// 		Msg("Successfully retrieved sales session")
-
-// 	return c.JSON(models.NewOutput([]*models.SalesSession{session}))
-// }
-
-// // GetSalesOfSession godoc
-// // @Security BearerAuth
-// // @Summary Get all sales sessions for a branch
-// // @Description Retrieves all sales sessions associated with a branch
-// // @Tags sales/session
-// // @Accept json
-// // @Produce json
-// // @Param branch_id path string true "Branch ID"
-// // @Success 200 {object} models.Output
-// // @Failure 400 {object} models.Output
-// // @Failure 404 {object} models.Output
-// // @Failure 500 {object} models.Output
-// // @Router /api/sales/session/branch/{branch_id} [get]
-// func (s *SalesTransactionsController) GetSalesSessionsOfBranch(c *fiber.Ctx) error {
-// 	branch_id := c.Params("branch_id")
-// 	log.Info().Str("branch_id", branch_id).Msg("Getting sales sessions for branch")
-
-// 	sessions, err := models.GetSalesSessionByBranchID(branch_id, s.cache)
-// 	if err != nil {
-// 		log.Error().Err(err).Str("branch_id", branch_id).Msg("Failed to get sales sessions")
-// 		return models.ReturnError(c, err)
-// 	}
-
-// 	log.Debug().
-// 		Str("branch_id", branch_id).
-// 		Int("session_count", len(sessions)).
-// 		Msg("Successfully retrieved sales sessions")
-
-// 	return c.JSON(models.NewOutput(sessions))
-// }
-
-// // DeleteSalesSession godoc
-// // @Security BearerAuth
-// // @Summary Delete a sales session
-// // @Description Deletes a sales session by ID
-// // @Tags sales/session
-// // @Accept json
-// // @Produce json
-// // @Param session_id path string true "Session ID"
-// // @Success 200 {object} models.Output
-// // @Failure 400 {object} models.Output
-// // @Failure 404 {object} models.Output
-// // @Failure 500 {object} models.Output
-// // @Router /api/sales/session/{session_id} [delete]
-// func (s *SalesTransactionsController) DeleteSalesSession(c *fiber.Ctx) error {
-// 	session_id := c.Params("session_id")
-// 	log.Info().Str("session_id", session_id).Msg("Deleting sales session")
-
-// 	session, err := models.GetSalesSession(session_id, s.cache)
-// 	if err != nil {
-// 		log.Error().Err(err).Str("session_id", session_id).Msg("Failed to get sales session")
-// 		return models.ReturnError(c, err)
-// 	}
-
-// 	err = session.DeleteSession(s.cache)
-// 	if err != nil {
-// 		log.Error().Err(err).Str("session_id", session_id).Msg("Failed to delete sales session")
-// 		return models.ReturnError(c, err)
-// 	}
-
-// 	return c.JSON(models.NewOutput([]*models.SalesSession{session}))
-// }
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/customers/bnpl"
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+	"github.com/aslon1213/g4h_pos_erp/platform/database"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// OpenSalesSession godoc
+// @Security BearerAuth
+// @Summary Open a new sales session
+// @Description Creates a new sales session (cart) for a branch
+// @Tags sales/session
+// @Accept json
+// @Produce json
+// @Param branch_id path string true "Branch ID"
+// @Success 200 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/sales/session/branch/{branch_id} [post]
+func (s *SalesTransactionsController) OpenSalesSession(c *fiber.Ctx) error {
+	branch_id := c.Params("branch_id")
+	log.Info().Str("branch_id", branch_id).Msg("Opening new sales session")
+
+	// check for branch_id existing
+	count, err := s.finances.CountDocuments(c.Context(), bson.M{"branch_id": branch_id})
+	if err != nil {
+		log.Error().Err(err).Str("branch_id", branch_id).Msg("Failed to find branch")
+		return models.ReturnError(c, err)
+	}
+
+	if count == 0 {
+		log.Error().Str("branch_id", branch_id).Msg("Branch not found")
+		return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Branch not found",
+			Code:    fiber.StatusNotFound,
+		}))
+	}
+
+	session, err := models.NewSalesSession(branch_id, s.cache)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create new sales session")
+		return models.ReturnError(c, err)
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeOpenSalesSession, fiber.Map{
+		"session_id": session.ID,
+		"branch_id":  branch_id,
+	}, s.activities)
+
+	return c.JSON(models.NewOutput([]*models.SalesSession{session}))
+}
+
+type AddProductItemToSessionInput struct {
+	ID       string `json:"id"`
+	Quantity int    `json:"quantity"`
+}
+
+// AddProductItemToSession godoc
+// @Security BearerAuth
+// @Summary Add product to sales session
+// @Description Adds a product item to an existing sales session. Rejected if the branch does not have enough quantity of the product
+// @Tags sales/session
+// @Accept json
+// @Produce json
+// @Param session_id path string true "Session ID"
+// @Param product body AddProductItemToSessionInput true "Product details"
+// @Success 200 {object} models.Output
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/sales/session/{session_id}/product [post]
+func (s *SalesTransactionsController) AddProductItemToSession(c *fiber.Ctx) error {
+	session_id := c.Params("session_id")
+
+	product_item := AddProductItemToSessionInput{}
+	if err := c.BodyParser(&product_item); err != nil {
+		log.Error().Err(err).Msg("Failed to parse product item")
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	if product_item.Quantity <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Quantity must be greater than 0",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	log.Info().Str("session_id", session_id).Interface("product_item", product_item).Msg("Adding product to session")
+	session, err := models.GetSalesSession(session_id, s.cache)
+	if err != nil {
+		log.Error().Err(err).Str("session_id", session_id).Msg("Failed to get sales session")
+		return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Sales session not found",
+			Code:    fiber.StatusNotFound,
+		}))
+	}
+
+	product := models.Product{}
+	err = s.products.FindOne(c.Context(), bson.M{"_id": product_item.ID}).Decode(&product)
+	if err != nil {
+		log.Error().Err(err).Str("product_id", product_item.ID).Msg("Failed to find product")
+		return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Product not found",
+			Code:    fiber.StatusNotFound,
+		}))
+	}
+
+	distribution, ok := FindDistribution(&product, session.BranchID)
+	if !ok {
+		log.Warn().Str("product_id", product.ID).Str("branch_id", session.BranchID).Msg("Product is not available in the branch")
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Product is not available in the branch",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	// quantity already in the cart is counted as well
+	in_cart := session.Products[product.ID].Quantity
+	if int(distribution.Quantity) < in_cart+product_item.Quantity {
+		log.Warn().
+			Str("product_id", product.ID).
+			Int32("available", distribution.Quantity).
+			Int("requested", in_cart+product_item.Quantity).
+			Msg("Insufficient quantity of product")
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: fmt.Sprintf("Insufficient quantity of product %s: available %d, requested %d", product.Name, distribution.Quantity, in_cart+product_item.Quantity),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	err = session.AddProductItem(product.ID, product_item.Quantity, distribution.Price, s.cache)
+	if err != nil {
+		log.Error().Err(err).Str("product_id", product_item.ID).Msg("Failed to add product item to session")
+		return models.ReturnError(c, err)
+	}
+	log.Info().
+		Str("product_id", product_item.ID).
+		Int("quantity", product_item.Quantity).
+		Int32("price", distribution.Price).
+		Msg("Added product item to session")
+
+	return c.JSON(models.NewOutput([]*models.SalesSession{session}))
+}
+
+// RemoveProductItemFromSession godoc
+// @Security BearerAuth
+// @Summary Remove product from sales session
+// @Description Removes a product line from an existing sales session
+// @Tags sales/session
+// @Accept json
+// @Produce json
+// @Param session_id path string true "Session ID"
+// @Param product_id path string true "Product ID"
+// @Success 200 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/sales/session/{session_id}/product/{product_id} [delete]
+func (s *SalesTransactionsController) RemoveProductItemFromSession(c *fiber.Ctx) error {
+	session_id := c.Params("session_id")
+	product_id := c.Params("product_id")
+	log.Info().Str("session_id", session_id).Str("product_id", product_id).Msg("Removing product from session")
+
+	session, err := models.GetSalesSession(session_id, s.cache)
+	if err != nil {
+		log.Error().Err(err).Str("session_id", session_id).Msg("Failed to get sales session")
+		return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Sales session not found",
+			Code:    fiber.StatusNotFound,
+		}))
+	}
+
+	if _, ok := session.Products[product_id]; !ok {
+		return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Product not found in session",
+			Code:    fiber.StatusNotFound,
+		}))
+	}
+
+	if err := session.RemoveProductItem(product_id, s.cache); err != nil {
+		log.Error().Err(err).Str("product_id", product_id).Msg("Failed to remove product item from session")
+		return models.ReturnError(c, err)
+	}
+
+	return c.JSON(models.NewOutput([]*models.SalesSession{session}))
+}
+
+// CloseSalesSession godoc
+// @Security BearerAuth
+// @Summary Close a sales session
+// @Description Closes (checks out) a sales session: decrements branch stock, creates a receipt with a transaction per tender and appends them to the open journal of the branch.
+// @Description Either payment_method (whole total) or tenders (split payment summing to the total) must be given
+// @Tags sales/session
+// @Accept json
+// @Produce json
+// @Param session_id path string true "Session ID"
+// @Param input body models.CloseSalesSessionInput true "Payment details"
+// @Success 200 {object} models.Output
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 409 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/sales/session/{session_id}/close [post]
+func (s *SalesTransactionsController) CloseSalesSession(c *fiber.Ctx) error {
+	session_id := c.Params("session_id")
+	log.Info().Str("session_id", session_id).Msg("Closing sales session")
+
+	input := models.CloseSalesSessionInput{}
+	if err := c.BodyParser(&input); err != nil {
+		log.Error().Err(err).Msg("Failed to parse close sales session input")
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+	if len(input.Tenders) == 0 {
+		if err := models.ValidatePaymentMethod(input.PaymentMethod); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+				Message: err.Error(),
+				Code:    fiber.StatusBadRequest,
+			}))
+		}
+	}
+
+	// only one request checks the session out --- the session is claimed before it is read
+	claimed, err := models.ClaimSalesSessionClose(session_id, s.cache)
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+	if !claimed {
+		return c.Status(fiber.StatusConflict).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Sales session is being closed",
+			Code:    fiber.StatusConflict,
+		}))
+	}
+	checked_out := false
+	defer func() {
+		// the claim is kept after a checkout until it expires, the session is deleted by then
+		if !checked_out {
+			if err := models.ReleaseSalesSessionClose(session_id, s.cache); err != nil {
+				log.Warn().Err(err).Str("session_id", session_id).Msg("Failed to release session close")
+			}
+		}
+	}()
+
+	session, err := models.GetSalesSession(session_id, s.cache)
+	if err != nil {
+		log.Error().Err(err).Str("session_id", session_id).Msg("Failed to get sales session")
+		return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Sales session not found",
+			Code:    fiber.StatusNotFound,
+		}))
+	}
+	if len(session.Products) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Sales session is empty",
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	total_price := session.TotalPrice()
+	// single payment method is a receipt with one tender of the whole total
+	tenders := input.Tenders
+	if len(tenders) == 0 {
+		tenders = []models.TenderInput{{PaymentMethod: input.PaymentMethod, Amount: total_price}}
+	}
+	if err := models.ValidateTenders(tenders, total_price, input.CustomerID); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
+	ses, ctx, err := database.StartTransaction(s.transactions.Database().Client())
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to start transaction")
+		return models.ReturnError(c, err)
+	}
+	defer ses.EndSession(ctx)
+	ctx = middleware.Attributed(c, ctx) // records are attributed to the user and terminal of the request
+
+	// decrement stock (and batches, first expired first out) of every line -- whole checkout is rejected if any line is short
+	for product_id, item := range session.Products {
+		if err := DecrementStock(ctx, s.products, product_id, session.BranchID, int32(item.Quantity)); err != nil {
+			ses.AbortTransaction(ctx)
+			if errors.Is(err, ErrInsufficientStock) {
+				return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+					Message: err.Error(),
+					Code:    fiber.StatusBadRequest,
+				}))
+			}
+			return models.ReturnError(c, err)
+		}
+		if _, err := DepleteBatches(ctx, s.batches, product_id, session.BranchID, int32(item.Quantity)); err != nil {
+			ses.AbortTransaction(ctx)
+			if errors.Is(err, ErrBatchesChanged) {
+				return c.Status(fiber.StatusConflict).JSON(models.NewOutput([]interface{}{}, models.Error{
+					Message: err.Error(),
+					Code:    fiber.StatusConflict,
+				}))
+			}
+			return models.ReturnError(c, err)
+		}
+
+		// snapshot the cost of the line for margin reports
+		product := models.Product{}
+		if err := s.products.FindOne(ctx, bson.M{"_id": product_id}).Decode(&product); err != nil {
+			ses.AbortTransaction(ctx)
+			log.Error().Err(err).Str("product_id", product_id).Msg("Failed to find product")
+			return models.ReturnError(c, err)
+		}
+		distribution, _ := FindDistribution(&product, session.BranchID)
+		item.Cost = distribution.Cost
+		session.Products[product_id] = item
+	}
+
+	description := input.Description
+	if description == "" {
+		description = "Sales session " + session.ID
+	}
+
+	receipt := models.NewReceipt(session.BranchID, total_price, description)
+	receipt.SessionID = session.ID
+	receipt.CustomerID = input.CustomerID
+	receipt.Products = session.Products
+	receipt.Cashier, _ = c.Locals("user").(string)
+	receipt.Terminal, _ = c.Locals("terminal").(string)
+
+	transactions, err := s.PayReceipt(ctx, receipt, tenders)
+	if err != nil {
+		ses.AbortTransaction(ctx)
+		if errors.Is(err, bnpl.ErrCustomerNotFound) {
+			return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+				Message: err.Error(),
+				Code:    fiber.StatusBadRequest,
+			}))
+		}
+		return models.ReturnError(c, err)
+	}
+
+	if len(transactions) > 0 {
+		if err := AppendToOpenJournal(ctx, s.journals, session.BranchID, transactions...); err != nil {
+			ses.AbortTransaction(ctx)
+			log.Error().Err(err).Str("branch_id", session.BranchID).Msg("Failed to append transaction to journal")
+			return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+				Message: err.Error(),
+				Code:    fiber.StatusBadRequest,
+			}))
+		}
+	}
+
+	if err := ses.CommitTransaction(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to commit transaction")
+		return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusInternalServerError,
+		}))
+	}
+
+	checked_out = true
+	if err := session.DeleteSession(s.cache); err != nil {
+		log.Warn().Err(err).Str("session_id", session_id).Msg("Failed to delete closed session from cache")
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeCloseSalesSession, fiber.Map{
+		"session_id":  session.ID,
+		"branch_id":   session.BranchID,
+		"receipt_id":  receipt.ID,
+		"total_price": total_price,
+	}, s.activities)
+
+	log.Info().
+		Str("session_id", session_id).
+		Int64("total_price", total_price).
+		Msg("Sales session closed successfully")
+
+	return c.JSON(models.NewOutput(fiber.Map{
+		"total_price":  total_price,
+		"session":      session,
+		"receipt":      receipt,
+		"transactions": transactions,
+	}))
+}
+
+// GetSalesSession godoc
+// @Security BearerAuth
+// @Summary Get a sales session by ID
+// @Description Retrieves details of a specific sales session
+// @Tags sales/session
+// @Accept json
+// @Produce json
+// @Param session_id path string true "Session ID"
+// @Success 200 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Router /api/sales/session/{session_id} [get]
+func (s *SalesTransactionsController) GetSalesSession(c *fiber.Ctx) error {
+	session_id := c.Params("session_id")
+	log.Info().Str("session_id", session_id).Msg("Getting sales session")
+
+	session, err := models.GetSalesSession(session_id, s.cache)
+	if err != nil {
+		log.Error().Err(err).Str("session_id", session_id).Msg("Failed to get sales session")
+		return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Sales session not found",
+			Code:    fiber.StatusNotFound,
+		}))
+	}
+
+	log.Debug().
+		Str("session_id", session_id).
+		Interface("session", session).
+		Msg("Successfully retrieved sales session")
+
+	return c.JSON(models.NewOutput([]*models.SalesSession{session}))
+}
+
+// GetSalesSessionsOfBranch godoc
+// @Security BearerAuth
+// @Summary Get all sales sessions for a branch
+// @Description Retrieves all open sales sessions associated with a branch
+// @Tags sales/session
+// @Accept json
+// @Produce json
+// @Param branch_id path string true "Branch ID"
+// @Success 200 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/sales/session/branch/{branch_id} [get]
+func (s *SalesTransactionsController) GetSalesSessionsOfBranch(c *fiber.Ctx) error {
+	branch_id := c.Params("branch_id")
+	log.Info().Str("branch_id", branch_id).Msg("Getting sales sessions for branch")
+
+	sessions, err := models.GetSalesSessionByBranchID(branch_id, s.cache)
+	if err != nil {
+		log.Error().Err(err).Str("branch_id", branch_id).Msg("Failed to get sales sessions")
+		return models.ReturnError(c, err)
+	}
+
+	log.Debug().
+		Str("branch_id", branch_id).
+		Int("session_count", len(sessions)).
+		Msg("Successfully retrieved sales sessions")
+
+	return c.JSON(models.NewOutput(sessions))
+}
+
+// DeleteSalesSession godoc
+// @Security BearerAuth
+// @Summary Void a sales session
+// @Description Voids (discards) a sales session by ID. Stock is not touched as it is only decremented on close
+// @Tags sales/session
+// @Accept json
+// @Produce json
+// @Param session_id path string true "Session ID"
+// @Success 200 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/sales/session/{session_id} [delete]
+func (s *SalesTransactionsController) DeleteSalesSession(c *fiber.Ctx) error {
+	session_id := c.Params("session_id")
+	log.Info().Str("session_id", session_id).Msg("Voiding sales session")
+
+	session, err := models.GetSalesSession(session_id, s.cache)
+	if err != nil {
+		log.Error().Err(err).Str("session_id", session_id).Msg("Failed to get sales session")
+		return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: "Sales session not found",
+			Code:    fiber.StatusNotFound,
+		}))
+	}
+
+	err = session.DeleteSession(s.cache)
+	if err != nil {
+		log.Error().Err(err).Str("session_id", session_id).Msg("Failed to delete sales session")
+		return models.ReturnError(c, err)
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeVoidSalesSession, fiber.Map{
+		"session_id": session.ID,
+		"branch_id":  session.BranchID,
+		"products":   session.Products,
+	}, s.activities)
+
+	return c.JSON(models.NewOutput([]*models.SalesSession{session}))
+}
+
+// SessionBranch resolves the branch of the sales session of the request for branch scoping of session routes.
+// Missing sessions are reported as not found so that the handlers respond with 404
+func (s *SalesTransactionsController) SessionBranch(c *fiber.Ctx) (string, error) {
+	session, err := models.GetSalesSession(c.Params("session_id"), s.cache)
+	if err != nil {
+		return "", mongo.ErrNoDocuments
+	}
+	return session.BranchID, nil
+}
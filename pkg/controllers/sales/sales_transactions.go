@@ -4,30 +4,46 @@ import (
 	"context"
 	"errors"
 
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/ledger"
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/transactions"
 	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
 	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+	"github.com/aslon1213/g4h_pos_erp/platform/cache"
 	"github.com/aslon1213/g4h_pos_erp/platform/database"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 type SalesTransactionsController struct {
 	transactions *mongo.Collection
 	finances     *mongo.Collection
 	products     *mongo.Collection
+	batches      *mongo.Collection
+	journals     *mongo.Collection
 	activities   *mongo.Collection
+	receipts     *mongo.Collection
+	refunds      *mongo.Collection
+	customers    *mongo.Collection
+	cache        *cache.Cache
 }
 
-func New(db *mongo.Database) *SalesTransactionsController {
+func New(db *mongo.Database, cache *cache.Cache) *SalesTransactionsController {
 	log.Info().Msg("Initializing SalesTransactionsController")
 	return &SalesTransactionsController{
 		transactions: db.Collection("transactions"),
 		finances:     db.Collection("finance"),
 		products:     db.Collection("products"),
+		batches:      db.Collection("batches"),
+		journals:     db.Collection("journals"),
 		activities:   db.Collection("activities"),
+		receipts:     db.Collection("receipts"),
+		refunds:      db.Collection("refunds"),
+		customers:    db.Collection("customers"),
+		cache:        cache,
 	}
 }
 
@@ -54,6 +70,12 @@ func (s *SalesTransactionsController) CreateSalesTransaction(c *fiber.Ctx) error
 			Code:    fiber.StatusBadRequest,
 		}))
 	}
+	if err := transaction_base.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
 
 	log.Info().
 		Str("branch_id", branch_id).
@@ -69,6 +91,7 @@ func (s *SalesTransactionsController) CreateSalesTransaction(c *fiber.Ctx) error
 		}))
 	}
 	defer ses.EndSession(ctx)
+	ctx = middleware.Attributed(c, ctx) // records are attributed to the user and terminal of the request
 
 	// log activity
 
@@ -95,13 +118,18 @@ func (s *SalesTransactionsController) CreateSalesTransaction(c *fiber.Ctx) error
 	log.Info().
 		Str("transaction_id", transaction.ID).
 		Str("branch_id", branch_id).
-		Uint32("amount", transaction.Amount).
+		Int64("amount", transaction.Amount).
 		Msg("Sales transaction created successfully")
 
 	return c.Status(fiber.StatusCreated).JSON(models.NewOutput(transaction))
 }
 
 func NewTransaction(ctx context.Context, transaction_base models.TransactionBase, branch_id string, transactionsCollection *mongo.Collection, financesCollection *mongo.Collection) (*models.Transaction, error) {
+	return NewReceiptTransaction(ctx, transaction_base, branch_id, "", transactionsCollection, financesCollection)
+}
+
+// NewReceiptTransaction creates a sales transaction which is a tender of the receipt with the given id
+func NewReceiptTransaction(ctx context.Context, transaction_base models.TransactionBase, branch_id string, receipt_id string, transactionsCollection *mongo.Collection, financesCollection *mongo.Collection) (*models.Transaction, error) {
 	transaction_base.Type = models.TransactionTypeCredit
 	log.Info().
 		Str("Collection", transactionsCollection.Name()).
@@ -113,12 +141,20 @@ func NewTransaction(ctx context.Context, transaction_base models.TransactionBase
 		log.Error().Err(err).Msg("Invalid payment method")
 		return nil, err
 	}
+	if err := transaction_base.Validate(); err != nil {
+		return nil, err
+	}
 
 	transaction := models.NewTransaction(
 		&transaction_base,
 		models.InitiatorTypeSales,
 		branch_id,
 	)
+	transaction.ReceiptID = receipt_id
+	transaction.Attribute(ctx)
+	if err := transaction.InBranchCurrency(ctx, financesCollection); err != nil {
+		return nil, err
+	}
 
 	_, err := transactionsCollection.InsertOne(ctx, transaction)
 	if err != nil {
@@ -130,67 +166,139 @@ func NewTransaction(ctx context.Context, transaction_base models.TransactionBase
 		log.Error().Err(err).Msg("Failed to increment balance")
 		return nil, err
 	}
+	if _, err := ledger.PostTransaction(ctx, ledger.Collection(transactionsCollection), transaction); err != nil {
+		return nil, err
+	}
 
 	log.Info().
 		Str("transaction_id", transaction.ID).
-		Uint32("amount", transaction.Amount).
+		Int64("amount", transaction.Amount).
 		Str("payment_method", string(transaction.PaymentMethod)).
 		Msg("Transaction created successfully")
 
 	return transaction, nil
 }
 
+// NewRefundTransaction creates a debit transaction which gives money of the refund back and decrements the balance of the branch
+func NewRefundTransaction(ctx context.Context, refund *models.Refund, description string, transactionsCollection *mongo.Collection, financesCollection *mongo.Collection) (*models.Transaction, error) {
+	if refund.Total <= 0 {
+		return nil, errors.New("refund total must be greater than 0")
+	}
+	transaction := models.NewTransaction(
+		&models.TransactionBase{
+			Amount:        refund.Total,
+			Description:   description,
+			Type:          models.TransactionTypeDebit,
+			PaymentMethod: refund.PaymentMethod,
+		},
+		models.InitiatorTypeSales,
+		refund.BranchID,
+	)
+	transaction.ReceiptID = refund.ReceiptID
+	transaction.Attribute(ctx)
+	if err := transaction.InBranchCurrency(ctx, financesCollection); err != nil {
+		return nil, err
+	}
+
+	_, err := transactionsCollection.InsertOne(ctx, transaction)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to insert refund transaction")
+		return nil, err
+	}
+
+	if err := DecrementBalance(ctx, financesCollection, refund.BranchID, *transaction); err != nil {
+		log.Error().Err(err).Msg("Failed to decrement balance")
+		return nil, err
+	}
+	if _, err := ledger.PostTransaction(ctx, ledger.Collection(transactionsCollection), transaction); err != nil {
+		return nil, err
+	}
+
+	log.Info().
+		Str("transaction_id", transaction.ID).
+		Str("refund_id", refund.ID).
+		Int64("amount", transaction.Amount).
+		Msg("Refund transaction created successfully")
+
+	return transaction, nil
+}
+
 // DeleteSalesTransaction godoc
 // @Security BearerAuth
-// @Summary Delete a sales transaction
-// @Description Delete a sales transaction by ID
+// @Summary Void a sales transaction
+// @Description Voids the sales transaction through the same path as DELETE /api/transactions/{id}: its effect is taken back from the finance
+// @Description of the branch and the total of its journal, its ledger entry is reversed and it is kept with the voided flag and its last version
+// @Description Only sales transactions are voided here
 // @Tags sales/transactions
 // @Accept json
 // @Produce json
 // @Param transaction_id path string true "Transaction ID"
-// @Success 200 {object} models.Output
+// @Param input body models.TransactionVoidInput true "Reason"
+// @Success 200 {object} models.TransactionRevisionOutput
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 409 {object} models.Output
 // @Failure 500 {object} models.Output
 // @Router /api/sales/transactions/{transaction_id} [delete]
 func (s *SalesTransactionsController) DeleteSalesTransaction(c *fiber.Ctx) error {
 	transaction_id := c.Params("transaction_id")
-	log.Info().Str("transaction_id", transaction_id).Msg("Deleting sales transaction")
+	log.Info().Str("transaction_id", transaction_id).Msg("Voiding sales transaction")
+
+	input := models.TransactionVoidInput{}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+	}
+	if err := input.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+	}
 
 	ses, ctx, err := database.StartTransaction(s.transactions.Database().Client())
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to start transaction")
-		return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
-			Message: err.Error(),
-			Code:    fiber.StatusInternalServerError,
-		}))
+		return models.ReturnError(c, err)
 	}
 	defer ses.EndSession(ctx)
+	ctx = middleware.Attributed(c, ctx)
 
-	// log activity
-	middleware.LogActivityWithCtx(c, middleware.ActivityTypeDeleteTransaction, transaction_id, s.activities)
+	// other transactions are voided through DELETE /api/transactions/{id} with the transactions:edit permission
+	transaction := models.Transaction{}
+	err = s.transactions.FindOne(ctx, bson.M{"_id": transaction_id}, options.FindOne().SetProjection(bson.M{"type": 1})).Decode(&transaction)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		ses.AbortTransaction(ctx)
+		return c.Status(fiber.StatusNotFound).JSON(models.NewOutput([]interface{}{}, models.NewError(transactions.ErrTransactionNotFound.Error(), fiber.StatusNotFound)))
+	}
+	if err != nil {
+		ses.AbortTransaction(ctx)
+		return models.ReturnError(c, err)
+	}
+	if transaction.Type != models.InitiatorTypeSales {
+		ses.AbortTransaction(ctx)
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError("only sales transactions are voided here", fiber.StatusBadRequest)))
+	}
 
-	transaction, err := DeleteSalesTransaction(ctx, transaction_id, s.transactions, s.finances)
+	revision, err := transactions.VoidTransaction(ctx, s.transactions, transaction_id, input.Reason)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
-			Message: err.Error(),
-			Code:    fiber.StatusInternalServerError,
-		}))
+		ses.AbortTransaction(ctx)
+		log.Error().Err(err).Str("transaction_id", transaction_id).Msg("Failed to void sales transaction")
+		status := transactions.RevisionErrorStatus(err)
+		return c.Status(status).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), status)))
 	}
 
 	if err := ses.CommitTransaction(ctx); err != nil {
 		log.Error().Err(err).Msg("Failed to commit transaction")
-		return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
-			Message: err.Error(),
-			Code:    fiber.StatusInternalServerError,
-		}))
+		return models.ReturnError(c, err)
 	}
 
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeVoidTransaction, revision.Previous, s.activities)
 	log.Info().
 		Str("transaction_id", transaction_id).
-		Msg("Transaction deleted successfully")
+		Msg("Sales transaction voided successfully")
 
-	return c.JSON(models.NewOutput(transaction))
+	return c.JSON(models.NewOutput(revision))
 }
 
+// DeleteSalesTransaction removes the transaction and takes its effect back from the finance. It is used by the reopening
+// of a shift to drop the transactions recorded by its close, other transactions are voided to keep their history
 func DeleteSalesTransaction(ctx context.Context, transactionID string, transactionsCollection *mongo.Collection, financesCollection *mongo.Collection) (models.Transaction, error) {
 	transaction := models.Transaction{}
 	err := transactionsCollection.FindOne(ctx, bson.M{"_id": transactionID}).Decode(&transaction)
@@ -209,6 +317,10 @@ func DeleteSalesTransaction(ctx context.Context, transactionID string, transacti
 		log.Error().Err(err).Str("branch_id", transaction.BranchID).Msg("Failed to decrement balance")
 		return models.Transaction{}, err
 	}
+	// the ledger keeps the sale, it is cancelled by a reversing entry
+	if _, err := ledger.ReverseTransaction(ctx, ledger.Collection(transactionsCollection), transaction.ID, "Deleted: "+transaction.Description); err != nil {
+		return models.Transaction{}, err
+	}
 
 	return transaction, nil
 }
@@ -260,15 +372,15 @@ func DecrementBalance(ctx context.Context, finance *mongo.Collection, branch_id
 	}
 	switch transaction.PaymentMethod {
 	case models.PaymentMethodCash:
-		update["$inc"].(bson.M)["finance.balance.cash"] = -int32(transaction.Amount)
+		update["$inc"].(bson.M)["finance.balance.cash"] = -transaction.Amount
 	case models.PaymentMethodBank:
-		update["$inc"].(bson.M)["finance.balance.bank"] = -int32(transaction.Amount)
+		update["$inc"].(bson.M)["finance.balance.bank"] = -transaction.Amount
 	case models.PaymentMethodTerminal:
-		update["$inc"].(bson.M)["finance.balance.terminal"] = -int32(transaction.Amount)
+		update["$inc"].(bson.M)["finance.balance.terminal"] = -transaction.Amount
 	case models.OnlineMobileAppPayment:
-		update["$inc"].(bson.M)["finance.balance.mobile_apps"] = -int32(transaction.Amount)
+		update["$inc"].(bson.M)["finance.balance.mobile_apps"] = -transaction.Amount
 	case models.OnlineTransfer:
-		update["$inc"].(bson.M)["finance.balance.mobile_apps"] = -int32(transaction.Amount)
+		update["$inc"].(bson.M)["finance.balance.mobile_apps"] = -transaction.Amount
 	}
 
 	log.Info().
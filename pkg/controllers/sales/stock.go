@@ -0,0 +1,220 @@
+package sales
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var (
+	ErrInsufficientStock = errors.New("insufficient quantity of product")
+	ErrBatchesChanged    = errors.New("batches of the product were changed by another request, try again")
+)
+
+// FindDistribution returns the quantity distribution of the product at the given place
+func FindDistribution(product *models.Product, place_id string) (models.ProductDistribution, bool) {
+	for _, distribution := range product.QuantityDistribution {
+		if distribution.Place.ID == place_id {
+			return distribution, true
+		}
+	}
+	return models.ProductDistribution{}, false
+}
+
+// DecrementStock atomically decreases the quantity of the product at the given place.
+// The update only matches if the place holds at least the requested quantity, otherwise ErrInsufficientStock is returned
+func DecrementStock(ctx context.Context, productsCollection *mongo.Collection, product_id string, place_id string, quantity int32) error {
+	filter := bson.M{
+		"_id": product_id,
+		"quantity_distribution": bson.M{
+			"$elemMatch": bson.M{
+				"place.id": place_id,
+				"quantity": bson.M{"$gte": quantity},
+			},
+		},
+	}
+	update := bson.M{
+		"$inc": bson.M{"quantity_distribution.$.quantity": -quantity},
+		"$set": bson.M{"updated_at": time.Now()},
+	}
+
+	result, err := productsCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Error().Err(err).Str("product_id", product_id).Msg("Failed to decrement stock")
+		return err
+	}
+	if result.MatchedCount == 0 {
+		log.Warn().Str("product_id", product_id).Str("place_id", place_id).Int32("quantity", quantity).Msg("Insufficient stock")
+		return fmt.Errorf("%w: product %s at %s", ErrInsufficientStock, product_id, place_id)
+	}
+	return nil
+}
+
+// IncrementStock puts the quantity of the product back to the given place --- used when products are returned
+func IncrementStock(ctx context.Context, productsCollection *mongo.Collection, product_id string, place_id string, quantity int32) error {
+	filter := bson.M{
+		"_id":                            product_id,
+		"quantity_distribution.place.id": place_id,
+	}
+	update := bson.M{
+		"$inc": bson.M{"quantity_distribution.$.quantity": quantity},
+		"$set": bson.M{"updated_at": time.Now()},
+	}
+
+	result, err := productsCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Error().Err(err).Str("product_id", product_id).Msg("Failed to increment stock")
+		return err
+	}
+	if result.MatchedCount == 0 {
+		log.Error().Str("product_id", product_id).Str("place_id", place_id).Msg("Product distribution not found")
+		return fmt.Errorf("product %s is not distributed to %s", product_id, place_id)
+	}
+	return nil
+}
+
+// AppendToOpenJournal pushes the transactions to the latest open journal of the branch and increments its total
+func AppendToOpenJournal(ctx context.Context, journalsCollection *mongo.Collection, branch_id string, transactions ...*models.Transaction) error {
+	filter := bson.M{
+		"branch._id":      branch_id,
+		"shift_is_closed": false,
+	}
+	var total int64
+	ids := []string{}
+	for _, transaction := range transactions {
+		total += transaction.Amount
+		ids = append(ids, transaction.ID)
+	}
+	update := bson.M{
+		"$inc":  bson.M{"total": total},
+		"$push": bson.M{"operations": bson.M{"$each": ids}},
+	}
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "date", Value: -1}})
+
+	err := journalsCollection.FindOneAndUpdate(ctx, filter, update, opts).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return errors.New("no open journal found for the branch")
+	}
+	if err != nil {
+		log.Error().Err(err).Str("branch_id", branch_id).Msg("Failed to update journal")
+		return err
+	}
+	return nil
+}
+
+// AppendRefundToOpenJournal pushes the refund transaction to the latest open journal of the branch and decreases its total
+// by the refund. Refunds of sales from earlier shifts can take the total below zero, the total still adds up to the operations
+func AppendRefundToOpenJournal(ctx context.Context, journalsCollection *mongo.Collection, branch_id string, transaction *models.Transaction) error {
+	filter := bson.M{
+		"branch._id":      branch_id,
+		"shift_is_closed": false,
+	}
+	update := bson.M{
+		"$inc":  bson.M{"total": transaction.JournalAmount()},
+		"$push": bson.M{"operations": transaction.ID},
+	}
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "date", Value: -1}})
+
+	err := journalsCollection.FindOneAndUpdate(ctx, filter, update, opts).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return errors.New("no open journal found for the branch")
+	}
+	if err != nil {
+		log.Error().Err(err).Str("branch_id", branch_id).Msg("Failed to update journal")
+		return err
+	}
+	return nil
+}
+
+// DepleteBatches takes the quantity from the batches of the product at the given place, first expired first out.
+// Stock received without expire date has no batches, so batches holding less than the quantity is not an error.
+// Returned batches hold the quantity taken from each of them. Every batch is only decreased if it still holds the quantity
+// taken from it, ErrBatchesChanged is returned if another request took from it meanwhile and the db transaction must be aborted
+func DepleteBatches(ctx context.Context, batchesCollection *mongo.Collection, product_id string, place_id string, quantity int32) ([]models.ProductBatch, error) {
+	filter := bson.M{
+		"product_id": product_id,
+		"place.id":   place_id,
+		"quantity":   bson.M{"$gt": 0},
+	}
+	cursor, err := batchesCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "expire", Value: 1}}))
+	if err != nil {
+		log.Error().Err(err).Str("product_id", product_id).Msg("Failed to find batches")
+		return nil, err
+	}
+	batches := []models.ProductBatch{}
+	if err := cursor.All(ctx, &batches); err != nil {
+		log.Error().Err(err).Msg("Failed to decode batches")
+		return nil, err
+	}
+
+	depleted := []models.ProductBatch{}
+	for _, batch := range batches {
+		if quantity == 0 {
+			break
+		}
+		taken := min(batch.Quantity, quantity)
+		result, err := batchesCollection.UpdateOne(ctx, bson.M{"_id": batch.ID, "quantity": bson.M{"$gte": taken}}, bson.M{"$inc": bson.M{"quantity": -taken}})
+		if err != nil {
+			log.Error().Err(err).Str("batch_id", batch.ID).Msg("Failed to deplete batch")
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			log.Warn().Str("batch_id", batch.ID).Int32("taken", taken).Msg("Batch was depleted by another request")
+			return nil, ErrBatchesChanged
+		}
+		batch.Quantity = taken
+		depleted = append(depleted, batch)
+		quantity -= taken
+	}
+	return depleted, nil
+}
+
+// RestoreBatches puts the quantity of returned products back into the batches of the product at the given place, latest expiring
+// first, each batch up to the quantity it was received with less what was written off. Quantity no batch has room for is stock
+// received without expire date. ErrBatchesChanged is returned if a batch was changed by another request meanwhile
+func RestoreBatches(ctx context.Context, batchesCollection *mongo.Collection, product_id string, place_id string, quantity int32) ([]models.ProductBatch, error) {
+	filter := bson.M{
+		"product_id": product_id,
+		"place.id":   place_id,
+		"$expr":      bson.M{"$lt": bson.A{bson.M{"$add": bson.A{"$quantity", "$written_off"}}, "$initial_quantity"}},
+	}
+	cursor, err := batchesCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "expire", Value: -1}}))
+	if err != nil {
+		log.Error().Err(err).Str("product_id", product_id).Msg("Failed to find batches")
+		return nil, err
+	}
+	batches := []models.ProductBatch{}
+	if err := cursor.All(ctx, &batches); err != nil {
+		log.Error().Err(err).Msg("Failed to decode batches")
+		return nil, err
+	}
+
+	restored := []models.ProductBatch{}
+	for _, batch := range batches {
+		if quantity == 0 {
+			break
+		}
+		put := min(batch.InitialQuantity-batch.WrittenOff-batch.Quantity, quantity)
+		result, err := batchesCollection.UpdateOne(ctx, bson.M{"_id": batch.ID, "quantity": batch.Quantity}, bson.M{"$inc": bson.M{"quantity": put}})
+		if err != nil {
+			log.Error().Err(err).Str("batch_id", batch.ID).Msg("Failed to restore batch")
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			log.Warn().Str("batch_id", batch.ID).Int32("put", put).Msg("Batch was changed by another request")
+			return nil, ErrBatchesChanged
+		}
+		batch.Quantity = put
+		restored = append(restored, batch)
+		quantity -= put
+	}
+	return restored, nil
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/ledger"
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
 	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
 	"github.com/aslon1213/g4h_pos_erp/platform/database"
 
@@ -11,12 +13,14 @@ import (
 	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 // NewTransaction godoc
 // @Security BearerAuth
 // @Summary Create a new transaction for a supplier
-// @Description Create a new transaction for a supplier and update financial records
+// @Description Create a new transaction for a supplier and update financial records. The amount is in the base currency of the branch
+// @Description or, if currency is set, in the currency of the supplier. The other balance changes by the amount converted at the rate of the day
 // @Tags suppliers, transactions
 // @Accept json
 // @Produce json
@@ -57,6 +61,13 @@ func (s *SuppliersController) NewTransaction(c *fiber.Ctx) error {
 		}))
 	}
 
+	if err := transactionBase.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
+
 	// Start a session
 	sess, ctx, err := database.StartTransaction(s.transactionsCollection.Database().Client())
 	if err != nil {
@@ -67,11 +78,18 @@ func (s *SuppliersController) NewTransaction(c *fiber.Ctx) error {
 		}))
 	}
 	defer sess.EndSession(ctx)
+	ctx = middleware.Attributed(c, ctx) // records are attributed to the user and terminal of the request
 
 	transaction, err := NewSupplierTransaction(ctx, transactionBase, supplier_id, branch_id, s.transactionsCollection, s.financeCollection, s.suppliersCollection)
 	if err != nil {
 		sess.AbortTransaction(ctx)
 		log.Error().Err(err).Msg("Failed to create new supplier transaction --- aborting")
+		if errors.Is(err, ErrSupplierCurrency) || errors.Is(err, models.ErrExchangeRateNotFound) {
+			return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+				Message: err.Error(),
+				Code:    fiber.StatusBadRequest,
+			}))
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
 			Message: err.Error(),
 			Code:    fiber.StatusInternalServerError,
@@ -91,11 +109,60 @@ func (s *SuppliersController) NewTransaction(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusCreated).JSON(models.NewOutput(transaction))
 }
 
+// ErrSupplierCurrency is returned for supplier transactions in neither the base currency of the branch nor the currency of the supplier
+var ErrSupplierCurrency = errors.New("currency must be the base currency of the branch or the currency of the supplier")
+
 func NewSupplierTransaction(ctx context.Context, transactionBase models.TransactionBase, supplier_id string, branch_id string, transactionsCollection *mongo.Collection, financeCollection *mongo.Collection, suppliersCollection *mongo.Collection) (*models.Transaction, error) {
+	if err := transactionBase.Validate(); err != nil {
+		return &models.Transaction{}, err
+	}
+	supplier := models.Supplier{}
+	err := suppliersCollection.FindOne(ctx, bson.M{"_id": supplier_id}, options.FindOne().SetProjection(bson.M{"currency": 1})).Decode(&supplier)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		log.Error().Msg("Supplier not found")
+		return &models.Transaction{}, errors.New("supplier not found")
+	}
+	if err != nil {
+		return &models.Transaction{}, err
+	}
+	base, err := models.BranchCurrency(ctx, financeCollection, branch_id)
+	if err != nil {
+		return &models.Transaction{}, err
+	}
+
 	// Create new transaction
 	transaction := models.NewTransaction(&transactionBase, models.InitiatorTypeSupplier, branch_id)
+	transaction.Attribute(ctx)
 	log.Info().Interface("transaction", transaction).Str("TransactionType", string(transaction.TransactionBase.Type)).Msg("Created new transaction")
 
+	// the branch balance changes by the amount in its base currency, the supplier balance by the amount in the currency of the supplier,
+	// both converted at the rate of the day of the transaction
+	supplier_currency := supplier.Currency.OrDefault()
+	if transaction.Currency == "" {
+		transaction.Currency = base
+	}
+	if transaction.Currency != base && transaction.Currency != supplier_currency {
+		return &models.Transaction{}, ErrSupplierCurrency
+	}
+	rates := models.ExchangeRatesCollection(transactionsCollection)
+	if transaction.Currency == base {
+		if err := transaction.InBaseCurrency(base); err != nil {
+			return &models.Transaction{}, err
+		}
+	} else {
+		rate, err := models.FindExchangeRate(ctx, rates, transaction.Currency, base, transaction.CreatedAt)
+		if err != nil {
+			return &models.Transaction{}, err
+		}
+		transaction.Convert(rate)
+	}
+	to_supplier, err := models.FindExchangeRate(ctx, rates, transaction.Currency, supplier_currency, transaction.CreatedAt)
+	if err != nil {
+		return &models.Transaction{}, err
+	}
+	supplier_amount := to_supplier.Convert(transaction.Amount)
+	amount := transaction.BaseAmount
+
 	// Insert transaction
 	res, err := transactionsCollection.InsertOne(ctx, transaction)
 	if err != nil {
@@ -110,21 +177,21 @@ func NewSupplierTransaction(ctx context.Context, transactionBase models.Transact
 			"financial_data.transactions": transaction,
 		},
 		"$inc": bson.M{
-			"financial_data.balance": func() int32 {
+			"financial_data.balance": func() int64 {
 				if transaction.TransactionBase.Type == models.TransactionTypeCredit {
-					return int32(transaction.Amount)
+					return supplier_amount
 				}
-				return -int32(transaction.Amount)
+				return -supplier_amount
 			}(),
-			"financial_data.total_income": func() int32 {
+			"financial_data.total_income": func() int64 {
 				if transaction.TransactionBase.Type == models.TransactionTypeCredit {
-					return int32(transaction.Amount)
+					return supplier_amount
 				}
 				return 0
 			}(),
-			"financial_data.total_expenses": func() int32 {
+			"financial_data.total_expenses": func() int64 {
 				if transaction.TransactionBase.Type == models.TransactionTypeDebit {
-					return int32(transaction.Amount)
+					return supplier_amount
 				}
 				return 0
 			}(),
@@ -150,12 +217,12 @@ func NewSupplierTransaction(ctx context.Context, transactionBase models.Transact
 		"branch_id": branch_id,
 	}
 	update_bson := bson.M{
-		"finance.debt": func() int32 {
+		"finance.debt": func() int64 {
 			switch transaction.TransactionBase.Type {
 			case models.TransactionTypeDebit:
-				return int32(transaction.Amount)
+				return amount
 			case models.TransactionTypeCredit:
-				return -int32(transaction.Amount)
+				return -amount
 			}
 			return 0
 		}(),
@@ -163,23 +230,23 @@ func NewSupplierTransaction(ctx context.Context, transactionBase models.Transact
 
 	switch transaction.TransactionBase.PaymentMethod {
 	case models.PaymentMethodCash:
-		update_bson["finance.balance.cash"] = func() int32 {
+		update_bson["finance.balance.cash"] = func() int64 {
 			if transaction.TransactionBase.Type == models.TransactionTypeCredit {
-				return -int32(transaction.Amount)
+				return -amount
 			}
 			return 0
 		}()
 	case models.PaymentMethodBank, models.OnlineMobileAppPayment:
-		update_bson["finance.balance.bank"] = func() int32 {
+		update_bson["finance.balance.bank"] = func() int64 {
 			if transaction.TransactionBase.Type == models.TransactionTypeCredit {
-				return -int32(transaction.Amount)
+				return -amount
 			}
 			return 0
 		}()
 	case models.OnlineTransfer:
-		update_bson["finance.balance.mobile_apps"] = func() int32 {
+		update_bson["finance.balance.mobile_apps"] = func() int64 {
 			if transaction.TransactionBase.Type == models.TransactionTypeCredit {
-				return -int32(transaction.Amount)
+				return -amount
 			}
 			return 0
 		}()
@@ -196,5 +263,9 @@ func NewSupplierTransaction(ctx context.Context, transactionBase models.Transact
 	}
 	log.Info().Interface("res", res_2).Msg("Branch finance updated successfully")
 
+	if _, err := ledger.PostTransaction(ctx, ledger.Collection(transactionsCollection), transaction); err != nil {
+		return &models.Transaction{}, err
+	}
+
 	return transaction, nil
 }
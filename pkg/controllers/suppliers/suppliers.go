@@ -2,6 +2,7 @@ package suppliers
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
@@ -13,6 +14,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 type SuppliersController struct {
@@ -143,6 +145,82 @@ func (s *SuppliersController) GetSuppliers(c *fiber.Ctx) error {
 	return c.JSON(models.NewOutput(suppliers))
 }
 
+// GetSupplierBalances godoc
+// @Security BearerAuth
+// @Summary Balances of suppliers in the base currency
+// @Description Balances of the suppliers in their currency and converted to the base currency of their branch at the latest rate of the date (today by default)
+// @Tags suppliers
+// @Produce json
+// @Param params query models.SupplierBalancesQueryParams false "Query params"
+// @Success 200 {object} models.SupplierBalancesOutput
+// @Failure 400 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/suppliers/balances [get]
+func (s *SuppliersController) GetSupplierBalances(c *fiber.Ctx) error {
+	params := models.SupplierBalancesQueryParams{}
+	if err := c.QueryParser(&params); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+	}
+	if params.Date.IsZero() {
+		params.Date = time.Now()
+	}
+	filter := bson.M{}
+	if params.Branch != "" {
+		filter["branch"] = params.Branch
+	}
+
+	opts := options.Find().SetProjection(bson.M{"financial_data.transactions": 0}).SetSort(bson.M{"name": 1})
+	cursor, err := s.suppliersCollection.Find(c.Context(), filter, opts)
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+	suppliers := []models.Supplier{}
+	if err := cursor.All(c.Context(), &suppliers); err != nil {
+		return models.ReturnError(c, err)
+	}
+
+	report := models.SupplierBalancesReport{
+		Date:      params.Date,
+		Suppliers: []models.SupplierBalance{},
+		Totals:    map[models.Currency]int64{},
+	}
+	rates := models.ExchangeRatesCollection(s.suppliersCollection)
+	bases := map[string]models.Currency{}
+	for _, supplier := range suppliers {
+		base, ok := bases[supplier.Branch]
+		if !ok {
+			base, err = models.BranchCurrency(c.Context(), s.financeCollection, supplier.Branch)
+			if err != nil {
+				return models.ReturnError(c, err)
+			}
+			bases[supplier.Branch] = base
+		}
+		currency := supplier.Currency.OrDefault()
+		rate, err := models.FindExchangeRate(c.Context(), rates, currency, base, params.Date)
+		if errors.Is(err, models.ErrExchangeRateNotFound) {
+			return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+		}
+		if err != nil {
+			return models.ReturnError(c, err)
+		}
+
+		balance := models.SupplierBalance{
+			SupplierID:   supplier.ID,
+			Name:         supplier.Name,
+			Branch:       supplier.Branch,
+			Currency:     currency,
+			Balance:      supplier.FinancialData.Balance,
+			BaseCurrency: base,
+			BaseBalance:  rate.Convert(supplier.FinancialData.Balance),
+			Rate:         rate.Rate,
+			RateDate:     rate.Date,
+		}
+		report.Suppliers = append(report.Suppliers, balance)
+		report.Totals[base] += balance.BaseBalance
+	}
+	return c.JSON(models.NewOutput(report))
+}
+
 // GetSupplierByID godoc
 // @Security BearerAuth
 // @Summary Get a supplier by ID
@@ -234,6 +312,15 @@ func (s *SuppliersController) CreateSupplier(c *fiber.Ctx) error {
 	}
 
 	supplier.Branch = branch.BranchID // set the branch id to the supplier ensuring that the supplier is associated with the branch
+	if supplier.Currency == "" {
+		supplier.Currency = branch.Currency.OrDefault()
+	}
+	if err := models.ValidateCurrency(supplier.Currency); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusBadRequest,
+		}))
+	}
 
 	_, err = s.suppliersCollection.InsertOne(context.Background(), supplier)
 	if err != nil {
@@ -345,12 +432,33 @@ func (s *SuppliersController) UpdateSupplier(c *fiber.Ctx) error {
 // @Param id path string true "Supplier ID"
 // @Success 200 {object} models.Output
 // @Failure 404 {object} models.Output
+// @Failure 409 {object} models.Output
 // @Failure 500 {object} models.Output
 // @Router /api/suppliers/{id} [delete]
 func (s *SuppliersController) DeleteSupplier(c *fiber.Ctx) error {
 	id := c.Params("id")
 	log.Debug().Str("id", id).Msg("Deleting supplier")
 
+	// suppliers with transactions in a closed period of their branch are kept
+	supplier := models.Supplier{}
+	err := s.suppliersCollection.FindOne(c.Context(), bson.M{"_id": id}, options.FindOne().SetProjection(bson.M{"branch": 1})).Decode(&supplier)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return models.ReturnError(c, err)
+	}
+	lock_date, err := models.LockDateOf(c.Context(), models.PeriodClosesCollection(s.suppliersCollection), supplier.Branch)
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+	if !lock_date.IsZero() {
+		locked, err := s.suppliersCollection.CountDocuments(c.Context(), bson.M{"_id": id, "financial_data.transactions.created_at": bson.M{"$lt": lock_date}})
+		if err != nil {
+			return models.ReturnError(c, err)
+		}
+		if locked > 0 {
+			return middleware.PeriodClosed(c, models.PeriodClosedError(lock_date))
+		}
+	}
+
 	result, err := s.suppliersCollection.DeleteOne(context.Background(), bson.M{"_id": id})
 	if err != nil {
 		log.Error().Err(err).Str("id", id).Msg("Failed to delete supplier")
@@ -0,0 +1,278 @@
+package transactions
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/ledger"
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+	"github.com/aslon1213/g4h_pos_erp/pkg/utils"
+	"github.com/aslon1213/g4h_pos_erp/platform/database"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var ErrTransactionNotFound = errors.New("transaction not found")
+
+// EditTransaction applies the input to the transaction. Must run in a db transaction
+func EditTransaction(ctx context.Context, transactionsCollection *mongo.Collection, transaction_id string, input *models.TransactionEditInput) (*models.TransactionRevision, error) {
+	original, err := findEditable(ctx, transactionsCollection, transaction_id)
+	if err != nil {
+		return nil, err
+	}
+	revised := input.Apply(*original)
+	return revise(ctx, transactionsCollection, original, &revised, models.TransactionRevisionEdit, input.Reason)
+}
+
+// VoidTransaction cancels the transaction, it is kept with the voided flag. Must run in a db transaction
+func VoidTransaction(ctx context.Context, transactionsCollection *mongo.Collection, transaction_id string, reason string) (*models.TransactionRevision, error) {
+	original, err := findEditable(ctx, transactionsCollection, transaction_id)
+	if err != nil {
+		return nil, err
+	}
+	revised := *original
+	revised.Voided = true
+	return revise(ctx, transactionsCollection, original, &revised, models.TransactionRevisionVoid, reason)
+}
+
+func findEditable(ctx context.Context, transactionsCollection *mongo.Collection, transaction_id string) (*models.Transaction, error) {
+	transaction := &models.Transaction{}
+	err := transactionsCollection.FindOne(ctx, bson.M{"_id": transaction_id}).Decode(transaction)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrTransactionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := transaction.Editable(); err != nil {
+		return nil, err
+	}
+	// approved expenses keep their amount, their transaction is changed through the expense
+	count, err := transactionsCollection.Database().Collection("internal_expenses").CountDocuments(ctx, bson.M{"transaction_id": transaction_id})
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, models.ErrTransactionNotEditable
+	}
+	return transaction, nil
+}
+
+// revise replaces the original transaction with the revised one: the finance of the branch and the total of the journal
+// change by the difference of their effects, the ledger entry is reversed and the revised one posted, both dated now,
+// and the original is kept as a version
+func revise(ctx context.Context, transactionsCollection *mongo.Collection, original *models.Transaction, revised *models.Transaction, action models.TransactionRevisionAction, reason string) (*models.TransactionRevision, error) {
+	version := models.NewTransactionVersion(ctx, original, revised, action, reason)
+	if len(version.Changes) == 0 {
+		return nil, models.ErrNothingToChange
+	}
+	revised.Version = original.Version + 1
+	revised.UpdatedAt = time.Now().In(utils.GetTimeZone())
+
+	result, err := transactionsCollection.ReplaceOne(ctx, bson.M{"_id": original.ID, "voided": bson.M{"$ne": true}}, revised)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, models.ErrTransactionVoided
+	}
+
+	if len(version.Effect) > 0 {
+		result, err := transactionsCollection.Database().Collection("finance").UpdateOne(ctx, bson.M{"branch_id": original.BranchID}, bson.M{"$inc": version.Effect.Inc()})
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, errors.New("finance of the branch not found")
+		}
+	}
+
+	journal := models.Journal{}
+	err = transactionsCollection.Database().Collection("journals").FindOne(ctx, bson.M{"operations": original.ID}, options.FindOne().SetProjection(bson.M{"_id": 1})).Decode(&journal)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	if err == nil {
+		version.JournalID = journal.ID.Hex()
+		version.JournalDelta = revised.JournalAmount() - original.JournalAmount()
+		update := bson.M{"$inc": bson.M{"total": version.JournalDelta}}
+		// voided operations leave the journal
+		if revised.Voided {
+			update["$pull"] = bson.M{"operations": original.ID}
+		}
+		if _, err := transactionsCollection.Database().Collection("journals").UpdateByID(ctx, journal.ID, update); err != nil {
+			return nil, err
+		}
+	}
+
+	ledgerCollection := ledger.Collection(transactionsCollection)
+	if _, err := ledger.ReverseTransaction(ctx, ledgerCollection, original.ID, string(action)+": "+reason); err != nil {
+		return nil, err
+	}
+	if !revised.Voided {
+		entry, err := models.NewLedgerEntryOfTransaction(ctx, revised)
+		if err != nil {
+			return nil, err
+		}
+		entry.Date = entry.CreatedAt
+		if err := ledger.Post(ctx, ledgerCollection, entry); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := models.TransactionVersionsCollection(transactionsCollection).InsertOne(ctx, version); err != nil {
+		return nil, err
+	}
+	return &models.TransactionRevision{
+		Transaction: *revised,
+		Previous:    *version,
+	}, nil
+}
+
+// RevisionErrorStatus returns the status of the errors of editing and voiding
+func RevisionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrTransactionNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, models.ErrTransactionNotEditable), errors.Is(err, models.ErrTransactionVoided):
+		return fiber.StatusConflict
+	case errors.Is(err, models.ErrNothingToChange), errors.Is(err, models.ErrReasonRequired):
+		return fiber.StatusBadRequest
+	}
+	return fiber.StatusInternalServerError
+}
+
+// UpdateTransactionByID godoc
+// @Security BearerAuth
+// @Summary Edit a transaction
+// @Description Changes the amount, description, type or payment method of a standalone sale or expense transaction. The finance of the branch
+// @Description and the total of its journal change by the difference to the original, the ledger entry is reversed and reposted and the
+// @Description original is kept as a version with who changed it, when and why. Tenders, transfers, supplier, BNPL, expense and stock loss
+// @Description transactions are changed through their documents
+// @Tags transactions
+// @Accept json
+// @Produce json
+// @Param id path string true "Transaction ID"
+// @Param input body models.TransactionEditInput true "Changes"
+// @Success 200 {object} models.TransactionRevisionOutput
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 409 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/transactions/{id} [put]
+func (t *TransactionsController) UpdateTransactionByID(c *fiber.Ctx) error {
+	t.logger.Info().Msg("UpdateTransactionByID called")
+	transaction_id := c.Params("id")
+	input := models.TransactionEditInput{}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+	}
+	if err := input.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+	}
+
+	revision, err := t.inTransaction(c, func(ctx context.Context) (*models.TransactionRevision, error) {
+		return EditTransaction(ctx, t.collection, transaction_id, &input)
+	})
+	if err != nil {
+		t.logger.Error().Err(err).Str("transaction_id", transaction_id).Msg("Error editing transaction")
+		status := RevisionErrorStatus(err)
+		return c.Status(status).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), status)))
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeEditTransaction, revision.Previous, t.activities)
+	t.logger.Info().Str("transaction_id", transaction_id).Int("version", revision.Transaction.Version).Msg("Successfully edited transaction")
+	return c.JSON(models.NewOutput(revision))
+}
+
+// DeleteTransactionByID godoc
+// @Security BearerAuth
+// @Summary Void a transaction
+// @Description Voids a standalone sale or expense transaction: its effect is taken back from the finance of the branch and the total of its journal,
+// @Description its ledger entry is reversed and it leaves the operations of the journal. The transaction is kept with the voided flag
+// @Description and its last version with who voided it, when and why
+// @Tags transactions
+// @Accept json
+// @Produce json
+// @Param id path string true "Transaction ID"
+// @Param input body models.TransactionVoidInput true "Reason"
+// @Success 200 {object} models.TransactionRevisionOutput
+// @Failure 400 {object} models.Output
+// @Failure 404 {object} models.Output
+// @Failure 409 {object} models.Output
+// @Failure 500 {object} models.Output
+// @Router /api/transactions/{id} [delete]
+func (t *TransactionsController) DeleteTransactionByID(c *fiber.Ctx) error {
+	t.logger.Info().Msg("DeleteTransactionByID called")
+	transaction_id := c.Params("id")
+	input := models.TransactionVoidInput{}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+	}
+	if err := input.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), fiber.StatusBadRequest)))
+	}
+
+	revision, err := t.inTransaction(c, func(ctx context.Context) (*models.TransactionRevision, error) {
+		return VoidTransaction(ctx, t.collection, transaction_id, input.Reason)
+	})
+	if err != nil {
+		t.logger.Error().Err(err).Str("transaction_id", transaction_id).Msg("Error voiding transaction")
+		status := RevisionErrorStatus(err)
+		return c.Status(status).JSON(models.NewOutput([]interface{}{}, models.NewError(err.Error(), status)))
+	}
+
+	middleware.LogActivityWithCtx(c, middleware.ActivityTypeVoidTransaction, revision.Previous, t.activities)
+	t.logger.Info().Str("transaction_id", transaction_id).Msg("Successfully voided transaction")
+	return c.JSON(models.NewOutput(revision))
+}
+
+// GetTransactionVersions godoc
+// @Security BearerAuth
+// @Summary Version history of a transaction
+// @Description Prior versions of the transaction, oldest first, each with the changes, who made them, when and why
+// @Tags transactions
+// @Produce json
+// @Param id path string true "Transaction ID"
+// @Success 200 {object} models.TransactionVersionsOutput
+// @Failure 500 {object} models.Output
+// @Router /api/transactions/{id}/versions [get]
+func (t *TransactionsController) GetTransactionVersions(c *fiber.Ctx) error {
+	transaction_id := c.Params("id")
+	cursor, err := models.TransactionVersionsCollection(t.collection).Find(c.Context(), bson.M{"transaction_id": transaction_id}, options.Find().SetSort(bson.M{"version": 1}))
+	if err != nil {
+		return models.ReturnError(c, err)
+	}
+	versions := []models.TransactionVersion{}
+	if err := cursor.All(c.Context(), &versions); err != nil {
+		return models.ReturnError(c, err)
+	}
+	return c.JSON(models.NewOutput(versions))
+}
+
+// inTransaction runs the revision in a db transaction attributed to the user of the request
+func (t *TransactionsController) inTransaction(c *fiber.Ctx, fn func(ctx context.Context) (*models.TransactionRevision, error)) (*models.TransactionRevision, error) {
+	ses, ctx, err := database.StartTransaction(t.collection.Database().Client())
+	if err != nil {
+		return nil, err
+	}
+	defer ses.EndSession(ctx)
+	ctx = middleware.Attributed(c, ctx)
+
+	revision, err := fn(ctx)
+	if err != nil {
+		ses.AbortTransaction(ctx)
+		return nil, err
+	}
+	if err := ses.CommitTransaction(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to commit transaction revision")
+		return nil, err
+	}
+	return revision, nil
+}
@@ -3,6 +3,7 @@ package transactions
 import (
 	"context"
 
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/ledger"
 	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
 
 	"github.com/gofiber/fiber/v2"
@@ -15,6 +16,7 @@ import (
 
 type TransactionsController struct {
 	collection *mongo.Collection
+	activities *mongo.Collection
 	logger     *zerolog.Logger
 	// cache      cache
 }
@@ -22,6 +24,7 @@ type TransactionsController struct {
 func New(db *mongo.Database) *TransactionsController {
 	return &TransactionsController{
 		collection: db.Collection("transactions"),
+		activities: db.Collection("activities"),
 		logger:     &log.Logger,
 	}
 }
@@ -166,91 +169,6 @@ func (t *TransactionsController) GetTransactionByID(c *fiber.Ctx) error {
 	return c.JSON(models.NewOutput(transaction))
 }
 
-// UpdateTransactionByID godoc
-// @Security BearerAuth
-// @Summary Update a transaction by ID
-// @Description Update transaction details by its ID
-// @Tags transactions
-// @Accept json
-// @Produce json
-// @Param id path string true "Transaction ID"
-// @Param amount query string false "Transaction amount"
-// @Param description query string false "Transaction description"
-// @Param type query string false "Type of transaction"
-// @Success 200 {object} map[string]string "message" : "transaction was succesfully updated"
-// @Failure 500 {object} models.Error
-// @Router /api/transactions/{id} [put]
-func (t *TransactionsController) UpdateTransactionByID(c *fiber.Ctx) error {
-	t.logger.Info().Msg("UpdateTransactionByID called")
-	idx := c.Params("id")
-	amount := c.Query("amount", "")
-	description := c.Query("description", "")
-	typeOfTransaction := c.Query("type", "")
-
-	query := bson.M{
-		"_id": idx,
-	}
-	set := bson.M{
-		"$set": bson.M{},
-	}
-	if amount != "" {
-		set["$set"].(bson.M)["amount"] = amount
-	}
-	if description != "" {
-		set["$set"].(bson.M)["description"] = description
-	}
-	if typeOfTransaction != "" {
-		set["$set"].(bson.M)["type"] = typeOfTransaction
-	}
-
-	_, err := t.collection.UpdateOne(context.Background(), query, set)
-	if err != nil {
-		t.logger.Error().Err(err).Str("transaction_id", idx).Msg("Error updating transaction")
-		return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
-			Message: err.Error(),
-			Code:    fiber.StatusInternalServerError,
-		}))
-	}
-	t.logger.Info().Str("transaction_id", idx).Msg("Successfully updated transaction")
-	return c.JSON(models.NewOutput(fiber.Map{
-		"message": "transaction was succesfully updated",
-	}))
-}
-
-// DeleteTransaction godoc
-// @Security BearerAuth
-// @Summary Delete a transaction by ID
-// @Description Delete a transaction from the database by its ID
-// @Tags transactions
-// @Accept json
-// @Produce json
-// @Param id path string true "Transaction ID"
-// @Success 200 {object} map[string]string "message" : "transaction was succesfully deleted"
-// @Failure 500 {object} models.Error
-// @Router /api/transactions/{id} [delete]
-func (t *TransactionsController) DeleteTransactionByID(c *fiber.Ctx) error {
-	t.logger.Info().Msg("DeleteTransactionByID called")
-	panic("not implemented")
-	idx := c.Params("id")
-	query := bson.M{
-		"_id": idx,
-	}
-	_, err := t.collection.DeleteOne(context.Background(), query)
-	if err != nil {
-		t.logger.Error().Err(err).Str("transaction_id", idx).Msg("Error deleting transaction")
-		return c.Status(fiber.StatusInternalServerError).JSON(models.NewOutput([]interface{}{}, models.Error{
-			Message: err.Error(),
-			Code:    fiber.StatusInternalServerError,
-		}))
-	}
-	t.logger.Info().Str("transaction_id", idx).Msg("Successfully deleted transaction")
-	return c.JSON(models.NewOutput(
-		fiber.Map{
-			"message": "transaction was succesfully deleted",
-		}))
-
-}
-
 // GetInitiatorType godoc
 // @Security BearerAuth
 // @Summary Get all initiator types
@@ -313,12 +231,21 @@ func (t *TransactionsController) GetPaymentMethod(c *fiber.Ctx) error {
 	return c.JSON(models.NewOutput(methods))
 }
 
-func NewTransaction(ctx context.Context, transaction models.TransactionBase, initiatorType models.InitiatorType, branchID string, transactionsCollection *mongo.Collection) (string, error) {
+func NewTransaction(ctx context.Context, transaction models.TransactionBase, initiatorType models.InitiatorType, branchID string, transactionsCollection *mongo.Collection, financesCollection *mongo.Collection) (string, error) {
+	if err := transaction.Validate(); err != nil {
+		return "", err
+	}
 	trx := models.NewTransaction(&transaction, initiatorType, branchID)
+	if err := trx.InBranchCurrency(ctx, financesCollection); err != nil {
+		return "", err
+	}
 	_, err := transactionsCollection.InsertOne(ctx, trx)
 	if err != nil {
 		return "", err
 	}
+	if _, err := ledger.PostTransaction(ctx, ledger.Collection(transactionsCollection), trx); err != nil {
+		return "", err
+	}
 	return trx.ID, nil
 
 }
@@ -12,43 +12,98 @@ import (
 type ActivityType string
 
 const (
-	ActivityTypeLogin             ActivityType = "login_success"
-	ActivityTypeLoginFailed       ActivityType = "login_failed"
-	ActivityTypeLogout            ActivityType = "logout_success"
-	ActivityTypeLogoutFailed      ActivityType = "logout_failed"
-	ActivityTypeRegister          ActivityType = "register_success"
-	ActivityTypeRegisterFailed    ActivityType = "register_failed"
-	ActivityTypeCreateTransaction ActivityType = "create_transaction"
-	ActivityTypeCreateJournal     ActivityType = "create_journal"
-	ActivityTypeCloseJournal      ActivityType = "close_journal"
-	ActivityTypeCreateSupplier    ActivityType = "create_supplier"
-	ActivityTypeCreateProduct     ActivityType = "create_product"
-	ActivityTypeEditTransaction   ActivityType = "edit_transaction"
-	ActivityTypeEditSupplier      ActivityType = "edit_supplier"
-	ActivityTypeEditProduct       ActivityType = "edit_product"
-	ActivityTypeDeleteTransaction ActivityType = "delete_transaction"
-	ActivityTypeReopenJournal     ActivityType = "reopen_journal"
-	ActivityTypeDeleteSupplier    ActivityType = "delete_supplier"
-	ActivityTypeDeleteProduct     ActivityType = "delete_product"
-	ActivityTypeCloseSalesSession ActivityType = "close_sales_session"
-	ActivityTypeOpenSalesSession  ActivityType = "open_sales_session"
-	ActivityTypeProductIncome     ActivityType = "product_income"
-	ActivityTypeProductTransfer   ActivityType = "product_transfer"
-	ActivityTypeCreateOperation   ActivityType = "create_operation"
-	ActivityTypeEditOperation     ActivityType = "edit_operation"
-	ActivityTypeDeleteOperation   ActivityType = "delete_operation"
-	ActivityTypeCreateFinance     ActivityType = "create_finance"
-	ActivityTypeEditFinance       ActivityType = "edit_finance"
-	ActivityTypeDeleteFinance     ActivityType = "delete_finance"
+	ActivityTypeLogin                ActivityType = "login_success"
+	ActivityTypeLoginFailed          ActivityType = "login_failed"
+	ActivityTypeLogout               ActivityType = "logout_success"
+	ActivityTypeLogoutFailed         ActivityType = "logout_failed"
+	ActivityTypeRegister             ActivityType = "register_success"
+	ActivityTypeRegisterFailed       ActivityType = "register_failed"
+	ActivityTypeCreateTransaction    ActivityType = "create_transaction"
+	ActivityTypeCreateJournal        ActivityType = "create_journal"
+	ActivityTypeCloseJournal         ActivityType = "close_journal"
+	ActivityTypeCreateSupplier       ActivityType = "create_supplier"
+	ActivityTypeCreateProduct        ActivityType = "create_product"
+	ActivityTypeEditTransaction      ActivityType = "edit_transaction"
+	ActivityTypeEditSupplier         ActivityType = "edit_supplier"
+	ActivityTypeEditProduct          ActivityType = "edit_product"
+	ActivityTypeDeleteTransaction    ActivityType = "delete_transaction"
+	ActivityTypeVoidTransaction      ActivityType = "void_transaction"
+	ActivityTypeReopenJournal        ActivityType = "reopen_journal"
+	ActivityTypeDeleteSupplier       ActivityType = "delete_supplier"
+	ActivityTypeDeleteProduct        ActivityType = "delete_product"
+	ActivityTypeCloseSalesSession    ActivityType = "close_sales_session"
+	ActivityTypeOpenSalesSession     ActivityType = "open_sales_session"
+	ActivityTypeVoidSalesSession     ActivityType = "void_sales_session"
+	ActivityTypeCreateReceipt        ActivityType = "create_receipt"
+	ActivityTypeCreateRefund         ActivityType = "create_refund"
+	ActivityTypeProductIncome        ActivityType = "product_income"
+	ActivityTypeProductTransfer      ActivityType = "product_transfer"
+	ActivityTypeDispatchTransfer     ActivityType = "dispatch_transfer"
+	ActivityTypeWriteOffBatch        ActivityType = "write_off_batch"
+	ActivityTypeReceiveTransfer      ActivityType = "receive_transfer"
+	ActivityTypeCreateStocktake      ActivityType = "create_stocktake"
+	ActivityTypeStocktakeCounts      ActivityType = "submit_stocktake_counts"
+	ActivityTypeApproveStocktake     ActivityType = "approve_stocktake"
+	ActivityTypeCancelStocktake      ActivityType = "cancel_stocktake"
+	ActivityTypeCreatePurchaseOrder  ActivityType = "create_purchase_order"
+	ActivityTypeReceivePurchaseOrder ActivityType = "receive_purchase_order"
+	ActivityTypeCancelPurchaseOrder  ActivityType = "cancel_purchase_order"
+	ActivityTypeCreateOperation      ActivityType = "create_operation"
+	ActivityTypeEditOperation        ActivityType = "edit_operation"
+	ActivityTypeDeleteOperation      ActivityType = "delete_operation"
+	ActivityTypeCreateFinance        ActivityType = "create_finance"
+	ActivityTypeEditFinance          ActivityType = "edit_finance"
+	ActivityTypeDeleteFinance        ActivityType = "delete_finance"
+	ActivityTypeCreateUser           ActivityType = "create_user"
+	ActivityTypeUpdateUser           ActivityType = "update_user"
+	ActivityTypeDisableUser          ActivityType = "disable_user"
+	ActivityTypeEnableUser           ActivityType = "enable_user"
+	ActivityTypeIssuePasswordReset   ActivityType = "issue_password_reset"
+	ActivityTypePasswordReset        ActivityType = "password_reset"
+	ActivityTypeRevokeSession        ActivityType = "revoke_session"
+	ActivityTypeForceLogout          ActivityType = "force_logout"
+	ActivityTypeLoginLockout         ActivityType = "login_lockout"
+	ActivityTypeUnlockLogin          ActivityType = "unlock_login"
+	ActivityTypeCreateAPIKey         ActivityType = "create_api_key"
+	ActivityTypeRevokeAPIKey         ActivityType = "revoke_api_key"
+	ActivityTypeCreateTerminal       ActivityType = "create_terminal"
+	ActivityTypeRevokeTerminal       ActivityType = "revoke_terminal"
+	ActivityTypeSetPIN               ActivityType = "set_pin"
+	ActivityTypeSwitchCashier        ActivityType = "switch_cashier"
+	ActivityTypeLockTerminal         ActivityType = "lock_terminal"
+	ActivityTypeCreateBranch         ActivityType = "create_branch"
+	ActivityTypeUpdateBranch         ActivityType = "update_branch"
+	ActivityTypeDeleteBranch         ActivityType = "delete_branch"
+	ActivityTypeMigrateBranches      ActivityType = "migrate_branches"
+	ActivityTypePostOpeningBalance   ActivityType = "post_opening_balance"
+	ActivityTypeAdjustFinance        ActivityType = "adjust_finance"
+	ActivityTypeTransferMoney        ActivityType = "transfer_money"
+	ActivityTypeSetExchangeRate      ActivityType = "set_exchange_rate"
+	ActivityTypeMigrateMoney         ActivityType = "migrate_money"
+	ActivityTypeClosePeriod          ActivityType = "close_period"
+	ActivityTypeReopenPeriod         ActivityType = "reopen_period"
+)
+
+// internal expenses
+const (
+	ActivityTypeCreateExpense             ActivityType = "create_expense"
+	ActivityTypeApproveExpense            ActivityType = "approve_expense"
+	ActivityTypeRejectExpense             ActivityType = "reject_expense"
+	ActivityTypeCreateExpenseCategory     ActivityType = "create_expense_category"
+	ActivityTypeUpdateExpenseCategory     ActivityType = "update_expense_category"
+	ActivityTypeCreateRecurringExpense    ActivityType = "create_recurring_expense"
+	ActivityTypeUpdateRecurringExpense    ActivityType = "update_recurring_expense"
+	ActivityTypeGenerateRecurringExpenses ActivityType = "generate_recurring_expenses"
 )
 
 type Activity struct {
-	UserID string       `bson:"user_id"`
-	Action ActivityType `bson:"action"`
-	Data   interface{}  `bson:"data"`
-	IP     string       `bson:"ip"`
-	Date   time.Time    `bson:"date"`
-	Status int          `bson:"status"`
+	UserID   string       `bson:"user_id"`
+	Action   ActivityType `bson:"action"`
+	Data     interface{}  `bson:"data"`
+	IP       string       `bson:"ip"`
+	Date     time.Time    `bson:"date"`
+	Status   int          `bson:"status"`
+	Terminal string       `bson:"terminal,omitempty"` // terminal the user was switched in on
 }
 
 // func SetActionType(ctx *fiber.Ctx, action ActivityType) {
@@ -84,7 +139,20 @@ func LogActivityWithCtx(ctx *fiber.Ctx, action ActivityType, data interface{}, c
 		user = "unknown"
 	}
 
-	LogActivity(user, action, data, ctx.IP(), ctx.Response().StatusCode(), collection)
+	terminal, _ := ctx.Locals("terminal").(string)
+
+	activity := Activity{
+		UserID:   user,
+		Action:   action,
+		Data:     data,
+		IP:       ctx.IP(),
+		Status:   ctx.Response().StatusCode(),
+		Date:     time.Now(),
+		Terminal: terminal,
+	}
+	if err := RecordActicity(context.Background(), activity, collection); err != nil {
+		log.Error().Err(err).Msg("Failed to insert activity")
+	}
 }
 
 func LogActivity(user string, action ActivityType, data interface{}, ip string, status int, collection *mongo.Collection) {
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"errors"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// APIKeyHeader carries the api keys of integrations
+const APIKeyHeader = "X-API-Key"
+
+// APIKeyOf returns the api key the request is authenticated with
+func APIKeyOf(c *fiber.Ctx) (*models.APIKey, bool) {
+	api_key, ok := c.Locals("api_key").(*models.APIKey)
+	return api_key, ok
+}
+
+// HasAPIKey tells the paseto middleware to skip requests authenticated with an api key
+func HasAPIKey(c *fiber.Ctx) bool {
+	_, ok := APIKeyOf(c)
+	return ok
+}
+
+// APIKeyMiddleware authenticates requests carrying an api key. Requests without one are passed to the paseto middleware.
+// Reads need the read scope of the path, writes need the permission the route requires as scope (checked by Require)
+func (m *Middlewares) APIKeyMiddleware(c *fiber.Ctx) error {
+	key := c.Get(APIKeyHeader)
+	if key == "" {
+		return c.Next()
+	}
+
+	api_key, err := models.FindAPIKey(c.Context(), key, m.APIKeysCollection)
+	if errors.Is(err, models.ErrInvalidAPIKey) {
+		log.Warn().Str("ip", c.IP()).Str("path", c.Path()).Msg("Invalid api key")
+		return c.Status(fiber.StatusUnauthorized).JSON(models.NewOutput([]interface{}{}, models.Error{
+			Message: err.Error(),
+			Code:    fiber.StatusUnauthorized,
+		}))
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to find api key")
+		return models.ReturnError(c, err)
+	}
+
+	scope, ok := models.APIKeyPathScope(c.Path())
+	if !ok {
+		return forbidden(c, "API keys can not access this path")
+	}
+	if (c.Method() == fiber.MethodGet || c.Method() == fiber.MethodHead) && !api_key.HasScope(scope) {
+		log.Warn().Str("api_key", api_key.Name).Str("scope", string(scope)).Str("path", c.Path()).Msg("Scope denied")
+		return forbidden(c, "Scope "+string(scope)+" is required")
+	}
+
+	if err := models.TouchAPIKey(c.Context(), api_key, c.IP(), m.APIKeysCollection); err != nil {
+		log.Error().Err(err).Str("api_key", api_key.Name).Msg("Failed to record use of api key")
+	}
+
+	// activities of the key are attributed to it
+	c.Locals("user", "api_key:"+api_key.Name)
+	c.Locals("api_key", api_key)
+	if api_key.Branch != "" {
+		c.Locals("branch", api_key.Branch)
+	}
+	return c.Next()
+}
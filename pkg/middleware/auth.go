@@ -1,9 +1,16 @@
 package middleware
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/configs"
 	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+	"github.com/aslon1213/g4h_pos_erp/platform/cache"
 	pasetoware "github.com/gofiber/contrib/paseto"
 	"github.com/gofiber/fiber/v2"
+	"github.com/o1egl/paseto"
 	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
@@ -12,33 +19,123 @@ import (
 type Middlewares struct {
 	UserCollection       *mongo.Collection
 	ActivitiesCollection *mongo.Collection
+	APIKeysCollection    *mongo.Collection
+	TerminalsCollection  *mongo.Collection
+	Cache                *cache.Cache
+	SymmetricKey         []byte // of the paseto tokens, the session cookie is decrypted with it
 }
 
-func New(db *mongo.Database) *Middlewares {
+func New(db *mongo.Database, cache *cache.Cache) *Middlewares {
+	config, err := configs.LoadConfig(".")
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load config")
+	}
+	key, err := base64.StdEncoding.DecodeString(config.Server.SecretSymmetricKey)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid symmetric key")
+	}
 	return &Middlewares{
 		UserCollection:       db.Collection("users"),
 		ActivitiesCollection: db.Collection("activities"),
+		APIKeysCollection:    db.Collection("api_keys"),
+		TerminalsCollection:  db.Collection("terminals"),
+		Cache:                cache,
+		SymmetricKey:         key,
+	}
+}
+
+// ValidateAccessToken is the payload validator of the paseto middleware. It stores the claims of the access token
+// instead of only the username so that the token and its session can be checked for revocation
+func ValidateAccessToken(decrypted []byte) (interface{}, error) {
+	payload := paseto.JSONToken{}
+	if err := json.Unmarshal(decrypted, &payload); err != nil {
+		return nil, pasetoware.ErrDataUnmarshal
+	}
+	if time.Now().After(payload.Expiration) {
+		return nil, pasetoware.ErrExpiredToken
 	}
+	if err := payload.Validate(paseto.ValidAt(time.Now())); err != nil {
+		return nil, err
+	}
+	return &models.AccessClaims{
+		Username:  payload.Get("data"),
+		TokenID:   payload.Jti,
+		SessionID: payload.Get("session_id"),
+		ExpiresAt: payload.Expiration,
+		Web:       payload.Get("kind") == WebTokenKind,
+		Terminal:  payload.Get("terminal_id"),
+	}, nil
+}
+
+// Claims returns the claims of the access token of the request
+func Claims(c *fiber.Ctx) (*models.AccessClaims, bool) {
+	claims, ok := c.Locals(pasetoware.DefaultContextKey).(*models.AccessClaims)
+	return claims, ok
 }
 
 func (m *Middlewares) AuthMiddleware(c *fiber.Ctx) error {
 
-	values := c.Locals(
-		pasetoware.DefaultContextKey,
-	).(string)
+	claims, ok := Claims(c)
+	// tokens of the session cookie live as long as the session, they are not accepted as bearer tokens
+	if !ok || claims.SessionID == "" || claims.Web {
+		return c.SendStatus(fiber.StatusUnauthorized)
+	}
+
+	user, status := m.userOfClaims(c, claims)
+	if status != fiber.StatusOK {
+		return c.SendStatus(status)
+	}
+	// add the user to the context
+	m.setUserLocals(c, user, claims)
+
+	return c.Next()
+}
+
+// userOfClaims checks that the token is not revoked, that its session is alive and that its user is enabled
+func (m *Middlewares) userOfClaims(c *fiber.Ctx, claims *models.AccessClaims) (*models.User, int) {
+	// revoked tokens (logout) and tokens of deleted sessions (force logout, expired refresh) are rejected
+	revoked, err := models.IsAccessTokenRevoked(claims.TokenID, m.Cache)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to check token denylist")
+		return nil, fiber.StatusInternalServerError
+	}
+	if revoked {
+		return nil, fiber.StatusUnauthorized
+	}
+	exists, err := models.AuthSessionExists(claims.SessionID, m.Cache)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to check session")
+		return nil, fiber.StatusInternalServerError
+	}
+	if !exists {
+		return nil, fiber.StatusUnauthorized
+	}
 
 	// got username
-	username := values
+	username := claims.Username
 
 	// retreive the user
 	user := &models.User{}
-	err := m.UserCollection.FindOne(c.Context(), bson.M{"username": username}).Decode(user)
+	err = m.UserCollection.FindOne(c.Context(), bson.M{"username": username}).Decode(user)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to find user")
-		return c.SendStatus(fiber.StatusUnauthorized)
+		return nil, fiber.StatusUnauthorized
 	}
-	// add the user to the context
-	c.Locals("user", user.Username)
+	if user.Disabled {
+		log.Warn().Str("username", user.Username).Msg("Request of disabled user")
+		return nil, fiber.StatusUnauthorized
+	}
+	return user, fiber.StatusOK
+}
 
-	return c.Next()
+func (m *Middlewares) setUserLocals(c *fiber.Ctx, user *models.User, claims *models.AccessClaims) {
+	c.Locals("user", user.Username)
+	c.Locals("role", user.Role)
+	c.Locals("session", claims.SessionID)
+	if claims.Terminal != "" {
+		c.Locals("terminal", claims.Terminal)
+	}
+	if branch, ok := user.BoundBranch(); ok {
+		c.Locals("branch", branch)
+	}
 }
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"errors"
+	"time"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// PeriodClosed responds with 409 for changes of documents dated in a closed period of their branch
+func PeriodClosed(c *fiber.Ctx, err error) error {
+	return c.Status(fiber.StatusConflict).JSON(models.NewOutput([]interface{}{}, models.Error{
+		Message: err.Error(),
+		Code:    fiber.StatusConflict,
+	}))
+}
+
+// PeriodOpenOfDocument lets the request through only if the document of the collection whose _id is the route param is dated
+// (dateField) after the lock date of its branch (branchField). Closed periods are locked for every user, bound or not
+func (m *Middlewares) PeriodOpenOfDocument(collection string, param string, branchField string, dateField string) fiber.Handler {
+	coll := m.UserCollection.Database().Collection(collection)
+	periods := models.PeriodClosesCollection(coll)
+	return func(c *fiber.Ctx) error {
+		document, err := findDocument(c, coll, param, branchField, dateField)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// handler responds with not found
+			return c.Next()
+		}
+		if err != nil {
+			log.Error().Err(err).Str("path", c.Path()).Msg("Failed to find document to check its period")
+			return models.ReturnError(c, err)
+		}
+
+		branch_id, _ := fieldOf(document, branchField).(string)
+		var date time.Time
+		switch value := fieldOf(document, dateField).(type) {
+		case bson.DateTime:
+			date = value.Time()
+		case time.Time:
+			date = value
+		}
+		err = models.CheckPeriodOpen(c.Context(), periods, branch_id, date)
+		if errors.Is(err, models.ErrPeriodClosed) {
+			log.Warn().Str("branch_id", branch_id).Time("date", date).Str("path", c.Path()).Msg("Document of closed period")
+			return PeriodClosed(c, err)
+		}
+		if err != nil {
+			return models.ReturnError(c, err)
+		}
+		return c.Next()
+	}
+}
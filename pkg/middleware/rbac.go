@@ -0,0 +1,161 @@
+package middleware
+
+import (
+	"errors"
+	"strings"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// BranchResolver returns the branch of the resource the request is about
+type BranchResolver func(c *fiber.Ctx) (string, error)
+
+// UserBranch returns the branch the user of the request is bound to (set by AuthMiddleware)
+func UserBranch(c *fiber.Ctx) (string, bool) {
+	branch, ok := c.Locals("branch").(string)
+	return branch, ok && branch != ""
+}
+
+// CanAccessBranch reports whether the user of the request may read/write data of the branch
+func CanAccessBranch(c *fiber.Ctx, branch_id string) bool {
+	branch, bound := UserBranch(c)
+	return !bound || branch == branch_id
+}
+
+func forbidden(c *fiber.Ctx, message string) error {
+	return c.Status(fiber.StatusForbidden).JSON(models.NewOutput([]interface{}{}, models.Error{
+		Message: message,
+		Code:    fiber.StatusForbidden,
+	}))
+}
+
+// ForbiddenBranch responds with 403 for requests to a branch the user is not bound to
+func ForbiddenBranch(c *fiber.Ctx) error {
+	return forbidden(c, "You do not have access to this branch")
+}
+
+// Require lets the request through only if the role of the user (or the api key) has every of the permissions
+func (m *Middlewares) Require(permissions ...models.Permission) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if api_key, ok := APIKeyOf(c); ok {
+			for _, permission := range permissions {
+				if !api_key.HasScope(permission) {
+					log.Warn().Str("api_key", api_key.Name).Str("scope", string(permission)).Str("path", c.Path()).Msg("Scope denied")
+					return forbidden(c, "Scope "+string(permission)+" is required")
+				}
+			}
+			return c.Next()
+		}
+
+		role, _ := c.Locals("role").(string)
+		for _, permission := range permissions {
+			if !models.HasPermission(role, permission) {
+				user, _ := c.Locals("user").(string)
+				log.Warn().Str("user", user).Str("role", role).Str("permission", string(permission)).Str("path", c.Path()).Msg("Permission denied")
+				return forbidden(c, "Permission "+string(permission)+" is required")
+			}
+		}
+		return c.Next()
+	}
+}
+
+// BranchOf lets the request through only if the branch of the resource is the branch the user is bound to.
+// Users which are not bound to a branch are always let through without resolving the branch
+func (m *Middlewares) BranchOf(resolve BranchResolver) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, bound := UserBranch(c); !bound {
+			return c.Next()
+		}
+		branch_id, err := resolve(c)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// handler responds with not found
+			return c.Next()
+		}
+		if err != nil {
+			log.Error().Err(err).Str("path", c.Path()).Msg("Failed to resolve branch of the resource")
+			return models.ReturnError(c, err)
+		}
+		if !CanAccessBranch(c, branch_id) {
+			return ForbiddenBranch(c)
+		}
+		return c.Next()
+	}
+}
+
+// BranchParam requires the route param to be the branch of the user
+func (m *Middlewares) BranchParam(param string) fiber.Handler {
+	return m.BranchOf(func(c *fiber.Ctx) (string, error) {
+		return c.Params(param), nil
+	})
+}
+
+// BranchQuery restricts queries of bound users to their branch by overriding the query param
+func (m *Middlewares) BranchQuery(query string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if branch, bound := UserBranch(c); bound {
+			c.Request().URI().QueryArgs().Set(query, branch)
+		}
+		return c.Next()
+	}
+}
+
+// BranchOfDocument resolves the branch from the field (dotted path) of the document of the collection whose _id is the route param.
+// Both string and object ids are matched
+func (m *Middlewares) BranchOfDocument(collection string, param string, field string) fiber.Handler {
+	coll := m.UserCollection.Database().Collection(collection)
+	return m.BranchOf(func(c *fiber.Ctx) (string, error) {
+		document, err := findDocument(c, coll, param, field)
+		if err != nil {
+			return "", err
+		}
+		branch_id, _ := fieldOf(document, field).(string)
+		return branch_id, nil
+	})
+}
+
+// findDocument returns the fields of the document of the collection whose _id (string or object id) is the route param
+func findDocument(c *fiber.Ctx, coll *mongo.Collection, param string, fields ...string) (bson.M, error) {
+	id := c.Params(param)
+	ids := bson.A{id}
+	if object_id, err := bson.ObjectIDFromHex(id); err == nil {
+		ids = append(ids, object_id)
+	}
+
+	projection := bson.M{}
+	for _, field := range fields {
+		projection[field] = 1
+	}
+	document := bson.M{}
+	opts := options.FindOne().SetProjection(projection)
+	if err := coll.FindOne(c.Context(), bson.M{"_id": bson.M{"$in": ids}}, opts).Decode(&document); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+// fieldOf returns the value of the field (dotted path) of the document, nil if it is missing
+func fieldOf(document bson.M, field string) interface{} {
+	var value interface{} = document
+	for _, key := range strings.Split(field, ".") {
+		switch nested := value.(type) {
+		case bson.M:
+			value = nested[key]
+		case bson.D:
+			value = nil
+			for _, element := range nested {
+				if element.Key == key {
+					value = element.Value
+				}
+			}
+		default:
+			return nil
+		}
+	}
+	return value
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// TerminalTokenHeader carries the device token of shared POS terminals
+const TerminalTokenHeader = "X-Terminal-Token"
+
+// Attributed adds the user and the terminal of the request to the context of the database transaction,
+// records created within it are attributed to them
+func Attributed(c *fiber.Ctx, ctx context.Context) context.Context {
+	user, _ := c.Locals("user").(string)
+	terminal, _ := c.Locals("terminal").(string)
+	return models.WithAttribution(ctx, models.Attribution{User: user, Terminal: terminal})
+}
+
+// TerminalOf returns the terminal the request is authenticated with by TerminalAuth
+func TerminalOf(c *fiber.Ctx) (*models.Terminal, bool) {
+	terminal, ok := c.Locals("terminal_device").(*models.Terminal)
+	return terminal, ok
+}
+
+// TerminalAuth authenticates the device token of a terminal
+func (m *Middlewares) TerminalAuth(c *fiber.Ctx) error {
+	token := c.Get(TerminalTokenHeader)
+	if token == "" {
+		return c.SendStatus(fiber.StatusUnauthorized)
+	}
+
+	terminal := &models.Terminal{}
+	err := m.TerminalsCollection.FindOneAndUpdate(
+		c.Context(),
+		bson.M{"token_hash": models.HashTerminalToken(token), "revoked": false},
+		bson.M{"$set": bson.M{"last_seen_at": time.Now()}},
+	).Decode(terminal)
+	if err == mongo.ErrNoDocuments {
+		log.Warn().Str("ip", c.IP()).Msg("Invalid terminal token")
+		return c.SendStatus(fiber.StatusUnauthorized)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to find terminal")
+		return models.ReturnError(c, err)
+	}
+
+	c.Locals("terminal_device", terminal)
+	c.Locals("terminal", terminal.ID)
+	return c.Next()
+}
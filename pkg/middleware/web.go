@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"net/url"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	pasetoware "github.com/gofiber/contrib/paseto"
+	"github.com/gofiber/fiber/v2"
+	"github.com/o1egl/paseto"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// SessionCookie holds the token of the session of the html pages (dashboards and docs)
+	SessionCookie = "g4h_session"
+	// WebTokenKind is the kind claim of the tokens of the session cookie
+	WebTokenKind = "web"
+	// WebLoginPath is the login page users without a valid session cookie are redirected to
+	WebLoginPath = "/dashboard/login"
+)
+
+// WebAuth protects the html pages with the session cookie set by the login page. Users without a valid session are
+// redirected to the login page, users whose role lacks a permission get 403. The dashboards show every branch,
+// so users bound to a branch are not let in either
+func (m *Middlewares) WebAuth(permissions ...models.Permission) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token := c.Cookies(SessionCookie)
+		if token == "" {
+			return redirectToLogin(c)
+		}
+
+		var decrypted []byte
+		if err := paseto.NewV2().Decrypt(token, m.SymmetricKey, &decrypted, nil); err != nil {
+			log.Warn().Err(err).Str("path", c.Path()).Msg("Invalid session cookie")
+			return redirectToLogin(c)
+		}
+		data, err := ValidateAccessToken(decrypted)
+		if err != nil {
+			return redirectToLogin(c)
+		}
+		claims := data.(*models.AccessClaims)
+		if !claims.Web || claims.SessionID == "" {
+			return redirectToLogin(c)
+		}
+
+		user, status := m.userOfClaims(c, claims)
+		if status == fiber.StatusUnauthorized {
+			return redirectToLogin(c)
+		}
+		if status != fiber.StatusOK {
+			return c.SendStatus(status)
+		}
+		c.Locals(pasetoware.DefaultContextKey, claims)
+		m.setUserLocals(c, user, claims)
+
+		for _, permission := range permissions {
+			if !models.HasPermission(user.Role, permission) {
+				log.Warn().Str("user", user.Username).Str("role", user.Role).Str("permission", string(permission)).Str("path", c.Path()).Msg("Permission denied")
+				return c.Status(fiber.StatusForbidden).SendString("Permission " + string(permission) + " is required")
+			}
+		}
+		if _, bound := user.BoundBranch(); bound && len(permissions) > 0 {
+			return c.Status(fiber.StatusForbidden).SendString("Users bound to a branch can not access the dashboards")
+		}
+		return c.Next()
+	}
+}
+
+// ClearSessionCookie removes the session cookie of the html pages
+func ClearSessionCookie(c *fiber.Ctx) {
+	c.ClearCookie(SessionCookie)
+}
+
+func redirectToLogin(c *fiber.Ctx) error {
+	ClearSessionCookie(c)
+	return c.Redirect(WebLoginPath+"?next="+url.QueryEscape(c.OriginalURL()), fiber.StatusSeeOther)
+}
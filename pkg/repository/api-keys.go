@@ -0,0 +1,210 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+var (
+	ErrInvalidAPIKey = errors.New("invalid, revoked or expired api key")
+)
+
+// Read scopes of api keys. Users read without permissions, api keys need a read scope for every GET request
+const (
+	ScopeProductsRead  Permission = "products:read"  // products, stock, purchase orders and proposals
+	ScopeSalesRead     Permission = "sales:read"     // sales sessions and receipts
+	ScopeFinanceRead   Permission = "finance:read"   // finances, journals, transactions and internal expenses
+	ScopeSuppliersRead Permission = "suppliers:read" // suppliers
+	ScopeCustomersRead Permission = "customers:read" // customers and bnpls
+)
+
+// APIKeyPathScopes maps the paths api keys may access to the scope required for reading them.
+// Writes are checked against the permissions required by the routes, paths which are not listed
+// (auth, users, api keys ...) can not be accessed with api keys at all
+var APIKeyPathScopes = []struct {
+	Prefix string
+	Scope  Permission
+}{
+	{"/api/products", ScopeProductsRead},
+	{"/api/purchase-orders", ScopeProductsRead},
+	{"/api/proposals", ScopeProductsRead},
+	{"/api/sales", ScopeSalesRead},
+	{"/api/finance", ScopeFinanceRead},
+	{"/api/journals", ScopeFinanceRead},
+	{"/api/transactions", ScopeFinanceRead},
+	{"/api/expenses", ScopeFinanceRead},
+	{"/api/suppliers", ScopeSuppliersRead},
+	{"/api/customers", ScopeCustomersRead},
+	{"/api/bnpl", ScopeCustomersRead},
+	{"/api/branches", ScopeCustomersRead},
+	{"/api/analytics", PermissionReportsRead},
+	{"/api/activities/recent", PermissionReportsRead},
+}
+
+// APIKeyPathScope returns the read scope of the path, false if api keys can not access it
+func APIKeyPathScope(path string) (Permission, bool) {
+	for _, path_scope := range APIKeyPathScopes {
+		if path == path_scope.Prefix || strings.HasPrefix(path, path_scope.Prefix+"/") {
+			return path_scope.Scope, true
+		}
+	}
+	return "", false
+}
+
+// ValidateAPIKeyScope accepts the read scopes and the permissions of the roles except managing users
+func ValidateAPIKeyScope(scope Permission) error {
+	switch scope {
+	case ScopeProductsRead, ScopeSalesRead, ScopeFinanceRead, ScopeSuppliersRead, ScopeCustomersRead:
+		return nil
+	case PermissionUsersManage, PermissionDocsRead:
+		return errors.New("scope " + string(scope) + " can not be given to api keys")
+	}
+	for _, permissions := range RolePermissions {
+		if slices.Contains(permissions, scope) {
+			return nil
+		}
+	}
+	return errors.New("invalid scope " + string(scope))
+}
+
+// APIKey authenticates an integration (kiosk, export job ...) instead of a user. Only the hash of the key is stored,
+// the key itself is shown once when it is created
+type APIKey struct {
+	ID         string       `bson:"_id" json:"id"`
+	Name       string       `bson:"name" json:"name"`
+	Prefix     string       `bson:"prefix" json:"prefix"` // public part of the key to tell keys apart
+	KeyHash    string       `bson:"key_hash" json:"-"`
+	Scopes     []Permission `bson:"scopes" json:"scopes"`
+	Branch     string       `bson:"branch" json:"branch"` // key can only access this branch if set
+	CreatedBy  string       `bson:"created_by" json:"created_by"`
+	CreatedAt  time.Time    `bson:"created_at" json:"created_at"`
+	ExpiresAt  *time.Time   `bson:"expires_at" json:"expires_at"` // never expires if nil
+	LastUsedAt *time.Time   `bson:"last_used_at" json:"last_used_at"`
+	LastUsedIP string       `bson:"last_used_ip" json:"last_used_ip"`
+	Revoked    bool         `bson:"revoked" json:"revoked"`
+	RevokedAt  *time.Time   `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+}
+
+func (k *APIKey) HasScope(scope Permission) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+func (k *APIKey) Active() bool {
+	return !k.Revoked && (k.ExpiresAt == nil || time.Now().Before(*k.ExpiresAt))
+}
+
+type APIKeyInput struct {
+	Name          string       `json:"name"`
+	Scopes        []Permission `json:"scopes"`
+	Branch        string       `json:"branch"`
+	ExpiresInDays int          `json:"expires_in_days"` // 0 never expires
+}
+
+func (i *APIKeyInput) Validate() error {
+	if i.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(i.Scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, scope := range i.Scopes {
+		if err := ValidateAPIKeyScope(scope); err != nil {
+			return err
+		}
+	}
+	if i.ExpiresInDays < 0 {
+		return errors.New("expires_in_days can not be negative")
+	}
+	return nil
+}
+
+type APIKeyCreatedOutput struct {
+	Data  []APIKey `json:"data"`
+	Key   string   `json:"key"` // shown only once
+	Error []Error  `json:"error"`
+}
+
+type APIKeysOutput struct {
+	Data  []APIKey `json:"data"`
+	Error []Error  `json:"error"`
+}
+
+type APIKeysQueryParams struct {
+	Revoked *bool `query:"revoked"`
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAPIKey creates the api key from the input and returns the key. Keys are g4h_<prefix>_<secret>
+func NewAPIKey(input APIKeyInput, created_by string) (*APIKey, string, error) {
+	buf := make([]byte, 28)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", err
+	}
+	raw := hex.EncodeToString(buf)
+	prefix := raw[:8]
+	key := "g4h_" + prefix + "_" + raw[8:]
+
+	api_key := &APIKey{
+		ID:        uuid.New().String(),
+		Name:      input.Name,
+		Prefix:    prefix,
+		KeyHash:   hashAPIKey(key),
+		Scopes:    input.Scopes,
+		Branch:    input.Branch,
+		CreatedBy: created_by,
+		CreatedAt: time.Now(),
+	}
+	if input.ExpiresInDays > 0 {
+		expires_at := time.Now().AddDate(0, 0, input.ExpiresInDays)
+		api_key.ExpiresAt = &expires_at
+	}
+	return api_key, key, nil
+}
+
+// FindAPIKey returns the active api key, ErrInvalidAPIKey if it does not exist, is revoked or expired
+func FindAPIKey(ctx context.Context, key string, collection *mongo.Collection) (*APIKey, error) {
+	parts := strings.Split(key, "_")
+	if len(parts) != 3 || parts[0] != "g4h" {
+		return nil, ErrInvalidAPIKey
+	}
+	api_key := &APIKey{}
+	err := collection.FindOne(ctx, bson.M{"prefix": parts[1]}).Decode(api_key)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(api_key.KeyHash), []byte(hashAPIKey(key))) != 1 || !api_key.Active() {
+		return nil, ErrInvalidAPIKey
+	}
+	return api_key, nil
+}
+
+// TouchAPIKey records the use of the key. Writes are throttled to one per minute per key
+func TouchAPIKey(ctx context.Context, api_key *APIKey, ip string, collection *mongo.Collection) error {
+	now := time.Now()
+	if api_key.LastUsedAt != nil && now.Sub(*api_key.LastUsedAt) < time.Minute {
+		return nil
+	}
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": api_key.ID}, bson.M{"$set": bson.M{
+		"last_used_at": now,
+		"last_used_ip": ip,
+	}})
+	return err
+}
@@ -13,4 +13,6 @@ type ProductProposal struct {
 	Branch    string        `json:"branch" bson:"branch"`
 	Fulfilled bool          `json:"fulfilled" bson:"fulfilled"`
 	ImageFile *string       `json:"image_file" bson:"image_file,omitempty"`
+	// purchase order the proposal is ordered with
+	PurchaseOrderID string `json:"purchase_order_id,omitempty" bson:"purchase_order_id,omitempty"`
 }
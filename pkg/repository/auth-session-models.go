@@ -0,0 +1,262 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/platform/cache"
+
+	"github.com/go-redis/redis"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrAuthSessionNotFound = errors.New("session not found or expired")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+)
+
+// AuthSession is a login of a user on a device. It lives in redis for the lifetime of the refresh token,
+// access tokens of the session are rejected as soon as the session is deleted
+type AuthSession struct {
+	ID          string    `json:"id"`
+	Username    string    `json:"username"`
+	Device      string    `json:"device"` // user agent of the login
+	IP          string    `json:"ip"`
+	TerminalID  string    `json:"terminal_id,omitempty"` // session of a cashier switched in on a terminal
+	CreatedAt   time.Time `json:"created_at"`
+	LastUsedAt  time.Time `json:"last_used_at"` // last refresh
+	ExpiresAt   time.Time `json:"expires_at"`
+	RefreshHash string    `json:"-"`
+	Current     bool      `json:"current"` // session of the request, set when listing sessions
+}
+
+// authSessionRecord is what is stored in redis --- AuthSession hides the refresh hash from the api
+type authSessionRecord struct {
+	AuthSession
+	RefreshHash string `json:"refresh_hash"`
+}
+
+// AccessClaims are the claims of a validated access token
+type AccessClaims struct {
+	Username  string
+	TokenID   string
+	SessionID string
+	ExpiresAt time.Time
+	Web       bool   // token of the session cookie of the dashboards
+	Terminal  string // terminal of the session
+}
+
+type TokenPairOutput struct {
+	Data         string    `json:"data"` // access token
+	RefreshToken string    `json:"refresh_token"`
+	SessionID    string    `json:"session_id"`
+	ExpiresAt    time.Time `json:"expires_at"` // of the access token
+}
+
+type RefreshTokenInput struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type AuthSessionsOutput struct {
+	Data  []AuthSession `json:"data"`
+	Error []Error       `json:"error"`
+}
+
+func authSessionKey(id string) string {
+	return "auth_session:" + id
+}
+
+func userSessionsKey(username string) string {
+	return "user_sessions:" + username
+}
+
+func revokedTokenKey(token_id string) string {
+	return "revoked_token:" + token_id
+}
+
+func hashRefreshSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAuthSession creates the session and returns its refresh token
+func NewAuthSession(username string, device string, ip string, ttl time.Duration, cache *cache.Cache) (*AuthSession, string, error) {
+	return newAuthSession(username, device, ip, "", ttl, cache)
+}
+
+// NewTerminalAuthSession creates the session of a cashier switched in on the terminal
+func NewTerminalAuthSession(username string, terminal *Terminal, ip string, ttl time.Duration, cache *cache.Cache) (*AuthSession, string, error) {
+	return newAuthSession(username, "terminal:"+terminal.Name, ip, terminal.ID, ttl, cache)
+}
+
+func newAuthSession(username string, device string, ip string, terminal_id string, ttl time.Duration, cache *cache.Cache) (*AuthSession, string, error) {
+	session := &AuthSession{
+		ID:         uuid.New().String(),
+		Username:   username,
+		Device:     device,
+		IP:         ip,
+		TerminalID: terminal_id,
+		CreatedAt:  time.Now(),
+		LastUsedAt: time.Now(),
+		ExpiresAt:  time.Now().Add(ttl),
+	}
+	refresh_token, err := session.rotateRefreshToken()
+	if err != nil {
+		return nil, "", err
+	}
+	if err := session.save(cache); err != nil {
+		return nil, "", err
+	}
+	if err := cache.RedisClient.SAdd(userSessionsKey(username), session.ID).Err(); err != nil {
+		log.Error().Err(err).Str("username", username).Msg("Failed to add session to sessions of user")
+		return nil, "", err
+	}
+	cache.RedisClient.Expire(userSessionsKey(username), ttl)
+	return session, refresh_token, nil
+}
+
+// rotateRefreshToken generates a new refresh token of the session. Refresh tokens are <session id>.<secret>
+func (s *AuthSession) rotateRefreshToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	secret := hex.EncodeToString(buf)
+	s.RefreshHash = hashRefreshSecret(secret)
+	return s.ID + "." + secret, nil
+}
+
+func (s *AuthSession) save(cache *cache.Cache) error {
+	ttl := time.Until(s.ExpiresAt)
+	if ttl <= 0 {
+		return ErrAuthSessionNotFound
+	}
+	data, err := json.Marshal(authSessionRecord{AuthSession: *s, RefreshHash: s.RefreshHash})
+	if err != nil {
+		return err
+	}
+	if err := cache.RedisClient.Set(authSessionKey(s.ID), data, ttl).Err(); err != nil {
+		log.Error().Err(err).Str("session_id", s.ID).Msg("Failed to save session")
+		return err
+	}
+	return nil
+}
+
+func GetAuthSession(id string, cache *cache.Cache) (*AuthSession, error) {
+	data, err := cache.RedisClient.Get(authSessionKey(id)).Result()
+	if err == redis.Nil {
+		return nil, ErrAuthSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	record := authSessionRecord{}
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		return nil, err
+	}
+	session := record.AuthSession
+	session.RefreshHash = record.RefreshHash
+	return &session, nil
+}
+
+// AuthSessionExists is the cheap check done on every request
+func AuthSessionExists(id string, cache *cache.Cache) (bool, error) {
+	count, err := cache.RedisClient.Exists(authSessionKey(id)).Result()
+	return count > 0, err
+}
+
+// RefreshAuthSession validates the refresh token and rotates it. Reusing an already rotated refresh token
+// means that it was stolen, the whole session is revoked then
+func RefreshAuthSession(refresh_token string, ip string, cache *cache.Cache) (*AuthSession, string, error) {
+	id, secret, ok := strings.Cut(refresh_token, ".")
+	if !ok {
+		return nil, "", ErrInvalidRefreshToken
+	}
+	session, err := GetAuthSession(id, cache)
+	if err != nil {
+		return nil, "", err
+	}
+	if subtle.ConstantTimeCompare([]byte(session.RefreshHash), []byte(hashRefreshSecret(secret))) != 1 {
+		log.Warn().Str("session_id", id).Str("username", session.Username).Msg("Reuse of rotated refresh token, revoking session")
+		DeleteAuthSession(session.Username, id, cache)
+		return nil, "", ErrInvalidRefreshToken
+	}
+
+	new_refresh_token, err := session.rotateRefreshToken()
+	if err != nil {
+		return nil, "", err
+	}
+	session.LastUsedAt = time.Now()
+	session.IP = ip
+	if err := session.save(cache); err != nil {
+		return nil, "", err
+	}
+	return session, new_refresh_token, nil
+}
+
+// GetAuthSessionsOfUser returns the live sessions of the user, expired ones are dropped from the set
+func GetAuthSessionsOfUser(username string, cache *cache.Cache) ([]AuthSession, error) {
+	ids, err := cache.RedisClient.SMembers(userSessionsKey(username)).Result()
+	if err != nil {
+		return nil, err
+	}
+	sessions := []AuthSession{}
+	for _, id := range ids {
+		session, err := GetAuthSession(id, cache)
+		if err == ErrAuthSessionNotFound {
+			cache.RedisClient.SRem(userSessionsKey(username), id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, nil
+}
+
+func DeleteAuthSession(username string, id string, cache *cache.Cache) error {
+	if err := cache.RedisClient.Del(authSessionKey(id)).Err(); err != nil {
+		log.Error().Err(err).Str("session_id", id).Msg("Failed to delete session")
+		return err
+	}
+	return cache.RedisClient.SRem(userSessionsKey(username), id).Err()
+}
+
+// DeleteAuthSessionsOfUser logs the user out everywhere and returns the number of revoked sessions
+func DeleteAuthSessionsOfUser(username string, cache *cache.Cache) (int, error) {
+	ids, err := cache.RedisClient.SMembers(userSessionsKey(username)).Result()
+	if err != nil {
+		return 0, err
+	}
+	keys := []string{userSessionsKey(username)}
+	for _, id := range ids {
+		keys = append(keys, authSessionKey(id))
+	}
+	if err := cache.RedisClient.Del(keys...).Err(); err != nil {
+		log.Error().Err(err).Str("username", username).Msg("Failed to delete sessions of user")
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// RevokeAccessToken puts the token on the denylist until it expires
+func RevokeAccessToken(claims *AccessClaims, cache *cache.Cache) error {
+	ttl := time.Until(claims.ExpiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return cache.RedisClient.Set(revokedTokenKey(claims.TokenID), claims.Username, ttl).Err()
+}
+
+func IsAccessTokenRevoked(token_id string, cache *cache.Cache) (bool, error) {
+	count, err := cache.RedisClient.Exists(revokedTokenKey(token_id)).Result()
+	return count > 0, err
+}
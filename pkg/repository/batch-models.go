@@ -0,0 +1,67 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// InitiatorTypeWriteOff is a loss transaction created when products are written off (expired, damaged, etc.)
+const InitiatorTypeWriteOff InitiatorType = "write_off"
+
+// ProductBatch is a quantity of a product received at a place at once --- all items of the batch share the expire date and the cost price.
+// Batches are depleted first expired first out on sales and transfers
+type ProductBatch struct {
+	ID              string       `json:"id" bson:"_id"`
+	ProductID       string       `json:"product_id" bson:"product_id"`
+	Place           ProductPlace `json:"place" bson:"place"`
+	ProductItem     `bson:",inline"`
+	Quantity        int32     `json:"quantity" bson:"quantity"`                 // quantity left in the batch
+	InitialQuantity int32     `json:"initial_quantity" bson:"initial_quantity"` // quantity the batch was received with
+	WrittenOff      int32     `json:"written_off" bson:"written_off"`           // quantity written off
+	ReceivedAt      time.Time `json:"received_at" bson:"received_at"`
+}
+
+func NewProductBatch(productID string, place ProductPlace, quantity int32, item ProductItem) *ProductBatch {
+	return &ProductBatch{
+		ID:              uuid.New().String(),
+		ProductID:       productID,
+		Place:           place,
+		ProductItem:     item,
+		Quantity:        quantity,
+		InitialQuantity: quantity,
+		ReceivedAt:      time.Now(),
+	}
+}
+
+type ProductBatchOutput struct {
+	Data  []ProductBatch `json:"data"`
+	Error []Error        `json:"error"`
+}
+
+type ExpiringBatchesQueryParams struct {
+	BranchID string `query:"branch_id"`
+	Days     int    `query:"days" default:"30"` // batches expiring within this many days, already expired ones included
+}
+
+type WriteOffInput struct {
+	Quantity int32  `json:"quantity"` // 0 writes off everything left in the batch
+	Reason   string `json:"reason"`
+}
+
+func (w *WriteOffInput) Validate(batch *ProductBatch) error {
+	if w.Quantity < 0 {
+		return errors.New("quantity cannot be negative")
+	}
+	if w.Quantity == 0 {
+		w.Quantity = batch.Quantity
+	}
+	if w.Quantity == 0 {
+		return errors.New("batch is empty")
+	}
+	if w.Quantity > batch.Quantity {
+		return errors.New("quantity exceeds what is left in the batch")
+	}
+	return nil
+}
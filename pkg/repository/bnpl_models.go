@@ -15,7 +15,7 @@ const (
 
 type NewBNPLInput struct {
 	CustomerID           string                      `json:"customer_id"`
-	TotalAmount          int32                       `json:"total_amount"`
+	TotalAmount          int64                       `json:"total_amount"`
 	CalculateTotalAmount bool                        `json:"calculate_total_amount"`
 	BranchID             string                      `json:"branch_id"`
 	Products             map[string]SalesSessionItem `json:"products"`
@@ -34,10 +34,10 @@ func (n *NewBNPLInput) Validate() error {
 type BNPL struct {
 	ID           string                      `json:"id" bson:"id"`
 	CustomerID   string                      `json:"customer_id" bson:"customer_id"`
-	TotalAmount  int32                       `json:"total_amount" bson:"total_amount"`
+	TotalAmount  int64                       `json:"total_amount" bson:"total_amount"`
 	BranchID     string                      `json:"branch_id" bson:"branch_id"`
 	Products     map[string]SalesSessionItem `json:"products" bson:"products"` // products in the BNPL
-	PaidAmount   int32                       `json:"paid_amount" bson:"paid_amount"`
+	PaidAmount   int64                       `json:"paid_amount" bson:"paid_amount"`
 	Status       BNPLStatus                  `json:"status" bson:"status"`             // active, completed, cancelled
 	Transactions []string                    `json:"transactions" bson:"transactions"` // id of transactions
 	CreatedAt    time.Time                   `json:"created_at" bson:"created_at"`
@@ -0,0 +1,215 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/utils"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+var (
+	ErrBranchNotFound = errors.New("branch not found")
+	ErrBranchInactive = errors.New("branch is not active")
+)
+
+// DefaultBranchTimezone is used by branches without timezone
+const DefaultBranchTimezone = "Asia/Tashkent"
+
+// Branch is a shop of the business. Journals, finance, proposals, products (places of type branch) and users
+// reference the branch by its ID --- the ID of the branch is the branch_id of its finance
+type Branch struct {
+	ID         string    `json:"id" bson:"_id"`
+	Name       string    `json:"name" bson:"name"`
+	Address    string    `json:"address" bson:"address"`
+	Phone      string    `json:"phone" bson:"phone"`
+	Timezone   string    `json:"timezone" bson:"timezone"`     // IANA name, journals of the branch are opened in it
+	Currency   Currency  `json:"currency" bson:"currency"`     // base currency, balances, journals and reports of the branch are in it
+	Active     bool      `json:"active" bson:"active"`         // inactive branches keep their history but get no new journals, proposals or stock
+	Warehouses []string  `json:"warehouses" bson:"warehouses"` // IDs of the warehouses supplying the branch
+	CreatedBy  string    `json:"created_by" bson:"created_by"`
+	CreatedAt  time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// Ref returns the snapshot of the branch stored in journals
+func (b *Branch) Ref() BranchRef {
+	return BranchRef{
+		ID:       b.ID,
+		Name:     b.Name,
+		Location: b.Address,
+		Phone:    b.Phone,
+	}
+}
+
+// Location returns the timezone of the branch
+func (b *Branch) Location() *time.Location {
+	if b.Timezone == "" {
+		return utils.GetTimeZone()
+	}
+	loc, err := time.LoadLocation(b.Timezone)
+	if err != nil {
+		return utils.GetTimeZone()
+	}
+	return loc
+}
+
+type BranchInput struct {
+	Name       string   `json:"name"`
+	Address    string   `json:"address"`
+	Phone      string   `json:"phone"`
+	Timezone   string   `json:"timezone"` // defaults to Asia/Tashkent
+	Currency   Currency `json:"currency"` // defaults to UZS, can not be changed later
+	Warehouses []string `json:"warehouses"`
+}
+
+func validateTimezone(timezone string) error {
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return errors.New("invalid timezone " + timezone)
+	}
+	return nil
+}
+
+func (i *BranchInput) Validate() error {
+	if i.Name == "" {
+		return errors.New("name is required")
+	}
+	if i.Timezone == "" {
+		i.Timezone = DefaultBranchTimezone
+	}
+	if i.Warehouses == nil {
+		i.Warehouses = []string{}
+	}
+	if i.Currency == "" {
+		i.Currency = DefaultCurrency
+	}
+	if err := ValidateCurrency(i.Currency); err != nil {
+		return err
+	}
+	return validateTimezone(i.Timezone)
+}
+
+// UpdateBranchInput changes the given fields of the branch
+type UpdateBranchInput struct {
+	Name       *string   `json:"name"`
+	Address    *string   `json:"address"`
+	Phone      *string   `json:"phone"`
+	Timezone   *string   `json:"timezone"`
+	Active     *bool     `json:"active"`
+	Warehouses *[]string `json:"warehouses"`
+}
+
+func (i *UpdateBranchInput) Validate() error {
+	if i.Name != nil && *i.Name == "" {
+		return errors.New("name can not be empty")
+	}
+	if i.Timezone != nil {
+		return validateTimezone(*i.Timezone)
+	}
+	return nil
+}
+
+func NewBranch(input BranchInput, created_by string) *Branch {
+	now := time.Now()
+	return &Branch{
+		ID:         uuid.New().String(),
+		Name:       input.Name,
+		Address:    input.Address,
+		Phone:      input.Phone,
+		Timezone:   input.Timezone,
+		Currency:   input.Currency,
+		Active:     true,
+		Warehouses: input.Warehouses,
+		CreatedBy:  created_by,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+}
+
+type BranchesOutput struct {
+	Data  []Branch `json:"data"`
+	Error []Error  `json:"error"`
+}
+
+type BranchesQueryParams struct {
+	Name      string `query:"name"` // case-insensitive search
+	Active    *bool  `query:"active"`
+	Warehouse string `query:"warehouse"` // branches supplied by the warehouse
+}
+
+// BranchFilter matches the branch by its ID or by its name (case-insensitive)
+func BranchFilter(id_or_name string) bson.M {
+	return bson.M{"$or": []bson.M{
+		{"_id": id_or_name},
+		{"name": bson.M{"$regex": "^" + regexp.QuoteMeta(id_or_name) + "$", "$options": "i"}},
+	}}
+}
+
+// FindBranch returns the branch by its ID or name, ErrBranchNotFound if there is no such branch
+func FindBranch(ctx context.Context, id_or_name string, collection *mongo.Collection) (*Branch, error) {
+	if id_or_name == "" {
+		return nil, ErrBranchNotFound
+	}
+	branch := &Branch{}
+	err := collection.FindOne(ctx, BranchFilter(id_or_name)).Decode(branch)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrBranchNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return branch, nil
+}
+
+// FindActiveBranch is FindBranch which also fails with ErrBranchInactive for inactive branches
+func FindActiveBranch(ctx context.Context, id_or_name string, collection *mongo.Collection) (*Branch, error) {
+	branch, err := FindBranch(ctx, id_or_name, collection)
+	if err != nil {
+		return nil, err
+	}
+	if !branch.Active {
+		return nil, ErrBranchInactive
+	}
+	return branch, nil
+}
+
+// CheckPlace checks that the branch of a place exists and is active. Warehouses are not managed as branches
+func CheckPlace(ctx context.Context, place ProductPlace, collection *mongo.Collection) error {
+	if place.PlaceType != ProductPlaceTypeBranch {
+		return nil
+	}
+	branch := &Branch{}
+	err := collection.FindOne(ctx, bson.M{"_id": place.ID}).Decode(branch)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return errors.New("branch " + place.ID + " not found")
+	}
+	if err != nil {
+		return err
+	}
+	if !branch.Active {
+		return errors.New("branch " + branch.Name + " is not active")
+	}
+	return nil
+}
+
+// BranchesMigrationReport is what the migration of branches created and changed. The migration is idempotent
+type BranchesMigrationReport struct {
+	BranchesCreated  []string `json:"branches_created"`  // IDs of the branches created from finances and the legacy branches
+	FinancesCreated  []string `json:"finances_created"`  // IDs of the branches which got an empty finance
+	ProposalsUpdated int64    `json:"proposals_updated"` // proposals which referenced their branch by name
+	JournalsUpdated  int64    `json:"journals_updated"`  // journals whose branch snapshot was empty
+	UsersUpdated     int64    `json:"users_updated"`     // users which were bound to their branch by name
+	UnknownProposals []string `json:"unknown_proposals"` // branch names of proposals which match no branch
+	UnknownUsers     []string `json:"unknown_users"`     // usernames of users bound to a branch which does not exist
+	UnknownPlaces    []string `json:"unknown_places"`    // IDs of branch places of products which match no branch
+}
+
+type BranchesMigrationOutput struct {
+	Data  BranchesMigrationReport `json:"data"`
+	Error []Error                 `json:"error"`
+}
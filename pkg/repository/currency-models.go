@@ -0,0 +1,250 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/utils"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var (
+	ErrExchangeRateNotFound = errors.New("exchange rate not found")
+	ErrForeignCurrency      = errors.New("amount must be in the base currency of the branch")
+	ErrNegativeAmount       = errors.New("amount can not be negative")
+)
+
+// Currency is the ISO 4217 code of a currency. Amounts are int64 counts of the minor unit of their currency
+type Currency string
+
+const (
+	CurrencyUZS Currency = "UZS"
+	CurrencyUSD Currency = "USD"
+)
+
+// DefaultCurrency is the base currency of branches created without currency and of the documents written before currencies
+const DefaultCurrency = CurrencyUZS
+
+// minor units in one major unit of the currency. Tiyins are out of circulation, so UZS amounts are whole sums
+var currencyMinorUnits = map[Currency]int64{
+	CurrencyUZS: 1,
+	CurrencyUSD: 100,
+}
+
+func ValidateCurrency(currency Currency) error {
+	if _, ok := currencyMinorUnits[currency]; !ok {
+		return fmt.Errorf("unsupported currency %s", currency)
+	}
+	return nil
+}
+
+// MinorUnits returns the number of minor units in one major unit of the currency
+func (c Currency) MinorUnits() int64 {
+	if units, ok := currencyMinorUnits[c]; ok {
+		return units
+	}
+	return 1
+}
+
+// OrDefault returns the default currency for documents without currency
+func (c Currency) OrDefault() Currency {
+	if c == "" {
+		return DefaultCurrency
+	}
+	return c
+}
+
+// ExchangeRate is the daily rate of a currency in the base currency. A rate is used from its day until the next stored rate
+type ExchangeRate struct {
+	ID        string    `json:"id" bson:"_id"`
+	Currency  Currency  `json:"currency" bson:"currency"` // currency being converted, e.g. USD
+	Base      Currency  `json:"base" bson:"base"`         // currency the rate is expressed in, e.g. UZS
+	Date      time.Time `json:"date" bson:"date"`         // midnight of the day in Asia/Tashkent
+	Rate      float64   `json:"rate" bson:"rate"`         // major units of the base currency for one major unit of the currency
+	CreatedBy string    `json:"created_by" bson:"created_by"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// Convert returns the amount in minor units of the currency of the rate in minor units of its base, rounded to the nearest unit
+func (r *ExchangeRate) Convert(amount int64) int64 {
+	major := float64(amount) / float64(r.Currency.MinorUnits())
+	return int64(math.Round(major * r.Rate * float64(r.Base.MinorUnits())))
+}
+
+// Inverse returns the rate converting the base back to the currency
+func (r *ExchangeRate) Inverse() *ExchangeRate {
+	inverse := *r
+	inverse.Currency, inverse.Base = r.Base, r.Currency
+	inverse.Rate = 1 / r.Rate
+	return &inverse
+}
+
+// RateDay returns the midnight of the day of the date, rates are stored per day
+func RateDay(date time.Time) time.Time {
+	loc := utils.GetTimeZone()
+	date = date.In(loc)
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, loc)
+}
+
+// ExchangeRateInput sets the rate of the currency for the day, the rate of the day is replaced if it was already set
+type ExchangeRateInput struct {
+	Currency Currency  `json:"currency"`
+	Base     Currency  `json:"base"` // defaults to UZS
+	Date     time.Time `json:"date"` // defaults to today
+	Rate     float64   `json:"rate"`
+}
+
+func (i *ExchangeRateInput) Validate() error {
+	if i.Base == "" {
+		i.Base = DefaultCurrency
+	}
+	if err := ValidateCurrency(i.Currency); err != nil {
+		return err
+	}
+	if err := ValidateCurrency(i.Base); err != nil {
+		return err
+	}
+	if i.Currency == i.Base {
+		return errors.New("currency and base must differ")
+	}
+	if i.Rate <= 0 || math.IsInf(i.Rate, 0) || math.IsNaN(i.Rate) {
+		return errors.New("rate must be greater than 0")
+	}
+	if i.Date.IsZero() {
+		i.Date = time.Now()
+	}
+	return nil
+}
+
+func NewExchangeRate(input *ExchangeRateInput, created_by string) *ExchangeRate {
+	now := time.Now().In(utils.GetTimeZone())
+	return &ExchangeRate{
+		ID:        uuid.New().String(),
+		Currency:  input.Currency,
+		Base:      input.Base,
+		Date:      RateDay(input.Date),
+		Rate:      input.Rate,
+		CreatedBy: created_by,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+type ExchangeRateQueryParams struct {
+	Currency Currency  `query:"currency"`
+	Base     Currency  `query:"base"`
+	DateMin  time.Time `query:"date_min"`
+	DateMax  time.Time `query:"date_max"`
+}
+
+// ExchangeRateLookupParams asks for the rate converting the currency to the base on the date
+type ExchangeRateLookupParams struct {
+	Currency Currency  `query:"currency"`
+	Base     Currency  `query:"base"` // defaults to UZS
+	Date     time.Time `query:"date"` // defaults to now
+}
+
+func (p *ExchangeRateLookupParams) Validate() error {
+	if p.Base == "" {
+		p.Base = DefaultCurrency
+	}
+	if err := ValidateCurrency(p.Currency); err != nil {
+		return err
+	}
+	if p.Date.IsZero() {
+		p.Date = time.Now()
+	}
+	return ValidateCurrency(p.Base)
+}
+
+type ExchangeRateOutput struct {
+	Data  []ExchangeRate `json:"data"`
+	Error []Error        `json:"error"`
+}
+
+type ExchangeRateOutputSingle struct {
+	Data  ExchangeRate `json:"data"`
+	Error []Error      `json:"error"`
+}
+
+// ExchangeRatesCollection returns the exchange rates collection of the database of the given collection
+func ExchangeRatesCollection(of *mongo.Collection) *mongo.Collection {
+	return of.Database().Collection("exchange_rates")
+}
+
+// FindExchangeRate returns the rate converting from the currency to the base on the date: the latest stored rate
+// of the day or before it. Rates stored the other way round are inverted, the same currency converts at 1
+func FindExchangeRate(ctx context.Context, collection *mongo.Collection, currency Currency, base Currency, date time.Time) (*ExchangeRate, error) {
+	day := RateDay(date)
+	if currency == base {
+		return &ExchangeRate{Currency: currency, Base: base, Date: day, Rate: 1}, nil
+	}
+	opts := options.FindOne().SetSort(bson.M{"date": -1})
+	find := func(currency Currency, base Currency) (*ExchangeRate, error) {
+		rate := &ExchangeRate{}
+		err := collection.FindOne(ctx, bson.M{"currency": currency, "base": base, "date": bson.M{"$lte": day}}, opts).Decode(rate)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return rate, err
+	}
+
+	rate, err := find(currency, base)
+	if err != nil || rate != nil {
+		return rate, err
+	}
+	rate, err = find(base, currency)
+	if err != nil {
+		return nil, err
+	}
+	if rate == nil {
+		return nil, fmt.Errorf("%w: %s to %s on %s", ErrExchangeRateNotFound, currency, base, day.Format(time.DateOnly))
+	}
+	return rate.Inverse(), nil
+}
+
+// BranchCurrency returns the base currency of the branch kept on its finance. Warehouses have no finance
+// and finances created before currencies have no currency, both are in the default currency
+func BranchCurrency(ctx context.Context, financeCollection *mongo.Collection, branch_id string) (Currency, error) {
+	finance := BranchFinance{}
+	opts := options.FindOne().SetProjection(bson.M{"currency": 1})
+	err := financeCollection.FindOne(ctx, bson.M{"branch_id": branch_id}, opts).Decode(&finance)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return "", err
+	}
+	return finance.Currency.OrDefault(), nil
+}
+
+// InBranchCurrency records the transaction in the base currency of its branch, ErrForeignCurrency if it is in another currency.
+// Sales, journals, expenses, BNPL and stock losses are always in the base currency of the branch
+func (t *Transaction) InBranchCurrency(ctx context.Context, financeCollection *mongo.Collection) error {
+	base, err := BranchCurrency(ctx, financeCollection, t.BranchID)
+	if err != nil {
+		return err
+	}
+	return t.InBaseCurrency(base)
+}
+
+// MoneyMigrationReport tells how many documents got their amounts converted to 64-bit integers and the default currency
+type MoneyMigrationReport struct {
+	Transactions int64 `json:"transactions"`
+	Finances     int64 `json:"finances"`
+	Branches     int64 `json:"branches"`
+	Suppliers    int64 `json:"suppliers"`
+	Journals     int64 `json:"journals"`
+	Receipts     int64 `json:"receipts"`
+	Expenses     int64 `json:"expenses"`
+}
+
+type MoneyMigrationOutput struct {
+	Data  MoneyMigrationReport `json:"data"`
+	Error []Error              `json:"error"`
+}
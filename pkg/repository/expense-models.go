@@ -0,0 +1,336 @@
+package models
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/utils"
+
+	"github.com/google/uuid"
+)
+
+// expense types of the categories --- they decide the expense account of the approved expenses in the ledger
+var expenseTypes = []InitiatorType{
+	InitiatorTypeSalary,
+	InitiatorTypeRent,
+	InitiatorTypeUtilities,
+	InitiatorTypeOther,
+}
+
+func ValidateExpenseType(expenseType InitiatorType) error {
+	if !slices.Contains(expenseTypes, expenseType) {
+		return errors.New("invalid expense type " + string(expenseType))
+	}
+	return nil
+}
+
+// ExpenseCategory groups internal expenses of the branches in reports
+type ExpenseCategory struct {
+	ID          string        `json:"id" bson:"_id"`
+	Name        string        `json:"name" bson:"name"`
+	Type        InitiatorType `json:"type" bson:"type"` // salary, rent, utilities or other
+	Description string        `json:"description" bson:"description"`
+	CreatedAt   time.Time     `json:"created_at" bson:"created_at"`
+}
+
+type ExpenseCategoryInput struct {
+	Name        string        `json:"name"`
+	Type        InitiatorType `json:"type"`
+	Description string        `json:"description"`
+}
+
+func (i *ExpenseCategoryInput) Validate() error {
+	if i.Name == "" {
+		return errors.New("name is required")
+	}
+	if i.Type == "" {
+		i.Type = InitiatorTypeOther
+	}
+	return ValidateExpenseType(i.Type)
+}
+
+func NewExpenseCategory(input *ExpenseCategoryInput) *ExpenseCategory {
+	return &ExpenseCategory{
+		ID:          uuid.New().String(),
+		Name:        input.Name,
+		Type:        input.Type,
+		Description: input.Description,
+		CreatedAt:   time.Now(),
+	}
+}
+
+type ExpenseStatus string
+
+const (
+	ExpenseStatusPending  ExpenseStatus = "pending"  // waits for the approval of a manager, finance is not changed yet
+	ExpenseStatusApproved ExpenseStatus = "approved" // paid --- the transaction is recorded and the finance is changed
+	ExpenseStatusRejected ExpenseStatus = "rejected"
+)
+
+// Expense is an internal cost of a branch (salaries, rent, utilities...) paid after approval
+type Expense struct {
+	ID              string        `json:"id" bson:"_id"`
+	BranchID        string        `json:"branch_id" bson:"branch_id"`
+	CategoryID      string        `json:"category_id" bson:"category_id"`
+	Category        string        `json:"category" bson:"category"` // name of the category at the time of creation
+	Type            InitiatorType `json:"type" bson:"type"`         // type of the category, initiator type of the transaction
+	Amount          int64         `json:"amount" bson:"amount"`
+	PaymentMethod   PaymentMethod `json:"payment_method" bson:"payment_method"`
+	Description     string        `json:"description" bson:"description"`
+	Date            time.Time     `json:"date" bson:"date"`               // date the expense is incurred
+	Attachments     []string      `json:"attachments" bson:"attachments"` // keys of the invoices and receipts in the storage
+	Status          ExpenseStatus `json:"status" bson:"status"`
+	RecurringID     string        `json:"recurring_id,omitempty" bson:"recurring_id,omitempty"`     // template the expense was generated from
+	TransactionID   string        `json:"transaction_id,omitempty" bson:"transaction_id,omitempty"` // transaction recorded on approval
+	RejectionReason string        `json:"rejection_reason,omitempty" bson:"rejection_reason,omitempty"`
+	CreatedBy       string        `json:"created_by" bson:"created_by"`
+	CreatedAt       time.Time     `json:"created_at" bson:"created_at"`
+	ReviewedBy      string        `json:"reviewed_by,omitempty" bson:"reviewed_by,omitempty"`
+	ReviewedAt      time.Time     `json:"reviewed_at" bson:"reviewed_at"`
+}
+
+type NewExpenseInput struct {
+	BranchID      string        `json:"branch_id"`
+	CategoryID    string        `json:"category_id"`
+	Amount        int64         `json:"amount"`
+	PaymentMethod PaymentMethod `json:"payment_method"`
+	Description   string        `json:"description"`
+	Date          time.Time     `json:"date"` // now if not set
+}
+
+func (i *NewExpenseInput) Validate() error {
+	if i.BranchID == "" {
+		return errors.New("branch_id is required")
+	}
+	if i.CategoryID == "" {
+		return errors.New("category_id is required")
+	}
+	if i.Amount <= 0 {
+		return errors.New("amount must be greater than 0")
+	}
+	return ValidatePaymentMethod(i.PaymentMethod)
+}
+
+func NewExpense(input *NewExpenseInput, category *ExpenseCategory, created_by string) *Expense {
+	date := input.Date
+	if date.IsZero() {
+		date = time.Now().In(utils.GetTimeZone())
+	}
+	return &Expense{
+		ID:            uuid.New().String(),
+		BranchID:      input.BranchID,
+		CategoryID:    category.ID,
+		Category:      category.Name,
+		Type:          category.Type,
+		Amount:        input.Amount,
+		PaymentMethod: input.PaymentMethod,
+		Description:   input.Description,
+		Date:          date,
+		Attachments:   []string{},
+		Status:        ExpenseStatusPending,
+		CreatedBy:     created_by,
+		CreatedAt:     time.Now(),
+	}
+}
+
+// Transaction returns the debit transaction paying the expense from the branch
+func (e *Expense) Transaction() *Transaction {
+	description := e.Category
+	if e.Description != "" {
+		description += ": " + e.Description
+	}
+	return NewTransaction(&TransactionBase{
+		Amount:        e.Amount,
+		Description:   description,
+		Type:          TransactionTypeDebit,
+		PaymentMethod: e.PaymentMethod,
+	}, e.Type, e.BranchID)
+}
+
+type RejectExpenseInput struct {
+	Reason string `json:"reason"`
+}
+
+type ExpenseQueryParams struct {
+	BranchID    string        `query:"branch_id"`
+	CategoryID  string        `query:"category_id"`
+	Status      ExpenseStatus `query:"status"`
+	RecurringID string        `query:"recurring_id"`
+	DateMin     time.Time     `query:"date_min"`
+	DateMax     time.Time     `query:"date_max"`
+	Page        int           `query:"page" default:"1"`
+	Count       int           `query:"count" default:"25"`
+}
+
+// RecurringExpense is a template of an expense generated every month on a day (rent, utilities...).
+// Generated expenses wait for approval like the ones created by hand
+type RecurringExpense struct {
+	ID            string        `json:"id" bson:"_id"`
+	BranchID      string        `json:"branch_id" bson:"branch_id"`
+	CategoryID    string        `json:"category_id" bson:"category_id"`
+	Amount        int64         `json:"amount" bson:"amount"`
+	PaymentMethod PaymentMethod `json:"payment_method" bson:"payment_method"`
+	Description   string        `json:"description" bson:"description"`
+	DayOfMonth    int           `json:"day_of_month" bson:"day_of_month"` // 1-28 so that every month has the day
+	Active        bool          `json:"active" bson:"active"`
+	NextRun       time.Time     `json:"next_run" bson:"next_run"` // date of the next expense to generate
+	LastRun       time.Time     `json:"last_run" bson:"last_run"` // date of the last generated expense
+	CreatedBy     string        `json:"created_by" bson:"created_by"`
+	CreatedAt     time.Time     `json:"created_at" bson:"created_at"`
+}
+
+type RecurringExpenseInput struct {
+	BranchID      string        `json:"branch_id"`
+	CategoryID    string        `json:"category_id"`
+	Amount        int64         `json:"amount"`
+	PaymentMethod PaymentMethod `json:"payment_method"`
+	Description   string        `json:"description"`
+	DayOfMonth    int           `json:"day_of_month"`
+	Active        *bool         `json:"active"` // active if not set, false pauses the template
+}
+
+func (i *RecurringExpenseInput) Validate() error {
+	if i.DayOfMonth < 1 || i.DayOfMonth > 28 {
+		return errors.New("day_of_month must be between 1 and 28")
+	}
+	expense := NewExpenseInput{
+		BranchID:      i.BranchID,
+		CategoryID:    i.CategoryID,
+		Amount:        i.Amount,
+		PaymentMethod: i.PaymentMethod,
+	}
+	return expense.Validate()
+}
+
+func NewRecurringExpense(input *RecurringExpenseInput, created_by string) *RecurringExpense {
+	recurring := &RecurringExpense{
+		ID:        uuid.New().String(),
+		CreatedBy: created_by,
+		CreatedAt: time.Now(),
+	}
+	active := true
+	if input.Active != nil {
+		active = *input.Active
+	}
+	recurring.Update(input, active)
+	return recurring
+}
+
+// Update changes the template to the input. The next run is moved if the day of the month changes or the template
+// is resumed, so that paused months are not generated afterwards
+func (r *RecurringExpense) Update(input *RecurringExpenseInput, active bool) {
+	reschedule := r.NextRun.IsZero() || r.DayOfMonth != input.DayOfMonth || (active && !r.Active)
+	r.BranchID = input.BranchID
+	r.CategoryID = input.CategoryID
+	r.Amount = input.Amount
+	r.PaymentMethod = input.PaymentMethod
+	r.Description = input.Description
+	r.DayOfMonth = input.DayOfMonth
+	r.Active = active
+	if reschedule {
+		// today is still due
+		r.NextRun = NextRecurringDate(time.Now().In(utils.GetTimeZone()).AddDate(0, 0, -1), r.DayOfMonth)
+	}
+}
+
+// NextRecurringDate returns the first day_of_month after the date, at the start of the day
+func NextRecurringDate(after time.Time, day_of_month int) time.Time {
+	next := time.Date(after.Year(), after.Month(), day_of_month, 0, 0, 0, 0, after.Location())
+	if !next.After(after) {
+		next = next.AddDate(0, 1, 0)
+	}
+	return next
+}
+
+// Due returns the dates of the expenses to generate up to now and moves the next run after them
+func (r *RecurringExpense) Due(now time.Time) []time.Time {
+	dates := []time.Time{}
+	for r.Active && !r.NextRun.After(now) {
+		dates = append(dates, r.NextRun)
+		r.LastRun = r.NextRun
+		r.NextRun = NextRecurringDate(r.NextRun, r.DayOfMonth)
+	}
+	return dates
+}
+
+// Expense returns the input of the expense generated by the template for the date
+func (r *RecurringExpense) Expense(date time.Time) *NewExpenseInput {
+	return &NewExpenseInput{
+		BranchID:      r.BranchID,
+		CategoryID:    r.CategoryID,
+		Amount:        r.Amount,
+		PaymentMethod: r.PaymentMethod,
+		Description:   r.Description,
+		Date:          date,
+	}
+}
+
+type ExpenseReportPeriod string
+
+const (
+	ExpenseReportPeriodDay   ExpenseReportPeriod = "day"
+	ExpenseReportPeriodMonth ExpenseReportPeriod = "month"
+	ExpenseReportPeriodYear  ExpenseReportPeriod = "year"
+)
+
+// Format returns the date format of $dateToString grouping the expenses by the period
+func (p ExpenseReportPeriod) Format() (string, error) {
+	switch p {
+	case ExpenseReportPeriodDay:
+		return "%Y-%m-%d", nil
+	case ExpenseReportPeriodMonth, "":
+		return "%Y-%m", nil
+	case ExpenseReportPeriodYear:
+		return "%Y", nil
+	}
+	return "", errors.New("invalid period " + string(p))
+}
+
+type ExpenseReportQueryParams struct {
+	BranchID string              `query:"branch_id"`
+	Period   ExpenseReportPeriod `query:"period"` // day, month (default) or year
+	Status   ExpenseStatus       `query:"status"` // approved if not set
+	DateMin  time.Time           `query:"date_min"`
+	DateMax  time.Time           `query:"date_max"`
+}
+
+// ExpenseReportRow is the total of the expenses of a category in a period
+type ExpenseReportRow struct {
+	Period     string `json:"period" bson:"period"`
+	CategoryID string `json:"category_id" bson:"category_id"`
+	Category   string `json:"category" bson:"category"`
+	Count      int64  `json:"count" bson:"count"`
+	Total      int64  `json:"total" bson:"total"`
+}
+
+type ExpenseReport struct {
+	Period ExpenseReportPeriod `json:"period" bson:"period"`
+	Rows   []ExpenseReportRow  `json:"rows" bson:"rows"`
+	Total  int64               `json:"total" bson:"total"`
+}
+
+type ExpenseCategoryOutput struct {
+	Data  []ExpenseCategory `json:"data" bson:"data"`
+	Error []Error           `json:"error" bson:"error"`
+}
+
+type ExpenseOutput struct {
+	Data  []Expense `json:"data" bson:"data"`
+	Error []Error   `json:"error" bson:"error"`
+}
+
+type ExpenseOutputSingle struct {
+	Data  Expense `json:"data" bson:"data"`
+	Error []Error `json:"error" bson:"error"`
+}
+
+type RecurringExpenseOutput struct {
+	Data  []RecurringExpense `json:"data" bson:"data"`
+	Error []Error            `json:"error" bson:"error"`
+}
+
+type ExpenseReportOutput struct {
+	Data  ExpenseReport `json:"data" bson:"data"`
+	Error []Error       `json:"error" bson:"error"`
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"fmt"
 	"slices"
 	"time"
@@ -28,7 +29,7 @@ type TransactionOutput struct {
 	Error []Error       `json:"error" bson:"error"`
 }
 
-func NewTransactionBase(amount uint32, description string, typeOfTransaction TransactionType) *TransactionBase {
+func NewTransactionBase(amount int64, description string, typeOfTransaction TransactionType) *TransactionBase {
 	return &TransactionBase{
 		Amount:      amount,
 		Description: description,
@@ -68,19 +69,74 @@ const (
 )
 
 type TransactionBase struct {
-	Amount        uint32          `json:"amount" bson:"amount"`
+	Amount        int64           `json:"amount" bson:"amount"`
 	Description   string          `json:"description" bson:"description"`
 	Type          TransactionType `json:"type" bson:"type"`
 	PaymentMethod PaymentMethod   `json:"payment_method" bson:"payment_method"`
+	Currency      Currency        `json:"currency,omitempty" bson:"currency"` // currency of the amount, the base currency of the branch if not set
+}
+
+// Validate checks the amount and currency given by the client
+func (t *TransactionBase) Validate() error {
+	if t.Amount < 0 {
+		return ErrNegativeAmount
+	}
+	if t.Currency != "" {
+		return ValidateCurrency(t.Currency)
+	}
+	return nil
 }
 
 type Transaction struct {
 	TransactionBase
-	Type      InitiatorType `json:"type" bson:"type"`
-	ID        string        `json:"id" bson:"_id"`
-	CreatedAt time.Time     `json:"created_at" bson:"created_at"`
-	UpdatedAt time.Time     `json:"updated_at" bson:"updated_at"`
-	BranchID  string        `json:"branch_id" bson:"branch_id"`
+	Type       InitiatorType `json:"type" bson:"type"`
+	ID         string        `json:"id" bson:"_id"`
+	CreatedAt  time.Time     `json:"created_at" bson:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at" bson:"updated_at"`
+	BranchID   string        `json:"branch_id" bson:"branch_id"`
+	ReceiptID  string        `json:"receipt_id,omitempty" bson:"receipt_id,omitempty"`   // receipt the transaction is a tender of
+	CreatedBy  string        `json:"created_by,omitempty" bson:"created_by,omitempty"`   // user (or api key) the transaction was created by
+	Terminal   string        `json:"terminal,omitempty" bson:"terminal,omitempty"`       // terminal the transaction was created on
+	TransferID string        `json:"transfer_id,omitempty" bson:"transfer_id,omitempty"` // money transfer the transaction is one side of
+	BaseAmount int64         `json:"base_amount" bson:"base_amount"`                     // amount in the base currency of the branch, balances, ledger and reports use it
+	Rate       float64       `json:"rate,omitempty" bson:"rate,omitempty"`               // exchange rate the amount was converted at, only for foreign currencies
+	Version    int           `json:"version,omitempty" bson:"version,omitempty"`         // number of edits and voids, prior versions are kept in transaction_versions
+	Voided     bool          `json:"voided,omitempty" bson:"voided,omitempty"`           // voided transactions have no effect on the finance, journal and ledger
+}
+
+// InBaseCurrency records the transaction in the base currency of its branch, ErrForeignCurrency if it is in another currency
+func (t *Transaction) InBaseCurrency(base Currency) error {
+	if t.Currency == "" {
+		t.Currency = base
+	}
+	if t.Currency != base {
+		return ErrForeignCurrency
+	}
+	t.BaseAmount = t.Amount
+	t.Rate = 0
+	return nil
+}
+
+// Convert records the amount of the transaction in the base currency at the rate
+func (t *Transaction) Convert(rate *ExchangeRate) {
+	t.BaseAmount = rate.Convert(t.Amount)
+	t.Rate = rate.Rate
+}
+
+// AmountInBase returns the amount in the base currency of the branch. Transactions written before currencies
+// have no base amount, their amount is in the default currency
+func (t *Transaction) AmountInBase() int64 {
+	if t.BaseAmount == 0 {
+		return t.Amount
+	}
+	return t.BaseAmount
+}
+
+// Attribute records who created the transaction and on which terminal from the attribution of the context
+func (t *Transaction) Attribute(ctx context.Context) {
+	attribution := AttributionOf(ctx)
+	t.CreatedBy = attribution.User
+	t.Terminal = attribution.Terminal
 }
 
 func NewTransaction(transactionBase *TransactionBase, typeOfTransaction InitiatorType, branchID string) *Transaction {
@@ -92,13 +148,14 @@ func NewTransaction(transactionBase *TransactionBase, typeOfTransaction Initiato
 		CreatedAt:       time.Now().In(loc),
 		UpdatedAt:       time.Now().In(loc),
 		BranchID:        branchID,
+		BaseAmount:      transactionBase.Amount,
 	}
 }
 
 type TransactionQueryParams struct {
 	Description       string          `query:"description"`
-	AmountMin         uint32          `query:"amount_min"`
-	AmountMax         uint32          `query:"amount_max"`
+	AmountMin         int64           `query:"amount_min"`
+	AmountMax         int64           `query:"amount_max"`
 	DateMin           time.Time       `query:"date_min"`
 	DateMax           time.Time       `query:"date_max"`
 	PaymentMethod     PaymentMethod   `query:"payment_method"`
@@ -142,6 +199,10 @@ func ValidateInitiatorType(initiatorType InitiatorType) error {
 		InitiatorTypeOther,
 		InitiatorTypeSales,
 		InitiatorTypeSupplier,
+		InitiatorTypeBNPL,
+		InitiatorTypeWriteOff,
+		InitiatorTypeShrinkage,
+		InitiatorTypeTransfer,
 	}
 	if !slices.Contains(initiatorTypes, initiatorType) {
 		return fmt.Errorf("invalid initiator type")
@@ -173,17 +234,17 @@ func (t *TransactionQueryParams) Validate() error {
 // then we need to add it to the mobile apps balance
 // if apps are used to pay using qr code or app service then it is considered as bank transfer
 type Balance struct {
-	Cash       int32 `json:"cash" bson:"cash"`
-	Bank       int32 `json:"bank" bson:"bank"`
-	Terminal   int32 `json:"terminal" bson:"terminal"`
-	MobileApps int32 `json:"mobile_apps" bson:"mobile_apps"`
+	Cash       int64 `json:"cash" bson:"cash"`
+	Bank       int64 `json:"bank" bson:"bank"`
+	Terminal   int64 `json:"terminal" bson:"terminal"`
+	MobileApps int64 `json:"mobile_apps" bson:"mobile_apps"`
 }
 
 type Finance struct {
 	Balance       Balance `json:"balance" bson:"balance"`
-	TotalIncome   int32   `json:"total_income" bson:"total_income"`
-	TotalExpenses int32   `json:"total_expenses" bson:"total_expenses"`
-	Debt          int32   `json:"debt" bson:"debt"`
+	TotalIncome   int64   `json:"total_income" bson:"total_income"`
+	TotalExpenses int64   `json:"total_expenses" bson:"total_expenses"`
+	Debt          int64   `json:"debt" bson:"debt"`
 }
 
 type FinanceWithTransactions struct {
@@ -193,13 +254,38 @@ type FinanceWithTransactions struct {
 
 type BranchFinance struct {
 	Finance
+	Currency   Currency    `json:"currency" bson:"currency"` // base currency of the branch the balances are kept in
 	Suppliers  []string    `json:"suppliers" bson:"suppliers"`
 	BranchID   string      `json:"branch_id" bson:"branch_id"`
 	BranchName string      `json:"branch_name" bson:"branch_name"`
 	Details    interface{} `json:"details" bson:"details"`
 }
 
+// NewBranchFinance returns the empty finance of the branch
+func NewBranchFinance(branch *Branch, details interface{}) BranchFinance {
+	return BranchFinance{
+		BranchID:   branch.ID,
+		BranchName: branch.Name,
+		Currency:   branch.Currency.OrDefault(),
+		Details:    details,
+		Finance: Finance{
+			Balance: Balance{
+				Cash:       0,
+				Bank:       0,
+				MobileApps: 0,
+			},
+			TotalIncome:   0,
+			TotalExpenses: 0,
+			Debt:          0,
+		},
+		Suppliers: []string{},
+	}
+}
+
+// NewBranchFinanceInput creates the finance of the branch. The branch is found by branch_id or by branch_name,
+// a new branch is created if there is no branch with the name
 type NewBranchFinanceInput struct {
+	BranchID   string      `json:"branch_id"`
 	BranchName string      `json:"branch_name"`
 	Details    interface{} `json:"details"`
 }
@@ -6,44 +6,22 @@ import (
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
-type Branch struct {
+// BranchRef is the snapshot of the branch kept in journals, the branch is referenced by its ID
+type BranchRef struct {
 	Name     string `bson:"name" json:"name"`
 	Location string `bson:"location" json:"location"`
 	Phone    string `bson:"phone" json:"phone"`
 	ID       string `bson:"_id" json:"id"`
 }
 
-func DoesBranchExist(branch string) bool {
-	_, ok := Branch_names[branch]
-	return ok
-}
-
-var Branch_names map[string]Branch = map[string]Branch{
-	"Xonobod": {
-		Name:     "Xonobod",
-		Location: "Xonobod",
-		Phone:    "+998 97 034 38 58",
-	},
-	"Yangi Hayot": {
-		Name:     "Yangi Hayot",
-		Location: "Yangi Hayot Qo'rg'ontepa mahallasi",
-		Phone:    "+998 33 119 12 13",
-	},
-	"Polevoy": {
-		Name:     "Polevoy",
-		Location: "Polevoy Savatchi mahallasi",
-		Phone:    "+998 33 119 12 13",
-	},
-}
-
 type JournalBase struct {
-	Branch          Branch        `bson:"branch" json:"branch"`
+	Branch          BranchRef     `bson:"branch" json:"branch"`
 	Date            time.Time     `bson:"date" json:"date"`
 	ID              bson.ObjectID `bson:"_id" json:"id"`
 	Shift_is_closed bool          `bson:"shift_is_closed" json:"shift_is_closed"`
-	Terminal_income uint32        `bson:"terminal_income" json:"terminal_income"`
-	Cash_left       uint32        `bson:"cash_left" json:"cash_left"`
-	Total           uint32        `bson:"total" json:"total"`
+	Terminal_income int64         `bson:"terminal_income" json:"terminal_income"`
+	Cash_left       int64         `bson:"cash_left" json:"cash_left"`
+	Total           int64         `bson:"total" json:"total"`
 }
 
 type Journal struct {
@@ -62,8 +40,8 @@ type NewJournalEntryInput struct {
 }
 
 type TotalValueQueryParams struct {
-	Min int32 `query:"min" default:"-1"`
-	Max int32 `query:"max" default:"30000000"`
+	Min int64 `query:"min" default:"-1"`
+	Max int64 `query:"max" default:"30000000"`
 	Use bool  `query:"used" default:"false"`
 }
 
@@ -83,8 +61,8 @@ type JournalOperationInput struct {
 }
 
 type CloseJournalEntryInput struct {
-	CashLeft       uint32 `json:"cash_left"`
-	TerminalIncome uint32 `json:"terminal_income"`
+	CashLeft       int64 `json:"cash_left"`
+	TerminalIncome int64 `json:"terminal_income"`
 }
 
 type JournalOutput struct {
@@ -96,8 +74,8 @@ type JournalOutputList struct {
 	Error []Error   `json:"error"`
 }
 
-func (j *Journal) GetSummOfTotals(journals []*Journal) uint32 {
-	var total uint32
+func (j *Journal) GetSummOfTotals(journals []*Journal) int64 {
+	var total int64
 	for _, journal := range journals {
 		total += journal.Total
 	}
@@ -0,0 +1,386 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/utils"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrUnbalancedEntry = errors.New("debits and credits of the ledger entry are not equal")
+	ErrEmptyEntry      = errors.New("ledger entry has no amount")
+)
+
+type AccountType string
+
+const (
+	AccountTypeAsset     AccountType = "asset"
+	AccountTypeLiability AccountType = "liability"
+	AccountTypeEquity    AccountType = "equity"
+	AccountTypeIncome    AccountType = "income"
+	AccountTypeExpense   AccountType = "expense"
+)
+
+// AccountCode is the code of an account of the chart of accounts
+type AccountCode string
+
+const (
+	AccountCash           AccountCode = "1000"
+	AccountBank           AccountCode = "1010"
+	AccountTerminal       AccountCode = "1020" // card payments not yet settled into the bank
+	AccountMobileApps     AccountCode = "1030" // click, payme, paynet and other wallets
+	AccountCheques        AccountCode = "1040"
+	AccountTransfers      AccountCode = "1090" // money in transit between buckets and branches, zero over all branches
+	AccountReceivables    AccountCode = "1100" // BNPLs of customers
+	AccountInventory      AccountCode = "1200"
+	AccountPayables       AccountCode = "2000" // debt to suppliers
+	AccountEquity         AccountCode = "3000" // opening balances
+	AccountReconciliation AccountCode = "3900" // corrective adjustments of the finance found by the reconciliation
+	AccountSales          AccountCode = "4000"
+	AccountSalesReturns   AccountCode = "4010"
+	AccountOtherIncome    AccountCode = "4900"
+	AccountSalaries       AccountCode = "5000"
+	AccountRent           AccountCode = "5010"
+	AccountUtilities      AccountCode = "5020"
+	AccountOtherExpenses  AccountCode = "5090"
+	AccountShrinkage      AccountCode = "5100" // write-offs and stocktake shrinkage
+)
+
+type Account struct {
+	Code AccountCode `json:"code" bson:"code"`
+	Name string      `json:"name" bson:"name"`
+	Type AccountType `json:"type" bson:"type"`
+}
+
+// ChartOfAccounts is the same for every branch, balances are kept per branch
+var ChartOfAccounts = []Account{
+	{Code: AccountCash, Name: "Cash", Type: AccountTypeAsset},
+	{Code: AccountBank, Name: "Bank", Type: AccountTypeAsset},
+	{Code: AccountTerminal, Name: "Terminal", Type: AccountTypeAsset},
+	{Code: AccountMobileApps, Name: "Mobile apps", Type: AccountTypeAsset},
+	{Code: AccountCheques, Name: "Cheques", Type: AccountTypeAsset},
+	{Code: AccountTransfers, Name: "Internal transfers", Type: AccountTypeAsset},
+	{Code: AccountReceivables, Name: "Receivables", Type: AccountTypeAsset},
+	{Code: AccountInventory, Name: "Inventory", Type: AccountTypeAsset},
+	{Code: AccountPayables, Name: "Payables", Type: AccountTypeLiability},
+	{Code: AccountEquity, Name: "Equity", Type: AccountTypeEquity},
+	{Code: AccountReconciliation, Name: "Reconciliation adjustments", Type: AccountTypeEquity},
+	{Code: AccountSales, Name: "Sales", Type: AccountTypeIncome},
+	{Code: AccountSalesReturns, Name: "Sales returns", Type: AccountTypeIncome},
+	{Code: AccountOtherIncome, Name: "Other income", Type: AccountTypeIncome},
+	{Code: AccountSalaries, Name: "Salaries", Type: AccountTypeExpense},
+	{Code: AccountRent, Name: "Rent", Type: AccountTypeExpense},
+	{Code: AccountUtilities, Name: "Utilities", Type: AccountTypeExpense},
+	{Code: AccountOtherExpenses, Name: "Other expenses", Type: AccountTypeExpense},
+	{Code: AccountShrinkage, Name: "Shrinkage and write-offs", Type: AccountTypeExpense},
+}
+
+// FindAccount returns the account of the chart of accounts with the code
+func FindAccount(code AccountCode) (Account, bool) {
+	for _, account := range ChartOfAccounts {
+		if account.Code == code {
+			return account, true
+		}
+	}
+	return Account{}, false
+}
+
+// DebitNormal is true for accounts which grow with debits (assets and expenses)
+func (a Account) DebitNormal() bool {
+	return a.Type == AccountTypeAsset || a.Type == AccountTypeExpense
+}
+
+// AccountOfPaymentMethod returns the account money of the payment method is kept in
+func AccountOfPaymentMethod(payment_method PaymentMethod) (AccountCode, error) {
+	switch payment_method {
+	case PaymentMethodCash:
+		return AccountCash, nil
+	case PaymentMethodBank:
+		return AccountBank, nil
+	case PaymentMethodTerminal:
+		return AccountTerminal, nil
+	case OnlineMobileAppPayment, OnlineTransfer:
+		return AccountMobileApps, nil
+	case Cheque:
+		return AccountCheques, nil
+	}
+	return "", fmt.Errorf("no account for payment method %s", payment_method)
+}
+
+// expense accounts of transactions which are not sales, suppliers or bnpls
+var expenseAccounts = map[InitiatorType]AccountCode{
+	InitiatorTypeSalary:    AccountSalaries,
+	InitiatorTypeRent:      AccountRent,
+	InitiatorTypeUtilities: AccountUtilities,
+	InitiatorTypeOther:     AccountOtherExpenses,
+	InitiatorTypeWriteOff:  AccountShrinkage,
+	InitiatorTypeShrinkage: AccountShrinkage,
+}
+
+// LedgerLine is one side of a ledger entry, either Debit or Credit is set
+type LedgerLine struct {
+	Account AccountCode `json:"account" bson:"account"`
+	Debit   int64       `json:"debit" bson:"debit"`
+	Credit  int64       `json:"credit" bson:"credit"`
+}
+
+func DebitLine(account AccountCode, amount int64) LedgerLine {
+	return LedgerLine{Account: account, Debit: amount}
+}
+
+func CreditLine(account AccountCode, amount int64) LedgerLine {
+	return LedgerLine{Account: account, Credit: amount}
+}
+
+// LedgerEntry is a balanced posting of a financial event of a branch. Entries are never changed,
+// a posted entry is cancelled by a reversing entry
+type LedgerEntry struct {
+	ID            string        `json:"id" bson:"_id"`
+	BranchID      string        `json:"branch_id" bson:"branch_id"`
+	Date          time.Time     `json:"date" bson:"date"` // date the event happened at, trial balances are taken by it
+	Source        InitiatorType `json:"source" bson:"source"`
+	TransactionID string        `json:"transaction_id,omitempty" bson:"transaction_id,omitempty"`
+	Reference     string        `json:"reference,omitempty" bson:"reference,omitempty"` // document of the event without transaction (bnpl, ...)
+	Reverses      string        `json:"reverses,omitempty" bson:"reverses,omitempty"`   // entry reversed by this entry
+	Description   string        `json:"description" bson:"description"`
+	Lines         []LedgerLine  `json:"lines" bson:"lines"`
+	CreatedBy     string        `json:"created_by,omitempty" bson:"created_by,omitempty"`
+	Terminal      string        `json:"terminal,omitempty" bson:"terminal,omitempty"`
+	CreatedAt     time.Time     `json:"created_at" bson:"created_at"`
+}
+
+// InitiatorTypeOpening is the source of entries which bring balances kept before the ledger into it
+const InitiatorTypeOpening InitiatorType = "opening"
+
+// InitiatorTypeReconciliation is the source of entries of corrective adjustments of the finance
+const InitiatorTypeReconciliation InitiatorType = "reconciliation"
+
+func NewLedgerEntry(ctx context.Context, branch_id string, source InitiatorType, description string, lines ...LedgerLine) *LedgerEntry {
+	now := time.Now().In(utils.GetTimeZone())
+	attribution := AttributionOf(ctx)
+	return &LedgerEntry{
+		ID:          uuid.New().String(),
+		BranchID:    branch_id,
+		Date:        now,
+		Source:      source,
+		Description: description,
+		Lines:       lines,
+		CreatedBy:   attribution.User,
+		Terminal:    attribution.Terminal,
+		CreatedAt:   now,
+	}
+}
+
+// Validate checks that the entry is balanced and posts to known accounts only
+func (e *LedgerEntry) Validate() error {
+	if e.BranchID == "" {
+		return errors.New("branch_id is required")
+	}
+	var debits, credits int64
+	for _, line := range e.Lines {
+		if _, ok := FindAccount(line.Account); !ok {
+			return fmt.Errorf("unknown account %s", line.Account)
+		}
+		if line.Debit < 0 || line.Credit < 0 || (line.Debit != 0 && line.Credit != 0) {
+			return fmt.Errorf("line of account %s must have either a positive debit or a positive credit", line.Account)
+		}
+		debits += line.Debit
+		credits += line.Credit
+	}
+	if debits != credits {
+		return ErrUnbalancedEntry
+	}
+	if debits == 0 {
+		return ErrEmptyEntry
+	}
+	return nil
+}
+
+// Reversal returns the entry cancelling e --- debits and credits are swapped
+func (e *LedgerEntry) Reversal(ctx context.Context, description string) *LedgerEntry {
+	lines := make([]LedgerLine, 0, len(e.Lines))
+	for _, line := range e.Lines {
+		lines = append(lines, LedgerLine{Account: line.Account, Debit: line.Credit, Credit: line.Debit})
+	}
+	reversal := NewLedgerEntry(ctx, e.BranchID, e.Source, description, lines...)
+	reversal.TransactionID = e.TransactionID
+	reversal.Reference = e.Reference
+	reversal.Reverses = e.ID
+	return reversal
+}
+
+// LinesOfTransaction maps the transaction to the debit and credit lines of its ledger entry
+func LinesOfTransaction(transaction *Transaction) ([]LedgerLine, error) {
+	amount := transaction.AmountInBase()
+	income := transaction.TransactionBase.Type == TransactionTypeCredit
+
+	// write-offs and shrinkage are losses of inventory, no money moves
+	if transaction.Type == InitiatorTypeWriteOff || transaction.Type == InitiatorTypeShrinkage {
+		return []LedgerLine{DebitLine(AccountShrinkage, amount), CreditLine(AccountInventory, amount)}, nil
+	}
+	// supplier gave us products --- the debt grows, no money moves
+	if transaction.Type == InitiatorTypeSupplier && !income {
+		return []LedgerLine{DebitLine(AccountInventory, amount), CreditLine(AccountPayables, amount)}, nil
+	}
+
+	money, err := AccountOfPaymentMethod(transaction.PaymentMethod)
+	if err != nil {
+		return nil, err
+	}
+	var counter AccountCode
+	switch transaction.Type {
+	case InitiatorTypeSales:
+		counter = AccountSales
+		if !income {
+			counter = AccountSalesReturns
+		}
+	case InitiatorTypeSupplier:
+		// we paid the supplier, the money leaves
+		return []LedgerLine{DebitLine(AccountPayables, amount), CreditLine(money, amount)}, nil
+	case InitiatorTypeBNPL:
+		counter = AccountReceivables
+	case InitiatorTypeTransfer:
+		// each side of a transfer goes through the transit account, so the pair moves the money between the buckets
+		counter = AccountTransfers
+	default:
+		account, ok := expenseAccounts[transaction.Type]
+		if !ok {
+			return nil, fmt.Errorf("no account for initiator type %s", transaction.Type)
+		}
+		counter = account
+		if income {
+			counter = AccountOtherIncome
+		}
+	}
+	if income {
+		return []LedgerLine{DebitLine(money, amount), CreditLine(counter, amount)}, nil
+	}
+	return []LedgerLine{DebitLine(counter, amount), CreditLine(money, amount)}, nil
+}
+
+// NewLedgerEntryOfTransaction returns the entry posting the transaction, dated by the creation of the transaction
+func NewLedgerEntryOfTransaction(ctx context.Context, transaction *Transaction) (*LedgerEntry, error) {
+	lines, err := LinesOfTransaction(transaction)
+	if err != nil {
+		return nil, err
+	}
+	entry := NewLedgerEntry(ctx, transaction.BranchID, transaction.Type, transaction.Description, lines...)
+	entry.TransactionID = transaction.ID
+	if !transaction.CreatedAt.IsZero() {
+		entry.Date = transaction.CreatedAt
+	}
+	return entry, nil
+}
+
+// AccountBalance is the debit and credit totals of an account of a branch
+type AccountBalance struct {
+	Account
+	Debit   int64 `json:"debit" bson:"debit"`
+	Credit  int64 `json:"credit" bson:"credit"`
+	Balance int64 `json:"balance" bson:"balance"` // in the normal side of the account
+}
+
+// TrialBalance lists every account of the branch with its totals up to the date
+type TrialBalance struct {
+	BranchID    string           `json:"branch_id" bson:"branch_id"`
+	Date        time.Time        `json:"date" bson:"date"`
+	Accounts    []AccountBalance `json:"accounts" bson:"accounts"`
+	TotalDebit  int64            `json:"total_debit" bson:"total_debit"`
+	TotalCredit int64            `json:"total_credit" bson:"total_credit"`
+	Balanced    bool             `json:"balanced" bson:"balanced"`
+}
+
+// NewTrialBalance builds the trial balance from debit and credit totals of the accounts.
+// Accounts of the chart without postings are listed with zeros
+func NewTrialBalance(branch_id string, date time.Time, totals map[AccountCode]LedgerLine) TrialBalance {
+	trial_balance := TrialBalance{
+		BranchID: branch_id,
+		Date:     date,
+		Accounts: make([]AccountBalance, 0, len(ChartOfAccounts)),
+	}
+	for _, account := range ChartOfAccounts {
+		total := totals[account.Code]
+		balance := AccountBalance{Account: account, Debit: total.Debit, Credit: total.Credit}
+		if account.DebitNormal() {
+			balance.Balance = total.Debit - total.Credit
+		} else {
+			balance.Balance = total.Credit - total.Debit
+		}
+		trial_balance.Accounts = append(trial_balance.Accounts, balance)
+		trial_balance.TotalDebit += total.Debit
+		trial_balance.TotalCredit += total.Credit
+	}
+	trial_balance.Balanced = trial_balance.TotalDebit == trial_balance.TotalCredit
+	return trial_balance
+}
+
+// Balance returns the balance of the account in the trial balance
+func (t *TrialBalance) Balance(code AccountCode) int64 {
+	for _, account := range t.Accounts {
+		if account.Code == code {
+			return account.Balance
+		}
+	}
+	return 0
+}
+
+// LedgerBalances are the balances of the branch derived from its ledger
+type LedgerBalances struct {
+	BranchID    string    `json:"branch_id" bson:"branch_id"`
+	Date        time.Time `json:"date" bson:"date"`
+	Cash        int64     `json:"cash" bson:"cash"`
+	Bank        int64     `json:"bank" bson:"bank"`
+	Terminal    int64     `json:"terminal" bson:"terminal"`
+	MobileApps  int64     `json:"mobile_apps" bson:"mobile_apps"`
+	Cheques     int64     `json:"cheques" bson:"cheques"`
+	Payables    int64     `json:"payables" bson:"payables"`
+	Receivables int64     `json:"receivables" bson:"receivables"`
+	Inventory   int64     `json:"inventory" bson:"inventory"`
+	Income      int64     `json:"income" bson:"income"`
+	Expenses    int64     `json:"expenses" bson:"expenses"`
+}
+
+// Balances derives the balances of the branch from the trial balance
+func (t *TrialBalance) Balances() LedgerBalances {
+	balances := LedgerBalances{
+		BranchID:    t.BranchID,
+		Date:        t.Date,
+		Cash:        t.Balance(AccountCash),
+		Bank:        t.Balance(AccountBank),
+		Terminal:    t.Balance(AccountTerminal),
+		MobileApps:  t.Balance(AccountMobileApps),
+		Cheques:     t.Balance(AccountCheques),
+		Payables:    t.Balance(AccountPayables),
+		Receivables: t.Balance(AccountReceivables),
+		Inventory:   t.Balance(AccountInventory),
+	}
+	for _, account := range t.Accounts {
+		switch account.Type {
+		case AccountTypeIncome:
+			balances.Income += account.Balance
+		case AccountTypeExpense:
+			balances.Expenses += account.Balance
+		}
+	}
+	return balances
+}
+
+type LedgerEntriesOutput struct {
+	Data  []LedgerEntry `json:"data" bson:"data"`
+	Error []Error       `json:"error" bson:"error"`
+}
+
+type TrialBalanceOutput struct {
+	Data  TrialBalance `json:"data" bson:"data"`
+	Error []Error      `json:"error" bson:"error"`
+}
+
+type LedgerBalancesOutput struct {
+	Data  LedgerBalances `json:"data" bson:"data"`
+	Error []Error        `json:"error" bson:"error"`
+}
@@ -0,0 +1,189 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/platform/cache"
+
+	"github.com/go-redis/redis"
+)
+
+// LoginPolicy is the brute-force protection of the login. Failures are counted per username and per ip within the window,
+// reaching the maximum locks the username (or the ip) for the lockout duration
+type LoginPolicy struct {
+	MaxUserFailures int
+	MaxIPFailures   int
+	Window          time.Duration
+	Lockout         time.Duration
+}
+
+type LockoutKind string
+
+const (
+	LockoutKindUser LockoutKind = "user"
+	LockoutKindIP   LockoutKind = "ip"
+)
+
+type LoginLockout struct {
+	Kind      LockoutKind `json:"kind"`
+	Value     string      `json:"value"` // username or ip
+	ExpiresAt time.Time   `json:"expires_at"`
+}
+
+type LoginLockoutsOutput struct {
+	Data  []LoginLockout `json:"data"`
+	Error []Error        `json:"error"`
+}
+
+type UnlockLoginInput struct {
+	Kind  LockoutKind `json:"kind"`
+	Value string      `json:"value"`
+}
+
+func loginFailuresKey(kind LockoutKind, value string) string {
+	return "login_failures:" + string(kind) + ":" + value
+}
+
+func loginLockoutKey(kind LockoutKind, value string) string {
+	return "login_lockout:" + string(kind) + ":" + value
+}
+
+// Delay is the progressive delay of the response to a failed login: nothing for the first two failures,
+// then doubling from half a second up to 8 seconds
+func (p LoginPolicy) Delay(failures int64) time.Duration {
+	if failures < 3 {
+		return 0
+	}
+	delay := 500 * time.Millisecond << (failures - 3)
+	if delay > 8*time.Second || delay <= 0 {
+		return 8 * time.Second
+	}
+	return delay
+}
+
+// LoginLockedFor returns how long logins of the username from the ip are still locked, 0 if they are not
+func LoginLockedFor(username string, ip string, cache *cache.Cache) (time.Duration, error) {
+	var locked time.Duration
+	for kind, value := range map[LockoutKind]string{LockoutKindUser: username, LockoutKindIP: ip} {
+		ttl, err := cache.RedisClient.TTL(loginLockoutKey(kind, value)).Result()
+		if err != nil && err != redis.Nil {
+			return 0, err
+		}
+		if ttl > locked {
+			locked = ttl
+		}
+	}
+	return locked, nil
+}
+
+// RegisterFailedLogin counts the failure and locks the username and/or the ip when the maximum is reached.
+// Returns the failures of the username within the window and the lockouts which were started
+func RegisterFailedLogin(username string, ip string, policy LoginPolicy, cache *cache.Cache) (int64, []LoginLockout, error) {
+	lockouts := []LoginLockout{}
+	var user_failures int64
+	for kind, limit := range map[LockoutKind]int{LockoutKindUser: policy.MaxUserFailures, LockoutKindIP: policy.MaxIPFailures} {
+		value := username
+		if kind == LockoutKindIP {
+			value = ip
+		}
+		key := loginFailuresKey(kind, value)
+		failures, err := cache.RedisClient.Incr(key).Result()
+		if err != nil {
+			return 0, nil, err
+		}
+		if failures == 1 {
+			cache.RedisClient.Expire(key, policy.Window)
+		}
+		if kind == LockoutKindUser {
+			user_failures = failures
+		}
+		if failures >= int64(limit) {
+			if err := cache.RedisClient.Set(loginLockoutKey(kind, value), failures, policy.Lockout).Err(); err != nil {
+				return 0, nil, err
+			}
+			cache.RedisClient.Del(key)
+			lockouts = append(lockouts, LoginLockout{Kind: kind, Value: value, ExpiresAt: time.Now().Add(policy.Lockout)})
+		}
+	}
+	return user_failures, lockouts, nil
+}
+
+// ResetFailedLogins forgets the failures of the username after a successful login
+func ResetFailedLogins(username string, cache *cache.Cache) error {
+	return cache.RedisClient.Del(loginFailuresKey(LockoutKindUser, username)).Err()
+}
+
+// UnlockLogin removes the lockout and the failures of the username or the ip. Returns false if it was not locked
+func UnlockLogin(kind LockoutKind, value string, cache *cache.Cache) (bool, error) {
+	deleted, err := cache.RedisClient.Del(loginLockoutKey(kind, value), loginFailuresKey(kind, value)).Result()
+	return deleted > 0, err
+}
+
+// GetLoginLockouts lists the active lockouts
+func GetLoginLockouts(cache *cache.Cache) ([]LoginLockout, error) {
+	var cursor uint64
+	keys := []string{}
+	for {
+		var k []string
+		var err error
+		k, cursor, err = cache.RedisClient.Scan(cursor, "login_lockout:*", 100).Result()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k...)
+		if cursor == 0 {
+			break
+		}
+	}
+
+	lockouts := []LoginLockout{}
+	for _, key := range keys {
+		ttl, err := cache.RedisClient.TTL(key).Result()
+		if err != nil || ttl <= 0 {
+			continue
+		}
+		kind, value, _ := strings.Cut(strings.TrimPrefix(key, "login_lockout:"), ":")
+		lockouts = append(lockouts, LoginLockout{
+			Kind:      LockoutKind(kind),
+			Value:     value,
+			ExpiresAt: time.Now().Add(ttl),
+		})
+	}
+	return lockouts, nil
+}
+
+// SecurityReportQueryParams configures the failed login burst detection: attempts of the same username from the same ip
+// are grouped into windows, windows with at least threshold attempts are bursts
+type SecurityReportQueryParams struct {
+	FromDate      string `query:"from_date"` // YYYY-MM-DD, default 7 days ago
+	ToDate        string `query:"to_date"`   // YYYY-MM-DD, default today
+	WindowMinutes int    `query:"window_minutes" default:"10"`
+	Threshold     int    `query:"threshold" default:"5"`
+}
+
+type FailedLoginBurst struct {
+	Username    string    `json:"username" bson:"username"`
+	IP          string    `json:"ip" bson:"ip"`
+	WindowStart time.Time `json:"window_start" bson:"window_start"`
+	Attempts    int       `json:"attempts" bson:"attempts"`
+	First       time.Time `json:"first" bson:"first"`
+	Last        time.Time `json:"last" bson:"last"`
+}
+
+type FailedLoginsCount struct {
+	Key       string    `json:"key" bson:"_id"` // username or ip
+	Attempts  int       `json:"attempts" bson:"attempts"`
+	Usernames []string  `json:"usernames,omitempty" bson:"usernames,omitempty"` // usernames tried from the ip
+	Last      time.Time `json:"last" bson:"last"`
+}
+
+type SecurityReport struct {
+	From          time.Time           `json:"from"`
+	To            time.Time           `json:"to"`
+	TotalFailures int                 `json:"total_failures"`
+	Bursts        []FailedLoginBurst  `json:"bursts"`
+	ByUsername    []FailedLoginsCount `json:"by_username"`
+	ByIP          []FailedLoginsCount `json:"by_ip"`
+	Lockouts      []LoginLockout      `json:"active_lockouts"`
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// InitiatorTypeTransfer is one of the two transactions moving money between the balance buckets of a branch or between branches.
+// Transfers change the balance only, never the income or expense totals
+const InitiatorTypeTransfer InitiatorType = "transfer"
+
+// payment methods money can be moved from and to --- cheques have no bucket in the balance
+var transferPaymentMethods = []PaymentMethod{
+	PaymentMethodCash,
+	PaymentMethodBank,
+	PaymentMethodTerminal,
+	OnlineMobileAppPayment,
+	OnlineTransfer,
+}
+
+// MoneyTransferEnd is a bucket of the balance of a branch
+type MoneyTransferEnd struct {
+	BranchID      string        `json:"branch_id"`
+	PaymentMethod PaymentMethod `json:"payment_method"` // cash, bank, terminal or mobile apps (online_payment, online_transfer)
+}
+
+// Bucket returns the bucket of the balance the money of the end is kept in
+func (e MoneyTransferEnd) Bucket() FinanceBucket {
+	switch e.PaymentMethod {
+	case PaymentMethodBank:
+		return FinanceBucketBank
+	case PaymentMethodTerminal:
+		return FinanceBucketTerminal
+	case OnlineMobileAppPayment, OnlineTransfer:
+		return FinanceBucketMobileApps
+	}
+	return FinanceBucketCash
+}
+
+func (e MoneyTransferEnd) String() string {
+	return fmt.Sprintf("%s of branch %s", e.Bucket(), e.BranchID)
+}
+
+func (e MoneyTransferEnd) validate(name string) error {
+	if e.BranchID == "" {
+		return errors.New(name + ".branch_id is required")
+	}
+	for _, method := range transferPaymentMethods {
+		if method == e.PaymentMethod {
+			return nil
+		}
+	}
+	return fmt.Errorf("money can not be transferred with payment method %s", e.PaymentMethod)
+}
+
+// MoneyTransferInput moves the amount from a bucket of a branch to a bucket of the same or another branch,
+// e.g. depositing cash to the bank, settling the terminal into the bank or moving cash to another branch
+type MoneyTransferInput struct {
+	From        MoneyTransferEnd `json:"from"`
+	To          MoneyTransferEnd `json:"to"`
+	Amount      int64            `json:"amount"`
+	Description string           `json:"description"`
+}
+
+func (i *MoneyTransferInput) Validate() error {
+	if i.Amount <= 0 {
+		return errors.New("amount must be greater than 0")
+	}
+	if err := i.From.validate("from"); err != nil {
+		return err
+	}
+	if err := i.To.validate("to"); err != nil {
+		return err
+	}
+	if i.From.BranchID == i.To.BranchID && i.From.Bucket() == i.To.Bucket() {
+		return errors.New("money can not be transferred to the same bucket")
+	}
+	return nil
+}
+
+// MoneyTransfer is the pair of transactions of a transfer sharing its ID
+type MoneyTransfer struct {
+	ID       string      `json:"id" bson:"id"`
+	Outgoing Transaction `json:"outgoing" bson:"outgoing"` // debit of the source bucket
+	Incoming Transaction `json:"incoming" bson:"incoming"` // credit of the destination bucket
+}
+
+// NewMoneyTransfer returns the paired transactions of the transfer
+func NewMoneyTransfer(input *MoneyTransferInput) *MoneyTransfer {
+	id := uuid.New().String()
+	description := func(direction string, end MoneyTransferEnd) string {
+		text := fmt.Sprintf("Transfer %s %s", direction, end)
+		if input.Description != "" {
+			text += ": " + input.Description
+		}
+		return text
+	}
+
+	outgoing := NewTransaction(&TransactionBase{
+		Amount:        input.Amount,
+		Description:   description("to", input.To),
+		Type:          TransactionTypeDebit,
+		PaymentMethod: input.From.PaymentMethod,
+	}, InitiatorTypeTransfer, input.From.BranchID)
+	outgoing.TransferID = id
+	incoming := NewTransaction(&TransactionBase{
+		Amount:        input.Amount,
+		Description:   description("from", input.From),
+		Type:          TransactionTypeCredit,
+		PaymentMethod: input.To.PaymentMethod,
+	}, InitiatorTypeTransfer, input.To.BranchID)
+	incoming.TransferID = id
+	incoming.CreatedAt = outgoing.CreatedAt
+	incoming.UpdatedAt = outgoing.UpdatedAt
+
+	return &MoneyTransfer{
+		ID:       id,
+		Outgoing: *outgoing,
+		Incoming: *incoming,
+	}
+}
+
+type MoneyTransferOutputSingle struct {
+	Data  MoneyTransfer `json:"data" bson:"data"`
+	Error []Error       `json:"error" bson:"error"`
+}
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/utils"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+var (
+	ErrPeriodClosed         = errors.New("accounting period is closed")
+	ErrPeriodAlreadyClosed  = errors.New("lock date must be after the current lock date of the branch")
+	ErrPeriodReopened       = errors.New("period is already reopened")
+	ErrPeriodNotLatestClose = errors.New("only the latest close of the branch can be reopened")
+)
+
+// PeriodClose locks the financial documents of a branch dated before the lock date. It keeps the balances of the branch
+// at the close, and who reopened it, when and why if it was reopened. The lock date of a branch is the one of its latest close which is not reopened
+type PeriodClose struct {
+	ID           string       `json:"id" bson:"_id"`
+	BranchID     string       `json:"branch_id" bson:"branch_id"`
+	LockDate     time.Time    `json:"lock_date" bson:"lock_date"` // midnight, documents dated before it can not be created, changed or deleted
+	Note         string       `json:"note" bson:"note"`
+	Finance      Finance      `json:"finance" bson:"finance"`             // balances and totals of the finance of the branch at the close
+	TrialBalance TrialBalance `json:"trial_balance" bson:"trial_balance"` // ledger of the branch up to the lock date
+	ClosedBy     string       `json:"closed_by" bson:"closed_by"`
+	ClosedAt     time.Time    `json:"closed_at" bson:"closed_at"`
+	Reopened     bool         `json:"reopened" bson:"reopened"`
+	ReopenedBy   string       `json:"reopened_by,omitempty" bson:"reopened_by,omitempty"`
+	ReopenedAt   *time.Time   `json:"reopened_at,omitempty" bson:"reopened_at,omitempty"`
+	ReopenReason string       `json:"reopen_reason,omitempty" bson:"reopen_reason,omitempty"`
+}
+
+// PeriodCloseInput closes the period of the branch up to the day of the lock date
+type PeriodCloseInput struct {
+	LockDate time.Time `json:"lock_date"` // documents dated before the midnight of its day are locked
+	Note     string    `json:"note"`
+}
+
+func (i *PeriodCloseInput) Validate() error {
+	if i.LockDate.IsZero() {
+		return errors.New("lock_date is required")
+	}
+	i.LockDate = RateDay(i.LockDate)
+	if i.LockDate.After(time.Now()) {
+		return errors.New("lock_date can not be in the future")
+	}
+	return nil
+}
+
+func NewPeriodClose(branch_id string, input *PeriodCloseInput, finance Finance, trial_balance TrialBalance, closed_by string) *PeriodClose {
+	return &PeriodClose{
+		ID:           uuid.New().String(),
+		BranchID:     branch_id,
+		LockDate:     input.LockDate,
+		Note:         input.Note,
+		Finance:      finance,
+		TrialBalance: trial_balance,
+		ClosedBy:     closed_by,
+		ClosedAt:     time.Now().In(utils.GetTimeZone()),
+	}
+}
+
+// PeriodReopenInput reopens a closed period, the reason is kept on the close
+type PeriodReopenInput struct {
+	Reason string `json:"reason"`
+}
+
+func (i *PeriodReopenInput) Validate() error {
+	if i.Reason == "" {
+		return ErrReasonRequired
+	}
+	return nil
+}
+
+type PeriodCloseQueryParams struct {
+	BranchID string `query:"branch_id"`
+}
+
+// PeriodLock is the current lock date of a branch, zero if no period is closed
+type PeriodLock struct {
+	BranchID string       `json:"branch_id"`
+	LockDate time.Time    `json:"lock_date"`
+	Close    *PeriodClose `json:"close,omitempty"` // close the lock date comes from
+}
+
+type PeriodCloseOutput struct {
+	Data  []PeriodClose `json:"data"`
+	Error []Error       `json:"error"`
+}
+
+type PeriodCloseOutputSingle struct {
+	Data  PeriodClose `json:"data"`
+	Error []Error     `json:"error"`
+}
+
+type PeriodLockOutput struct {
+	Data  PeriodLock `json:"data"`
+	Error []Error    `json:"error"`
+}
+
+// PeriodClosesCollection returns the period closes collection of the database of the given collection
+func PeriodClosesCollection(of *mongo.Collection) *mongo.Collection {
+	return of.Database().Collection("period_closes")
+}
+
+// LatestPeriodClose returns the latest close of the branch which is not reopened, nil if there is none
+func LatestPeriodClose(ctx context.Context, collection *mongo.Collection, branch_id string) (*PeriodClose, error) {
+	period := &PeriodClose{}
+	opts := options.FindOne().SetSort(bson.M{"lock_date": -1}).SetProjection(bson.M{"finance": 0, "trial_balance": 0})
+	err := collection.FindOne(ctx, bson.M{"branch_id": branch_id, "reopened": false}, opts).Decode(period)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return period, nil
+}
+
+// LockDateOf returns the lock date of the branch, zero if no period of the branch is closed
+func LockDateOf(ctx context.Context, collection *mongo.Collection, branch_id string) (time.Time, error) {
+	period, err := LatestPeriodClose(ctx, collection, branch_id)
+	if err != nil || period == nil {
+		return time.Time{}, err
+	}
+	return period.LockDate, nil
+}
+
+// CheckPeriodOpen returns ErrPeriodClosed if documents of the branch dated at the date are locked
+func CheckPeriodOpen(ctx context.Context, collection *mongo.Collection, branch_id string, date time.Time) error {
+	lock_date, err := LockDateOf(ctx, collection, branch_id)
+	if err != nil {
+		return err
+	}
+	if date.Before(lock_date) {
+		return PeriodClosedError(lock_date)
+	}
+	return nil
+}
+
+// PeriodClosedError tells up to which date documents are locked
+func PeriodClosedError(lock_date time.Time) error {
+	return fmt.Errorf("%w: documents dated before %s are locked", ErrPeriodClosed, lock_date.In(utils.GetTimeZone()).Format(time.DateOnly))
+}
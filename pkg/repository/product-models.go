@@ -33,6 +33,7 @@ type ProductDistribution struct {
 	ProductQuantityInfo              // Embedded quantity info
 	Place               ProductPlace `json:"place" bson:"place"` // Location details
 	Price               int32        `json:"price" bson:"price"` // Price of the product
+	Cost                int32        `json:"cost" bson:"cost"`   // Moving weighted average cost of the product at the place
 }
 
 type PriceDistribution struct {
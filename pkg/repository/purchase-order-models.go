@@ -0,0 +1,206 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type PurchaseOrderStatus string
+
+const (
+	PurchaseOrderStatusOpen              PurchaseOrderStatus = "open"               // ordered, nothing is received yet
+	PurchaseOrderStatusPartiallyReceived PurchaseOrderStatus = "partially_received" // some of the lines are not fully received
+	PurchaseOrderStatusReceived          PurchaseOrderStatus = "received"           // every line is fully received --- proposals are fulfilled
+	PurchaseOrderStatusCancelled         PurchaseOrderStatus = "cancelled"
+)
+
+type PurchaseOrderLine struct {
+	ProductID        string `json:"product_id" bson:"product_id"`
+	Quantity         int32  `json:"quantity" bson:"quantity"`                   // ordered quantity
+	ExpectedPrice    int32  `json:"expected_price" bson:"expected_price"`       // expected unit price of the supplier
+	ReceivedQuantity int32  `json:"received_quantity" bson:"received_quantity"` // quantity received so far
+}
+
+// PurchaseOrderReceiptLine is a quantity of a product received with a receipt at the actual unit price
+type PurchaseOrderReceiptLine struct {
+	ProductID    string    `json:"product_id" bson:"product_id"`
+	Quantity     int32     `json:"quantity" bson:"quantity"`
+	Price        int32     `json:"price" bson:"price"`                 // actual unit price, defaults to the expected price
+	SellingPrice int32     `json:"selling_price" bson:"selling_price"` // optional --- current selling price of the place is kept if not set
+	Expire       time.Time `json:"expire" bson:"expire"`               // optional --- if set the items are tracked as a batch
+}
+
+// PurchaseOrderReceipt is a (partial) delivery of a purchase order
+type PurchaseOrderReceipt struct {
+	ID            string                     `json:"id" bson:"id"`
+	Lines         []PurchaseOrderReceiptLine `json:"lines" bson:"lines"`
+	Value         int64                      `json:"value" bson:"value"`                   // actual value of the received items, added to the supplier payable
+	TransactionID string                     `json:"transaction_id" bson:"transaction_id"` // supplier transaction
+	ReceivedBy    string                     `json:"received_by" bson:"received_by"`
+	ReceivedAt    time.Time                  `json:"received_at" bson:"received_at"`
+}
+
+// PurchaseOrder orders products of proposals from a supplier to a place
+type PurchaseOrder struct {
+	ID            string                 `json:"id" bson:"_id"`
+	SupplierID    string                 `json:"supplier_id" bson:"supplier_id"`
+	Destination   ProductPlace           `json:"destination" bson:"destination"`
+	Lines         []PurchaseOrderLine    `json:"lines" bson:"lines"`
+	ProposalIDs   []bson.ObjectID        `json:"proposal_ids" bson:"proposal_ids"`
+	Status        PurchaseOrderStatus    `json:"status" bson:"status"`
+	ExpectedValue int64                  `json:"expected_value" bson:"expected_value"` // ordered quantities at expected prices
+	ReceivedValue int64                  `json:"received_value" bson:"received_value"` // sum of the receipt values
+	Receipts      []PurchaseOrderReceipt `json:"receipts" bson:"receipts"`
+	Note          string                 `json:"note" bson:"note"`
+	CreatedBy     string                 `json:"created_by" bson:"created_by"`
+	CreatedAt     time.Time              `json:"created_at" bson:"created_at"`
+	ClosedAt      time.Time              `json:"closed_at" bson:"closed_at"`
+}
+
+type PurchaseOrderLineInput struct {
+	ProductID     string `json:"product_id"`
+	Quantity      int32  `json:"quantity"`
+	ExpectedPrice int32  `json:"expected_price"`
+}
+
+type NewPurchaseOrderInput struct {
+	SupplierID  string                   `json:"supplier_id"`
+	Destination ProductPlace             `json:"destination"`
+	ProposalIDs []string                 `json:"proposal_ids"`
+	Lines       []PurchaseOrderLineInput `json:"lines"`
+	Note        string                   `json:"note"`
+}
+
+func (n *NewPurchaseOrderInput) Validate() error {
+	if n.SupplierID == "" {
+		return errors.New("supplier_id is required")
+	}
+	if n.Destination.ID == "" {
+		return errors.New("destination is required")
+	}
+	if len(n.Lines) == 0 {
+		return errors.New("at least one line is required")
+	}
+	seen := map[string]bool{}
+	for _, line := range n.Lines {
+		if line.ProductID == "" {
+			return errors.New("product_id is required")
+		}
+		if line.Quantity <= 0 {
+			return errors.New("quantity must be greater than 0")
+		}
+		if line.ExpectedPrice < 0 {
+			return errors.New("expected price cannot be negative")
+		}
+		if seen[line.ProductID] {
+			return errors.New("product " + line.ProductID + " is listed more than once")
+		}
+		seen[line.ProductID] = true
+	}
+	return nil
+}
+
+func NewPurchaseOrder(input *NewPurchaseOrderInput, proposalIDs []bson.ObjectID, createdBy string) *PurchaseOrder {
+	lines := []PurchaseOrderLine{}
+	var expected int64
+	for _, line := range input.Lines {
+		lines = append(lines, PurchaseOrderLine{
+			ProductID:     line.ProductID,
+			Quantity:      line.Quantity,
+			ExpectedPrice: line.ExpectedPrice,
+		})
+		expected += int64(line.Quantity) * int64(line.ExpectedPrice)
+	}
+	return &PurchaseOrder{
+		ID:            uuid.New().String(),
+		SupplierID:    input.SupplierID,
+		Destination:   input.Destination,
+		Lines:         lines,
+		ProposalIDs:   proposalIDs,
+		Status:        PurchaseOrderStatusOpen,
+		ExpectedValue: expected,
+		Receipts:      []PurchaseOrderReceipt{},
+		Note:          input.Note,
+		CreatedBy:     createdBy,
+		CreatedAt:     time.Now(),
+	}
+}
+
+type ReceivePurchaseOrderInput struct {
+	Lines []PurchaseOrderReceiptLine `json:"lines"`
+}
+
+// Receive adds received quantities of the receipt to the lines and updates the status of the order.
+// Receiving more than what is left of a line is rejected
+func (po *PurchaseOrder) Receive(input *ReceivePurchaseOrderInput, receivedBy string) (*PurchaseOrderReceipt, error) {
+	if po.Status != PurchaseOrderStatusOpen && po.Status != PurchaseOrderStatusPartiallyReceived {
+		return nil, errors.New("purchase order is " + string(po.Status))
+	}
+	if len(input.Lines) == 0 {
+		return nil, errors.New("at least one line is required")
+	}
+
+	receipt := &PurchaseOrderReceipt{
+		ID:         uuid.New().String(),
+		Lines:      []PurchaseOrderReceiptLine{},
+		ReceivedBy: receivedBy,
+		ReceivedAt: time.Now(),
+	}
+	for _, received := range input.Lines {
+		index := -1
+		for i, line := range po.Lines {
+			if line.ProductID == received.ProductID {
+				index = i
+				break
+			}
+		}
+		if index == -1 {
+			return nil, errors.New("product " + received.ProductID + " is not in the purchase order")
+		}
+		line := &po.Lines[index]
+		if received.Quantity <= 0 {
+			return nil, errors.New("received quantity must be greater than 0")
+		}
+		if received.Quantity > line.Quantity-line.ReceivedQuantity {
+			return nil, errors.New("received quantity of product " + received.ProductID + " is more than what is left to receive")
+		}
+		if received.Price < 0 {
+			return nil, errors.New("price cannot be negative")
+		}
+		if received.Price == 0 {
+			received.Price = line.ExpectedPrice
+		}
+		line.ReceivedQuantity += received.Quantity
+		receipt.Value += int64(received.Quantity) * int64(received.Price)
+		receipt.Lines = append(receipt.Lines, received)
+	}
+
+	po.Status = PurchaseOrderStatusReceived
+	for _, line := range po.Lines {
+		if line.ReceivedQuantity < line.Quantity {
+			po.Status = PurchaseOrderStatusPartiallyReceived
+			break
+		}
+	}
+	if po.Status == PurchaseOrderStatusReceived {
+		po.ClosedAt = receipt.ReceivedAt
+	}
+	po.ReceivedValue += receipt.Value
+	return receipt, nil
+}
+
+type PurchaseOrderOutput struct {
+	Data  []PurchaseOrder `json:"data"`
+	Error []Error         `json:"error"`
+}
+
+type PurchaseOrderQueryParams struct {
+	Status     PurchaseOrderStatus `query:"status"`
+	SupplierID string              `query:"supplier_id"`
+	PlaceID    string              `query:"place_id"`
+	Page       int                 `query:"page" default:"1"`
+	Count      int                 `query:"count" default:"25"`
+}
@@ -0,0 +1,177 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/utils"
+
+	"github.com/google/uuid"
+)
+
+// PaymentMethodBNPL is only valid as a tender of a receipt --- the part of the sale is paid later through BNPL credits
+const PaymentMethodBNPL PaymentMethod = "bnpl"
+
+// Tender is one payment line of a receipt
+type Tender struct {
+	PaymentMethod PaymentMethod `json:"payment_method" bson:"payment_method"`
+	Amount        int64         `json:"amount" bson:"amount"`
+	TransactionID string        `json:"transaction_id,omitempty" bson:"transaction_id,omitempty"` // transaction created for this tender
+	BNPLID        string        `json:"bnpl_id,omitempty" bson:"bnpl_id,omitempty"`               // set only for bnpl tenders
+}
+
+// Receipt ties all tenders (and transactions) of a single sale together
+type Receipt struct {
+	ID          string                      `json:"id" bson:"_id"`
+	BranchID    string                      `json:"branch_id" bson:"branch_id"`
+	SessionID   string                      `json:"session_id,omitempty" bson:"session_id,omitempty"`
+	CustomerID  string                      `json:"customer_id,omitempty" bson:"customer_id,omitempty"`
+	Description string                      `json:"description" bson:"description"`
+	Products    map[string]SalesSessionItem `json:"products" bson:"products"`
+	Total       int64                       `json:"total" bson:"total"`
+	Tenders     []Tender                    `json:"tenders" bson:"tenders"`
+	Returned    map[string]int              `json:"returned" bson:"returned"` // quantity of every product returned so far
+	Refunded    int64                       `json:"refunded" bson:"refunded"` // amount refunded so far
+	Cashier     string                      `json:"cashier" bson:"cashier"`
+	Terminal    string                      `json:"terminal,omitempty" bson:"terminal,omitempty"` // terminal the receipt was created on
+	CreatedAt   time.Time                   `json:"created_at" bson:"created_at"`
+}
+
+type TenderInput struct {
+	PaymentMethod PaymentMethod `json:"payment_method"`
+	Amount        int64         `json:"amount"`
+}
+
+type NewReceiptInput struct {
+	Description string        `json:"description"`
+	CustomerID  string        `json:"customer_id"`
+	Tenders     []TenderInput `json:"tenders"`
+}
+
+func NewReceipt(branchID string, total int64, description string) *Receipt {
+	return &Receipt{
+		ID:          uuid.New().String(),
+		BranchID:    branchID,
+		Description: description,
+		Products:    map[string]SalesSessionItem{},
+		Total:       total,
+		Tenders:     []Tender{},
+		Returned:    map[string]int{},
+		CreatedAt:   time.Now().In(utils.GetTimeZone()),
+	}
+}
+
+// ValidateTenders checks that every tender is valid and that tenders sum up to the total
+func ValidateTenders(tenders []TenderInput, total int64, customerID string) error {
+	if len(tenders) == 0 {
+		return errors.New("at least one tender is required")
+	}
+	var sum int64
+	for _, tender := range tenders {
+		if tender.Amount <= 0 {
+			return errors.New("tender amount must be greater than 0")
+		}
+		if tender.PaymentMethod == PaymentMethodBNPL {
+			if customerID == "" {
+				return errors.New("customer_id is required for bnpl tender")
+			}
+		} else if err := ValidatePaymentMethod(tender.PaymentMethod); err != nil {
+			return err
+		}
+		sum += tender.Amount
+	}
+	if sum != total {
+		return fmt.Errorf("tenders sum %d does not match total %d", sum, total)
+	}
+	return nil
+}
+
+type ReceiptOutput struct {
+	Data  []Receipt `json:"data"`
+	Error []Error   `json:"error"`
+}
+
+// Refund is a (partial) return against a receipt. Returned products are restocked at the branch of the receipt
+type Refund struct {
+	ID            string                      `json:"id" bson:"_id"`
+	ReceiptID     string                      `json:"receipt_id" bson:"receipt_id"`
+	BranchID      string                      `json:"branch_id" bson:"branch_id"`
+	Lines         map[string]SalesSessionItem `json:"lines" bson:"lines"` // returned products --- price is the price they were sold for
+	Total         int64                       `json:"total" bson:"total"`
+	PaymentMethod PaymentMethod               `json:"payment_method" bson:"payment_method"` // tender the money is given back with
+	TransactionID string                      `json:"transaction_id" bson:"transaction_id"` // debit transaction of the refund
+	Reason        string                      `json:"reason" bson:"reason"`
+	Cashier       string                      `json:"cashier" bson:"cashier"`
+	Terminal      string                      `json:"terminal,omitempty" bson:"terminal,omitempty"`
+	CreatedAt     time.Time                   `json:"created_at" bson:"created_at"`
+}
+
+type RefundLineInput struct {
+	ProductID string `json:"product_id"`
+	Quantity  int    `json:"quantity"`
+}
+
+type NewRefundInput struct {
+	Lines         []RefundLineInput `json:"lines"`
+	Amount        int64             `json:"amount"` // only for receipts without products
+	PaymentMethod PaymentMethod     `json:"payment_method"`
+	Reason        string            `json:"reason"`
+}
+
+// NewRefund builds a refund against the receipt and validates the returned lines against what was sold and already returned
+func (r *Receipt) NewRefund(input *NewRefundInput) (*Refund, error) {
+	if err := ValidatePaymentMethod(input.PaymentMethod); err != nil {
+		return nil, err
+	}
+
+	refund := &Refund{
+		ID:            uuid.New().String(),
+		ReceiptID:     r.ID,
+		BranchID:      r.BranchID,
+		Lines:         map[string]SalesSessionItem{},
+		PaymentMethod: input.PaymentMethod,
+		Reason:        input.Reason,
+		CreatedAt:     time.Now().In(utils.GetTimeZone()),
+	}
+
+	if len(r.Products) == 0 {
+		// receipt created without products --- only amount can be refunded
+		if input.Amount <= 0 {
+			return nil, errors.New("amount must be greater than 0")
+		}
+		refund.Total = input.Amount
+	} else {
+		if len(input.Lines) == 0 {
+			return nil, errors.New("at least one line is required")
+		}
+		for _, line := range input.Lines {
+			sold, ok := r.Products[line.ProductID]
+			if !ok {
+				return nil, fmt.Errorf("product %s is not in the receipt", line.ProductID)
+			}
+			if line.Quantity <= 0 {
+				return nil, errors.New("quantity must be greater than 0")
+			}
+			returned := r.Returned[line.ProductID] + refund.Lines[line.ProductID].Quantity
+			if returned+line.Quantity > sold.Quantity {
+				return nil, fmt.Errorf("cannot return %d of product %s: sold %d, already returned %d", line.Quantity, line.ProductID, sold.Quantity, returned)
+			}
+			item := refund.Lines[line.ProductID]
+			item.Quantity += line.Quantity
+			item.Price = sold.Price
+			refund.Lines[line.ProductID] = item
+			refund.Total += int64(sold.Price) * int64(line.Quantity)
+		}
+	}
+
+	if r.Refunded+refund.Total > r.Total {
+		return nil, fmt.Errorf("refund of %d exceeds the refundable amount %d", refund.Total, r.Total-r.Refunded)
+	}
+	return refund, nil
+}
+
+type RefundOutput struct {
+	Data  []Refund `json:"data"`
+	Error []Error  `json:"error"`
+}
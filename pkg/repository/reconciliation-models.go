@@ -0,0 +1,295 @@
+package models
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// FinanceBucket is a field of the finance of a branch which is kept by incrementing it
+type FinanceBucket string
+
+const (
+	FinanceBucketCash          FinanceBucket = "cash"
+	FinanceBucketBank          FinanceBucket = "bank"
+	FinanceBucketTerminal      FinanceBucket = "terminal"
+	FinanceBucketMobileApps    FinanceBucket = "mobile_apps"
+	FinanceBucketTotalIncome   FinanceBucket = "total_income"
+	FinanceBucketTotalExpenses FinanceBucket = "total_expenses"
+)
+
+var FinanceBuckets = []FinanceBucket{
+	FinanceBucketCash,
+	FinanceBucketBank,
+	FinanceBucketTerminal,
+	FinanceBucketMobileApps,
+	FinanceBucketTotalIncome,
+	FinanceBucketTotalExpenses,
+}
+
+// Field returns the path of the bucket in the finance document
+func (b FinanceBucket) Field() string {
+	switch b {
+	case FinanceBucketTotalIncome, FinanceBucketTotalExpenses:
+		return "finance." + string(b)
+	}
+	return "finance.balance." + string(b)
+}
+
+func ValidateFinanceBucket(bucket FinanceBucket) error {
+	if !slices.Contains(FinanceBuckets, bucket) {
+		return errors.New("invalid bucket " + string(bucket))
+	}
+	return nil
+}
+
+// FinanceEffect is how much a transaction changes the buckets of the finance of its branch
+type FinanceEffect map[FinanceBucket]int64
+
+// Inc returns the $inc document applying the effect to the finance document
+func (e FinanceEffect) Inc() bson.M {
+	inc := bson.M{}
+	for bucket, amount := range e {
+		inc[bucket.Field()] = amount
+	}
+	return inc
+}
+
+// Of returns the value of the bucket in the finance
+func (f *Finance) Of(bucket FinanceBucket) int64 {
+	switch bucket {
+	case FinanceBucketCash:
+		return f.Balance.Cash
+	case FinanceBucketBank:
+		return f.Balance.Bank
+	case FinanceBucketTerminal:
+		return f.Balance.Terminal
+	case FinanceBucketMobileApps:
+		return f.Balance.MobileApps
+	case FinanceBucketTotalIncome:
+		return f.TotalIncome
+	case FinanceBucketTotalExpenses:
+		return f.TotalExpenses
+	}
+	return 0
+}
+
+// money accounts of the ledger kept in the buckets of the balance. Cheques have no bucket
+var bucketsOfAccounts = map[AccountCode]FinanceBucket{
+	AccountCash:       FinanceBucketCash,
+	AccountBank:       FinanceBucketBank,
+	AccountTerminal:   FinanceBucketTerminal,
+	AccountMobileApps: FinanceBucketMobileApps,
+}
+
+// LinesOfAdjustment maps the adjustment of the buckets to ledger lines --- money buckets to their accounts, totals to
+// other income and other expenses --- balanced against the reconciliation account
+func LinesOfAdjustment(adjustments FinanceEffect) []LedgerLine {
+	lines := []LedgerLine{}
+	var reconciliation int64
+	add := func(account AccountCode, debit int64) {
+		switch {
+		case debit > 0:
+			lines = append(lines, DebitLine(account, debit))
+		case debit < 0:
+			lines = append(lines, CreditLine(account, -debit))
+		}
+		reconciliation -= debit
+	}
+	for _, account := range ChartOfAccounts {
+		if bucket, ok := bucketsOfAccounts[account.Code]; ok {
+			add(account.Code, adjustments[bucket])
+		}
+	}
+	add(AccountOtherIncome, -adjustments[FinanceBucketTotalIncome])
+	add(AccountOtherExpenses, adjustments[FinanceBucketTotalExpenses])
+	switch {
+	case reconciliation > 0:
+		lines = append(lines, DebitLine(AccountReconciliation, reconciliation))
+	case reconciliation < 0:
+		lines = append(lines, CreditLine(AccountReconciliation, -reconciliation))
+	}
+	return lines
+}
+
+// ExpectedEffectOfTransaction is the effect the transaction should have, derived from its ledger lines:
+// money accounts change the balance, income and expense accounts change the totals
+func ExpectedEffectOfTransaction(transaction *Transaction) (FinanceEffect, error) {
+	lines, err := LinesOfTransaction(transaction)
+	if err != nil {
+		return nil, err
+	}
+	effect := FinanceEffect{}
+	for _, line := range lines {
+		if bucket, ok := bucketsOfAccounts[line.Account]; ok {
+			effect[bucket] += line.Debit - line.Credit
+			continue
+		}
+		account, _ := FindAccount(line.Account)
+		switch account.Type {
+		case AccountTypeIncome:
+			effect[FinanceBucketTotalIncome] += line.Credit - line.Debit
+		case AccountTypeExpense:
+			effect[FinanceBucketTotalExpenses] += line.Debit - line.Credit
+		}
+	}
+	return effect, nil
+}
+
+// RecordedEffectOfTransaction is the effect the code paths creating the transaction applied to the finance.
+// Sales skip cheques, supplier payments skip terminal and cheques and book online payments to the bank,
+// BNPL credits always go to cash and only write-offs, shrinkage and approved expenses touch the totals.
+// Approved expenses and money transfers apply their expected effect
+func RecordedEffectOfTransaction(transaction *Transaction) FinanceEffect {
+	amount := transaction.AmountInBase()
+	income := transaction.TransactionBase.Type == TransactionTypeCredit
+	effect := FinanceEffect{}
+	switch transaction.Type {
+	case InitiatorTypeSales:
+		if !income {
+			amount = -amount
+		}
+		switch transaction.PaymentMethod {
+		case PaymentMethodCash:
+			effect[FinanceBucketCash] = amount
+		case PaymentMethodBank:
+			effect[FinanceBucketBank] = amount
+		case PaymentMethodTerminal:
+			effect[FinanceBucketTerminal] = amount
+		case OnlineMobileAppPayment, OnlineTransfer:
+			effect[FinanceBucketMobileApps] = amount
+		}
+	case InitiatorTypeSupplier:
+		if !income {
+			break
+		}
+		switch transaction.PaymentMethod {
+		case PaymentMethodCash:
+			effect[FinanceBucketCash] = -amount
+		case PaymentMethodBank, OnlineMobileAppPayment:
+			effect[FinanceBucketBank] = -amount
+		case OnlineTransfer:
+			effect[FinanceBucketMobileApps] = -amount
+		}
+	case InitiatorTypeBNPL:
+		effect[FinanceBucketCash] = amount
+	case InitiatorTypeWriteOff, InitiatorTypeShrinkage:
+		effect[FinanceBucketTotalExpenses] = amount
+	case InitiatorTypeSalary, InitiatorTypeRent, InitiatorTypeUtilities, InitiatorTypeOther, InitiatorTypeTransfer:
+		if expected, err := ExpectedEffectOfTransaction(transaction); err == nil {
+			return expected
+		}
+	}
+	return effect
+}
+
+// TransactionDrift is a transaction whose recorded effect on a bucket differs from the expected one
+type TransactionDrift struct {
+	TransactionID string        `json:"transaction_id" bson:"transaction_id"`
+	Type          InitiatorType `json:"type" bson:"type"`
+	PaymentMethod PaymentMethod `json:"payment_method" bson:"payment_method"`
+	Amount        int64         `json:"amount" bson:"amount"`
+	CreatedAt     time.Time     `json:"created_at" bson:"created_at"`
+	Expected      int64         `json:"expected" bson:"expected"`
+	Recorded      int64         `json:"recorded" bson:"recorded"`
+}
+
+// BucketReconciliation compares a bucket of the finance with the sum of the transactions of the branch
+type BucketReconciliation struct {
+	Bucket       FinanceBucket      `json:"bucket" bson:"bucket"`
+	Stored       int64              `json:"stored" bson:"stored"`
+	Computed     int64              `json:"computed" bson:"computed"`
+	Difference   int64              `json:"difference" bson:"difference"`     // computed - stored, applied as the adjustment
+	Explained    int64              `json:"explained" bson:"explained"`       // part of the difference caused by the listed transactions
+	Offending    int                `json:"offending" bson:"offending"`       // number of transactions causing drift, only the first ones are listed
+	Transactions []TransactionDrift `json:"transactions" bson:"transactions"` // offending transactions
+}
+
+// Reconciliation of the finance of a branch with its transactions
+type Reconciliation struct {
+	BranchID   string                 `json:"branch_id" bson:"branch_id"`
+	BranchName string                 `json:"branch_name" bson:"branch_name"`
+	Date       time.Time              `json:"date" bson:"date"`
+	Buckets    []BucketReconciliation `json:"buckets" bson:"buckets"`
+	Unmapped   []string               `json:"unmapped" bson:"unmapped"` // transactions without expected effect (unknown payment method or initiator type)
+	InSync     bool                   `json:"in_sync" bson:"in_sync"`
+}
+
+// Differences returns the non-zero differences of the buckets, limited to the given buckets if any
+func (r *Reconciliation) Differences(buckets ...FinanceBucket) FinanceEffect {
+	differences := FinanceEffect{}
+	for _, bucket := range r.Buckets {
+		if len(buckets) > 0 && !slices.Contains(buckets, bucket.Bucket) {
+			continue
+		}
+		if bucket.Difference != 0 {
+			differences[bucket.Bucket] = bucket.Difference
+		}
+	}
+	return differences
+}
+
+// ApplyReconciliationInput corrects the buckets of the finance to the values computed from the transactions
+type ApplyReconciliationInput struct {
+	Reason  string          `json:"reason"`
+	Buckets []FinanceBucket `json:"buckets"` // every bucket if empty
+}
+
+func (i *ApplyReconciliationInput) Validate() error {
+	if i.Reason == "" {
+		return errors.New("reason is required")
+	}
+	for _, bucket := range i.Buckets {
+		if err := ValidateFinanceBucket(bucket); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FinanceAdjustment is the audit record of a corrective adjustment of the finance of a branch
+type FinanceAdjustment struct {
+	ID          string        `json:"id" bson:"_id"`
+	BranchID    string        `json:"branch_id" bson:"branch_id"`
+	Reason      string        `json:"reason" bson:"reason"`
+	Adjustments FinanceEffect `json:"adjustments" bson:"adjustments"` // added to the buckets
+	Before      Finance       `json:"before" bson:"before"`
+	After       Finance       `json:"after" bson:"after"`
+	Automatic   bool          `json:"automatic" bson:"automatic"` // applied by the reconciliation job
+	CreatedBy   string        `json:"created_by" bson:"created_by"`
+	CreatedAt   time.Time     `json:"created_at" bson:"created_at"`
+}
+
+func NewFinanceAdjustment(branch_id string, reason string, adjustments FinanceEffect, created_by string) *FinanceAdjustment {
+	return &FinanceAdjustment{
+		ID:          uuid.New().String(),
+		BranchID:    branch_id,
+		Reason:      reason,
+		Adjustments: adjustments,
+		CreatedBy:   created_by,
+		CreatedAt:   time.Now(),
+	}
+}
+
+type ReconciliationOutput struct {
+	Data  []Reconciliation `json:"data" bson:"data"`
+	Error []Error          `json:"error" bson:"error"`
+}
+
+type ReconciliationOutputSingle struct {
+	Data  Reconciliation `json:"data" bson:"data"`
+	Error []Error        `json:"error" bson:"error"`
+}
+
+type FinanceAdjustmentOutputSingle struct {
+	Data  FinanceAdjustment `json:"data" bson:"data"`
+	Error []Error           `json:"error" bson:"error"`
+}
+
+type FinanceAdjustmentOutput struct {
+	Data  []FinanceAdjustment `json:"data" bson:"data"`
+	Error []Error             `json:"error" bson:"error"`
+}
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"errors"
+	"slices"
+)
+
+// Permission is an action a role is allowed to do. Routes declare the permissions they require
+type Permission string
+
+const (
+	PermissionSalesWrite       Permission = "sales:write"       // sales sessions, receipts and sales transactions
+	PermissionSalesRefund      Permission = "sales:refund"      // refunds of receipts
+	PermissionSalesDelete      Permission = "sales:delete"      // delete sales transactions
+	PermissionJournalsWrite    Permission = "journals:write"    // open and close journals, operations of open journals
+	PermissionJournalsReopen   Permission = "journals:reopen"   // reopen closed journals
+	PermissionTransactionsEdit Permission = "transactions:edit" // edit and delete transactions
+	PermissionFinanceManage    Permission = "finance:manage"    // create finances of branches
+	PermissionBranchesManage   Permission = "branches:manage"   // create, edit and deactivate branches, migrate branches
+	PermissionProductsManage   Permission = "products:manage"   // create, edit and delete products
+	PermissionStockManage      Permission = "stock:manage"      // income, transfers, write-offs and stocktake counts
+	PermissionStocktakeApprove Permission = "stocktake:approve" // approve and cancel stocktakes
+	PermissionPurchaseOrders   Permission = "purchase_orders:manage"
+	PermissionSuppliersManage  Permission = "suppliers:manage"
+	PermissionCustomersManage  Permission = "customers:manage"
+	PermissionBNPLManage       Permission = "bnpl:manage" // create and credit bnpls
+	PermissionBNPLDelete       Permission = "bnpl:delete"
+	PermissionProposalsManage  Permission = "proposals:manage"
+	PermissionReportsRead      Permission = "reports:read" // analytics, dashboards and activities of all users
+	PermissionDocsRead         Permission = "docs:read"    // swagger documentation of the api
+	PermissionUsersManage      Permission = "users:manage"
+	PermissionTerminalsManage  Permission = "terminals:manage" // register and revoke terminals, audit of terminals
+	PermissionExpensesWrite    Permission = "expenses:write"   // record internal expenses of branches and their attachments
+	PermissionExpensesApprove  Permission = "expenses:approve" // approve and reject internal expenses --- approved expenses are paid
+	PermissionExpensesManage   Permission = "expenses:manage"  // expense categories and recurring expense templates
+	PermissionPeriodsClose     Permission = "periods:close"    // close accounting periods of branches
+	PermissionPeriodsReopen    Permission = "periods:reopen"   // reopen closed periods --- admins only
+	PermissionDataMigrate      Permission = "data:migrate"     // money migration and opening balances of the ledger, rewrite data of every branch --- admins only
+)
+
+const (
+	RoleAdmin       = "admin"       // every permission, never bound to a branch
+	RoleManager     = "manager"     // runs branches --- everything except managing users
+	RoleCashier     = "cashier"     // sells at a branch
+	RoleStorekeeper = "storekeeper" // handles stock at a branch or a warehouse
+	RoleOwner       = "owner"       // reads the reports and dashboards of every branch
+	RoleAccountant  = "accountant"  // reads the reports and dashboards of every branch
+)
+
+// RolePermissions maps roles to their permissions. Users with unknown or empty role have no permissions
+var RolePermissions = map[string][]Permission{
+	RoleManager: {
+		PermissionSalesWrite,
+		PermissionSalesRefund,
+		PermissionSalesDelete,
+		PermissionJournalsWrite,
+		PermissionJournalsReopen,
+		PermissionTransactionsEdit,
+		PermissionFinanceManage,
+		PermissionProductsManage,
+		PermissionStockManage,
+		PermissionStocktakeApprove,
+		PermissionPurchaseOrders,
+		PermissionSuppliersManage,
+		PermissionCustomersManage,
+		PermissionBNPLManage,
+		PermissionBNPLDelete,
+		PermissionProposalsManage,
+		PermissionReportsRead,
+		PermissionTerminalsManage,
+		PermissionExpensesWrite,
+		PermissionExpensesApprove,
+		PermissionExpensesManage,
+		PermissionPeriodsClose,
+	},
+	RoleCashier: {
+		PermissionSalesWrite,
+		PermissionJournalsWrite,
+		PermissionCustomersManage,
+		PermissionBNPLManage,
+		PermissionProposalsManage,
+		PermissionExpensesWrite,
+	},
+	RoleStorekeeper: {
+		PermissionStockManage,
+		PermissionPurchaseOrders,
+		PermissionProposalsManage,
+	},
+	RoleOwner: {
+		PermissionReportsRead,
+		PermissionDocsRead,
+	},
+	RoleAccountant: {
+		PermissionReportsRead,
+		PermissionDocsRead,
+		PermissionPeriodsClose,
+	},
+}
+
+func HasPermission(role string, permission Permission) bool {
+	if role == RoleAdmin {
+		return true
+	}
+	return slices.Contains(RolePermissions[role], permission)
+}
+
+func ValidateRole(role string) error {
+	if role == RoleAdmin {
+		return nil
+	}
+	if _, ok := RolePermissions[role]; !ok {
+		return errors.New("invalid role " + role)
+	}
+	return nil
+}
+
+// BoundBranch returns the branch the user is restricted to. Admins and users without branch can access every branch
+func (u *User) BoundBranch() (string, bool) {
+	if u.Role == RoleAdmin || u.Branch == "" {
+		return "", false
+	}
+	return u.Branch, true
+}
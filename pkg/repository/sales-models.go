@@ -20,6 +20,23 @@ type SalesSession struct {
 type SalesSessionItem struct {
 	Quantity int   `json:"quantity" bson:"quantity"`
 	Price    int32 `json:"price" bson:"price"`
+	Cost     int32 `json:"cost" bson:"cost"` // cost of the product at the branch, snapshotted on checkout
+}
+
+type CloseSalesSessionInput struct {
+	PaymentMethod PaymentMethod `json:"payment_method"` // used when the whole sale is paid with a single method
+	Description   string        `json:"description"`
+	CustomerID    string        `json:"customer_id"`
+	Tenders       []TenderInput `json:"tenders"` // split payment --- must sum to the session total
+}
+
+// TotalPrice returns the sum of price * quantity of all items in the session
+func (s *SalesSession) TotalPrice() int64 {
+	var total int64
+	for _, item := range s.Products {
+		total += int64(item.Price) * int64(item.Quantity)
+	}
+	return total
 }
 
 func NewSalesSession(branchID string, cache *cache.Cache) (*SalesSession, error) {
@@ -60,6 +77,22 @@ func (s *SalesSession) DeleteSession(cache *cache.Cache) error {
 	return nil
 }
 
+// ClaimSalesSessionClose locks the session for checkout, false if another request is closing it. The lock expires by itself
+// so that a crashed checkout does not keep the session locked
+func ClaimSalesSessionClose(id string, cache *cache.Cache) (bool, error) {
+	claimed, err := cache.RedisClient.SetNX("sales_session_close:"+id, time.Now().Unix(), 1*time.Minute).Result()
+	if err != nil {
+		log.Error().Err(err).Str("session_id", id).Msg("Failed to claim session close")
+		return false, err
+	}
+	return claimed, nil
+}
+
+// ReleaseSalesSessionClose unlocks the session after a failed checkout so that it can be closed again
+func ReleaseSalesSessionClose(id string, cache *cache.Cache) error {
+	return cache.RedisClient.Del("sales_session_close:" + id).Err()
+}
+
 func GetSalesSession(id string, cache *cache.Cache) (*SalesSession, error) {
 	log.Info().Str("session_id", id).Msg("Getting sales session")
 
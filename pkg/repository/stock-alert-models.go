@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// LowStockItem is a product whose quantity at a place is below its MinimumStockAlert
+type LowStockItem struct {
+	ProductID         string       `json:"product_id" bson:"product_id"`
+	Name              string       `json:"name" bson:"name"`
+	SKU               string       `json:"sku" bson:"sku"`
+	Place             ProductPlace `json:"place" bson:"place"`
+	Quantity          int32        `json:"quantity" bson:"quantity"`
+	MinimumStockAlert int32        `json:"minimum_stock_alert" bson:"minimum_stock_alert"`
+}
+
+// StockAlert marks a product at a place as already alerted, so an event is only emitted when the threshold is crossed.
+// It is removed once the quantity is back at or above the threshold
+type StockAlert struct {
+	ID           string `json:"id" bson:"_id"` // product_id:place_id
+	LowStockItem `bson:",inline"`
+	AlertedAt    time.Time `json:"alerted_at" bson:"alerted_at"`
+}
+
+func StockAlertID(productID string, placeID string) string {
+	return productID + ":" + placeID
+}
+
+const LowStockEventType = "low_stock"
+
+// LowStockEvent is sent to the configured webhook when products cross their threshold
+type LowStockEvent struct {
+	Type  string         `json:"type"`
+	Items []LowStockItem `json:"items"`
+	Date  time.Time      `json:"date"`
+}
+
+type LowStockOutput struct {
+	Data  []LowStockItem `json:"data"`
+	Error []Error        `json:"error"`
+}
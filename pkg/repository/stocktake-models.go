@@ -0,0 +1,162 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// InitiatorTypeShrinkage is a loss transaction created when a stocktake finds less stock than recorded
+const InitiatorTypeShrinkage InitiatorType = "shrinkage"
+
+type StocktakeStatus string
+
+const (
+	StocktakeStatusOpen      StocktakeStatus = "open"      // snapshot is taken, counts are accepted
+	StocktakeStatusApproved  StocktakeStatus = "approved"  // variances are posted as stock adjustments
+	StocktakeStatusCancelled StocktakeStatus = "cancelled" // nothing is posted
+)
+
+// StocktakeLine is a product of the place frozen at the time the stocktake was opened
+type StocktakeLine struct {
+	ProductID     string           `json:"product_id" bson:"product_id"`
+	Name          string           `json:"name" bson:"name"`
+	SKU           string           `json:"sku" bson:"sku"`
+	Expected      int32            `json:"expected" bson:"expected"`             // quantity recorded at the place when the snapshot was taken
+	Cost          int32            `json:"cost" bson:"cost"`                     // unit cost at the place when the snapshot was taken
+	Counts        map[string]int32 `json:"counts" bson:"counts"`                 // counted quantity per device, devices count different shelves so they are summed
+	Counted       int32            `json:"counted" bson:"counted"`               // total counted quantity
+	Variance      int32            `json:"variance" bson:"variance"`             // counted - expected
+	VarianceValue int64            `json:"variance_value" bson:"variance_value"` // variance at cost
+}
+
+// IsCounted reports whether any device submitted a count for the line. Lines which are not counted are not adjusted
+func (l *StocktakeLine) IsCounted() bool {
+	return len(l.Counts) > 0
+}
+
+func (l *StocktakeLine) calculate() {
+	l.Counted = 0
+	for _, quantity := range l.Counts {
+		l.Counted += quantity
+	}
+	l.Variance = 0
+	if l.IsCounted() {
+		l.Variance = l.Counted - l.Expected
+	}
+	l.VarianceValue = int64(l.Variance) * int64(l.Cost)
+}
+
+// Stocktake is a physical inventory count session of a place
+type Stocktake struct {
+	ID             string                    `json:"id" bson:"_id"`
+	Place          ProductPlace              `json:"place" bson:"place"`
+	Status         StocktakeStatus           `json:"status" bson:"status"`
+	Lines          map[string]*StocktakeLine `json:"lines" bson:"lines"` // product id -> line
+	Note           string                    `json:"note" bson:"note"`
+	ShrinkageValue int64                     `json:"shrinkage_value" bson:"shrinkage_value"` // net loss at cost, negative if more stock was found than recorded
+	TransactionID  string                    `json:"transaction_id" bson:"transaction_id"`   // shrinkage transaction posted on approval
+	CreatedBy      string                    `json:"created_by" bson:"created_by"`
+	CreatedAt      time.Time                 `json:"created_at" bson:"created_at"`
+	ClosedBy       string                    `json:"closed_by" bson:"closed_by"` // approved or cancelled by
+	ClosedAt       time.Time                 `json:"closed_at" bson:"closed_at"`
+}
+
+func NewStocktake(place ProductPlace, note string, createdBy string) *Stocktake {
+	return &Stocktake{
+		ID:        uuid.New().String(),
+		Place:     place,
+		Status:    StocktakeStatusOpen,
+		Lines:     map[string]*StocktakeLine{},
+		Note:      note,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}
+}
+
+// AddLine freezes the recorded quantity and cost of the product at the place
+func (s *Stocktake) AddLine(product *Product, distribution ProductDistribution) {
+	s.Lines[product.ID] = &StocktakeLine{
+		ProductID: product.ID,
+		Name:      product.Name,
+		SKU:       product.SKU,
+		Expected:  distribution.Quantity,
+		Cost:      distribution.Cost,
+		Counts:    map[string]int32{},
+	}
+}
+
+// Calculate fills counted quantities and variances of the lines and the shrinkage value of the stocktake
+func (s *Stocktake) Calculate() {
+	s.ShrinkageValue = 0
+	for _, line := range s.Lines {
+		if line.Counts == nil {
+			line.Counts = map[string]int32{}
+		}
+		line.calculate()
+		s.ShrinkageValue -= line.VarianceValue
+	}
+}
+
+// Variances returns counted lines whose counted quantity differs from the expected one
+func (s *Stocktake) Variances() []StocktakeLine {
+	variances := []StocktakeLine{}
+	for _, line := range s.Lines {
+		if line.Variance != 0 {
+			variances = append(variances, *line)
+		}
+	}
+	return variances
+}
+
+type NewStocktakeInput struct {
+	Place ProductPlace `json:"place"`
+	Note  string       `json:"note"`
+}
+
+type StocktakeCountInput struct {
+	ProductID string `json:"product_id"`
+	Quantity  int32  `json:"quantity"`
+}
+
+// SubmitStocktakeCountsInput holds quantities counted on a device. Submitting again from the same device replaces its previous counts of the products
+type SubmitStocktakeCountsInput struct {
+	Device string                `json:"device"` // defaults to the user
+	Counts []StocktakeCountInput `json:"counts"`
+}
+
+func (i *SubmitStocktakeCountsInput) Validate(stocktake *Stocktake) error {
+	if i.Device == "" {
+		return errors.New("device is required")
+	}
+	// device is used as a key of the counts
+	if strings.ContainsAny(i.Device, ".$") {
+		return errors.New("device must not contain '.' or '$'")
+	}
+	if len(i.Counts) == 0 {
+		return errors.New("at least one count is required")
+	}
+	for _, count := range i.Counts {
+		if _, ok := stocktake.Lines[count.ProductID]; !ok {
+			return errors.New("product " + count.ProductID + " is not in the stocktake")
+		}
+		if count.Quantity < 0 {
+			return errors.New("counted quantity cannot be negative")
+		}
+	}
+	return nil
+}
+
+type StocktakeOutput struct {
+	Data  []Stocktake `json:"data"`
+	Error []Error     `json:"error"`
+}
+
+type StocktakeQueryParams struct {
+	Status  StocktakeStatus `query:"status"`
+	PlaceID string          `query:"place_id"`
+	Page    int             `query:"page" default:"1"`
+	Count   int             `query:"count" default:"25"`
+}
@@ -10,13 +10,15 @@ type SupplierBase struct {
 	INN     string `json:"inn,omitempty" bson:"inn,omitempty"`
 	Notes   string `json:"notes,omitempty" bson:"notes,omitempty"`
 	Branch  string `json:"branch" bson:"branch"`
+	// the supplier is paid in the currency and its balance is kept in it, defaults to the base currency of the branch
+	Currency Currency `json:"currency" bson:"currency"`
 }
 
 type FinancialData struct {
-	Balance       int32         `json:"balance" bson:"balance"`
+	Balance       int64         `json:"balance" bson:"balance"`
 	Transactions  []Transaction `json:"transactions" bson:"transactions"`
-	TotalIncome   int32         `json:"total_income" bson:"total_income"`
-	TotalExpenses int32         `json:"total_expenses" bson:"total_expenses"`
+	TotalIncome   int64         `json:"total_income" bson:"total_income"`
+	TotalExpenses int64         `json:"total_expenses" bson:"total_expenses"`
 }
 
 type Supplier struct {
@@ -36,3 +38,33 @@ type SupplierOutputSingle struct {
 	Data  Supplier `json:"data" bson:"data"`
 	Error []Error  `json:"error" bson:"error"`
 }
+
+type SupplierBalancesQueryParams struct {
+	Branch string    `query:"branch"` // branch id, all branches if not set
+	Date   time.Time `query:"date"`   // date of the exchange rates, defaults to now
+}
+
+// SupplierBalance is the balance of the supplier in its currency and in the base currency of its branch
+type SupplierBalance struct {
+	SupplierID   string    `json:"supplier_id"`
+	Name         string    `json:"name"`
+	Branch       string    `json:"branch"`
+	Currency     Currency  `json:"currency"`
+	Balance      int64     `json:"balance"`
+	BaseCurrency Currency  `json:"base_currency"`
+	BaseBalance  int64     `json:"base_balance"`
+	Rate         float64   `json:"rate"`      // rate the balance was converted at
+	RateDate     time.Time `json:"rate_date"` // day of the rate
+}
+
+// SupplierBalancesReport lists the balances of the suppliers converted to the base currencies of their branches at the rates of the date
+type SupplierBalancesReport struct {
+	Date      time.Time          `json:"date"`
+	Suppliers []SupplierBalance  `json:"suppliers"`
+	Totals    map[Currency]int64 `json:"totals"` // sum of the converted balances per base currency
+}
+
+type SupplierBalancesOutput struct {
+	Data  SupplierBalancesReport `json:"data"`
+	Error []Error                `json:"error"`
+}
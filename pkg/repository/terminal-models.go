@@ -0,0 +1,161 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidPIN = errors.New("invalid username or pin")
+
+type attributionKey struct{}
+
+// Attribution is who does an operation and on which terminal. It travels in the context of the database transaction
+// so that the records created within it are attributed to the active cashier
+type Attribution struct {
+	User     string
+	Terminal string
+}
+
+func WithAttribution(ctx context.Context, attribution Attribution) context.Context {
+	return context.WithValue(ctx, attributionKey{}, attribution)
+}
+
+func AttributionOf(ctx context.Context) Attribution {
+	attribution, _ := ctx.Value(attributionKey{}).(Attribution)
+	return attribution
+}
+
+// Terminal is a shared POS device of a branch. It authenticates with its device token, cashiers switch on it with their PINs
+type Terminal struct {
+	ID          string     `json:"id" bson:"_id"`
+	Name        string     `json:"name" bson:"name"`
+	Branch      string     `json:"branch" bson:"branch"`
+	TokenHash   string     `json:"-" bson:"token_hash"`
+	CreatedBy   string     `json:"created_by" bson:"created_by"`
+	CreatedAt   time.Time  `json:"created_at" bson:"created_at"`
+	Revoked     bool       `json:"revoked" bson:"revoked"`
+	ActiveUser  string     `json:"active_user" bson:"active_user"` // cashier working on the terminal, empty if locked
+	ActiveShift string     `json:"active_shift" bson:"active_shift"`
+	ActiveSince *time.Time `json:"active_since" bson:"active_since"`
+	LastSeenAt  *time.Time `json:"last_seen_at" bson:"last_seen_at"`
+}
+
+type TerminalInput struct {
+	Name   string `json:"name"`
+	Branch string `json:"branch"`
+}
+
+func (i *TerminalInput) Validate() error {
+	if i.Name == "" {
+		return errors.New("name is required")
+	}
+	if i.Branch == "" {
+		return errors.New("branch is required")
+	}
+	return nil
+}
+
+type TerminalCreatedOutput struct {
+	Data  []Terminal `json:"data"`
+	Token string     `json:"token"` // device token, shown only once
+	Error []Error    `json:"error"`
+}
+
+type TerminalsOutput struct {
+	Data  []Terminal `json:"data"`
+	Error []Error    `json:"error"`
+}
+
+func HashTerminalToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewTerminal creates the terminal and returns its device token
+func NewTerminal(input TerminalInput, created_by string) (*Terminal, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", err
+	}
+	token := hex.EncodeToString(buf)
+	return &Terminal{
+		ID:        uuid.New().String(),
+		Name:      input.Name,
+		Branch:    input.Branch,
+		TokenHash: HashTerminalToken(token),
+		CreatedBy: created_by,
+		CreatedAt: time.Now(),
+	}, token, nil
+}
+
+type TerminalShiftEnd string
+
+const (
+	TerminalShiftEndSwitch  TerminalShiftEnd = "switch"  // another cashier switched in
+	TerminalShiftEndLock    TerminalShiftEnd = "lock"    // terminal was locked
+	TerminalShiftEndRevoked TerminalShiftEnd = "revoked" // terminal was revoked
+)
+
+// TerminalShift is the period a cashier was active on a terminal, the audit of the terminal
+type TerminalShift struct {
+	ID         string           `json:"id" bson:"_id"`
+	TerminalID string           `json:"terminal_id" bson:"terminal_id"`
+	Branch     string           `json:"branch" bson:"branch"`
+	Username   string           `json:"username" bson:"username"`
+	SessionID  string           `json:"session_id" bson:"session_id"`
+	StartedAt  time.Time        `json:"started_at" bson:"started_at"`
+	EndedAt    *time.Time       `json:"ended_at" bson:"ended_at"`
+	EndReason  TerminalShiftEnd `json:"end_reason" bson:"end_reason"`
+}
+
+func NewTerminalShift(terminal *Terminal, username string, session_id string) *TerminalShift {
+	return &TerminalShift{
+		ID:         uuid.New().String(),
+		TerminalID: terminal.ID,
+		Branch:     terminal.Branch,
+		Username:   username,
+		SessionID:  session_id,
+		StartedAt:  time.Now(),
+	}
+}
+
+type TerminalShiftsOutput struct {
+	Data  []TerminalShift `json:"data"`
+	Error []Error         `json:"error"`
+}
+
+type TerminalShiftsQueryParams struct {
+	Username string `query:"username"`
+	FromDate string `query:"from_date"` // YYYY-MM-DD
+	ToDate   string `query:"to_date"`   // YYYY-MM-DD
+	Page     int    `query:"page" default:"1"`
+	Count    int    `query:"count" default:"50"`
+}
+
+// SwitchCashierInput switches the active cashier of the terminal
+type SwitchCashierInput struct {
+	Username string `json:"username"`
+	PIN      string `json:"pin"`
+}
+
+// SetPINInput sets the PIN of the user, the password confirms it
+type SetPINInput struct {
+	Password string `json:"password"`
+	PIN      string `json:"pin"`
+}
+
+var pinPattern = regexp.MustCompile(`^[0-9]{4,8}$`)
+
+func ValidatePIN(pin string) error {
+	if !pinPattern.MatchString(pin) {
+		return errors.New("pin must be 4 to 8 digits")
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/utils"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+var (
+	ErrTransactionNotEditable = errors.New("transaction belongs to another document (receipt, transfer, supplier, bnpl, expense or stock loss) and is changed through it")
+	ErrTransactionVoided      = errors.New("transaction is voided")
+	ErrNothingToChange        = errors.New("nothing to change")
+	ErrReasonRequired         = errors.New("reason is required")
+)
+
+// TransactionRevisionAction is how a transaction was changed
+type TransactionRevisionAction string
+
+const (
+	TransactionRevisionEdit TransactionRevisionAction = "edit"
+	TransactionRevisionVoid TransactionRevisionAction = "void"
+)
+
+// Editable returns ErrTransactionNotEditable if the transaction is a part of another document. Only standalone sales
+// and expenses change the finance of the branch alone, the rest must be changed together with their documents
+func (t *Transaction) Editable() error {
+	if t.Voided {
+		return ErrTransactionVoided
+	}
+	if t.ReceiptID != "" || t.TransferID != "" {
+		return ErrTransactionNotEditable
+	}
+	switch t.Type {
+	case InitiatorTypeSales, InitiatorTypeSalary, InitiatorTypeRent, InitiatorTypeUtilities, InitiatorTypeOther:
+		return nil
+	}
+	return ErrTransactionNotEditable
+}
+
+// JournalAmount is how much the transaction adds to the total of the journal it is an operation of
+func (t *Transaction) JournalAmount() int64 {
+	if t.Voided {
+		return 0
+	}
+	if t.TransactionBase.Type == TransactionTypeDebit {
+		return -t.AmountInBase()
+	}
+	return t.AmountInBase()
+}
+
+// TransactionEditInput changes the given fields of a transaction, the reason is kept in its history
+type TransactionEditInput struct {
+	Amount        *int64          `json:"amount"`
+	Description   *string         `json:"description"`
+	Type          TransactionType `json:"type"`           // credit or debit
+	PaymentMethod PaymentMethod   `json:"payment_method"` // cash, bank, terminal, ...
+	Reason        string          `json:"reason"`
+}
+
+func (i *TransactionEditInput) Validate() error {
+	if i.Reason == "" {
+		return ErrReasonRequired
+	}
+	if i.Amount == nil && i.Description == nil && i.Type == "" && i.PaymentMethod == "" {
+		return ErrNothingToChange
+	}
+	if i.Amount != nil && *i.Amount <= 0 {
+		return errors.New("amount must be greater than 0")
+	}
+	if i.Type != "" {
+		if err := ValidateTransactionType(i.Type); err != nil {
+			return err
+		}
+	}
+	if i.PaymentMethod != "" {
+		return ValidatePaymentMethod(i.PaymentMethod)
+	}
+	return nil
+}
+
+// Apply returns the transaction with the changes of the input. Editable transactions are in the base currency of the branch
+func (i *TransactionEditInput) Apply(transaction Transaction) Transaction {
+	if i.Amount != nil {
+		transaction.Amount = *i.Amount
+		transaction.BaseAmount = *i.Amount
+	}
+	if i.Description != nil {
+		transaction.Description = *i.Description
+	}
+	if i.Type != "" {
+		transaction.TransactionBase.Type = i.Type
+	}
+	if i.PaymentMethod != "" {
+		transaction.PaymentMethod = i.PaymentMethod
+	}
+	return transaction
+}
+
+// TransactionVoidInput cancels a transaction, the reason is kept in its history
+type TransactionVoidInput struct {
+	Reason string `json:"reason"`
+}
+
+func (i *TransactionVoidInput) Validate() error {
+	if i.Reason == "" {
+		return ErrReasonRequired
+	}
+	return nil
+}
+
+// TransactionChange is a field of a transaction changed by a revision
+type TransactionChange struct {
+	Field string      `json:"field" bson:"field"`
+	From  interface{} `json:"from" bson:"from"`
+	To    interface{} `json:"to" bson:"to"`
+}
+
+// DiffTransactions returns the fields changed between the two versions of a transaction
+func DiffTransactions(from *Transaction, to *Transaction) []TransactionChange {
+	changes := []TransactionChange{}
+	add := func(field string, from interface{}, to interface{}) {
+		if from != to {
+			changes = append(changes, TransactionChange{Field: field, From: from, To: to})
+		}
+	}
+	add("amount", from.Amount, to.Amount)
+	add("base_amount", from.AmountInBase(), to.AmountInBase())
+	add("description", from.Description, to.Description)
+	add("type", from.TransactionBase.Type, to.TransactionBase.Type)
+	add("payment_method", from.PaymentMethod, to.PaymentMethod)
+	add("voided", from.Voided, to.Voided)
+	return changes
+}
+
+// EffectOfRevision is the change of the finance of the branch turning the recorded effect of the original into the one of the revised transaction
+func EffectOfRevision(original *Transaction, revised *Transaction) FinanceEffect {
+	effect := FinanceEffect{}
+	if !revised.Voided {
+		for bucket, amount := range RecordedEffectOfTransaction(revised) {
+			effect[bucket] += amount
+		}
+	}
+	for bucket, amount := range RecordedEffectOfTransaction(original) {
+		effect[bucket] -= amount
+	}
+	for bucket, amount := range effect {
+		if amount == 0 {
+			delete(effect, bucket)
+		}
+	}
+	return effect
+}
+
+// TransactionVersion is a prior version of a transaction kept when it was edited or voided, with who changed it, when and why
+type TransactionVersion struct {
+	ID            string                    `json:"id" bson:"_id"`
+	TransactionID string                    `json:"transaction_id" bson:"transaction_id"`
+	BranchID      string                    `json:"branch_id" bson:"branch_id"`
+	Version       int                       `json:"version" bson:"version"` // version of the transaction before the change, the original is 0
+	Action        TransactionRevisionAction `json:"action" bson:"action"`
+	Reason        string                    `json:"reason" bson:"reason"`
+	Transaction   Transaction               `json:"transaction" bson:"transaction"` // the transaction as it was before the change
+	Changes       []TransactionChange       `json:"changes" bson:"changes"`
+	Effect        FinanceEffect             `json:"effect" bson:"effect"`                             // change applied to the finance of the branch
+	JournalID     string                    `json:"journal_id,omitempty" bson:"journal_id,omitempty"` // journal the transaction is an operation of
+	JournalDelta  int64                     `json:"journal_delta" bson:"journal_delta"`               // change applied to the total of the journal
+	ChangedBy     string                    `json:"changed_by,omitempty" bson:"changed_by,omitempty"`
+	Terminal      string                    `json:"terminal,omitempty" bson:"terminal,omitempty"`
+	ChangedAt     time.Time                 `json:"changed_at" bson:"changed_at"`
+}
+
+// NewTransactionVersion keeps the original transaction changed into the revised one, attributed to the user of the context
+func NewTransactionVersion(ctx context.Context, original *Transaction, revised *Transaction, action TransactionRevisionAction, reason string) *TransactionVersion {
+	attribution := AttributionOf(ctx)
+	return &TransactionVersion{
+		ID:            uuid.New().String(),
+		TransactionID: original.ID,
+		BranchID:      original.BranchID,
+		Version:       original.Version,
+		Action:        action,
+		Reason:        reason,
+		Transaction:   *original,
+		Changes:       DiffTransactions(original, revised),
+		Effect:        EffectOfRevision(original, revised),
+		ChangedBy:     attribution.User,
+		Terminal:      attribution.Terminal,
+		ChangedAt:     time.Now().In(utils.GetTimeZone()),
+	}
+}
+
+// TransactionRevision is the transaction after an edit or void together with its prior version and the diff
+type TransactionRevision struct {
+	Transaction Transaction        `json:"transaction" bson:"transaction"`
+	Previous    TransactionVersion `json:"previous" bson:"previous"`
+}
+
+type TransactionRevisionOutput struct {
+	Data  TransactionRevision `json:"data"`
+	Error []Error             `json:"error"`
+}
+
+type TransactionVersionsOutput struct {
+	Data  []TransactionVersion `json:"data"`
+	Error []Error              `json:"error"`
+}
+
+// TransactionVersionsCollection returns the transaction versions collection of the database of the given collection
+func TransactionVersionsCollection(of *mongo.Collection) *mongo.Collection {
+	return of.Database().Collection("transaction_versions")
+}
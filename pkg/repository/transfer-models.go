@@ -0,0 +1,157 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type TransferStatus string
+
+const (
+	TransferStatusDraft     TransferStatus = "draft"      // created, stock is not touched yet
+	TransferStatusInTransit TransferStatus = "in_transit" // dispatched --- quantity is taken from the source place
+	TransferStatusReceived  TransferStatus = "received"   // received --- quantity is added to the destination place
+)
+
+type TransferLine struct {
+	ProductID        string         `json:"product_id" bson:"product_id"`
+	Quantity         int32          `json:"quantity" bson:"quantity"`                   // quantity sent
+	ReceivedQuantity int32          `json:"received_quantity" bson:"received_quantity"` // quantity received at the destination
+	Batches          []ProductBatch `json:"batches" bson:"batches"`                     // batches taken from the source on dispatch, quantity is the quantity taken
+}
+
+// TransferDiscrepancy is recorded when received quantity of a line differs from the sent quantity
+type TransferDiscrepancy struct {
+	ProductID string `json:"product_id" bson:"product_id"`
+	Sent      int32  `json:"sent" bson:"sent"`
+	Received  int32  `json:"received" bson:"received"`
+}
+
+// Transfer moves products between places (branches and warehouses)
+type Transfer struct {
+	ID            string                `json:"id" bson:"_id"`
+	Source        ProductPlace          `json:"source" bson:"source"`
+	Destination   ProductPlace          `json:"destination" bson:"destination"`
+	Lines         []TransferLine        `json:"lines" bson:"lines"`
+	Status        TransferStatus        `json:"status" bson:"status"`
+	Discrepancies []TransferDiscrepancy `json:"discrepancies" bson:"discrepancies"`
+	Note          string                `json:"note" bson:"note"`
+	CreatedBy     string                `json:"created_by" bson:"created_by"`
+	CreatedAt     time.Time             `json:"created_at" bson:"created_at"`
+	DispatchedAt  time.Time             `json:"dispatched_at" bson:"dispatched_at"`
+	ReceivedAt    time.Time             `json:"received_at" bson:"received_at"`
+}
+
+type TransferLineInput struct {
+	ProductID string `json:"product_id"`
+	Quantity  int32  `json:"quantity"`
+}
+
+type NewTransferInput struct {
+	Source      ProductPlace        `json:"source"`
+	Destination ProductPlace        `json:"destination"`
+	Lines       []TransferLineInput `json:"lines"`
+	Note        string              `json:"note"`
+}
+
+func (n *NewTransferInput) Validate() error {
+	if n.Source.ID == "" || n.Destination.ID == "" {
+		return errors.New("source and destination are required")
+	}
+	if n.Source.ID == n.Destination.ID {
+		return errors.New("source and destination must be different")
+	}
+	if len(n.Lines) == 0 {
+		return errors.New("at least one line is required")
+	}
+	seen := map[string]bool{}
+	for _, line := range n.Lines {
+		if line.ProductID == "" {
+			return errors.New("product_id is required")
+		}
+		if line.Quantity <= 0 {
+			return errors.New("quantity must be greater than 0")
+		}
+		if seen[line.ProductID] {
+			return errors.New("product " + line.ProductID + " is listed more than once")
+		}
+		seen[line.ProductID] = true
+	}
+	return nil
+}
+
+// ReceiveTransferInput holds quantities counted at the destination. Lines which are not listed are considered fully received
+type ReceiveTransferInput struct {
+	Lines []TransferLineInput `json:"lines"`
+	Note  string              `json:"note"`
+}
+
+func NewTransfer(input *NewTransferInput, createdBy string) *Transfer {
+	lines := []TransferLine{}
+	for _, line := range input.Lines {
+		lines = append(lines, TransferLine{
+			ProductID: line.ProductID,
+			Quantity:  line.Quantity,
+		})
+	}
+	return &Transfer{
+		ID:            uuid.New().String(),
+		Source:        input.Source,
+		Destination:   input.Destination,
+		Lines:         lines,
+		Status:        TransferStatusDraft,
+		Discrepancies: []TransferDiscrepancy{},
+		Note:          input.Note,
+		CreatedBy:     createdBy,
+		CreatedAt:     time.Now(),
+	}
+}
+
+// Receive fills received quantities of the lines and records discrepancies
+func (t *Transfer) Receive(input *ReceiveTransferInput) error {
+	received := map[string]int32{}
+	for _, line := range input.Lines {
+		if line.Quantity < 0 {
+			return errors.New("received quantity cannot be negative")
+		}
+		received[line.ProductID] = line.Quantity
+	}
+
+	t.Discrepancies = []TransferDiscrepancy{}
+	for i, line := range t.Lines {
+		quantity, ok := received[line.ProductID]
+		if !ok {
+			quantity = line.Quantity
+		}
+		delete(received, line.ProductID)
+		t.Lines[i].ReceivedQuantity = quantity
+		if quantity != line.Quantity {
+			t.Discrepancies = append(t.Discrepancies, TransferDiscrepancy{
+				ProductID: line.ProductID,
+				Sent:      line.Quantity,
+				Received:  quantity,
+			})
+		}
+	}
+	for product_id := range received {
+		return errors.New("product " + product_id + " is not in the transfer")
+	}
+	if input.Note != "" {
+		t.Note = input.Note
+	}
+	return nil
+}
+
+type TransferOutput struct {
+	Data  []Transfer `json:"data"`
+	Error []Error    `json:"error"`
+}
+
+type TransferQueryParams struct {
+	Status  TransferStatus `query:"status"`
+	PlaceID string         `query:"place_id"` // source or destination
+	Page    int            `query:"page" default:"1"`
+	Count   int            `query:"count" default:"25"`
+}
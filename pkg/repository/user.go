@@ -1,17 +1,32 @@
 package models
 
 import (
+	"errors"
+	"time"
+
 	"github.com/google/uuid"
 )
 
 type User struct {
-	ID       string `bson:"_id" json:"id"`
-	Email    string `bson:"email" unique:"true" json:"email"`
-	Username string `bson:"username" unique:"true" json:"username"`
-	Password string `bson:"password" json:"password"`
-	Role     string `bson:"role" json:"role"`
-	Phone    string `bson:"phone" json:"phone"`
-	Branch   string `bson:"branch" json:"branch"`
+	ID            string         `bson:"_id" json:"id"`
+	Email         string         `bson:"email" unique:"true" json:"email"`
+	Username      string         `bson:"username" unique:"true" json:"username"`
+	Password      string         `bson:"password" json:"password"`
+	Role          string         `bson:"role" json:"role"`
+	Phone         string         `bson:"phone" json:"phone"`
+	Branch        string         `bson:"branch" json:"branch"`
+	Disabled      bool           `bson:"disabled" json:"disabled"`
+	PasswordReset *PasswordReset `bson:"password_reset,omitempty" json:"-"`
+	PINHash       string         `bson:"pin_hash,omitempty" json:"-"` // bcrypt hash of the PIN to switch in on terminals
+	CreatedAt     time.Time      `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time      `bson:"updated_at" json:"updated_at"`
+}
+
+// PasswordReset is a one-time code issued by an admin. Only the hash of the code is stored
+type PasswordReset struct {
+	CodeHash  string    `bson:"code_hash"`
+	IssuedBy  string    `bson:"issued_by"`
+	ExpiresAt time.Time `bson:"expires_at"`
 }
 
 type UserRegisterInput struct {
@@ -23,14 +38,105 @@ type UserRegisterInput struct {
 	Branch   string `bson:"branch" json:"branch"`
 }
 
+func (u *UserRegisterInput) Validate() error {
+	if u.Username == "" || u.Password == "" {
+		return errors.New("username and password are required")
+	}
+	return ValidateRole(u.Role)
+}
+
 func NewUser(username string, password string, email string, role string, phone string, branch string) *User {
 	return &User{
-		ID:       uuid.New().String(),
-		Username: username,
-		Password: password,
-		Email:    email,
-		Role:     role,
-		Phone:    phone,
-		Branch:   branch,
+		ID:        uuid.New().String(),
+		Username:  username,
+		Password:  password,
+		Email:     email,
+		Role:      role,
+		Phone:     phone,
+		Branch:    branch,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+}
+
+// UserInfo is the user as returned by the api --- without password and reset codes
+type UserInfo struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	Phone     string    `json:"phone"`
+	Branch    string    `json:"branch"`
+	Disabled  bool      `json:"disabled"`
+	HasPIN    bool      `json:"has_pin"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (u *User) Info() UserInfo {
+	return UserInfo{
+		ID:        u.ID,
+		Email:     u.Email,
+		Username:  u.Username,
+		Role:      u.Role,
+		Phone:     u.Phone,
+		Branch:    u.Branch,
+		Disabled:  u.Disabled,
+		HasPIN:    u.PINHash != "",
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+	}
+}
+
+type UsersOutput struct {
+	Data  []UserInfo `json:"data"`
+	Error []Error    `json:"error"`
+}
+
+type UsersQueryParams struct {
+	Search   string `query:"search"` // username, email or phone
+	Role     string `query:"role"`
+	Branch   string `query:"branch"`
+	Disabled *bool  `query:"disabled"`
+	Page     int    `query:"page" default:"1"`
+	Count    int    `query:"count" default:"25"`
+}
+
+// UpdateUserInput changes role and/or branch of the user. Empty branch unbinds the user from branch
+type UpdateUserInput struct {
+	Role   *string `json:"role"`
+	Branch *string `json:"branch"`
+}
+
+func (u *UpdateUserInput) Validate() error {
+	if u.Role == nil && u.Branch == nil {
+		return errors.New("role or branch is required")
+	}
+	if u.Role != nil {
+		return ValidateRole(*u.Role)
+	}
+	return nil
+}
+
+// PasswordResetCodeOutput is shown to the admin once, the code is handed to the user
+type PasswordResetCodeOutput struct {
+	Username  string    `json:"username"`
+	Code      string    `json:"code"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type RedeemPasswordResetInput struct {
+	Username    string `json:"username"`
+	Code        string `json:"code"`
+	NewPassword string `json:"new_password"`
+}
+
+func (r *RedeemPasswordResetInput) Validate() error {
+	if r.Username == "" || r.Code == "" {
+		return errors.New("username and code are required")
+	}
+	if len(r.NewPassword) < 6 {
+		return errors.New("new password must be at least 6 characters")
 	}
+	return nil
 }
@@ -10,166 +10,323 @@ import (
 	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/analytics"
 	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/arrivals"
 	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/auth"
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/branches"
 	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/customers"
 	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/customers/bnpl"
 	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/finance"
+	internalexpenses "github.com/aslon1213/g4h_pos_erp/pkg/controllers/internalExpenses"
 	journal_handlers "github.com/aslon1213/g4h_pos_erp/pkg/controllers/journals"
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/ledger"
 	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/products"
 	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/sales"
 	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/suppliers"
 	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/transactions"
 	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
 
 	pasetoware "github.com/gofiber/contrib/paseto"
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/basicauth"
 	"github.com/rs/zerolog/log"
+	fiberSwagger "github.com/swaggo/fiber-swagger"
 )
 
-func DashboardRoutes(router *fiber.App, dashboardController *analytics.DashboardHandler, middleware *middleware.Middlewares) {
+func DashboardRoutes(router *fiber.App, dashboardController *analytics.DashboardHandler, authController *auth.AuthControllers, middleware *middleware.Middlewares) {
 	dashboard := router.Group("/dashboard")
-	config, err := configs.LoadConfig(".")
-	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to load config")
-	}
-	auth := basicauth.New(basicauth.Config{
-		Users: map[string]string{
-			config.Server.AdminDocsUsers[0].Username: config.Server.AdminDocsUsers[0].Password,
-			config.Server.AdminDocsUsers[1].Username: config.Server.AdminDocsUsers[1].Password,
-		},
-		Realm: "Restricted",
-	})
+	dashboard.Get("/login", authController.LoginPage)                         // login page of dashboards and docs
+	dashboard.Post("/login", authController.WebLogin)                         // login form, sets session cookie -- activity logged here if succesfull
+	dashboard.Post("/logout", middleware.WebAuth(), authController.WebLogout) // logout -- activity logged here if succesfull
 
-	// log.Debug().Interface("auth", auth).Msg("Auth middleware initialized")
+	// dashboards show every branch --- users bound to a branch are not let in
+	auth := middleware.WebAuth(models.PermissionReportsRead)
 	dashboard.Get("/journals", auth, dashboardController.ServeDashBoardDays)
 	dashboard.Get("/general", auth, dashboardController.ServeDashBoardGeneral)
 	dashboard.Get("/comparison", auth, dashboardController.ServeDashBoardComparison)
 	dashboard.Get("/", auth, dashboardController.MainPage)
+	router.Get("/api/analytics/margins", middleware.Require(models.PermissionReportsRead), middleware.BranchQuery("branch_id"), dashboardController.GetMarginReport) // gross margin report
 	// dashboard.Get("/branches")
 }
 
+func DocsRoutes(router *fiber.App, middleware *middleware.Middlewares) {
+	router.Get("/docs/*", middleware.WebAuth(models.PermissionDocsRead), fiberSwagger.WrapHandler) // swagger docs, login with the login page of the dashboards
+}
+
 func AuthRoutes(router *fiber.App, authController *auth.AuthControllers, middleware *middleware.Middlewares) {
 	auth := router.Group("/auth")
-	auth.Post("/login", authController.Login)                                // login -- activity logged here if succesfull
-	auth.Post("/register", authController.Register)                          // register -- activity logged here if succesfull
-	router.Get("/api/auth/me", authController.InfoMe)                        // get user info
-	router.Get("/api/activities/recent", authController.GetRecentActivities) // get recent activities
-	router.Get("/api/activities/me", authController.GetActivitesOfUser)      // get activities of user
+	auth.Post("/login", authController.Login)                                                                                  // login -- activity logged here if succesfull
+	auth.Post("/register", authController.Register)                                                                            // register first admin -- activity logged here if succesfull
+	auth.Post("/password-reset", authController.RedeemPasswordReset)                                                           // reset password with one-time code -- activity logged here if succesfull
+	auth.Post("/refresh", authController.Refresh)                                                                              // exchange refresh token for new tokens
+	router.Get("/api/auth/me", authController.InfoMe)                                                                          // get user info
+	router.Post("/api/auth/logout", authController.Logout)                                                                     // logout -- activity logged here if succesfull
+	router.Get("/api/auth/sessions", authController.GetMySessions)                                                             // get active sessions of user
+	router.Delete("/api/auth/sessions/:id", authController.RevokeMySession)                                                    // revoke session of user -- activity logged here if succesfull
+	router.Put("/api/auth/pin", authController.SetMyPIN)                                                                       // set PIN for terminals -- activity logged here if succesfull
+	router.Get("/api/activities/recent", middleware.Require(models.PermissionReportsRead), authController.GetRecentActivities) // get recent activities
+	router.Get("/api/activities/me", authController.GetActivitesOfUser)                                                        // get activities of user
+
+	// user management
+	users := router.Group("/api/users", middleware.Require(models.PermissionUsersManage))
+	users.Get("/", authController.QueryUsers)                            // query users
+	users.Post("/", authController.CreateUser)                           // create user -- activity logged here if succesfull
+	users.Get("/:id", authController.GetUserByID)                        // get user by id
+	users.Put("/:id", authController.UpdateUser)                         // change role and branch of user -- activity logged here if succesfull
+	users.Post("/:id/disable", authController.DisableUser)               // disable user -- activity logged here if succesfull
+	users.Post("/:id/enable", authController.EnableUser)                 // enable user -- activity logged here if succesfull
+	users.Post("/:id/password-reset", authController.IssuePasswordReset) // issue one-time password reset code -- activity logged here if succesfull
+	users.Post("/:id/unlock", authController.UnlockUser)                 // unlock login of user -- activity logged here if succesfull
+	users.Post("/:id/logout", authController.ForceLogoutUser)            // force logout of user -- activity logged here if succesfull
+	users.Get("/:id/activities", authController.GetActivitiesOfUserByID) // get activities of user
+
+	// brute-force protection
+	security := router.Group("/api/security", middleware.Require(models.PermissionUsersManage))
+	security.Get("/lockouts", authController.GetLoginLockouts) // get locked usernames and ips
+	security.Post("/unlock", authController.UnlockLogin)       // unlock username or ip -- activity logged here if succesfull
+	security.Get("/report", authController.GetSecurityReport)  // failed login bursts
+
+	// api keys of integrations
+	api_keys := router.Group("/api/api-keys", middleware.Require(models.PermissionUsersManage))
+	api_keys.Get("/", authController.QueryAPIKeys)       // query api keys
+	api_keys.Post("/", authController.CreateAPIKey)      // create api key -- activity logged here if succesfull
+	api_keys.Get("/:id", authController.GetAPIKeyByID)   // get api key by id
+	api_keys.Delete("/:id", authController.RevokeAPIKey) // revoke api key -- activity logged here if succesfull
+
+	// shared POS terminals
+	terminals := router.Group("/api/terminals", middleware.Require(models.PermissionTerminalsManage))
+	terminals.Get("/", authController.QueryTerminals)              // query terminals
+	terminals.Post("/", authController.CreateTerminal)             // register terminal -- activity logged here if succesfull
+	terminals.Delete("/:id", authController.RevokeTerminal)        // revoke terminal -- activity logged here if succesfull
+	terminals.Get("/:id/shifts", authController.GetTerminalShifts) // who was active on the terminal when
+
+	// authenticated with the device token of the terminal
+	terminal := router.Group("/terminal", middleware.TerminalAuth)
+	terminal.Get("/", authController.GetTerminal)          // get terminal and its active cashier
+	terminal.Post("/switch", authController.SwitchCashier) // switch cashier with PIN -- activity logged here if succesfull
+	terminal.Post("/lock", authController.LockTerminal)    // lock terminal -- activity logged here if succesfull
 }
 
 func SuppliersRoutes(router *fiber.App, suppliersController *suppliers.SuppliersController, middleware *middleware.Middlewares) {
+	manage := middleware.Require(models.PermissionSuppliersManage)
 	api := router.Group("/api")
-	api.Get("/suppliers", suppliersController.GetSuppliers)                                         // get all suppliers
-	api.Get("/suppliers/:id", suppliersController.GetSupplierByID)                                  // get supplier by id
-	api.Post("/suppliers", suppliersController.CreateSupplier)                                      // create supplier -- activity logged here if succesfull                                   // create supplier
-	api.Put("/suppliers/:id", suppliersController.UpdateSupplier)                                   // update supplier
-	api.Delete("/suppliers/:id", suppliersController.DeleteSupplier)                                // delete supplier
-	api.Post("/suppliers/:branch_id/:supplier_id/transactions", suppliersController.NewTransaction) // create transaction
+	api.Get("/suppliers", suppliersController.GetSuppliers)                                                                                                     // get all suppliers
+	api.Get("/suppliers/balances", middleware.Require(models.PermissionReportsRead), middleware.BranchQuery("branch"), suppliersController.GetSupplierBalances) // balances of suppliers converted to the base currency of their branches
+	api.Get("/suppliers/:id", suppliersController.GetSupplierByID)                                                                                              // get supplier by id
+	api.Post("/suppliers", manage, suppliersController.CreateSupplier)                                                                                          // create supplier -- activity logged here if succesfull
+	api.Put("/suppliers/:id", manage, suppliersController.UpdateSupplier)                                                                                       // update supplier
+	api.Delete("/suppliers/:id", manage, suppliersController.DeleteSupplier)                                                                                    // delete supplier
+	api.Post("/suppliers/:branch_id/:supplier_id/transactions", manage, middleware.BranchParam("branch_id"), suppliersController.NewTransaction)                // create transaction
 }
 
 func SalesRoutes(router *fiber.App, salesController *sales.SalesTransactionsController, middleware *middleware.Middlewares) {
+	write := middleware.Require(models.PermissionSalesWrite)
+	branch := middleware.BranchParam("branch_id")
+	session_branch := middleware.BranchOf(salesController.SessionBranch)
+	receipt_branch := middleware.BranchOfDocument("receipts", "receipt_id", "branch_id")
 	api := router.Group("/api")
-	api.Post("/sales/transactions/:branch_id", salesController.CreateSalesTransaction)        // create sales transaction -- activity logged here if succesfull
-	api.Delete("/sales/transactions/:transaction_id", salesController.DeleteSalesTransaction) // delete sales transaction -- activity logged here if succesfull
+	api.Post("/sales/transactions/:branch_id", write, branch, salesController.CreateSalesTransaction)                                                                                                                                                                                                                     // create sales transaction -- activity logged here if succesfull
+	api.Delete("/sales/transactions/:transaction_id", middleware.Require(models.PermissionSalesDelete), middleware.BranchOfDocument("transactions", "transaction_id", "branch_id"), middleware.PeriodOpenOfDocument("transactions", "transaction_id", "branch_id", "created_at"), salesController.DeleteSalesTransaction) // void sales transaction -- activity logged here if succesfull
 	// sales session routes
-	// api.Post("/sales/session/branch/:branch_id", salesController.OpenSalesSession)          // open sales session
-	// api.Post("/sales/session/:session_id/product", salesController.AddProductItemToSession) // add product to session
-	// api.Post("/sales/session/:session_id/close", salesController.CloseSalesSession)         // close sales session
-	// api.Get("/sales/session/:session_id", salesController.GetSalesSession)                  // get sales session
-	// api.Delete("/sales/session/:session_id", salesController.DeleteSalesSession)            // delete sales session
-	// api.Get("/sales/session/branch/:branch_id", salesController.GetSalesSessionsOfBranch)   // get sales of session
-	// router.Get("/sales/branch/:branch_id/sessions", salesController.GetSalesOfSession)
-
+	api.Post("/sales/session/branch/:branch_id", write, branch, salesController.OpenSalesSession)                                     // open sales session -- activity logged here if succesfull
+	api.Get("/sales/session/branch/:branch_id", branch, salesController.GetSalesSessionsOfBranch)                                     // get sales sessions of branch
+	api.Post("/sales/session/:session_id/product", write, session_branch, salesController.AddProductItemToSession)                    // add product to session
+	api.Delete("/sales/session/:session_id/product/:product_id", write, session_branch, salesController.RemoveProductItemFromSession) // remove product from session
+	api.Post("/sales/session/:session_id/close", write, session_branch, salesController.CloseSalesSession)                            // close sales session -- activity logged here if succesfull
+	api.Get("/sales/session/:session_id", session_branch, salesController.GetSalesSession)                                            // get sales session
+	api.Delete("/sales/session/:session_id", write, session_branch, salesController.DeleteSalesSession)                               // void sales session -- activity logged here if succesfull
+	// receipts (split-tender sales)
+	api.Post("/sales/receipts/:branch_id", write, branch, salesController.CreateReceipt)                                                             // create receipt -- activity logged here if succesfull
+	api.Get("/sales/receipts/branch/:branch_id", branch, salesController.GetReceiptsOfBranch)                                                        // get receipts of branch
+	api.Get("/sales/receipts/:receipt_id", receipt_branch, salesController.GetReceipt)                                                               // get receipt
+	api.Post("/sales/receipts/:receipt_id/refunds", middleware.Require(models.PermissionSalesRefund), receipt_branch, salesController.RefundReceipt) // refund (return) products of receipt -- activity logged here if succesfull
+	api.Get("/sales/receipts/:receipt_id/refunds", receipt_branch, salesController.GetRefundsOfReceipt)                                              // get refunds of receipt
 }
 
 func ProductsRoutes(router *fiber.App, productsController *products.ProductsController, middleware *middleware.Middlewares) {
+	manage := middleware.Require(models.PermissionProductsManage)
+	stock := middleware.Require(models.PermissionStockManage)
+	approve := middleware.Require(models.PermissionStocktakeApprove)
+	orders := middleware.Require(models.PermissionPurchaseOrders)
+	stocktake_branch := middleware.BranchOfDocument("stocktakes", "id", "place.id")
+	order_branch := middleware.BranchOfDocument("purchase_orders", "id", "destination.id")
+	transfer_source := middleware.BranchOfDocument("transfers", "id", "source.id")
+	transfer_destination := middleware.BranchOfDocument("transfers", "id", "destination.id")
 	api := router.Group("/api")
 
-	api.Post("/products", productsController.CreateProduct)                        // create product -- activity logged here if succesfull
-	api.Put("/products/:id", productsController.EditProduct)                       // edit product -- activity logged here if succesfull
-	api.Delete("/products/:id", productsController.DeleteProduct)                  // delete product -- activity logged here if succesfull
-	api.Get("/products/:id", productsController.GetProductByID)                    // get product by id
-	api.Get("/products", productsController.QueryProducts)                         // query products
-	api.Post("/products/:id/income", productsController.NewIncome)                 // create income -- activity logged here if succesfull
-	api.Post("/products/transfer", productsController.NewTransfer)                 // create transfer -- activity logged here if succesfull
-	api.Post("/products/:id/images", productsController.UploadProductImage)        // upload product image
-	api.Delete("/products/:id/images/:key", productsController.DeleteProductImage) // delete product image
-	api.Get("/products/:id/images", productsController.GetImagesOfProduct)         // get images of product
-	api.Get("/products/images/:key", productsController.GetImage)                  // get image
+	// transfer, batch, low-stock and stocktake routes are registered before /products/:id so that they are not matched as a product id
+	api.Post("/products/transfer", stock, productsController.NewTransfer)                                                                          // create transfer -- activity logged here if succesfull
+	api.Get("/products/transfer", middleware.BranchQuery("place_id"), productsController.QueryTransfers)                                           // query transfers
+	api.Get("/products/transfer/:id", transfer_source, productsController.GetTransferByID)                                                         // get transfer by id
+	api.Post("/products/transfer/:id/dispatch", stock, transfer_source, productsController.DispatchTransfer)                                       // dispatch transfer -- activity logged here if succesfull
+	api.Post("/products/transfer/:id/receive", stock, transfer_destination, productsController.ReceiveTransfer)                                    // receive transfer -- activity logged here if succesfull
+	api.Get("/products/low-stock", middleware.BranchQuery("branch_id"), productsController.GetLowStockProducts)                                    // get products below minimum stock alert
+	api.Post("/products/stocktakes", stock, productsController.NewStocktake)                                                                       // open stocktake -- activity logged here if succesfull
+	api.Get("/products/stocktakes", middleware.BranchQuery("place_id"), productsController.QueryStocktakes)                                        // query stocktakes
+	api.Get("/products/stocktakes/:id", stocktake_branch, productsController.GetStocktakeByID)                                                     // get stocktake with variances
+	api.Post("/products/stocktakes/:id/counts", stock, stocktake_branch, productsController.SubmitStocktakeCounts)                                 // submit counted quantities -- activity logged here if succesfull
+	api.Post("/products/stocktakes/:id/approve", approve, stocktake_branch, productsController.ApproveStocktake)                                   // approve stocktake and post adjustments -- activity logged here if succesfull
+	api.Post("/products/stocktakes/:id/cancel", approve, stocktake_branch, productsController.CancelStocktake)                                     // cancel stocktake -- activity logged here if succesfull
+	api.Get("/products/batches/expiring", middleware.BranchQuery("branch_id"), productsController.GetExpiringBatches)                              // get batches expiring within N days
+	api.Post("/products/batches/:id/write-off", stock, middleware.BranchOfDocument("batches", "id", "place.id"), productsController.WriteOffBatch) // write off batch -- activity logged here if succesfull
+	api.Post("/products", manage, productsController.CreateProduct)                                                                                // create product -- activity logged here if succesfull
+	api.Put("/products/:id", manage, productsController.EditProduct)                                                                               // edit product -- activity logged here if succesfull
+	api.Delete("/products/:id", manage, productsController.DeleteProduct)                                                                          // delete product -- activity logged here if succesfull
+	api.Get("/products/:id", productsController.GetProductByID)                                                                                    // get product by id
+	api.Get("/products", productsController.QueryProducts)                                                                                         // query products
+	api.Post("/products/:id/income", stock, productsController.NewIncome)                                                                          // create income -- activity logged here if succesfull
+	api.Post("/products/:id/images", manage, productsController.UploadProductImage)                                                                // upload product image
+	api.Delete("/products/:id/images/:key", manage, productsController.DeleteProductImage)                                                         // delete product image
+	api.Get("/products/:id/images", productsController.GetImagesOfProduct)                                                                         // get images of product
+	api.Get("/products/images/:key", productsController.GetImage)                                                                                  // get image
+
+	api.Post("/purchase-orders", orders, productsController.NewPurchaseOrder)                               // create purchase order from proposals -- activity logged here if succesfull
+	api.Get("/purchase-orders", middleware.BranchQuery("place_id"), productsController.QueryPurchaseOrders) // query purchase orders
+	api.Get("/purchase-orders/:id", order_branch, productsController.GetPurchaseOrderByID)                  // get purchase order by id
+	api.Post("/purchase-orders/:id/receive", orders, order_branch, productsController.ReceivePurchaseOrder) // receive (part of) purchase order -- activity logged here if succesfull
+	api.Post("/purchase-orders/:id/cancel", orders, order_branch, productsController.CancelPurchaseOrder)   // cancel purchase order -- activity logged here if succesfull
 }
 
 func JournalsRoutes(router *fiber.App, journalsController *journal_handlers.JournalHandlers, operationsController *journal_handlers.OperationHandlers, middleware *middleware.Middlewares) {
+	write := middleware.Require(models.PermissionJournalsWrite)
+	journal_branch := middleware.BranchOfDocument("journals", "id", "branch._id")
+	journal_period := middleware.PeriodOpenOfDocument("journals", "id", "branch._id", "date")
 	api := router.Group("/api")
-	api.Get("/journals/:id", journalsController.GetJournalEntryByID)                                                  // get journal entry by id
-	api.Get("/journals/branch/:branch_id", journalsController.QueryJournalEntries)                                    // query journal entries
-	api.Post("/journals", journalsController.NewJournalEntry)                                                         // create journal entry -- activity logged here if succesfull
-	api.Post("/journals/:id/close", operationsController.ShiftIsOpenMiddleware, journalsController.CloseJournalEntry) // close journal entry -- activity logged here if succesfull
-	api.Post("/journals/:id/reopen", journalsController.ReOpenJournalEntry)                                           // reopen journal entry -- activity logged here if succesfull
+	api.Get("/journals/:id", journal_branch, journalsController.GetJournalEntryByID)                                                                             // get journal entry by id
+	api.Get("/journals/branch/:branch_id", middleware.BranchParam("branch_id"), journalsController.QueryJournalEntries)                                          // query journal entries
+	api.Post("/journals", write, journalsController.NewJournalEntry)                                                                                             // create journal entry -- activity logged here if succesfull
+	api.Post("/journals/:id/close", write, journal_branch, journal_period, operationsController.ShiftIsOpenMiddleware, journalsController.CloseJournalEntry)     // close journal entry -- activity logged here if succesfull
+	api.Post("/journals/:id/reopen", middleware.Require(models.PermissionJournalsReopen), journal_branch, journal_period, journalsController.ReOpenJournalEntry) // reopen journal entry -- activity logged here if succesfull
 
 	// operations
-	api.Post("/journals/:id/operations", operationsController.ShiftIsOpenMiddleware, operationsController.NewOperationTransaction)                        // create operation transaction -- activity logged here if succesfull
-	api.Put("/journals/:id/operations/:operation_id", operationsController.ShiftIsOpenMiddleware, operationsController.UpdateOperationTransactionByID)    // update operation transaction by id -- activity logged here if succesfull
-	api.Delete("/journals/:id/operations/:operation_id", operationsController.ShiftIsOpenMiddleware, operationsController.DeleteOperationTransactionByID) // delete operation transaction by id -- activity logged here if succesfull
-	api.Get("/journals/:id/operations/:operation_id", operationsController.GetOperationTransactionByID)                                                   // get operation transaction by id
+	api.Post("/journals/:id/operations", write, journal_branch, journal_period, operationsController.ShiftIsOpenMiddleware, operationsController.NewOperationTransaction)                        // create operation transaction -- activity logged here if succesfull
+	api.Put("/journals/:id/operations/:operation_id", write, journal_branch, journal_period, operationsController.ShiftIsOpenMiddleware, operationsController.UpdateOperationTransactionByID)    // update operation transaction by id -- activity logged here if succesfull
+	api.Delete("/journals/:id/operations/:operation_id", write, journal_branch, journal_period, operationsController.ShiftIsOpenMiddleware, operationsController.DeleteOperationTransactionByID) // delete operation transaction by id -- activity logged here if succesfull
+	api.Get("/journals/:id/operations/:operation_id", journal_branch, operationsController.GetOperationTransactionByID)                                                                          // get operation transaction by id
 
 }
 
-func InternalExpensesRoutes(router *fiber.App, middleware *middleware.Middlewares) {
-
+func InternalExpensesRoutes(router *fiber.App, expensesController *internalexpenses.InternalExpensesController, middleware *middleware.Middlewares) {
+	write := middleware.Require(models.PermissionExpensesWrite)
+	approve := middleware.Require(models.PermissionExpensesApprove)
+	manage := middleware.Require(models.PermissionExpensesManage)
+	expense_branch := middleware.BranchOfDocument("internal_expenses", "id", "branch_id")
+	expense_period := middleware.PeriodOpenOfDocument("internal_expenses", "id", "branch_id", "date")
+	api := router.Group("/api")
+	// categories, recurring templates and reports are registered before /expenses/:id so that they are not matched as an expense id
+	api.Get("/expenses/categories", expensesController.GetExpenseCategories)                                                                                    // list expense categories
+	api.Post("/expenses/categories", manage, expensesController.CreateExpenseCategory)                                                                          // create expense category -- activity logged here if succesfull
+	api.Put("/expenses/categories/:id", manage, expensesController.UpdateExpenseCategory)                                                                       // update expense category -- activity logged here if succesfull
+	api.Get("/expenses/recurring", middleware.BranchQuery("branch_id"), expensesController.GetRecurringExpenses)                                                // list recurring expense templates
+	api.Post("/expenses/recurring", manage, expensesController.CreateRecurringExpense)                                                                          // create recurring expense template -- activity logged here if succesfull
+	api.Post("/expenses/recurring/generate", manage, expensesController.GenerateRecurringExpenses)                                                              // generate due recurring expenses -- activity logged here if succesfull
+	api.Put("/expenses/recurring/:id", manage, middleware.BranchOfDocument("recurring_expenses", "id", "branch_id"), expensesController.UpdateRecurringExpense) // update, pause or resume recurring expense template -- activity logged here if succesfull
+	api.Get("/expenses/reports", middleware.Require(models.PermissionReportsRead), middleware.BranchQuery("branch_id"), expensesController.GetExpenseReport)    // expenses by category and period
+	api.Get("/expenses", middleware.BranchQuery("branch_id"), expensesController.GetInternalExpenses)                                                           // query expenses
+	api.Post("/expenses", write, expensesController.CreateInternalExpense)                                                                                      // create pending expense -- activity logged here if succesfull
+	api.Get("/expenses/:id", expense_branch, expensesController.GetInternalExpense)                                                                             // get expense by id
+	api.Post("/expenses/:id/approve", approve, expense_branch, expense_period, expensesController.ApproveInternalExpense)                                       // approve and pay expense -- activity logged here if succesfull
+	api.Post("/expenses/:id/reject", approve, expense_branch, expense_period, expensesController.RejectInternalExpense)                                         // reject expense -- activity logged here if succesfull
+	api.Post("/expenses/:id/attachments", write, expense_branch, expensesController.UploadExpenseAttachment)                                                    // attach invoice or receipt
+	api.Get("/expenses/:id/attachments/:name", expense_branch, expensesController.GetExpenseAttachment)                                                         // download attachment
+	api.Delete("/expenses/:id/attachments/:name", write, expense_branch, expensesController.DeleteExpenseAttachment)                                            // delete attachment of pending expense
 }
 
 func FinanceRoutes(router *fiber.App, financeController *finance.FinanceController, middleware *middleware.Middlewares) {
 	api := router.Group("/api")
-	api.Get("/finance/branches", financeController.GetBranches)                            // get all branches
-	api.Get("/finance/branch/id/:id", financeController.GetFinanceBranchByBranchID)        // get branch by id
-	api.Get("/finance/branch/name/:branch_name", financeController.GetFinanceByBranchName) // get branch by name
-	api.Get("/finance/id/:id", financeController.GetFinanceByID)                           // get finance by id
-	api.Post("/finance", financeController.NewFinanceOfBranch)                             // create new finance of branch -- activity logged here if succesfull
+	api.Get("/finance/branches", financeController.GetBranches)                                                             // get all branches
+	api.Get("/finance/branch/id/:id", middleware.BranchParam("id"), financeController.GetFinanceBranchByBranchID)           // get branch by id
+	api.Get("/finance/branch/name/:branch_name", financeController.GetFinanceByBranchName)                                  // get branch by name
+	api.Get("/finance/id/:id", middleware.BranchOfDocument("finance", "id", "branch_id"), financeController.GetFinanceByID) // get finance by id
+	api.Post("/finance", middleware.Require(models.PermissionFinanceManage), financeController.NewFinanceOfBranch)          // create new finance of branch -- activity logged here if succesfull
+
+	read := middleware.Require(models.PermissionReportsRead)
+	branch := middleware.BranchParam("branch_id")
+	api.Get("/finance/reconciliation", read, middleware.BranchQuery("branch_id"), financeController.GetReconciliations)                                     // reconcile finances of all branches with their transactions
+	api.Get("/finance/reconciliation/:branch_id", read, branch, financeController.GetReconciliation)                                                        // reconcile finance of branch with its transactions
+	api.Post("/finance/reconciliation/:branch_id/apply", middleware.Require(models.PermissionFinanceManage), branch, financeController.ApplyReconciliation) // apply corrective adjustment -- activity logged here if succesfull
+	api.Get("/finance/adjustments/:branch_id", read, branch, financeController.GetFinanceAdjustments)                                                       // audit of corrective adjustments of branch
+	api.Post("/finance/transfers", middleware.Require(models.PermissionFinanceManage), financeController.TransferMoney)                                     // move money between buckets or branches -- activity logged here if succesfull
+	api.Get("/finance/transfers/:id", financeController.GetMoneyTransfer)                                                                                   // paired transactions of transfer
+	api.Post("/finance/exchange-rates", middleware.Require(models.PermissionFinanceManage), financeController.SetExchangeRate)                              // set rate of currency for a day -- activity logged here if succesfull
+	api.Get("/finance/exchange-rates", financeController.GetExchangeRates)                                                                                  // stored daily rates
+	api.Get("/finance/exchange-rates/rate", financeController.GetExchangeRate)                                                                              // rate of currency on a date
+	api.Post("/finance/migrate-money", middleware.Require(models.PermissionDataMigrate), financeController.MigrateMoneyHandler)                             // convert amounts to 64-bit integers and give documents the default currency -- activity logged here if succesfull
+	api.Get("/finance/periods", read, middleware.BranchQuery("branch_id"), financeController.GetPeriodCloses)                                               // period closes of branches
+	api.Get("/finance/periods/:branch_id/lock", branch, financeController.GetPeriodLock)                                                                    // lock date of branch
+	api.Post("/finance/periods/:branch_id/close", middleware.Require(models.PermissionPeriodsClose), branch, financeController.ClosePeriod)                 // close accounting period of branch -- activity logged here if succesfull
+	api.Post("/finance/periods/:id/reopen", middleware.Require(models.PermissionPeriodsReopen), financeController.ReopenPeriod)                             // reopen latest close of branch -- activity logged here if succesfull
+
+}
+
+func LedgerRoutes(router *fiber.App, ledgerController *ledger.LedgerController, middleware *middleware.Middlewares) {
+	read := middleware.Require(models.PermissionReportsRead)
+	branch := middleware.BranchParam("branch_id")
+	api := router.Group("/api")
+	api.Get("/ledger/accounts", ledgerController.GetChartOfAccounts)                                                                                     // chart of accounts
+	api.Get("/ledger/branch/:branch_id/entries", read, branch, ledgerController.QueryLedgerEntries)                                                      // query ledger entries of branch
+	api.Get("/ledger/branch/:branch_id/trial-balance", read, branch, ledgerController.GetTrialBalance)                                                   // trial balance of branch at date
+	api.Get("/ledger/branch/:branch_id/balances", read, branch, ledgerController.GetLedgerBalances)                                                      // balances of branch derived from the ledger
+	api.Post("/ledger/branch/:branch_id/opening-balance", middleware.Require(models.PermissionDataMigrate), branch, ledgerController.PostOpeningBalance) // bring balances of finance into the empty ledger -- activity logged here if succesfull
+}
 
+func BranchesRoutes(router *fiber.App, branchesController *branches.BranchesController, middleware *middleware.Middlewares) {
+	manage := middleware.Require(models.PermissionBranchesManage)
+	api := router.Group("/api")
+	api.Get("/branches", branchesController.QueryBranches)                    // query branches
+	api.Post("/branches", manage, branchesController.CreateBranch)            // create branch with its finance -- activity logged here if succesfull
+	api.Post("/branches/migrate", manage, branchesController.MigrateBranches) // migrate branches from finances, proposals and users -- activity logged here if succesfull
+	api.Get("/branches/:id", branchesController.GetBranchByID)                // get branch by id
+	api.Put("/branches/:id", manage, branchesController.UpdateBranch)         // update branch -- activity logged here if succesfull
+	api.Delete("/branches/:id", manage, branchesController.DeleteBranch)      // delete branch which is not referenced -- activity logged here if succesfull
 }
 
 func TransactionsRoutes(router *fiber.App, transactionsController *transactions.TransactionsController, middleware *middleware.Middlewares) {
+	edit := middleware.Require(models.PermissionTransactionsEdit)
+	transaction_branch := middleware.BranchOfDocument("transactions", "id", "branch_id")
+	transaction_period := middleware.PeriodOpenOfDocument("transactions", "id", "branch_id", "created_at")
 	api := router.Group("/api")
-	api.Get("/transactions/branch/:branch_id", transactionsController.GetTransactionsByQueryParams) // get transactions by query params
-	api.Get("/transactions/:id", transactionsController.GetTransactionByID)                         // get transaction by id
+	api.Get("/transactions/branch/:branch_id", middleware.BranchParam("branch_id"), transactionsController.GetTransactionsByQueryParams) // get transactions by query params
+	api.Get("/transactions/:id", transaction_branch, transactionsController.GetTransactionByID)                                          // get transaction by id
 	// router.Post("/transactions/:branch_id", transactionsController.Tra)
-	api.Put("/transactions/:id", transactionsController.UpdateTransactionByID)            // update transaction by id
-	api.Delete("/transactions/:id", transactionsController.DeleteTransactionByID)         // delete transaction by id
-	api.Get("/transactions/docs/initiator_type", transactionsController.GetInitiatorType) // get initiator type
-	api.Get("/transactions/docs/type", transactionsController.GetTransactionType)         // get transaction type
-	api.Get("/transactions/docs/payment_method", transactionsController.GetPaymentMethod) // get payment method
+	api.Put("/transactions/:id", edit, transaction_branch, transaction_period, transactionsController.UpdateTransactionByID)    // edit transaction by id
+	api.Delete("/transactions/:id", edit, transaction_branch, transaction_period, transactionsController.DeleteTransactionByID) // void transaction by id
+	api.Get("/transactions/:id/versions", transaction_branch, transactionsController.GetTransactionVersions)                    // version history of transaction
+	api.Get("/transactions/docs/initiator_type", transactionsController.GetInitiatorType)                                       // get initiator type
+	api.Get("/transactions/docs/type", transactionsController.GetTransactionType)                                               // get transaction type
+	api.Get("/transactions/docs/payment_method", transactionsController.GetPaymentMethod)                                       // get payment method
 }
 
 func CustomerRoutes(router *fiber.App, customerController *customers.CustomersController, middleware *middleware.Middlewares) {
+	manage := middleware.Require(models.PermissionCustomersManage)
 	api := router.Group("/api")
-	api.Get("/customers", customerController.GetCustomers)          // get all customers
-	api.Get("/customers/:id", customerController.GetCustomerByID)   // get customer by id
-	api.Post("/customers", customerController.CreateCustomer)       // create customer -- activity logged here if succesfull
-	api.Put("/customers/:id", customerController.UpdateCustomer)    // update customer
-	api.Delete("/customers/:id", customerController.DeleteCustomer) // delete customer
+	api.Get("/customers", customerController.GetCustomers)                  // get all customers
+	api.Get("/customers/:id", customerController.GetCustomerByID)           // get customer by id
+	api.Post("/customers", manage, customerController.CreateCustomer)       // create customer -- activity logged here if succesfull
+	api.Put("/customers/:id", manage, customerController.UpdateCustomer)    // update customer
+	api.Delete("/customers/:id", manage, customerController.DeleteCustomer) // delete customer
 }
 
 func BNPLRoutes(router *fiber.App, bnplController *bnpl.BNPLController, middleware *middleware.Middlewares) {
+	// bnpls are kept in the customers, the handlers check the branch of the bnpl
 	api := router.Group("/api")
-	api.Post("/bnpl", bnplController.NewBNPL)                                   // create bnpl -- activity logged here if succesfull
-	api.Post("/bnpl/:id/credit", bnplController.CreditBNPL)                     // credit bnpl
-	api.Delete("/bnpl/:id", bnplController.DeleteBNPL)                          // delete bnpl
-	api.Get("/bnpl/:id", bnplController.GetBNPLByID)                            // get bnpl by id
-	api.Get("/customers/:customer_id/bnpls", bnplController.GetBNPLSofCustomer) // get bnpls of customer
-	api.Get("/branches/:branch_id/bnpls", bnplController.GetBNPLsOfBranch)      // get bnpls of branch
+	api.Post("/bnpl", middleware.Require(models.PermissionBNPLManage), bnplController.NewBNPL)                       // create bnpl -- activity logged here if succesfull
+	api.Post("/bnpl/:id/credit", middleware.Require(models.PermissionBNPLManage), bnplController.CreditBNPL)         // credit bnpl
+	api.Delete("/bnpl/:id", middleware.Require(models.PermissionBNPLDelete), bnplController.DeleteBNPL)              // delete bnpl
+	api.Get("/bnpl/:id", bnplController.GetBNPLByID)                                                                 // get bnpl by id
+	api.Get("/customers/:customer_id/bnpls", middleware.BranchQuery("branch_id"), bnplController.GetBNPLSofCustomer) // get bnpls of customer
+	api.Get("/branches/:branch_id/bnpls", middleware.BranchParam("branch_id"), bnplController.GetBNPLsOfBranch)      // get bnpls of branch
 }
 
 func ProposalsRoutes(router *fiber.App, proposalsController *arrivals.ProposalsHandlers, middleware *middleware.Middlewares) {
+	manage := middleware.Require(models.PermissionProposalsManage)
 	api := router.Group("/api")
-	api.Get("/proposals", proposalsController.GetProposals)               // get proposals
-	api.Get("/proposals/detail", proposalsController.GetProposalDetail)   // get proposal by id
-	api.Post("/proposals/new", proposalsController.NewProposals)          // create proposal
-	api.Put("/proposals/edit", proposalsController.EditProposal)          // update proposal
-	api.Delete("/proposals/delete", proposalsController.DeleteProposal)   // delete proposal
-	api.Get("/proposals/image", proposalsController.GetImageByProposalID) // get image by proposal id
-	api.Post("/proposals/image", proposalsController.UploadImage)         // upload image
-	api.Get("/proposals/pdf/pdf", proposalsController.GeneratePDF)        // generate pdf
-	api.Get("/proposals/fulfill", proposalsController.FulfillProposals)   // fulfill proposals
+	api.Get("/proposals", middleware.BranchQuery("branch"), proposalsController.GetProposals)        // get proposals
+	api.Get("/proposals/detail", proposalsController.GetProposalDetail)                              // get proposal by id
+	api.Post("/proposals/new", manage, proposalsController.NewProposals)                             // create proposal
+	api.Put("/proposals/edit", manage, proposalsController.EditProposal)                             // update proposal
+	api.Delete("/proposals/delete", manage, proposalsController.DeleteProposal)                      // delete proposal
+	api.Get("/proposals/image", proposalsController.GetImageByProposalID)                            // get image by proposal id
+	api.Post("/proposals/image", manage, proposalsController.UploadImage)                            // upload image
+	api.Get("/proposals/pdf/pdf", middleware.BranchQuery("branch"), proposalsController.GeneratePDF) // generate pdf
+	api.Get("/proposals/fulfill", manage, proposalsController.FulfillProposals)                      // fulfill proposals
 }
 
 func ForwardProxy(c *fiber.Ctx) error {
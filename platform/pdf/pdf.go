@@ -0,0 +1,181 @@
+// Package pdf is a minimal PDF writer for simple printable reports: A4 pages with text, lines and JPEG images.
+// Text uses the standard Helvetica fonts with WinAnsi encoding, characters outside of Latin-1 are printed as '?'
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"strings"
+
+	_ "image/png" // png images are decoded and re-encoded as jpeg
+)
+
+// A4 page size in points
+const (
+	PageWidth  = 595.28
+	PageHeight = 841.89
+)
+
+type pdfImage struct {
+	name   string
+	width  int
+	height int
+	data   []byte // jpeg
+}
+
+type page struct {
+	content bytes.Buffer
+}
+
+// Document is built page by page. Coordinates are in points from the top left corner of the page
+type Document struct {
+	pages  []*page
+	images []*pdfImage
+}
+
+func New() *Document {
+	return &Document{}
+}
+
+// AddPage starts a new page, following drawing goes to it
+func (d *Document) AddPage() {
+	d.pages = append(d.pages, &page{})
+}
+
+func (d *Document) current() *page {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+	return d.pages[len(d.pages)-1]
+}
+
+// Text writes a single line of text with its baseline at y
+func (d *Document) Text(x, y, size float64, bold bool, text string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(&d.current().content, "BT /%s %.2f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, PageHeight-y, escape(text))
+}
+
+// Line draws a thin line
+func (d *Document) Line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&d.current().content, "0.5 w %.2f %.2f m %.2f %.2f l S\n", x1, PageHeight-y1, x2, PageHeight-y2)
+}
+
+// Image draws the image scaled to fit into the w x h box at x, y (top left corner) keeping its aspect ratio.
+// The image is downscaled to at most max_pixels on the longer side before it is embedded
+func (d *Document) Image(img image.Image, x, y, w, h float64, max_pixels int) error {
+	img = downscale(img, max_pixels)
+	buf := bytes.Buffer{}
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80}); err != nil {
+		return err
+	}
+	bounds := img.Bounds()
+	embedded := &pdfImage{
+		name:   fmt.Sprintf("Im%d", len(d.images)+1),
+		width:  bounds.Dx(),
+		height: bounds.Dy(),
+		data:   buf.Bytes(),
+	}
+	d.images = append(d.images, embedded)
+
+	scale := min(w/float64(embedded.width), h/float64(embedded.height))
+	draw_w, draw_h := float64(embedded.width)*scale, float64(embedded.height)*scale
+	fmt.Fprintf(&d.current().content, "q %.2f 0 0 %.2f %.2f %.2f cm /%s Do Q\n", draw_w, draw_h, x, PageHeight-y-draw_h, embedded.name)
+	return nil
+}
+
+// Bytes renders the document
+func (d *Document) Bytes() []byte {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+
+	out := bytes.Buffer{}
+	offsets := []int{}
+	object := func(body string, stream []byte) int {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\n", len(offsets), body)
+		if stream != nil {
+			out.WriteString("stream\n")
+			out.Write(stream)
+			out.WriteString("\nendstream\n")
+		}
+		out.WriteString("endobj\n")
+		return len(offsets)
+	}
+
+	out.WriteString("%PDF-1.4\n")
+
+	// catalog is 1, pages is 2 --- pages and their contents follow the images
+	first_page := 5 + len(d.images)
+	kids := []string{}
+	for i := range d.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", first_page+2*i))
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>", nil)
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)), nil)
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>", nil)
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>", nil)
+
+	xobjects := []string{}
+	for _, img := range d.images {
+		id := object(fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /DCTDecode /Length %d >>",
+			img.width, img.height, len(img.data)), img.data)
+		xobjects = append(xobjects, fmt.Sprintf("/%s %d 0 R", img.name, id))
+	}
+	resources := fmt.Sprintf("<< /Font << /F1 3 0 R /F2 4 0 R >> /XObject << %s >> >>", strings.Join(xobjects, " "))
+
+	for i, p := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources %s /Contents %d 0 R >>",
+			PageWidth, PageHeight, resources, first_page+2*i+1), nil)
+		object(fmt.Sprintf("<< /Length %d >>", p.content.Len()), p.content.Bytes())
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// escape converts the text to WinAnsi and escapes it for a PDF string
+func escape(text string) string {
+	b := strings.Builder{}
+	for _, r := range text {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteByte(byte(r))
+		case r >= 32 && r < 127, r >= 0xA0 && r <= 0xFF:
+			b.WriteByte(byte(r))
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// downscale resizes the image (nearest neighbour) so that its longer side is at most max_pixels
+func downscale(img image.Image, max_pixels int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if max_pixels <= 0 || (w <= max_pixels && h <= max_pixels) {
+		return img
+	}
+	scale := float64(max_pixels) / float64(max(w, h))
+	new_w, new_h := max(int(float64(w)*scale), 1), max(int(float64(h)*scale), 1)
+
+	resized := image.NewRGBA(image.Rect(0, 0, new_w, new_h))
+	for y := 0; y < new_h; y++ {
+		for x := 0; x < new_w; x++ {
+			resized.Set(x, y, img.At(bounds.Min.X+int(float64(x)/scale), bounds.Min.Y+int(float64(y)/scale)))
+		}
+	}
+	return resized
+}
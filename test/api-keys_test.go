@@ -0,0 +1,74 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+	"github.com/aslon1213/g4h_pos_erp/test/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeyScopes(t *testing.T) {
+	ChangeLogging()
+	admin := getClient(t)
+
+	resp, created, err := admin.CreateAPIKey(models.APIKeyInput{
+		Name:          "price checker",
+		Scopes:        []models.Permission{models.ScopeProductsRead},
+		ExpiresInDays: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+	assert.NotEmpty(t, created.Key)
+	if len(created.Data) == 0 {
+		t.Fatal("Expected created api key")
+	}
+
+	kiosk := &client.Client{Host: admin.Host, Port: admin.Port}
+	headers := func() map[string]string {
+		return map[string]string{middleware.APIKeyHeader: created.Key, "Content-Type": "application/json"}
+	}
+
+	resp, err = kiosk.MakeRequest("GET", "/api/products", nil, headers(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+
+	// no write scope
+	resp, err = kiosk.MakeRequest("POST", "/api/products", []byte(`{}`), headers(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "Expected status code 403, but got %d", resp.StatusCode)
+
+	// no read scope of suppliers
+	resp, err = kiosk.MakeRequest("GET", "/api/suppliers", nil, headers(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "Expected status code 403, but got %d", resp.StatusCode)
+
+	// users can never be managed with api keys
+	resp, err = kiosk.MakeRequest("GET", "/api/users", nil, headers(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "Expected status code 403, but got %d", resp.StatusCode)
+
+	resp, err = admin.RevokeAPIKey(created.Data[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+
+	resp, err = kiosk.MakeRequest("GET", "/api/products", nil, headers(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "Expected status code 401, but got %d", resp.StatusCode)
+}
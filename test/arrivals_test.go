@@ -1,6 +1,7 @@
 package test
 
 import (
+	"bytes"
 	"net/http"
 	"testing"
 
@@ -54,3 +55,16 @@ func TestArrivals(t *testing.T) {
 	}
 
 }
+
+func TestProposalsPDF(t *testing.T) {
+	ChangeLogging()
+	client := getClient(t)
+
+	resp, output, err := client.GetProposalsPDF("xonobod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+	assert.Equal(t, "application/pdf", resp.Header.Get("Content-Type"))
+	assert.True(t, bytes.HasPrefix(output, []byte("%PDF-")), "Expected a pdf document")
+}
@@ -0,0 +1,37 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetExpiringBatches(t *testing.T) {
+	ChangeLogging()
+	client := getClient(t)
+
+	resp, _, err := client.GetExpiringBatches("", 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Expected status code 400, but got %d", resp.StatusCode)
+
+	branches := getAllBranches(t, client)
+	if len(branches) == 0 {
+		t.Fatal("No branches found")
+	}
+
+	resp, output, err := client.GetExpiringBatches(branches[0].BranchID, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+	limit := time.Now().AddDate(0, 0, 30)
+	for _, batch := range output.Data {
+		assert.Equal(t, branches[0].BranchID, batch.Place.ID)
+		assert.True(t, batch.Quantity > 0)
+		assert.True(t, batch.Expire.Before(limit))
+	}
+}
@@ -0,0 +1,100 @@
+package test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBranchLifecycle(t *testing.T) {
+	ChangeLogging()
+	client := getClient(t)
+
+	name := fmt.Sprintf("Branch %d", time.Now().UnixNano())
+	resp, output, err := client.NewBranch(models.BranchInput{
+		Name:    name,
+		Address: "Somewhere",
+		Phone:   "+998 90 000 00 00",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+	if len(output.Data) != 1 {
+		t.Fatal("Expected created branch")
+	}
+	branch := output.Data[0]
+	assert.True(t, branch.Active)
+	assert.Equal(t, models.DefaultBranchTimezone, branch.Timezone)
+
+	// names are unique regardless of the case
+	resp, _, err = client.NewBranch(models.BranchInput{Name: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "Expected status code 409, but got %d", resp.StatusCode)
+
+	// the finance of the branch is created and renamed with it
+	renamed := name + " renamed"
+	resp, _, err = client.UpdateBranch(branch.ID, models.UpdateBranchInput{Name: &renamed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+	finance := getBranchByID(t, client, branch.ID)
+	assert.Equal(t, renamed, finance.BranchName)
+
+	// journals take the snapshot of the branch
+	resp, journal, err := client.OpenJournal(models.NewJournalEntryInput{BranchNameOrID: branch.ID, Date: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+	assert.Equal(t, renamed, journal.Data.Branch.Name)
+	assert.Equal(t, "Somewhere", journal.Data.Branch.Location)
+
+	// referenced branches can only be deactivated
+	resp, err = client.DeleteBranch(branch.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "Expected status code 409, but got %d", resp.StatusCode)
+
+	inactive := false
+	resp, _, err = client.UpdateBranch(branch.ID, models.UpdateBranchInput{Active: &inactive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+
+	resp, _, err = client.OpenJournal(models.NewJournalEntryInput{BranchNameOrID: branch.ID, Date: time.Now().AddDate(0, 0, -1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Expected status code 400, but got %d", resp.StatusCode)
+}
+
+func TestMigrateBranches(t *testing.T) {
+	ChangeLogging()
+	client := getClient(t)
+
+	resp, output, err := client.MigrateBranches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+
+	// every finance has its branch after the migration, running it again changes nothing
+	resp, output, err = client.MigrateBranches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+	assert.Empty(t, output.Data.BranchesCreated)
+	assert.Zero(t, output.Data.ProposalsUpdated)
+}
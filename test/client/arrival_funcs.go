@@ -2,6 +2,7 @@ package client
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
 )
 
@@ -31,3 +32,13 @@ func (c *Client) GetArrivals(branch_id string) (resp *http.Response, output []ma
 	}
 	return resp, output, err
 }
+
+func (c *Client) GetProposalsPDF(branch string) (resp *http.Response, output []byte, err error) {
+	resp, err = c.MakeRequest("GET", "/api/proposals/pdf/pdf?branch="+branch, nil, nil, false)
+	if err != nil {
+		return nil, output, err
+	}
+	defer resp.Body.Close()
+	output, err = io.ReadAll(resp.Body)
+	return resp, output, err
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"net/http"
 
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
 	"github.com/rs/zerolog/log"
 )
 
@@ -41,6 +43,9 @@ func (c *Client) Login() (*http.Response, string, error) {
 		return response, "", err
 	}
 	log.Info().Interface("resp_body", resp_body).Msg("resp_body")
+	if refresh_token, ok := resp_body["refresh_token"].(string); ok {
+		c.RefreshToken = refresh_token
+	}
 
 	return response, resp_body["data"].(string), nil
 }
@@ -74,3 +79,195 @@ func (c *Client) Register(username string, password string) (*http.Response, err
 
 	return response, nil
 }
+
+func (c *Client) CreateUser(username string, password string, role string, branch string) (*http.Response, models.UsersOutput, error) {
+	body := map[string]string{
+		"username": username,
+		"password": password,
+		"role":     role,
+		"branch":   branch,
+	}
+	json_body, err := json.Marshal(body)
+	if err != nil {
+		return nil, models.UsersOutput{}, err
+	}
+
+	response, err := c.MakeRequest("POST", "/api/users", json_body, map[string]string{
+		"Content-Type": "application/json",
+	}, true)
+	if err != nil {
+		return response, models.UsersOutput{}, err
+	}
+
+	output := models.UsersOutput{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
+
+func (c *Client) SetUserDisabled(user_id string, disabled bool) (*http.Response, error) {
+	action := "enable"
+	if disabled {
+		action = "disable"
+	}
+	return c.MakeRequest("POST", "/api/users/"+user_id+"/"+action, nil, map[string]string{}, true)
+}
+
+func (c *Client) IssuePasswordReset(user_id string) (*http.Response, string, error) {
+	response, err := c.MakeRequest("POST", "/api/users/"+user_id+"/password-reset", nil, map[string]string{}, true)
+	if err != nil {
+		return response, "", err
+	}
+	output := struct {
+		Data []models.PasswordResetCodeOutput `json:"data"`
+	}{}
+	if err := json.NewDecoder(response.Body).Decode(&output); err != nil {
+		return response, "", err
+	}
+	if len(output.Data) == 0 {
+		return response, "", nil
+	}
+	return response, output.Data[0].Code, nil
+}
+
+func (c *Client) RedeemPasswordReset(username string, code string, new_password string) (*http.Response, error) {
+	json_body, err := json.Marshal(models.RedeemPasswordResetInput{
+		Username:    username,
+		Code:        code,
+		NewPassword: new_password,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.MakeRequest("POST", "/auth/password-reset", json_body, map[string]string{
+		"Content-Type": "application/json",
+	}, false)
+}
+
+func (c *Client) Refresh(refresh_token string) (*http.Response, models.TokenPairOutput, error) {
+	json_body, err := json.Marshal(models.RefreshTokenInput{RefreshToken: refresh_token})
+	if err != nil {
+		return nil, models.TokenPairOutput{}, err
+	}
+	response, err := c.MakeRequest("POST", "/auth/refresh", json_body, map[string]string{
+		"Content-Type": "application/json",
+	}, false)
+	if err != nil {
+		return response, models.TokenPairOutput{}, err
+	}
+	output := models.TokenPairOutput{}
+	if response.StatusCode == http.StatusOK {
+		err = json.NewDecoder(response.Body).Decode(&output)
+	}
+	return response, output, err
+}
+
+func (c *Client) Logout() (*http.Response, error) {
+	return c.MakeRequest("POST", "/api/auth/logout", nil, map[string]string{}, true)
+}
+
+func (c *Client) GetMySessions() (*http.Response, models.AuthSessionsOutput, error) {
+	response, err := c.MakeRequest("GET", "/api/auth/sessions", nil, map[string]string{}, true)
+	if err != nil {
+		return response, models.AuthSessionsOutput{}, err
+	}
+	output := models.AuthSessionsOutput{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
+
+// TryLogin logs in with the given credentials without touching the token of the client
+func (c *Client) TryLogin(username string, password string) (*http.Response, error) {
+	json_body, err := json.Marshal(map[string]string{
+		"username": username,
+		"password": password,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.MakeRequest("POST", "/auth/login", json_body, map[string]string{
+		"Content-Type": "application/json",
+	}, false)
+}
+
+func (c *Client) UnlockUser(user_id string) (*http.Response, error) {
+	return c.MakeRequest("POST", "/api/users/"+user_id+"/unlock", nil, map[string]string{}, true)
+}
+
+func (c *Client) CreateAPIKey(input models.APIKeyInput) (*http.Response, models.APIKeyCreatedOutput, error) {
+	json_body, err := json.Marshal(input)
+	if err != nil {
+		return nil, models.APIKeyCreatedOutput{}, err
+	}
+	response, err := c.MakeRequest("POST", "/api/api-keys", json_body, map[string]string{
+		"Content-Type": "application/json",
+	}, true)
+	if err != nil {
+		return response, models.APIKeyCreatedOutput{}, err
+	}
+	output := models.APIKeyCreatedOutput{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
+
+func (c *Client) RevokeAPIKey(id string) (*http.Response, error) {
+	return c.MakeRequest("DELETE", "/api/api-keys/"+id, nil, map[string]string{}, true)
+}
+
+func (c *Client) SetMyPIN(password string, pin string) (*http.Response, error) {
+	json_body, err := json.Marshal(models.SetPINInput{Password: password, PIN: pin})
+	if err != nil {
+		return nil, err
+	}
+	return c.MakeRequest("PUT", "/api/auth/pin", json_body, map[string]string{
+		"Content-Type": "application/json",
+	}, true)
+}
+
+func (c *Client) CreateTerminal(name string, branch string) (*http.Response, models.TerminalCreatedOutput, error) {
+	json_body, err := json.Marshal(models.TerminalInput{Name: name, Branch: branch})
+	if err != nil {
+		return nil, models.TerminalCreatedOutput{}, err
+	}
+	response, err := c.MakeRequest("POST", "/api/terminals", json_body, map[string]string{
+		"Content-Type": "application/json",
+	}, true)
+	if err != nil {
+		return response, models.TerminalCreatedOutput{}, err
+	}
+	output := models.TerminalCreatedOutput{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
+
+func (c *Client) GetTerminalShifts(terminal_id string) (*http.Response, models.TerminalShiftsOutput, error) {
+	response, err := c.MakeRequest("GET", "/api/terminals/"+terminal_id+"/shifts", nil, map[string]string{}, true)
+	if err != nil {
+		return response, models.TerminalShiftsOutput{}, err
+	}
+	output := models.TerminalShiftsOutput{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
+
+// SwitchCashier switches the cashier of the terminal with the device token, the client gets the tokens of the cashier
+func (c *Client) SwitchCashier(terminal_token string, username string, pin string) (*http.Response, error) {
+	json_body, err := json.Marshal(models.SwitchCashierInput{Username: username, PIN: pin})
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.MakeRequest("POST", "/terminal/switch", json_body, map[string]string{
+		"Content-Type":     "application/json",
+		"X-Terminal-Token": terminal_token,
+	}, false)
+	if err != nil || response.StatusCode != http.StatusOK {
+		return response, err
+	}
+	tokens := models.TokenPairOutput{}
+	if err := json.NewDecoder(response.Body).Decode(&tokens); err != nil {
+		return response, err
+	}
+	c.Username = username
+	c.Token = tokens.Data
+	c.RefreshToken = tokens.RefreshToken
+	return response, nil
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+)
+
+func (c *Client) decodeBranches(response *http.Response) (models.BranchesOutput, error) {
+	output := models.BranchesOutput{}
+	err := json.NewDecoder(response.Body).Decode(&output)
+	return output, err
+}
+
+// NewBranch creates a managed branch with its finance
+func (c *Client) NewBranch(input models.BranchInput) (*http.Response, models.BranchesOutput, error) {
+	json_body, err := json.Marshal(input)
+	if err != nil {
+		return nil, models.BranchesOutput{}, err
+	}
+	response, err := c.MakeRequest("POST", "/api/branches", json_body, map[string]string{
+		"Content-Type": "application/json",
+	}, true)
+	if err != nil {
+		return response, models.BranchesOutput{}, err
+	}
+	output, err := c.decodeBranches(response)
+	return response, output, err
+}
+
+func (c *Client) UpdateBranch(branch_id string, input models.UpdateBranchInput) (*http.Response, models.BranchesOutput, error) {
+	json_body, err := json.Marshal(input)
+	if err != nil {
+		return nil, models.BranchesOutput{}, err
+	}
+	response, err := c.MakeRequest("PUT", "/api/branches/"+branch_id, json_body, map[string]string{
+		"Content-Type": "application/json",
+	}, true)
+	if err != nil {
+		return response, models.BranchesOutput{}, err
+	}
+	output, err := c.decodeBranches(response)
+	return response, output, err
+}
+
+func (c *Client) DeleteBranch(branch_id string) (*http.Response, error) {
+	return c.MakeRequest("DELETE", "/api/branches/"+branch_id, nil, map[string]string{}, true)
+}
+
+func (c *Client) MigrateBranches() (*http.Response, models.BranchesMigrationOutput, error) {
+	response, err := c.MakeRequest("POST", "/api/branches/migrate", nil, map[string]string{}, true)
+	if err != nil {
+		return response, models.BranchesMigrationOutput{}, err
+	}
+	output := models.BranchesMigrationOutput{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
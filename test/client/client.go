@@ -13,11 +13,12 @@ import (
 )
 
 type Client struct {
-	Host     string
-	Port     string
-	Username string
-	Passwrod string
-	Token    string
+	Host         string
+	Port         string
+	Username     string
+	Passwrod     string
+	Token        string
+	RefreshToken string
 }
 
 func NewClient(host, port, username, password string) *Client {
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+)
+
+type CustomersOutput struct {
+	Data  []models.Customer `json:"data"`
+	Error []models.Error    `json:"error"`
+}
+
+type BNPLsOutput struct {
+	Data  []models.BNPL  `json:"data"`
+	Error []models.Error `json:"error"`
+}
+
+func (c *Client) CreateCustomer(input models.CustomerBase) (*http.Response, CustomersOutput, error) {
+	json_body, err := json.Marshal(input)
+	if err != nil {
+		return nil, CustomersOutput{}, err
+	}
+	response, err := c.MakeRequest("POST", "/api/customers", json_body, map[string]string{
+		"Content-Type": "application/json",
+	}, true)
+	if err != nil {
+		return response, CustomersOutput{}, err
+	}
+	output := CustomersOutput{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
+
+func (c *Client) NewBNPL(input models.NewBNPLInput) (*http.Response, error) {
+	json_body, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	return c.MakeRequest("POST", "/api/bnpl", json_body, map[string]string{
+		"Content-Type": "application/json",
+	}, true)
+}
+
+func (c *Client) GetBNPLsOfCustomer(customer_id string) (*http.Response, BNPLsOutput, error) {
+	response, err := c.MakeRequest("GET", "/api/customers/"+customer_id+"/bnpls", nil, map[string]string{}, true)
+	if err != nil {
+		return response, BNPLsOutput{}, err
+	}
+	output := BNPLsOutput{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+)
+
+func (c *Client) CreateExpenseCategory(input models.ExpenseCategoryInput) (*http.Response, models.ExpenseCategoryOutput, error) {
+	json_body, err := json.Marshal(input)
+	if err != nil {
+		return nil, models.ExpenseCategoryOutput{}, err
+	}
+	response, err := c.MakeRequest("POST", "/api/expenses/categories", json_body, map[string]string{
+		"Content-Type": "application/json",
+	}, true)
+	if err != nil {
+		return response, models.ExpenseCategoryOutput{}, err
+	}
+	output := models.ExpenseCategoryOutput{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
+
+func (c *Client) CreateExpense(input models.NewExpenseInput) (*http.Response, models.ExpenseOutputSingle, error) {
+	json_body, err := json.Marshal(input)
+	if err != nil {
+		return nil, models.ExpenseOutputSingle{}, err
+	}
+	response, err := c.MakeRequest("POST", "/api/expenses", json_body, map[string]string{
+		"Content-Type": "application/json",
+	}, true)
+	if err != nil {
+		return response, models.ExpenseOutputSingle{}, err
+	}
+	output := models.ExpenseOutputSingle{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
+
+func (c *Client) ApproveExpense(expense_id string) (*http.Response, models.ExpenseOutputSingle, error) {
+	response, err := c.MakeRequest("POST", "/api/expenses/"+expense_id+"/approve", nil, map[string]string{}, true)
+	if err != nil {
+		return response, models.ExpenseOutputSingle{}, err
+	}
+	output := models.ExpenseOutputSingle{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
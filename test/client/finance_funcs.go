@@ -0,0 +1,118 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+)
+
+func (c *Client) GetReconciliation(branch_id string) (*http.Response, models.ReconciliationOutputSingle, error) {
+	response, err := c.MakeRequest("GET", "/api/finance/reconciliation/"+branch_id, nil, map[string]string{}, true)
+	if err != nil {
+		return response, models.ReconciliationOutputSingle{}, err
+	}
+	output := models.ReconciliationOutputSingle{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
+
+func (c *Client) ApplyReconciliation(branch_id string, input models.ApplyReconciliationInput) (*http.Response, models.FinanceAdjustmentOutputSingle, error) {
+	json_body, err := json.Marshal(input)
+	if err != nil {
+		return nil, models.FinanceAdjustmentOutputSingle{}, err
+	}
+	response, err := c.MakeRequest("POST", "/api/finance/reconciliation/"+branch_id+"/apply", json_body, map[string]string{
+		"Content-Type": "application/json",
+	}, true)
+	if err != nil {
+		return response, models.FinanceAdjustmentOutputSingle{}, err
+	}
+	output := models.FinanceAdjustmentOutputSingle{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
+
+func (c *Client) TransferMoney(input models.MoneyTransferInput) (*http.Response, models.MoneyTransferOutputSingle, error) {
+	json_body, err := json.Marshal(input)
+	if err != nil {
+		return nil, models.MoneyTransferOutputSingle{}, err
+	}
+	response, err := c.MakeRequest("POST", "/api/finance/transfers", json_body, map[string]string{
+		"Content-Type": "application/json",
+	}, true)
+	if err != nil {
+		return response, models.MoneyTransferOutputSingle{}, err
+	}
+	output := models.MoneyTransferOutputSingle{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
+
+func (c *Client) SetExchangeRate(input models.ExchangeRateInput) (*http.Response, models.ExchangeRateOutputSingle, error) {
+	json_body, err := json.Marshal(input)
+	if err != nil {
+		return nil, models.ExchangeRateOutputSingle{}, err
+	}
+	response, err := c.MakeRequest("POST", "/api/finance/exchange-rates", json_body, map[string]string{
+		"Content-Type": "application/json",
+	}, true)
+	if err != nil {
+		return response, models.ExchangeRateOutputSingle{}, err
+	}
+	output := models.ExchangeRateOutputSingle{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
+
+func (c *Client) GetSupplierBalances(branch_id string) (*http.Response, models.SupplierBalancesOutput, error) {
+	response, err := c.MakeRequest("GET", "/api/suppliers/balances?branch="+branch_id, nil, map[string]string{}, true)
+	if err != nil {
+		return response, models.SupplierBalancesOutput{}, err
+	}
+	output := models.SupplierBalancesOutput{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
+
+func (c *Client) ClosePeriod(branch_id string, input models.PeriodCloseInput) (*http.Response, models.PeriodCloseOutputSingle, error) {
+	json_body, err := json.Marshal(input)
+	if err != nil {
+		return nil, models.PeriodCloseOutputSingle{}, err
+	}
+	response, err := c.MakeRequest("POST", "/api/finance/periods/"+branch_id+"/close", json_body, map[string]string{
+		"Content-Type": "application/json",
+	}, true)
+	if err != nil {
+		return response, models.PeriodCloseOutputSingle{}, err
+	}
+	output := models.PeriodCloseOutputSingle{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
+
+func (c *Client) ReopenPeriod(period_id string, reason string) (*http.Response, models.PeriodCloseOutputSingle, error) {
+	json_body, err := json.Marshal(models.PeriodReopenInput{Reason: reason})
+	if err != nil {
+		return nil, models.PeriodCloseOutputSingle{}, err
+	}
+	response, err := c.MakeRequest("POST", "/api/finance/periods/"+period_id+"/reopen", json_body, map[string]string{
+		"Content-Type": "application/json",
+	}, true)
+	if err != nil {
+		return response, models.PeriodCloseOutputSingle{}, err
+	}
+	output := models.PeriodCloseOutputSingle{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
+
+func (c *Client) GetPeriodLock(branch_id string) (*http.Response, models.PeriodLockOutput, error) {
+	response, err := c.MakeRequest("GET", "/api/finance/periods/"+branch_id+"/lock", nil, map[string]string{}, true)
+	if err != nil {
+		return response, models.PeriodLockOutput{}, err
+	}
+	output := models.PeriodLockOutput{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+)
+
+func (c *Client) GetLedgerEntries(branch_id string, transaction_id string) (*http.Response, models.LedgerEntriesOutput, error) {
+	response, err := c.MakeRequest("GET", "/api/ledger/branch/"+branch_id+"/entries?transaction_id="+transaction_id, nil, map[string]string{}, true)
+	if err != nil {
+		return response, models.LedgerEntriesOutput{}, err
+	}
+	output := models.LedgerEntriesOutput{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
+
+func (c *Client) GetTrialBalance(branch_id string) (*http.Response, models.TrialBalanceOutput, error) {
+	response, err := c.MakeRequest("GET", "/api/ledger/branch/"+branch_id+"/trial-balance", nil, map[string]string{}, true)
+	if err != nil {
+		return response, models.TrialBalanceOutput{}, err
+	}
+	output := models.TrialBalanceOutput{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
+
+func (c *Client) GetLedgerBalances(branch_id string) (*http.Response, models.LedgerBalancesOutput, error) {
+	response, err := c.MakeRequest("GET", "/api/ledger/branch/"+branch_id+"/balances", nil, map[string]string{}, true)
+	if err != nil {
+		return response, models.LedgerBalancesOutput{}, err
+	}
+	output := models.LedgerBalancesOutput{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
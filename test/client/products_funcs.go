@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/analytics"
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/products"
 	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
 )
 
@@ -126,3 +128,126 @@ func (c *Client) QueryProducts(params *models.ProductQueryParams) (*http.Respons
 	}
 	return resp, productOutput, nil
 }
+
+func (c *Client) NewTransfer(input *models.NewTransferInput) (*http.Response, models.TransferOutput, error) {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return nil, models.TransferOutput{}, err
+	}
+	resp, err := c.MakeRequest("POST", "/api/products/transfer", body, map[string]string{"Content-Type": "application/json"}, false)
+	if err != nil {
+		return nil, models.TransferOutput{}, err
+	}
+	output := models.TransferOutput{}
+	err = json.NewDecoder(resp.Body).Decode(&output)
+	return resp, output, err
+}
+
+func (c *Client) GetExpiringBatches(branch_id string, days int) (*http.Response, models.ProductBatchOutput, error) {
+	resp, err := c.MakeRequest("GET", "/api/products/batches/expiring?branch_id="+branch_id+"&days="+strconv.Itoa(days), nil, nil, false)
+	if err != nil {
+		return nil, models.ProductBatchOutput{}, err
+	}
+	output := models.ProductBatchOutput{}
+	err = json.NewDecoder(resp.Body).Decode(&output)
+	return resp, output, err
+}
+
+func (c *Client) GetLowStockProducts(branch_id string) (*http.Response, models.LowStockOutput, error) {
+	resp, err := c.MakeRequest("GET", "/api/products/low-stock?branch_id="+branch_id, nil, nil, false)
+	if err != nil {
+		return nil, models.LowStockOutput{}, err
+	}
+	output := models.LowStockOutput{}
+	err = json.NewDecoder(resp.Body).Decode(&output)
+	return resp, output, err
+}
+
+func (c *Client) NewStocktake(input *models.NewStocktakeInput) (*http.Response, models.StocktakeOutput, error) {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return nil, models.StocktakeOutput{}, err
+	}
+	resp, err := c.MakeRequest("POST", "/api/products/stocktakes", body, map[string]string{"Content-Type": "application/json"}, false)
+	if err != nil {
+		return nil, models.StocktakeOutput{}, err
+	}
+	output := models.StocktakeOutput{}
+	err = json.NewDecoder(resp.Body).Decode(&output)
+	return resp, output, err
+}
+
+func (c *Client) SubmitStocktakeCounts(stocktake_id string, input *models.SubmitStocktakeCountsInput) (*http.Response, models.StocktakeOutput, error) {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return nil, models.StocktakeOutput{}, err
+	}
+	resp, err := c.MakeRequest("POST", "/api/products/stocktakes/"+stocktake_id+"/counts", body, map[string]string{"Content-Type": "application/json"}, false)
+	if err != nil {
+		return nil, models.StocktakeOutput{}, err
+	}
+	output := models.StocktakeOutput{}
+	err = json.NewDecoder(resp.Body).Decode(&output)
+	return resp, output, err
+}
+
+func (c *Client) CancelStocktake(stocktake_id string) (*http.Response, models.StocktakeOutput, error) {
+	resp, err := c.MakeRequest("POST", "/api/products/stocktakes/"+stocktake_id+"/cancel", nil, nil, false)
+	if err != nil {
+		return nil, models.StocktakeOutput{}, err
+	}
+	output := models.StocktakeOutput{}
+	err = json.NewDecoder(resp.Body).Decode(&output)
+	return resp, output, err
+}
+
+func (c *Client) NewPurchaseOrder(input *models.NewPurchaseOrderInput) (*http.Response, models.PurchaseOrderOutput, error) {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return nil, models.PurchaseOrderOutput{}, err
+	}
+	resp, err := c.MakeRequest("POST", "/api/purchase-orders", body, map[string]string{"Content-Type": "application/json"}, false)
+	if err != nil {
+		return nil, models.PurchaseOrderOutput{}, err
+	}
+	output := models.PurchaseOrderOutput{}
+	err = json.NewDecoder(resp.Body).Decode(&output)
+	return resp, output, err
+}
+
+func (c *Client) GetProductByID(product_id string) (*http.Response, models.ProductOutput, error) {
+	resp, err := c.MakeRequest("GET", "/api/products/"+product_id, nil, nil, true)
+	if err != nil {
+		return nil, models.ProductOutput{}, err
+	}
+	output, err := DecodeProductOutput(resp)
+	return resp, output, err
+}
+
+func (c *Client) NewIncome(product_id string, input *products.NewIncomeInput) (*http.Response, models.ProductOutput, error) {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return nil, models.ProductOutput{}, err
+	}
+	resp, err := c.MakeRequest("POST", "/api/products/"+product_id+"/income", body, map[string]string{"Content-Type": "application/json"}, true)
+	if err != nil {
+		return nil, models.ProductOutput{}, err
+	}
+	output, err := DecodeProductOutput(resp)
+	return resp, output, err
+}
+
+type MarginReportOutput struct {
+	Data  []analytics.MarginReportRow `json:"data"`
+	Error []models.Error              `json:"error"`
+}
+
+func (c *Client) GetMarginReport(group_by string, branch_id string) (*http.Response, MarginReportOutput, error) {
+	resp, err := c.MakeRequest("GET", "/api/analytics/margins?group_by="+group_by+"&branch_id="+branch_id, nil, nil, true)
+	if err != nil {
+		return nil, MarginReportOutput{}, err
+	}
+	output := MarginReportOutput{}
+	err = json.NewDecoder(resp.Body).Decode(&output)
+	return resp, output, err
+}
@@ -23,11 +23,41 @@ func (c *Client) CreateSalesTransaction(branch_id string, transaction models.Tra
 	return resp, output, err
 }
 
-func (c *Client) DeleteSalesTransaction(branch_id string, transaction_id string) (resp *http.Response, output models.TransactionOutputSingle, err error) {
-	resp, err = c.MakeRequest("DELETE", "/api/sales/"+branch_id+"/"+transaction_id, nil, map[string]string{"Content-Type": "application/json"}, false)
+func (c *Client) DeleteSalesTransaction(transaction_id string, reason string) (resp *http.Response, output models.TransactionRevisionOutput, err error) {
+	body, err := json.Marshal(models.TransactionVoidInput{Reason: reason})
 	if err != nil {
-		return nil, models.TransactionOutputSingle{}, err
+		return nil, models.TransactionRevisionOutput{}, err
 	}
-	output, err = DecodeTransactionOutputSingle(resp)
+	resp, err = c.MakeRequest("DELETE", "/api/sales/transactions/"+transaction_id, body, map[string]string{"Content-Type": "application/json"}, false)
+	if err != nil {
+		return nil, models.TransactionRevisionOutput{}, err
+	}
+	err = json.NewDecoder(resp.Body).Decode(&output)
+	return resp, output, err
+}
+
+func (c *Client) CreateReceipt(branch_id string, input models.NewReceiptInput) (resp *http.Response, output models.ReceiptOutput, err error) {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return nil, models.ReceiptOutput{}, err
+	}
+	resp, err = c.MakeRequest("POST", "/api/sales/receipts/"+branch_id, body, map[string]string{"Content-Type": "application/json"}, false)
+	if err != nil {
+		return nil, models.ReceiptOutput{}, err
+	}
+	err = json.NewDecoder(resp.Body).Decode(&output)
+	return resp, output, err
+}
+
+func (c *Client) RefundReceipt(receipt_id string, input models.NewRefundInput) (resp *http.Response, output models.RefundOutput, err error) {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return nil, models.RefundOutput{}, err
+	}
+	resp, err = c.MakeRequest("POST", "/api/sales/receipts/"+receipt_id+"/refunds", body, map[string]string{"Content-Type": "application/json"}, false)
+	if err != nil {
+		return nil, models.RefundOutput{}, err
+	}
+	err = json.NewDecoder(resp.Body).Decode(&output)
 	return resp, output, err
 }
@@ -111,3 +111,28 @@ func (c *Client) DeleteSalesSession(session_id string) (*models.SalesSession, er
 	}
 	return sessions[0], nil
 }
+
+func (c *Client) RemoveProductFromSession(session_id string, product_id string) (*http.Response, []*models.SalesSession, error) {
+	endpoint := fmt.Sprintf("/api/sales/session/%s/product/%s", session_id, product_id)
+	response, err := c.MakeRequest("DELETE", endpoint, nil, nil, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	sessions, err := DecodeSessionResponse(response)
+	return response, sessions, err
+}
+
+func (c *Client) CloseSalesSession(session_id string, input models.CloseSalesSessionInput) (*http.Response, map[string]interface{}, error) {
+	endpoint := fmt.Sprintf("/api/sales/session/%s/close", session_id)
+	body, err := json.Marshal(input)
+	if err != nil {
+		return nil, nil, err
+	}
+	response, err := c.MakeRequest("POST", endpoint, body, map[string]string{"Content-Type": "application/json"}, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	output := map[string]interface{}{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"time"
@@ -8,7 +9,7 @@ import (
 	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
 )
 
-func (c *Client) GetTransactions(branch_id string, description string, amount_min uint32, amount_max uint32, payment_method models.PaymentMethod, type_of_transaction models.TransactionType, initiator_type models.InitiatorType, date_min time.Time, date_max time.Time, page int, count int) (resp *http.Response, output models.TransactionOutput, err error) {
+func (c *Client) GetTransactions(branch_id string, description string, amount_min int64, amount_max int64, payment_method models.PaymentMethod, type_of_transaction models.TransactionType, initiator_type models.InitiatorType, date_min time.Time, date_max time.Time, page int, count int) (resp *http.Response, output models.TransactionOutput, err error) {
 	url := fmt.Sprintf("/api/transactions/branch/%s?", branch_id)
 	if description != "" {
 		url += fmt.Sprintf("description=%s&", description)
@@ -53,3 +54,41 @@ func (c *Client) GetTransactionByID(branch_id string, transaction_id string) (re
 	output, err = DecodeTransactionOutputSingle(resp)
 	return resp, output, err
 }
+
+func (c *Client) EditTransaction(transaction_id string, input models.TransactionEditInput) (*http.Response, models.TransactionRevisionOutput, error) {
+	json_body, err := json.Marshal(input)
+	if err != nil {
+		return nil, models.TransactionRevisionOutput{}, err
+	}
+	response, err := c.MakeRequest("PUT", "/api/transactions/"+transaction_id, json_body, map[string]string{"Content-Type": "application/json"}, true)
+	if err != nil {
+		return response, models.TransactionRevisionOutput{}, err
+	}
+	output := models.TransactionRevisionOutput{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
+
+func (c *Client) VoidTransaction(transaction_id string, reason string) (*http.Response, models.TransactionRevisionOutput, error) {
+	json_body, err := json.Marshal(models.TransactionVoidInput{Reason: reason})
+	if err != nil {
+		return nil, models.TransactionRevisionOutput{}, err
+	}
+	response, err := c.MakeRequest("DELETE", "/api/transactions/"+transaction_id, json_body, map[string]string{"Content-Type": "application/json"}, true)
+	if err != nil {
+		return response, models.TransactionRevisionOutput{}, err
+	}
+	output := models.TransactionRevisionOutput{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
+
+func (c *Client) GetTransactionVersions(transaction_id string) (*http.Response, models.TransactionVersionsOutput, error) {
+	response, err := c.MakeRequest("GET", "/api/transactions/"+transaction_id+"/versions", nil, map[string]string{}, true)
+	if err != nil {
+		return response, models.TransactionVersionsOutput{}, err
+	}
+	output := models.TransactionVersionsOutput{}
+	err = json.NewDecoder(response.Body).Decode(&output)
+	return response, output, err
+}
@@ -0,0 +1,118 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUSDSupplierTransactionIsConvertedToBaseCurrency(t *testing.T) {
+	ChangeLogging()
+	client := getClient(t)
+
+	branches := getAllBranches(t, client)
+	if len(branches) == 0 {
+		t.Fatal("No branches found")
+	}
+	branch := branches[0]
+	if branch.Currency.OrDefault() != models.CurrencyUZS {
+		t.Skip("Branch is not kept in UZS")
+	}
+
+	resp, _, err := client.SetExchangeRate(models.ExchangeRateInput{
+		Currency: models.CurrencyUSD,
+		Base:     models.CurrencyUZS,
+		Date:     time.Now(),
+		Rate:     12500,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+
+	resp, supplier, err := client.CreateSupplier(models.SupplierBase{
+		Name:     "USD supplier",
+		Branch:   branch.BranchID,
+		Currency: models.CurrencyUSD,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+
+	// 100.50 USD of goods from the supplier
+	resp, transaction, err := client.NewSupplierTransaction(branch.BranchID, supplier.Data.ID, models.TransactionBase{
+		Amount:        10050,
+		Description:   "goods in USD",
+		Type:          models.TransactionTypeDebit,
+		PaymentMethod: models.PaymentMethodUndefined,
+		Currency:      models.CurrencyUSD,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+	assert.Equal(t, models.CurrencyUSD, transaction.Data.Currency)
+	assert.Equal(t, int64(1256250), transaction.Data.BaseAmount)
+
+	_, after, err := client.GetBranchByID(branch.BranchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, branch.Finance.Debt+1256250, after.Data.Finance.Debt)
+
+	_, updated, err := client.GetSupplierByID(supplier.Data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(-10050), updated.Data.FinancialData.Balance)
+
+	_, balances, err := client.GetSupplierBalances(branch.BranchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, balance := range balances.Data.Suppliers {
+		if balance.SupplierID == supplier.Data.ID {
+			assert.Equal(t, models.CurrencyUZS, balance.BaseCurrency)
+			assert.Equal(t, int64(-1256250), balance.BaseBalance)
+		}
+	}
+}
+
+func TestSupplierTransactionInThirdCurrencyIsRejected(t *testing.T) {
+	ChangeLogging()
+	client := getClient(t)
+
+	branches := getAllBranches(t, client)
+	if len(branches) == 0 {
+		t.Fatal("No branches found")
+	}
+	branch := branches[0]
+
+	resp, supplier, err := client.CreateSupplier(models.SupplierBase{
+		Name:   "UZS supplier",
+		Branch: branch.BranchID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+	if supplier.Data.Currency != models.CurrencyUZS || branch.Currency.OrDefault() != models.CurrencyUZS {
+		t.Skip("Branch is not kept in UZS")
+	}
+
+	resp, _, err = client.NewSupplierTransaction(branch.BranchID, supplier.Data.ID, models.TransactionBase{
+		Amount:        100,
+		Type:          models.TransactionTypeDebit,
+		PaymentMethod: models.PaymentMethodUndefined,
+		Currency:      models.CurrencyUSD,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Expected status code 400, but got %d", resp.StatusCode)
+}
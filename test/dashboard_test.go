@@ -0,0 +1,82 @@
+package test
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDashboardLogin(t *testing.T) {
+	ChangeLogging()
+	admin := getClient(t)
+	base := "http://" + admin.Host + admin.Port
+	// redirects are checked, not followed
+	web := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	resp, err := web.Get(base + "/dashboard/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode, "Expected status code 303, but got %d", resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Location"), middleware.WebLoginPath))
+
+	username := fmt.Sprintf("accountant_%d", time.Now().UnixNano())
+	resp, _, err = admin.CreateUser(username, "accountant", "accountant", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+
+	resp, err = web.PostForm(base+middleware.WebLoginPath, url.Values{
+		"username": {username},
+		"password": {"accountant"},
+		"next":     {"/dashboard/general"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode, "Expected status code 303, but got %d", resp.StatusCode)
+	assert.Equal(t, "/dashboard/general", resp.Header.Get("Location"))
+	var cookie *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == middleware.SessionCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		t.Fatal("Expected session cookie")
+	}
+
+	get := func(path string) *http.Response {
+		req, _ := http.NewRequest("GET", base+path, nil)
+		req.AddCookie(cookie)
+		resp, err := web.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	resp = get("/dashboard/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+
+	// accountants read the docs like owners
+	resp = get("/docs/index.html")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+
+	// the token of the cookie is not a bearer token
+	req, _ := http.NewRequest("GET", base+"/api/auth/me", nil)
+	req.Header.Set("Authorization", cookie.Value)
+	resp, err = web.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "Expected status code 401, but got %d", resp.StatusCode)
+}
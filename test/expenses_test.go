@@ -0,0 +1,73 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApprovedExpenseChangesFinance(t *testing.T) {
+	ChangeLogging()
+	client := getClient(t)
+
+	branches := getAllBranches(t, client)
+	if len(branches) == 0 {
+		t.Fatal("No branches found")
+	}
+	branch := branches[0]
+
+	resp, categories, err := client.CreateExpenseCategory(models.ExpenseCategoryInput{
+		Name: "utilities " + uuid.New().String(),
+		Type: models.InitiatorTypeUtilities,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+
+	resp, expense, err := client.CreateExpense(models.NewExpenseInput{
+		BranchID:      branch.BranchID,
+		CategoryID:    categories.Data[0].ID,
+		Amount:        1200,
+		PaymentMethod: models.PaymentMethodCash,
+		Description:   "electricity",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+	assert.Equal(t, models.ExpenseStatusPending, expense.Data.Status)
+
+	// pending expenses do not change the finance
+	_, before, err := client.GetBranchByID(branch.BranchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, branch.Finance.Balance.Cash, before.Data.Finance.Balance.Cash)
+
+	resp, approved, err := client.ApproveExpense(expense.Data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+	assert.Equal(t, models.ExpenseStatusApproved, approved.Data.Status)
+	assert.NotEmpty(t, approved.Data.TransactionID)
+
+	_, after, err := client.GetBranchByID(branch.BranchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, before.Data.Finance.Balance.Cash-1200, after.Data.Finance.Balance.Cash)
+	assert.Equal(t, before.Data.Finance.TotalExpenses+1200, after.Data.Finance.TotalExpenses)
+
+	// approving twice is rejected
+	resp, _, err = client.ApproveExpense(expense.Data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Expected status code 400, but got %d", resp.StatusCode)
+}
@@ -123,6 +123,7 @@ func TestMain(m *testing.M) {
 	app := app.New()
 
 	app.DB.Database("magazin").Collection("finance").DeleteMany(context.Background(), bson.M{})
+	app.DB.Database("magazin").Collection("branches").DeleteMany(context.Background(), bson.M{})
 	app.DB.Database("magazin").Collection("suppliers").DeleteMany(context.Background(), bson.M{})
 	app.DB.Database("magazin").Collection("transactions").DeleteMany(context.Background(), bson.M{})
 	app.DB.Database("magazin").Collection("journals").DeleteMany(context.Background(), bson.M{})
@@ -307,10 +308,10 @@ func TestNewSupplierTransaction(t *testing.T) {
 	}
 
 	branch_response := getBranchByID(t, client, Branch)
-	assert.Equal(t, int32(-balance), branch_response.Finance.Debt, "Expected balance to be %f, but got %f", -balance, branch_response.Finance.Debt)
-	assert.Equal(t, int32(-5500000), branch_response.Finance.Balance.Bank, "Expected balance to be %f, but got %f", 5500000, branch_response.Finance.Balance.Bank)
-	assert.Equal(t, int32(-9000000), branch_response.Finance.Balance.Cash, "Expected balance to be %f, but got %f", 10000000, branch_response.Finance.Balance.Cash)
-	assert.Equal(t, int32(-1000000), branch_response.Finance.Balance.MobileApps, "Expected balance to be %f, but got %f", 1000000, branch_response.Finance.Balance.MobileApps)
+	assert.Equal(t, int64(-balance), branch_response.Finance.Debt, "Expected balance to be %f, but got %f", -balance, branch_response.Finance.Debt)
+	assert.Equal(t, int64(-5500000), branch_response.Finance.Balance.Bank, "Expected balance to be %f, but got %f", 5500000, branch_response.Finance.Balance.Bank)
+	assert.Equal(t, int64(-9000000), branch_response.Finance.Balance.Cash, "Expected balance to be %f, but got %f", 10000000, branch_response.Finance.Balance.Cash)
+	assert.Equal(t, int64(-1000000), branch_response.Finance.Balance.MobileApps, "Expected balance to be %f, but got %f", 1000000, branch_response.Finance.Balance.MobileApps)
 
 	supplier_response := getSupplierByID(t, client, supplierID)
 	assert.Equal(t, supplier_response.ID, supplierID, "Expected supplier ID to be %s, but got %s", supplierID, supplier_response.ID)
@@ -371,10 +372,10 @@ func TestSalesTransaction(t *testing.T) {
 		}
 	}
 	balance := models.Balance{
-		Cash:       int32(cash),
-		Bank:       int32(bank),
-		Terminal:   int32(terminal),
-		MobileApps: int32(mobile_apps),
+		Cash:       int64(cash),
+		Bank:       int64(bank),
+		Terminal:   int64(terminal),
+		MobileApps: int64(mobile_apps),
 	}
 	output_branch := getBranchByID(t, client, branchID)
 	assert.Equal(t, output_branch.Finance.Balance, balance, "Expected balance to be %f, but got %f", balance, output_branch.Finance.Balance)
@@ -495,7 +496,7 @@ func TestUpdateOperation(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
 	assert.Nil(t, output_.Error, "Expected no error, but got one")
 	assert.Equal(t, output_.Data.Total, journal_.Total+1000000-operation_1.Amount, "Expected total to be %d, but got %d", journal_.Total+1000000-operation_1.Amount, output_.Data.Total)
-	assert.Equal(t, output_.Data.Operations[0].Amount, uint32(1000000), "Expected amount to be %d, but got %d", 1000000, output_.Data.Operations[0].Amount)
+	assert.Equal(t, output_.Data.Operations[0].Amount, int64(1000000), "Expected amount to be %d, but got %d", 1000000, output_.Data.Operations[0].Amount)
 	assert.Equal(t, output_.Data.Operations[0].Description, operation_1.Description, "Expected description to be %s, but got %s", operation_1.Description, output_.Data.Operations[0].Description)
 
 	resp, output_, err = client.UpdateOperation(journal_.ID.Hex(), operation_2.ID, 1000000, "Test Operation 2 - bla bla bla")
@@ -507,7 +508,7 @@ func TestUpdateOperation(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
 	assert.Nil(t, output_.Error, "Expected no error, but got one")
 	assert.Equal(t, output_.Data.Total, journal_.Total+2000000-operation_1.Amount-operation_2.Amount, "Expected total to be %d, but got %d", journal_.Total+1000000-operation_1.Amount-operation_2.Amount, output_.Data.Total)
-	assert.Equal(t, output_.Data.Operations[1].Amount, uint32(1000000), "Expected amount to be %d, but got %d", 1000000, output_.Data.Operations[1].Amount)
+	assert.Equal(t, output_.Data.Operations[1].Amount, int64(1000000), "Expected amount to be %d, but got %d", 1000000, output_.Data.Operations[1].Amount)
 	assert.Equal(t, output_.Data.Operations[1].Description, "Test Operation 2 - bla bla bla", "Expected description to be %s, but got %s", "Test Operation 2 + bla bla bla", output_.Data.Operations[1].Description)
 }
 
@@ -593,6 +594,6 @@ func TestReOpenJournal(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
 	assert.Nil(t, output_2.Error, "Expected no error, but got one")
 	assert.Equal(t, false, output_2.Data.Shift_is_closed, "Expected shift to be open, but got %t", output_2.Data.Shift_is_closed)
-	assert.Equal(t, uint32(0), output_2.Data.Cash_left)
-	assert.Equal(t, uint32(0), output_2.Data.Terminal_income)
+	assert.Equal(t, int64(0), output_2.Data.Cash_left)
+	assert.Equal(t, int64(0), output_2.Data.Terminal_income)
 }
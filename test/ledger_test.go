@@ -0,0 +1,62 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSalesTransactionIsPostedToLedger(t *testing.T) {
+	ChangeLogging()
+	client := getClient(t)
+
+	branches := getAllBranches(t, client)
+	if len(branches) == 0 {
+		t.Fatal("No branches found")
+	}
+	branch := branches[0]
+
+	_, before, err := client.GetLedgerBalances(branch.BranchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, output, err := client.CreateSalesTransaction(branch.BranchID, models.TransactionBase{
+		Amount:        2500,
+		Description:   "ledger test",
+		PaymentMethod: models.PaymentMethodTerminal,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+
+	// terminal is debited and sales credited by the same amount
+	resp, entries, err := client.GetLedgerEntries(branch.BranchID, output.Data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+	if len(entries.Data) != 1 {
+		t.Fatalf("Expected one ledger entry of the transaction, got %d", len(entries.Data))
+	}
+	assert.ElementsMatch(t, []models.LedgerLine{
+		models.DebitLine(models.AccountTerminal, 2500),
+		models.CreditLine(models.AccountSales, 2500),
+	}, entries.Data[0].Lines)
+
+	_, after, err := client.GetLedgerBalances(branch.BranchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, before.Data.Terminal+2500, after.Data.Terminal)
+
+	_, trial_balance, err := client.GetTrialBalance(branch.BranchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, trial_balance.Data.Balanced, "Expected debits and credits of the trial balance to be equal")
+}
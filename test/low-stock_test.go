@@ -0,0 +1,28 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetLowStockProducts(t *testing.T) {
+	ChangeLogging()
+	client := getClient(t)
+
+	branches := getAllBranches(t, client)
+	if len(branches) == 0 {
+		t.Fatal("No branches found")
+	}
+
+	resp, output, err := client.GetLowStockProducts(branches[0].BranchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+	for _, item := range output.Data {
+		assert.Equal(t, branches[0].BranchID, item.Place.ID)
+		assert.Less(t, item.Quantity, item.MinimumStockAlert)
+	}
+}
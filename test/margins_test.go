@@ -0,0 +1,107 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/aslon1213/g4h_pos_erp/pkg/controllers/products"
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWeightedAverageCostAndMarginReport(t *testing.T) {
+	ChangeLogging()
+	client := getClient(t)
+
+	branches := getAllBranches(t, client)
+	if len(branches) == 0 {
+		t.Fatal("No branches found")
+	}
+	branch := branches[0]
+	supplier_id := ""
+	for _, supplier := range getAllSuppliers(t, client) {
+		if supplier.Branch == branch.BranchID && (supplier.Currency == "" || supplier.Currency == branch.Currency) {
+			supplier_id = supplier.ID
+			break
+		}
+	}
+	if supplier_id == "" {
+		t.Skip("No supplier of the branch in its base currency")
+	}
+
+	resp, created, err := client.CreateProduct(&models.ProductBase{
+		Name: "margin " + uuid.New().String(),
+		SKU:  uuid.New().String(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+	product_id := created.Data[0].ID
+
+	// 10 at 100 and 10 at 200 average to 150
+	place := models.ProductPlace{ID: branch.BranchID, PlaceType: models.ProductPlaceTypeBranch}
+	for _, cost := range []int32{100, 200} {
+		resp, _, err := client.NewIncome(product_id, &products.NewIncomeInput{
+			IncomeHistory: models.IncomeHistory{
+				Price:      cost,
+				Quantity:   10,
+				UploadedTo: place,
+				SupplierID: supplier_id,
+			},
+			SellingPrice: 300,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+	}
+
+	_, product, err := client.GetProductByID(product_id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, distribution := range product.Data[0].QuantityDistribution {
+		if distribution.Place.ID == branch.BranchID {
+			found = true
+			assert.Equal(t, int32(20), distribution.Quantity)
+			assert.Equal(t, int32(150), distribution.Cost)
+		}
+	}
+	assert.True(t, found, "Product is not distributed to the branch")
+
+	session, err := client.NewSession(branch.BranchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.AddProductToSession(session.ID, product_id, 4); err != nil {
+		t.Fatal(err)
+	}
+	resp, _, err = client.CloseSalesSession(session.ID, models.CloseSalesSessionInput{PaymentMethod: models.PaymentMethodCash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+
+	// the sale is costed at the average snapshotted on checkout
+	resp, report, err := client.GetMarginReport("product", branch.BranchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+	found = false
+	for _, row := range report.Data {
+		if row.Key == product_id {
+			found = true
+			assert.Equal(t, int64(4), row.Quantity)
+			assert.Equal(t, int64(1200), row.Revenue)
+			assert.Equal(t, int64(600), row.Cost)
+			assert.Equal(t, int64(600), row.Margin)
+			assert.InDelta(t, 50.0, row.MarginPercent, 0.01)
+		}
+	}
+	assert.True(t, found, "Product is not in the margin report")
+}
@@ -0,0 +1,63 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransferCashToBank(t *testing.T) {
+	ChangeLogging()
+	client := getClient(t)
+
+	branches := getAllBranches(t, client)
+	if len(branches) == 0 {
+		t.Fatal("No branches found")
+	}
+	branch := branches[0]
+	if branch.Finance.Balance.Cash < 100 {
+		t.Skip("Not enough cash in the branch to transfer")
+	}
+
+	resp, output, err := client.TransferMoney(models.MoneyTransferInput{
+		From:        models.MoneyTransferEnd{BranchID: branch.BranchID, PaymentMethod: models.PaymentMethodCash},
+		To:          models.MoneyTransferEnd{BranchID: branch.BranchID, PaymentMethod: models.PaymentMethodBank},
+		Amount:      100,
+		Description: "deposit",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+	assert.Equal(t, output.Data.ID, output.Data.Outgoing.TransferID)
+	assert.Equal(t, output.Data.ID, output.Data.Incoming.TransferID)
+
+	_, after, err := client.GetBranchByID(branch.BranchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, branch.Finance.Balance.Cash-100, after.Data.Finance.Balance.Cash)
+	assert.Equal(t, branch.Finance.Balance.Bank+100, after.Data.Finance.Balance.Bank)
+	assert.Equal(t, branch.Finance.TotalIncome, after.Data.Finance.TotalIncome)
+	assert.Equal(t, branch.Finance.TotalExpenses, after.Data.Finance.TotalExpenses)
+}
+
+func TestTransferToSameBucketIsRejected(t *testing.T) {
+	ChangeLogging()
+	client := getClient(t)
+
+	branches := getAllBranches(t, client)
+	if len(branches) == 0 {
+		t.Fatal("No branches found")
+	}
+	end := models.MoneyTransferEnd{BranchID: branches[0].BranchID, PaymentMethod: models.PaymentMethodCash}
+
+	resp, _, err := client.TransferMoney(models.MoneyTransferInput{From: end, To: end, Amount: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Expected status code 400, but got %d", resp.StatusCode)
+}
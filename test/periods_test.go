@@ -0,0 +1,110 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClosedPeriodLocksDocuments(t *testing.T) {
+	ChangeLogging()
+	client := getClient(t)
+
+	branches := getAllBranches(t, client)
+	if len(branches) == 0 {
+		t.Fatal("No branches found")
+	}
+	branch := branches[0]
+
+	// lock dates can not be in the future
+	resp, _, err := client.ClosePeriod(branch.BranchID, models.PeriodCloseInput{LockDate: time.Now().AddDate(0, 0, 2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Expected status code 400, but got %d", resp.StatusCode)
+
+	resp, period, err := client.ClosePeriod(branch.BranchID, models.PeriodCloseInput{LockDate: time.Now(), Note: "daily close"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+	assert.Equal(t, models.RateDay(time.Now()).Unix(), period.Data.LockDate.Unix())
+
+	_, lock, err := client.GetPeriodLock(branch.BranchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, period.Data.LockDate.Unix(), lock.Data.LockDate.Unix())
+
+	// the same lock date again is rejected
+	resp, _, err = client.ClosePeriod(branch.BranchID, models.PeriodCloseInput{LockDate: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "Expected status code 409, but got %d", resp.StatusCode)
+
+	resp, categories, err := client.CreateExpenseCategory(models.ExpenseCategoryInput{
+		Name: "rent " + uuid.New().String(),
+		Type: models.InitiatorTypeRent,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+
+	// expenses dated in the closed period are rejected, later ones are not
+	expense := models.NewExpenseInput{
+		BranchID:      branch.BranchID,
+		CategoryID:    categories.Data[0].ID,
+		Amount:        700,
+		PaymentMethod: models.PaymentMethodCash,
+		Description:   "last month rent",
+		Date:          time.Now().AddDate(0, 0, -1),
+	}
+	resp, _, err = client.CreateExpense(expense)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "Expected status code 409, but got %d", resp.StatusCode)
+
+	expense.Date = time.Now()
+	resp, _, err = client.CreateExpense(expense)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+
+	// reopening needs a reason
+	resp, _, err = client.ReopenPeriod(period.Data.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Expected status code 400, but got %d", resp.StatusCode)
+
+	resp, reopened, err := client.ReopenPeriod(period.Data.ID, "missed invoice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+	assert.True(t, reopened.Data.Reopened)
+	assert.Equal(t, "missed invoice", reopened.Data.ReopenReason)
+
+	expense.Date = time.Now().AddDate(0, 0, -1)
+	resp, _, err = client.CreateExpense(expense)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+
+	// reopened closes can not be reopened again
+	resp, _, err = client.ReopenPeriod(period.Data.ID, "again")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "Expected status code 409, but got %d", resp.StatusCode)
+}
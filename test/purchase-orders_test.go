@@ -0,0 +1,41 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPurchaseOrderValidation(t *testing.T) {
+	ChangeLogging()
+	client := getClient(t)
+
+	branches := getAllBranches(t, client)
+	if len(branches) == 0 {
+		t.Fatal("No branches found")
+	}
+	place := models.ProductPlace{ID: branches[0].BranchID, PlaceType: models.ProductPlaceTypeBranch}
+	lines := []models.PurchaseOrderLineInput{{ProductID: "product", Quantity: 1, ExpectedPrice: 100}}
+
+	inputs := []*models.NewPurchaseOrderInput{
+		// no supplier
+		{Destination: place, Lines: lines},
+		// no lines
+		{SupplierID: "supplier", Destination: place},
+		// unknown supplier
+		{SupplierID: "unknown-supplier", Destination: place, Lines: lines},
+		// invalid proposal id
+		{SupplierID: "unknown-supplier", Destination: place, Lines: lines, ProposalIDs: []string{"not-an-object-id"}},
+	}
+
+	for _, input := range inputs {
+		resp, _, err := client.NewPurchaseOrder(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Expected status code 400, but got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,126 @@
+package test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+	"github.com/aslon1213/g4h_pos_erp/test/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCashierIsScopedToBranch(t *testing.T) {
+	ChangeLogging()
+	admin := getClient(t)
+
+	branches := getAllBranches(t, admin)
+	if len(branches) < 2 {
+		t.Skip("At least two branches are required")
+	}
+
+	username := fmt.Sprintf("cashier_%d", time.Now().UnixNano())
+	resp, _, err := admin.CreateUser(username, "cashier", "cashier", branches[0].BranchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+
+	cashier := client.NewClient(admin.Host, admin.Port, username, "cashier")
+
+	// own branch
+	resp, err = cashier.MakeRequest("GET", "/api/transactions/branch/"+branches[0].BranchID, nil, map[string]string{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+
+	// other branch
+	resp, err = cashier.MakeRequest("GET", "/api/transactions/branch/"+branches[1].BranchID, nil, map[string]string{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "Expected status code 403, but got %d", resp.StatusCode)
+
+	// cashiers can not create finances of branches
+	resp, err = cashier.MakeRequest("POST", "/api/finance", []byte(`{}`), map[string]string{"Content-Type": "application/json"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "Expected status code 403, but got %d", resp.StatusCode)
+}
+
+func TestCreateUserWithInvalidRole(t *testing.T) {
+	ChangeLogging()
+	admin := getClient(t)
+
+	resp, _, err := admin.CreateUser(fmt.Sprintf("invalid_%d", time.Now().UnixNano()), "password", "superuser", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Expected status code 400, but got %d", resp.StatusCode)
+}
+
+func TestBNPLIsScopedToBranch(t *testing.T) {
+	ChangeLogging()
+	admin := getClient(t)
+
+	branches := getAllBranches(t, admin)
+	if len(branches) < 2 {
+		t.Skip("At least two branches are required")
+	}
+
+	resp, customers, err := admin.CreateCustomer(models.CustomerBase{
+		Name:  "bnpl customer",
+		Phone: fmt.Sprintf("+998%d", time.Now().UnixNano()%1000000000),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+	customer_id := customers.Data[0].ID
+
+	// bnpl of the second branch
+	resp, err = admin.NewBNPL(models.NewBNPLInput{CustomerID: customer_id, TotalAmount: 1000, BranchID: branches[1].BranchID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+	_, bnpls, err := admin.GetBNPLsOfCustomer(customer_id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bnpls.Data) == 0 {
+		t.Fatal("BNPL of the customer not found")
+	}
+	bnpl_id := bnpls.Data[0].ID
+
+	// manager of the first branch
+	username := fmt.Sprintf("manager_%d", time.Now().UnixNano())
+	resp, _, err = admin.CreateUser(username, "manager", "manager", branches[0].BranchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+	manager := client.NewClient(admin.Host, admin.Port, username, "manager")
+
+	for _, request := range []struct{ method, path string }{
+		{"GET", "/api/bnpl/" + bnpl_id},
+		{"POST", "/api/bnpl/" + bnpl_id + "/credit?amount=100"},
+		{"DELETE", "/api/bnpl/" + bnpl_id},
+	} {
+		resp, err := manager.MakeRequest(request.method, request.path, nil, map[string]string{}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, "%s %s: expected status code 403, but got %d", request.method, request.path, resp.StatusCode)
+	}
+
+	// the bnpl is untouched
+	resp, err = admin.MakeRequest("GET", "/api/bnpl/"+bnpl_id, nil, map[string]string{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+}
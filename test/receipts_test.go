@@ -0,0 +1,97 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateReceiptRejectsInvalidTenders(t *testing.T) {
+	ChangeLogging()
+	client := getClient(t)
+
+	branches := getAllBranches(t, client)
+	if len(branches) == 0 {
+		t.Fatal("No branches found")
+	}
+	branch := branches[0]
+
+	inputs := []models.NewReceiptInput{
+		// no tenders
+		{Description: "empty receipt"},
+		// zero amount tender
+		{Tenders: []models.TenderInput{
+			{PaymentMethod: models.PaymentMethodCash, Amount: 1000},
+			{PaymentMethod: models.PaymentMethodTerminal, Amount: 0},
+		}},
+		// unknown payment method
+		{Tenders: []models.TenderInput{
+			{PaymentMethod: "gold", Amount: 1000},
+		}},
+		// bnpl tender without customer
+		{Tenders: []models.TenderInput{
+			{PaymentMethod: models.PaymentMethodCash, Amount: 1000},
+			{PaymentMethod: models.PaymentMethodBNPL, Amount: 5000},
+		}},
+	}
+
+	for _, input := range inputs {
+		resp, _, err := client.CreateReceipt(branch.BranchID, input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Expected status code 400, but got %d", resp.StatusCode)
+	}
+}
+
+func TestRefundUnknownReceipt(t *testing.T) {
+	ChangeLogging()
+	client := getClient(t)
+
+	resp, _, err := client.RefundReceipt("unknown-receipt", models.NewRefundInput{
+		Amount:        1000,
+		PaymentMethod: models.PaymentMethodCash,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Expected status code 404, but got %d", resp.StatusCode)
+}
+
+func TestRefundRejectsNonPositiveAmount(t *testing.T) {
+	ChangeLogging()
+	client := getClient(t)
+
+	branches := getAllBranches(t, client)
+	if len(branches) == 0 {
+		t.Fatal("No branches found")
+	}
+	branch := branches[0]
+
+	resp, receipts, err := client.CreateReceipt(branch.BranchID, models.NewReceiptInput{
+		Description: "receipt to refund",
+		Tenders: []models.TenderInput{
+			{PaymentMethod: models.PaymentMethodCash, Amount: 1000},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+
+	// negative amounts would add money to the branch and lower the refunded amount
+	for _, amount := range []int64{0, -500} {
+		resp, _, err := client.RefundReceipt(receipts.Data[0].ID, models.NewRefundInput{
+			Amount:        amount,
+			PaymentMethod: models.PaymentMethodCash,
+			Reason:        "returned",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Expected status code 400, but got %d", resp.StatusCode)
+	}
+}
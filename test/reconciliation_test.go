@@ -0,0 +1,48 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconciliationReportsEveryBucket(t *testing.T) {
+	ChangeLogging()
+	client := getClient(t)
+
+	branches := getAllBranches(t, client)
+	if len(branches) == 0 {
+		t.Fatal("No branches found")
+	}
+	branch := branches[0]
+
+	resp, output, err := client.GetReconciliation(branch.BranchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+	assert.Len(t, output.Data.Buckets, len(models.FinanceBuckets))
+	for _, bucket := range output.Data.Buckets {
+		assert.Equal(t, bucket.Computed-bucket.Stored, bucket.Difference, "Difference of bucket %s", bucket.Bucket)
+		assert.LessOrEqual(t, len(bucket.Transactions), bucket.Offending)
+	}
+}
+
+func TestApplyReconciliationRequiresReason(t *testing.T) {
+	ChangeLogging()
+	client := getClient(t)
+
+	branches := getAllBranches(t, client)
+	if len(branches) == 0 {
+		t.Fatal("No branches found")
+	}
+
+	resp, _, err := client.ApplyReconciliation(branches[0].BranchID, models.ApplyReconciliationInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Expected status code 400, but got %d", resp.StatusCode)
+}
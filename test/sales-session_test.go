@@ -0,0 +1,52 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSalesSessionRejectsInsufficientQuantity(t *testing.T) {
+	ChangeLogging()
+	client := getClient(t)
+
+	branches := getAllBranches(t, client)
+	if len(branches) == 0 {
+		t.Fatal("No branches found")
+	}
+	branch := branches[0]
+
+	resp, products, err := client.QueryProducts(&models.ProductQueryParams{BranchID: branch.BranchID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+	if len(products.Data) == 0 {
+		t.Skip("No products in the branch")
+	}
+
+	session, err := client.NewSession(branch.BranchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// more than any branch could hold
+	_, err = client.AddProductToSession(session.ID, products.Data[0].ID, 1<<30)
+	assert.NotNil(t, err, "Expected adding more than available quantity to fail")
+
+	// closing an empty session is rejected
+	resp, _, err = client.CloseSalesSession(session.ID, models.CloseSalesSessionInput{PaymentMethod: models.PaymentMethodCash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Expected status code 400, but got %d", resp.StatusCode)
+
+	voided, err := client.DeleteSalesSession(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, session.ID, voided.ID)
+}
@@ -0,0 +1,54 @@
+package test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginLockoutAndUnlock(t *testing.T) {
+	ChangeLogging()
+	admin := getClient(t)
+
+	username := fmt.Sprintf("lockout_%d", time.Now().UnixNano())
+	resp, users, err := admin.CreateUser(username, "password", "cashier", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+	if len(users.Data) == 0 {
+		t.Fatal("Expected created user")
+	}
+
+	// default policy locks the username after 5 failures
+	for i := 0; i < 5; i++ {
+		resp, err = admin.TryLogin(username, "wrong password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "Expected status code 401, but got %d", resp.StatusCode)
+	}
+
+	// even the right password is rejected while locked
+	resp, err = admin.TryLogin(username, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode, "Expected status code 429, but got %d", resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+
+	resp, err = admin.UnlockUser(users.Data[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+
+	resp, err = admin.TryLogin(username, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+}
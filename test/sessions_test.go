@@ -0,0 +1,81 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/aslon1213/g4h_pos_erp/test/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogoutRevokesToken(t *testing.T) {
+	ChangeLogging()
+	admin := getClient(t)
+
+	resp, sessions, err := admin.GetMySessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+	current := 0
+	for _, session := range sessions.Data {
+		if session.Current {
+			current++
+		}
+	}
+	assert.Equal(t, 1, current, "Expected exactly one current session")
+
+	resp, err = admin.Logout()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+
+	// the token can not be used anymore
+	resp, _, err = admin.GetMySessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "Expected status code 401, but got %d", resp.StatusCode)
+
+	// and the session can not be refreshed
+	resp, _, err = admin.Refresh(admin.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "Expected status code 401, but got %d", resp.StatusCode)
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	ChangeLogging()
+	admin := getClient(t)
+	first_refresh_token := admin.RefreshToken
+
+	resp, tokens, err := admin.Refresh(first_refresh_token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+	assert.NotEmpty(t, tokens.Data)
+	assert.NotEqual(t, first_refresh_token, tokens.RefreshToken)
+
+	refreshed := &client.Client{Host: admin.Host, Port: admin.Port, Token: tokens.Data}
+	resp, _, err = refreshed.GetMySessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+
+	// reusing the rotated refresh token revokes the session
+	resp, _, err = admin.Refresh(first_refresh_token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "Expected status code 401, but got %d", resp.StatusCode)
+
+	resp, _, err = refreshed.GetMySessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "Expected status code 401, but got %d", resp.StatusCode)
+}
@@ -0,0 +1,55 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStocktakeRejectsUnknownProductsAndCancels(t *testing.T) {
+	ChangeLogging()
+	client := getClient(t)
+
+	branches := getAllBranches(t, client)
+	if len(branches) == 0 {
+		t.Fatal("No branches found")
+	}
+	place := models.ProductPlace{ID: branches[0].BranchID, PlaceType: models.ProductPlaceTypeBranch}
+
+	resp, output, err := client.NewStocktake(&models.NewStocktakeInput{Place: place, Note: "test stocktake"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+	if len(output.Data) == 0 {
+		t.Fatal("No stocktake returned")
+	}
+	stocktake := output.Data[0]
+	assert.Equal(t, models.StocktakeStatusOpen, stocktake.Status)
+
+	// only one stocktake can be open per place
+	resp, _, err = client.NewStocktake(&models.NewStocktakeInput{Place: place})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Expected status code 400, but got %d", resp.StatusCode)
+
+	resp, _, err = client.SubmitStocktakeCounts(stocktake.ID, &models.SubmitStocktakeCountsInput{
+		Device: "scanner-1",
+		Counts: []models.StocktakeCountInput{{ProductID: "unknown-product", Quantity: 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Expected status code 400, but got %d", resp.StatusCode)
+
+	resp, output, err = client.CancelStocktake(stocktake.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+	assert.Equal(t, models.StocktakeStatusCancelled, output.Data[0].Status)
+}
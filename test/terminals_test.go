@@ -0,0 +1,74 @@
+package test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/test/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSwitchCashierWithPIN(t *testing.T) {
+	ChangeLogging()
+	admin := getClient(t)
+
+	branches := getAllBranches(t, admin)
+	if len(branches) == 0 {
+		t.Skip("At least one branch is required")
+	}
+
+	username := fmt.Sprintf("cashier_%d", time.Now().UnixNano())
+	resp, _, err := admin.CreateUser(username, "cashier", "cashier", branches[0].BranchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+
+	cashier := client.NewClient(admin.Host, admin.Port, username, "cashier")
+	resp, err = cashier.SetMyPIN("cashier", "4821")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+
+	resp, created, err := admin.CreateTerminal("till "+username, branches[0].BranchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+	if len(created.Data) == 0 {
+		t.Fatal("Expected created terminal")
+	}
+
+	terminal := &client.Client{Host: admin.Host, Port: admin.Port}
+	resp, err = terminal.SwitchCashier(created.Token, username, "0000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "Expected status code 401, but got %d", resp.StatusCode)
+
+	resp, err = terminal.SwitchCashier(created.Token, username, "4821")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+
+	// the token of the terminal works like the token of the cashier
+	resp, err = terminal.MakeRequest("GET", "/api/transactions/branch/"+branches[0].BranchID, nil, map[string]string{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+
+	resp, shifts, err := admin.GetTerminalShifts(created.Data[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+	if assert.Len(t, shifts.Data, 1) {
+		assert.Equal(t, username, shifts.Data[0].Username)
+		assert.Nil(t, shifts.Data[0].EndedAt)
+	}
+}
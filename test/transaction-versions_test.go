@@ -0,0 +1,107 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEditAndVoidSalesTransaction(t *testing.T) {
+	ChangeLogging()
+	client := getClient(t)
+
+	branches := getAllBranches(t, client)
+	if len(branches) == 0 {
+		t.Fatal("No branches found")
+	}
+	branch := branches[0]
+
+	resp, created, err := client.CreateSalesTransaction(branch.BranchID, models.TransactionBase{
+		Amount:        1000,
+		Description:   "sale to be corrected",
+		Type:          models.TransactionTypeCredit,
+		PaymentMethod: models.PaymentMethodCash,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+	_, before, err := client.GetBranchByID(branch.BranchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	amount := int64(1500)
+	resp, edited, err := client.EditTransaction(created.Data.ID, models.TransactionEditInput{Amount: &amount, Reason: "typo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+	assert.Equal(t, int64(1500), edited.Data.Transaction.Amount)
+	assert.Equal(t, 1, edited.Data.Transaction.Version)
+	assert.Equal(t, int64(1000), edited.Data.Previous.Transaction.Amount)
+	assert.Equal(t, "typo", edited.Data.Previous.Reason)
+	assert.Equal(t, int64(500), edited.Data.Previous.Effect[models.FinanceBucketCash])
+
+	_, after, err := client.GetBranchByID(branch.BranchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, before.Data.Finance.Balance.Cash+500, after.Data.Finance.Balance.Cash)
+
+	resp, voided, err := client.VoidTransaction(created.Data.ID, "duplicate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+	assert.True(t, voided.Data.Transaction.Voided)
+
+	_, after, err = client.GetBranchByID(branch.BranchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, before.Data.Finance.Balance.Cash-1000, after.Data.Finance.Balance.Cash)
+
+	_, versions, err := client.GetTransactionVersions(created.Data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, len(versions.Data))
+
+	// voided transactions can not be changed again
+	resp, _, err = client.VoidTransaction(created.Data.ID, "again")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "Expected status code 409, but got %d", resp.StatusCode)
+}
+
+func TestEditWithoutReasonIsRejected(t *testing.T) {
+	ChangeLogging()
+	client := getClient(t)
+
+	branches := getAllBranches(t, client)
+	if len(branches) == 0 {
+		t.Fatal("No branches found")
+	}
+	resp, created, err := client.CreateSalesTransaction(branches[0].BranchID, models.TransactionBase{
+		Amount:        1000,
+		Description:   "sale",
+		Type:          models.TransactionTypeCredit,
+		PaymentMethod: models.PaymentMethodCash,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+
+	amount := int64(2000)
+	resp, _, err = client.EditTransaction(created.Data.ID, models.TransactionEditInput{Amount: &amount})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Expected status code 400, but got %d", resp.StatusCode)
+}
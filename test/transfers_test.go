@@ -0,0 +1,38 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	models "github.com/aslon1213/g4h_pos_erp/pkg/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTransferValidation(t *testing.T) {
+	ChangeLogging()
+	client := getClient(t)
+
+	branches := getAllBranches(t, client)
+	if len(branches) == 0 {
+		t.Fatal("No branches found")
+	}
+	place := models.ProductPlace{ID: branches[0].BranchID, PlaceType: models.ProductPlaceTypeBranch}
+
+	inputs := []*models.NewTransferInput{
+		// same source and destination
+		{Source: place, Destination: place, Lines: []models.TransferLineInput{{ProductID: "product", Quantity: 1}}},
+		// no lines
+		{Source: place, Destination: models.ProductPlace{ID: "warehouse", PlaceType: models.ProductPlaceTypeWarehouse}},
+		// unknown product
+		{Source: place, Destination: models.ProductPlace{ID: "warehouse", PlaceType: models.ProductPlaceTypeWarehouse}, Lines: []models.TransferLineInput{{ProductID: "unknown-product", Quantity: 1}}},
+	}
+
+	for _, input := range inputs {
+		resp, _, err := client.NewTransfer(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Expected status code 400, but got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,80 @@
+package test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aslon1213/g4h_pos_erp/test/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterIsClosed(t *testing.T) {
+	ChangeLogging()
+	admin := getClient(t)
+
+	resp, err := admin.Register(fmt.Sprintf("intruder_%d", time.Now().UnixNano()), "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "Expected status code 403, but got %d", resp.StatusCode)
+}
+
+func TestDisableAndResetPasswordOfUser(t *testing.T) {
+	ChangeLogging()
+	admin := getClient(t)
+
+	username := fmt.Sprintf("storekeeper_%d", time.Now().UnixNano())
+	resp, output, err := admin.CreateUser(username, "storekeeper", "storekeeper", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Expected status code 201, but got %d", resp.StatusCode)
+	if len(output.Data) == 0 {
+		t.Fatal("No user returned")
+	}
+	user := output.Data[0]
+	assert.Equal(t, "storekeeper", user.Role)
+
+	// disabled users can not login
+	resp, err = admin.SetUserDisabled(user.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+	storekeeper := &client.Client{Host: admin.Host, Port: admin.Port, Username: username, Passwrod: "storekeeper"}
+	resp, _, _ = storekeeper.Login()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "Expected status code 403, but got %d", resp.StatusCode)
+
+	resp, err = admin.SetUserDisabled(user.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+
+	// reset password with one-time code
+	resp, code, err := admin.IssuePasswordReset(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+	assert.NotEmpty(t, code)
+
+	resp, err = admin.RedeemPasswordReset(username, code, "new-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+
+	// the code can be used only once
+	resp, err = admin.RedeemPasswordReset(username, code, "another-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "Expected status code 401, but got %d", resp.StatusCode)
+
+	storekeeper.Passwrod = "new-password"
+	resp, _, _ = storekeeper.Login()
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Expected status code 200, but got %d", resp.StatusCode)
+}